@@ -0,0 +1,683 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/config"
+	"github.com/scu/cleanpg/logger"
+)
+
+// extractRepeatedFlag removes every occurrence of the flag named
+// long or short (-name value, --name value, -name=value) from args
+// and returns their values in order along with the remaining args.
+// flagplus keeps only the last value given for a flag, so repeatable
+// flags are collected here before the flag set is parsed.
+func extractRepeatedFlag(args []string, long, short string) (values []string, rest []string) {
+	names := map[string]bool{
+		"-" + long: true, "--" + long: true,
+		"-" + short: true, "--" + short: true,
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// Everything after "--" is a positional argument
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		if names[arg] {
+			if i+1 < len(args) {
+				values = append(values, args[i+1])
+				i++
+			} else {
+				values = append(values, "")
+			}
+			continue
+		}
+		if eq := strings.IndexByte(arg, '='); eq > 0 && names[arg[:eq]] {
+			values = append(values, arg[eq+1:])
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	return values, rest
+}
+
+// expandBareFlag rewrites the flag named long or short when it is
+// given without a value (-name, --name) as --long=value, so that a
+// string flag can be used like a boolean one. A value can still be
+// given as -name=value.
+func expandBareFlag(args []string, long, short, value string) []string {
+	names := map[string]bool{
+		"-" + long: true, "--" + long: true,
+		"-" + short: true, "--" + short: true,
+	}
+
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if names[arg] {
+			arg = "--" + long + "=" + value
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// flagGiven determines if the flag named long or short is
+// given in args, rather than taking its default
+func flagGiven(args []string, long, short string) bool {
+	names := map[string]bool{
+		"-" + long: true, "--" + long: true,
+		"-" + short: true, "--" + short: true,
+	}
+
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if eq := strings.IndexByte(arg, '='); eq > 0 {
+			arg = arg[:eq]
+		}
+		if names[arg] {
+			return true
+		}
+	}
+	return false
+}
+
+// expandOptionalFlag rewrites the flag named long or short as
+// --long=value when it is given without a value: at the end of
+// args, before another flag, or before the last argument, which
+// is taken as the URL. Unlike expandBareFlag, "-name value"
+// keeps its value.
+func expandOptionalFlag(args []string, long, short, value string) []string {
+	names := map[string]bool{
+		"-" + long: true, "--" + long: true,
+		"-" + short: true, "--" + short: true,
+	}
+
+	expanded := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if names[arg] {
+			if i+2 < len(args) && (!strings.HasPrefix(args[i+1], "-") || args[i+1] == "-") {
+				expanded = append(expanded, arg, args[i+1])
+				i++
+				continue
+			}
+			arg = "--" + long + "=" + value
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// expandAlias rewrites each -alias (or --alias) in args as
+// replacement, for a flag which has been renamed
+func expandAlias(args []string, alias, replacement string) []string {
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if arg == "-"+alias || arg == "--"+alias {
+			arg = replacement
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// parseHeader splits a "Name: value" header flag
+func parseHeader(s string) (name, value string, err error) {
+	colon := strings.IndexByte(s, ':')
+	if colon < 0 {
+		return "", "", fmt.Errorf("header %q must have the form \"Name: value\"", s)
+	}
+
+	name = strings.TrimSpace(s[:colon])
+	value = strings.TrimSpace(s[colon+1:])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("header %q has an invalid name", s)
+	}
+
+	return name, value, nil
+}
+
+// headerList collects the values of the repeatable "header"
+// flag; it implements the flag.Value interface
+type headerList struct {
+	header http.Header
+}
+
+// String implements the flag.Value interface
+func (h *headerList) String() string {
+	var b strings.Builder
+	h.header.Write(&b)
+	return strings.TrimSpace(b.String())
+}
+
+// Set implements the flag.Value interface, adding a
+// "Name: value" header. Host can't be set, as it is
+// taken from the URL.
+func (h *headerList) Set(s string) error {
+	name, value, err := parseHeader(s)
+	if err != nil {
+		return err
+	}
+	if http.CanonicalHeaderKey(name) == "Host" {
+		return fmt.Errorf("header %q can't be set; the host comes from the URL", s)
+	}
+
+	if h.header == nil {
+		h.header = http.Header{}
+	}
+	h.header.Add(name, value)
+	return nil
+}
+
+// parseHeaders builds request headers from "Name: value" flags
+func parseHeaders(flags []string) (http.Header, error) {
+	h := &headerList{header: http.Header{}}
+	for _, f := range flags {
+		if err := h.Set(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.header, nil
+}
+
+// parseCookie splits a "name=value" cookie flag
+func parseCookie(s string) (*http.Cookie, error) {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("cookie must have the form \"name=value\"")
+	}
+
+	name := strings.TrimSpace(s[:eq])
+	value := strings.TrimSpace(s[eq+1:])
+	if strings.ContainsAny(name, " \t;,=") || strings.ContainsAny(value, " \t;,\"") {
+		return nil, fmt.Errorf("cookie %q has invalid characters", name)
+	}
+
+	return &http.Cookie{Name: name, Value: value}, nil
+}
+
+// parseCookies builds request cookies from "name=value" flags
+func parseCookies(flags []string) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	for _, f := range flags {
+		c, err := parseCookie(f)
+		if err != nil {
+			return nil, err
+		}
+		cookies = append(cookies, c)
+	}
+
+	return cookies, nil
+}
+
+// sizeUnits maps size suffixes to their multipliers
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"k":   1 << 10,
+	"m":   1 << 20,
+	"g":   1 << 30,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+}
+
+// parseSize converts a human-friendly size such as "512", "5MB"
+// or "1.5GiB" to a number of bytes
+func parseSize(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+
+	// Split at the first character which isn't part of the number
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(str)
+	}
+
+	number, err := strconv.ParseFloat(str[:i], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(str[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q (use B, KB, MB, GB, KiB, MiB or GiB)", s)
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+// proxyVars are the environment variables net/http takes
+// proxies from, each also read in lower case
+var proxyVars = []string{"HTTPS_PROXY", "HTTP_PROXY"}
+
+// environmentProxy describes the proxies set in the environment
+// read by getenv, such as os.Getenv
+func environmentProxy(getenv func(string) string) string {
+	var set []string
+	for _, name := range append(proxyVars, "NO_PROXY") {
+		value := getenv(name)
+		if value == "" {
+			value = getenv(strings.ToLower(name))
+		}
+		if value != "" {
+			set = append(set, fmt.Sprintf("%s=[%s]", name, value))
+		}
+	}
+	if len(set) == 0 || strings.HasPrefix(set[0], "NO_PROXY") {
+		return "fetching directly: no proxy is set"
+	}
+	return "fetching through the proxies of the environment: " + strings.Join(set, " ")
+}
+
+// readPassword prompts on w for user's password and reads a single
+// line from r, a byte at a time so nothing after it is consumed.
+// Passwords are never taken from the command line, where other
+// users can see them.
+func readPassword(r io.Reader, w io.Writer, user string) (string, error) {
+	fmt.Fprintf(w, "Password for %s: ", user)
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not read password for [%s]: %s", user, err)
+		}
+	}
+	fmt.Fprintln(w)
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// parseFetcher builds the fetcher named by a --fetcher value:
+// "" or "http" for the built-in fetch, or "exec:command args"
+// to run a command which prints the document
+func parseFetcher(spec string, opts cleanhtml.FetchOptions) (cleanhtml.Fetcher, error) {
+	switch {
+	case spec == "" || spec == "http":
+		return &cleanhtml.HTTPFetcher{Options: opts}, nil
+	case strings.HasPrefix(spec, "exec:"):
+		command := strings.Fields(spec[len("exec:"):])
+		if len(command) == 0 {
+			return nil, fmt.Errorf("missing command in fetcher [%s]", spec)
+		}
+		return &cleanhtml.ExecFetcher{Command: command, Options: opts}, nil
+	}
+	return nil, fmt.Errorf("unknown fetcher [%s]: expected http or exec:command", spec)
+}
+
+// readInputFile reads the URL list in fileName, or stdin for "-"
+func readInputFile(fileName string) ([]string, error) {
+	if fileName == "-" {
+		return readURLList(stdin, "stdin")
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open input file: %s", err)
+	}
+	defer f.Close()
+	return readURLList(f, fileName)
+}
+
+// readStdin reads the document to clean from stdin, subject
+// to the same size limit and charset detection as a fetch
+func readStdin(opts cleanhtml.FetchOptions) (*cleanhtml.FetchResult, error) {
+	start := time.Now()
+	body, err := cleanhtml.ReadHTMLFrom(stdin, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &cleanhtml.FetchResult{URL: "-", Body: body, Elapsed: time.Since(start)}, nil
+}
+
+// readURLList reads a list of URLs, one per line, skipping blank
+// lines, "#" comments and repeats. A line which isn't an http,
+// https or file URL is reported and skipped.
+func readURLList(r io.Reader, name string) ([]string, error) {
+	var urls []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		u, err := url.Parse(line)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") ||
+			(u.Scheme != "file" && u.Host == "") {
+			logger.Write(logger.WARNING, "%s line %d: skipping invalid URL [%s]", name, lineNum, line)
+			continue
+		}
+		if seen[line] {
+			logger.Write(logger.NOTICE, "%s line %d: skipping repeated URL [%s]", name, lineNum, line)
+			continue
+		}
+		seen[line] = true
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", name, err)
+	}
+
+	return urls, nil
+}
+
+// configError is an error reading the configuration
+// file or CLEANPG_* environment variables
+type configError struct {
+	error
+}
+
+// configWarnings holds the warnings from reading the configuration
+// file and environment, which are read before logging is set up
+var configWarnings []string
+
+// envPrefix begins the environment variable for each flag,
+// e.g. CLEANPG_USER_AGENT for --user-agent
+const envPrefix = "CLEANPG_"
+
+// envName returns the environment variable setting the flag name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// defaultConfigFile returns the path of the configuration file
+// read when --config is not given, or "" if there is no home directory
+func defaultConfigFile() string {
+	if fileName, ok := os.LookupEnv(envName("config")); ok {
+		return fileName
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "cleanpg", "config")
+}
+
+// applyConfig sets the flags named in the configuration file as defaults
+// for the command line, returning the values of the repeatable flags by
+// name; configFlags holds any --config values given. A missing default
+// file is not an error.
+func applyConfig(configFlags []string) (map[string][]string, error) {
+	fileName, explicit := defaultConfigFile(), false
+	if len(configFlags) > 0 {
+		fileName, explicit = configFlags[len(configFlags)-1], true
+	}
+	if fileName == "" {
+		return nil, nil
+	}
+
+	entries, err := config.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return nil, nil
+		}
+		return nil, &configError{err}
+	}
+
+	values := make(map[string][]string)
+	for _, e := range entries {
+		if _, ok := repeatableFlags[e.Key]; ok {
+			values[e.Key] = append(values[e.Key], e.Value)
+			continue
+		}
+
+		switch e.Key {
+		case "config":
+			configWarnings = append(configWarnings,
+				fmt.Sprintf("config key [config] on line %d of [%s] has no effect", e.Line, fileName))
+		default:
+			if err := setDefault(e.Key, e.Value); err != nil {
+				if isUnknownFlag(err) {
+					configWarnings = append(configWarnings,
+						fmt.Sprintf("unknown config key [%s] on line %d of [%s]", e.Key, e.Line, fileName))
+					continue
+				}
+				return nil, &configError{fmt.Errorf("invalid value %q for [%s] on line %d of [%s]", e.Value, e.Key, e.Line, fileName)}
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// applyEnv sets the flags named by CLEANPG_* environment variables
+// as defaults for the command line, overriding the configuration file,
+// and returns the values of the repeatable flags by name
+func applyEnv() (map[string][]string, error) {
+	values := make(map[string][]string)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		eq := strings.IndexByte(kv, '=')
+		variable, value := kv[:eq], kv[eq+1:]
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(variable, envPrefix), "_", "-"))
+
+		if _, ok := repeatableFlags[name]; ok {
+			values[name] = append(values[name], value)
+			continue
+		}
+
+		switch name {
+		case "config":
+			// Read by defaultConfigFile
+		default:
+			if err := setDefault(name, value); err != nil {
+				if isUnknownFlag(err) {
+					configWarnings = append(configWarnings,
+						fmt.Sprintf("unknown environment variable [%s]", variable))
+					continue
+				}
+				return nil, &configError{fmt.Errorf("invalid value %q for [%s]", value, variable)}
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// setDefault sets the flag name to value before the command line is
+// parsed; boolean flags also accept yes and no
+func setDefault(name, value string) error {
+	err := fs.SimulateArg(name, value)
+	if err != nil && !isUnknownFlag(err) {
+		switch strings.ToLower(value) {
+		case "yes":
+			return fs.SimulateArg(name, "true")
+		case "no":
+			return fs.SimulateArg(name, "false")
+		}
+	}
+	return err
+}
+
+// isUnknownFlag determines if err is setting a flag which isn't defined
+func isUnknownFlag(err error) bool {
+	return strings.HasPrefix(err.Error(), "no such flag")
+}
+
+// domainName matches a host's domain name, capturing its top level
+var domainName = regexp.MustCompile(`^[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*\.([a-zA-Z]{2,})$`)
+
+// documentExts are the extensions of saved documents, which
+// end file names rather than host names
+var documentExts = map[string]bool{
+	"htm": true, "html": true, "xhtml": true, "xml": true,
+	"txt": true, "md": true, "json": true, "gz": true, "warc": true,
+}
+
+// looksLikeHost determines if source, given without a scheme,
+// names a host rather than a file, e.g. example.com:8080/page
+func looksLikeHost(source string) bool {
+	if strings.HasPrefix(source, "/") || strings.ContainsRune(source, '\\') {
+		return false
+	}
+	u, err := url.Parse("https://" + source)
+	if err != nil {
+		return false
+	}
+
+	host := u.Hostname()
+	if host == "localhost" || net.ParseIP(host) != nil {
+		return true
+	}
+	m := domainName.FindStringSubmatch(host)
+	return m != nil && !documentExts[strings.ToLower(m[1])]
+}
+
+// sourceScheme matches the scheme of a source given as a URL; a
+// single letter is a Windows drive, and digits a host's port
+var sourceScheme = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]+):(?:[^0-9]|$)`)
+
+// sourceQuotes are the pairs which come around a URL copied
+// from a message or a document
+var sourceQuotes = [][2]string{{"<", ">"}, {`"`, `"`}, {"'", "'"}, {"\u201c", "\u201d"}, {"\u2018", "\u2019"}}
+
+// normalizeSource returns a source as it was copied, without the
+// white space, angle brackets or quotes which came along with it,
+// or an error if it is a URL of a scheme cleanpg can't fetch
+func normalizeSource(source string) (string, error) {
+	source = strings.TrimSpace(source)
+	for unquoted := true; unquoted; {
+		unquoted = false
+		for _, q := range sourceQuotes {
+			if len(source) >= len(q[0])+len(q[1]) && strings.HasPrefix(source, q[0]) && strings.HasSuffix(source, q[1]) {
+				source = strings.TrimSpace(source[len(q[0]) : len(source)-len(q[1])])
+				unquoted = true
+			}
+		}
+	}
+
+	if m := sourceScheme.FindStringSubmatch(source); m != nil {
+		switch strings.ToLower(m[1]) {
+		case "http", "https", "file":
+		default:
+			return "", fmt.Errorf("unsupported scheme [%s] in [%s]: cleanpg fetches http and https URLs, and reads files", m[1], source)
+		}
+	}
+	return source, nil
+}
+
+// bareHostURL returns the https URL fetched for source when it
+// names a host without a scheme, e.g. example.com/article, and
+// isn't the name of a file, which would be read instead
+func bareHostURL(source string) (string, bool) {
+	if source == "-" || sourceScheme.MatchString(source) || !looksLikeHost(source) {
+		return "", false
+	}
+	if _, err := os.Stat(source); err == nil {
+		return "", false
+	}
+	return "https://" + source, true
+}
+
+// httpsFailed determines if err is a failure to connect to a
+// server or to make a TLS connection with it, after which
+// --allow-http tries http
+func httpsFailed(err error) bool {
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case isTimeout(err):
+		return false
+	case errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "remote error"),
+		errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return true
+	}
+	// net/http reports a plain http server as just this
+	return strings.Contains(err.Error(), "server gave HTTP response to HTTPS client")
+}
+
+// checkLocalSource checks a source given without a scheme, which
+// is read as a local file rather than fetched: one named like a
+// host is read with a notice, and a missing one is an error rather
+// than a fetch. It returns the exit code of a failure.
+func checkLocalSource(source string) int {
+	if source == "-" || sourceScheme.MatchString(source) {
+		return 0
+	}
+
+	info, err := os.Stat(source)
+	switch {
+	case err == nil && info.IsDir():
+		logger.Write(logger.FATAL, "[%s] is a directory: give a file, or a URL", source)
+		return exitUsage
+	case err == nil:
+		if looksLikeHost(source) {
+			logger.Write(logger.NOTICE, "reading the local file [%s]; to fetch the site, give https://%s", source, source)
+		}
+		return 0
+	}
+	logger.Write(logger.FATAL, "Could not read file [%s]: %s", source, err)
+	return exitFetch
+}
+
+// parseSyslog returns the network and address of the "syslog"
+// flag's value: network://address, or "local" for the local syslog
+func parseSyslog(value string) (network, addr string, err error) {
+	if value == "local" {
+		return "", "", nil
+	}
+	i := strings.Index(value, "://")
+	if i <= 0 || i+3 == len(value) {
+		return "", "", fmt.Errorf("expected network://address, e.g. udp://localhost:514, or local")
+	}
+	return value[:i], value[i+3:], nil
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+func TestExtractRepeatedFlag(t *testing.T) {
+	args := []string{"cleanpg", "-H", "Accept-Language: de", "-v",
+		"--header=X-Token: a:b", "--header", "Referer: x", "http://example.com",
+		"--", "-H"}
+
+	values, rest := extractRepeatedFlag(args, "header", "H")
+
+	expectValues := []string{"Accept-Language: de", "X-Token: a:b", "Referer: x"}
+	if !reflect.DeepEqual(values, expectValues) {
+		t.Errorf("Expected values %q, got %q", expectValues, values)
+	}
+	expectRest := []string{"cleanpg", "-v", "http://example.com", "--", "-H"}
+	if !reflect.DeepEqual(rest, expectRest) {
+		t.Errorf("Expected rest %q, got %q", expectRest, rest)
+	}
+}
+
+func TestExpandOptionalFlag(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"cleanpg", "--save", "page.html", "http://example.com"}, []string{"cleanpg", "--save", "page.html", "http://example.com"}},
+		{[]string{"cleanpg", "-s", "-", "http://example.com"}, []string{"cleanpg", "-s", "-", "http://example.com"}},
+		{[]string{"cleanpg", "--save", "http://example.com"}, []string{"cleanpg", "--save=auto", "http://example.com"}},
+		{[]string{"cleanpg", "-s", "-v", "http://example.com"}, []string{"cleanpg", "--save=auto", "-v", "http://example.com"}},
+		{[]string{"cleanpg", "--save"}, []string{"cleanpg", "--save=auto"}},
+		{[]string{"cleanpg", "--", "--save", "http://example.com"}, []string{"cleanpg", "--", "--save", "http://example.com"}},
+	}
+
+	for _, tt := range tests {
+		if got := expandOptionalFlag(tt.args, "save", "s", "auto"); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%q: expected %q, got %q", tt.args, tt.expect, got)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		flag  string
+		name  string
+		value string
+		ok    bool
+	}{
+		{"Accept-Language: de", "Accept-Language", "de", true},
+		{"X-Time: 12:30:00", "X-Time", "12:30:00", true},
+		{"X-Empty:", "X-Empty", "", true},
+		{"no colon here", "", "", false},
+		{": value", "", "", false},
+		{"Bad Name: value", "", "", false},
+	}
+
+	for _, tt := range tests {
+		name, value, err := parseHeader(tt.flag)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: expected ok=%v, got error %v", tt.flag, tt.ok, err)
+			continue
+		}
+		if name != tt.name || value != tt.value {
+			t.Errorf("%q: expected %q/%q, got %q/%q", tt.flag, tt.name, tt.value, name, value)
+		}
+	}
+}
+
+func TestParseCookie(t *testing.T) {
+	tests := []struct {
+		flag  string
+		name  string
+		value string
+		ok    bool
+	}{
+		{"consent=yes", "consent", "yes", true},
+		{"token=YWJj==", "token", "YWJj==", true},
+		{"session=", "session", "", true},
+		{"=value", "", "", false},
+		{"novalue", "", "", false},
+		{"a=1; b=2", "", "", false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCookie(tt.flag)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: expected ok=%v, got error %v", tt.flag, tt.ok, err)
+			continue
+		}
+		if err == nil && (c.Name != tt.name || c.Value != tt.value) {
+			t.Errorf("%q: expected %q/%q, got %q/%q", tt.flag, tt.name, tt.value, c.Name, c.Value)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size   string
+		expect int64
+		ok     bool
+	}{
+		{"512", 512, true},
+		{"0", 0, true},
+		{"5MB", 5000000, true},
+		{"5 mb", 5000000, true},
+		{"20MiB", 20 << 20, true},
+		{"1.5GiB", 3 << 29, true},
+		{"10k", 10 << 10, true},
+		{"MB", 0, false},
+		{"5XB", 0, false},
+		{"-1", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.size)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: expected ok=%v, got error %v", tt.size, tt.ok, err)
+			continue
+		}
+		if got != tt.expect {
+			t.Errorf("%q: expected %d, got %d", tt.size, tt.expect, got)
+		}
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+		fail   bool
+	}{
+		{"hunter2\n", "hunter2", false},
+		{"pass word\r\nignored\n", "pass word", false},
+		{"no newline", "no newline", false},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		var prompt bytes.Buffer
+		got, err := readPassword(strings.NewReader(tt.input), &prompt, "bob")
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		}
+		if got != tt.expect {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+		if !strings.HasPrefix(prompt.String(), "Password for bob: ") {
+			t.Errorf("Expected a password prompt, got %q", prompt.String())
+		}
+	}
+
+	// Nothing past the line is read
+	r := strings.NewReader("hunter2\n<html><body>page</body></html>")
+	if _, err := readPassword(r, ioutil.Discard, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := ioutil.ReadAll(r); string(rest) != "<html><body>page</body></html>" {
+		t.Errorf("Expected the rest of the input left, got %q", rest)
+	}
+}
+
+func TestParseFetcher(t *testing.T) {
+	opts := cleanhtml.DefaultFetchOptions()
+
+	for _, spec := range []string{"", "http"} {
+		f, err := parseFetcher(spec, opts)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", spec, err)
+		}
+		if _, ok := f.(*cleanhtml.HTTPFetcher); !ok {
+			t.Errorf("%q: expected an HTTPFetcher, got %T", spec, f)
+		}
+	}
+
+	f, err := parseFetcher("exec:chromium --headless --dump-dom", opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ef, ok := f.(*cleanhtml.ExecFetcher)
+	if !ok {
+		t.Fatalf("Expected an ExecFetcher, got %T", f)
+	}
+	if expect := []string{"chromium", "--headless", "--dump-dom"}; !reflect.DeepEqual(ef.Command, expect) {
+		t.Errorf("Expected %q, got %q", expect, ef.Command)
+	}
+
+	for _, spec := range []string{"exec:", "exec:  ", "chrome"} {
+		if _, err := parseFetcher(spec, opts); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestReadURLList(t *testing.T) {
+	f, err := os.Open("testdata/reading-list.txt")
+	if err != nil {
+		t.Fatalf("Could not open fixture: %v", err)
+	}
+	defer f.Close()
+
+	got, err := readURLList(f, "reading-list.txt")
+	if err != nil {
+		t.Fatalf("Could not read list: %v", err)
+	}
+	expect := []string{"https://example.com/articles/one", "https://example.com/articles/two", "file:///home/me/saved.html"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	var _ flag.Value = &headerList{}
+
+	headers, err := parseHeaders([]string{"Accept-Language: de", "X-Token: abc", "accept-language: en"})
+	if err != nil {
+		t.Fatalf("Could not parse headers: %v", err)
+	}
+	expect := http.Header{"Accept-Language": {"de", "en"}, "X-Token": {"abc"}}
+	if !reflect.DeepEqual(headers, expect) {
+		t.Errorf("Expected %v, got %v", expect, headers)
+	}
+
+	for _, bad := range []string{"Host: evil.example", "host: evil.example", "no colon"} {
+		if _, err := parseHeaders([]string{bad}); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	data := "user-agent = from-config\nnostyle = true\nheader = 'X-Config: 1'\ntheme = dark\n"
+	if err := ioutil.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatalf("Could not write config: %v", err)
+	}
+
+	tests := []struct {
+		args      []string
+		userAgent string
+		nostyle   bool
+		timeout   string
+		headers   []string
+	}{
+		// The config file overrides the built-in defaults...
+		{[]string{"cleanpg", "--config", configFile, "url"}, "from-config", true, "30s", []string{"X-Config: 1"}},
+		// ...and the command line overrides the config file
+		{[]string{"cleanpg", "-a", configFile, "-A", "from-flag", "-H", "X-Flag: 2", "url"}, "from-flag", true, "30s", []string{"X-Config: 1", "X-Flag: 2"}},
+		// A missing default config file leaves the built-in defaults
+		{[]string{"cleanpg", "-t", "5s", "url"}, cleanhtml.DefaultUserAgent, false, "5s", nil},
+	}
+
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	os.Setenv("HOME", dir)
+
+	for _, tt := range tests {
+		if err := parseArgs(tt.args); err != nil {
+			t.Fatalf("%q: could not parse: %v", tt.args, err)
+		}
+		userAgent, _ := fs.GetString("user-agent")
+		nostyle, _ := fs.Get("nostyle")
+		timeout, _ := fs.GetString("timeout")
+		if userAgent != tt.userAgent || nostyle != tt.nostyle || timeout != tt.timeout {
+			t.Errorf("%q: expected %q %v %q, got %q %v %q", tt.args, tt.userAgent, tt.nostyle, tt.timeout, userAgent, nostyle, timeout)
+		}
+		if !reflect.DeepEqual(headerFlags, tt.headers) {
+			t.Errorf("%q: expected headers %q, got %q", tt.args, tt.headers, headerFlags)
+		}
+	}
+
+	// Unknown keys are warned about by name and line
+	parseArgs([]string{"cleanpg", "--config", configFile, "url"})
+	if expect := []string{"unknown config key [theme] on line 4 of [" + configFile + "]"}; !reflect.DeepEqual(configWarnings, expect) {
+		t.Errorf("Expected %q, got %q", expect, configWarnings)
+	}
+
+	// An explicit config file must exist, and its values be valid
+	if err := parseArgs([]string{"cleanpg", "--config", filepath.Join(dir, "missing"), "url"}); err == nil {
+		t.Errorf("Expected an error for a missing config file")
+	}
+	ioutil.WriteFile(configFile, []byte("nostyle = maybe\n"), 0644)
+	if err := parseArgs([]string{"cleanpg", "--config", configFile, "url"}); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected an error naming the line, got %v", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("timeout = 10s\nuser-agent = from-config\n"), 0644); err != nil {
+		t.Fatalf("Could not write config: %v", err)
+	}
+
+	env := map[string]string{
+		"CLEANPG_CONFIG":     configFile,
+		"CLEANPG_TIMEOUT":    "60s",
+		"CLEANPG_NOSTYLE":    "yes",
+		"CLEANPG_HEADER":     "X-Env: 1",
+		"CLEANPG_OUTPUT_DIR": "pages",
+		"CLEANPG_COLOUR":     "true",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	// Environment variables override the config file,
+	// and the command line overrides both
+	if err := parseArgs([]string{"cleanpg", "-H", "X-Flag: 2", "-O", "out", "url"}); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	tests := []struct {
+		name   string
+		expect string
+	}{
+		{"timeout", "60s"},
+		{"user-agent", "from-config"},
+		{"output-dir", "out"},
+	}
+	for _, tt := range tests {
+		if got, _ := fs.GetString(tt.name); got != tt.expect {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expect, got)
+		}
+	}
+	if nostyle, _ := fs.Get("nostyle"); !nostyle {
+		t.Errorf("Expected CLEANPG_NOSTYLE=yes to set --nostyle")
+	}
+	if expect := []string{"X-Env: 1", "X-Flag: 2"}; !reflect.DeepEqual(headerFlags, expect) {
+		t.Errorf("Expected headers %q, got %q", expect, headerFlags)
+	}
+	if expect := []string{"unknown environment variable [CLEANPG_COLOUR]"}; !reflect.DeepEqual(configWarnings, expect) {
+		t.Errorf("Expected %q, got %q", expect, configWarnings)
+	}
+
+	os.Setenv("CLEANPG_TIMEOUT", "")
+	os.Setenv("CLEANPG_NOSTYLE", "maybe")
+	if err := parseArgs([]string{"cleanpg", "url"}); err == nil || !strings.Contains(err.Error(), "CLEANPG_NOSTYLE") {
+		t.Errorf("Expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestEnvironmentProxy(t *testing.T) {
+	tests := []struct {
+		env    map[string]string
+		expect string
+	}{
+		{nil, "fetching directly: no proxy is set"},
+		{map[string]string{"NO_PROXY": "intranet"}, "fetching directly: no proxy is set"},
+		{map[string]string{"http_proxy": "http://proxy:3128", "NO_PROXY": "intranet"},
+			"fetching through the proxies of the environment: HTTP_PROXY=[http://proxy:3128] NO_PROXY=[intranet]"},
+		{map[string]string{"HTTPS_PROXY": "socks5://proxy:1080", "https_proxy": "unused"},
+			"fetching through the proxies of the environment: HTTPS_PROXY=[socks5://proxy:1080]"},
+	}
+	for _, tt := range tests {
+		if got := environmentProxy(func(name string) string { return tt.env[name] }); got != tt.expect {
+			t.Errorf("%v: expected %q, got %q", tt.env, tt.expect, got)
+		}
+	}
+}
+
+func TestLooksLikeHost(t *testing.T) {
+	for source, expect := range map[string]bool{
+		"example.com":            true,
+		"www.example.co.uk/page": true,
+		"example.org:8080/a.htm": true,
+		"saved.html":             false,
+		"pages/saved.html":       false,
+		"./example.com":          false,
+		"archive.warc.gz":        false,
+		"localhost:8080":         true,
+		"127.0.0.1/page":         true,
+		"/tmp/example.com":       false,
+		`pages\example.com`:      false,
+	} {
+		if got := looksLikeHost(source); got != expect {
+			t.Errorf("%q: expected %v, got %v", source, expect, got)
+		}
+	}
+}
+
+func TestNormalizeSource(t *testing.T) {
+	tests := []struct {
+		source string
+		expect string
+		err    bool
+	}{
+		{"https://example.com/article", "https://example.com/article", false},
+		{"  https://example.com/article\n", "https://example.com/article", false},
+		{"<https://example.com/article>", "https://example.com/article", false},
+		{`"https://example.com/article"`, "https://example.com/article", false},
+		{"'https://example.com/article' ", "https://example.com/article", false},
+		{"\u201chttps://example.com/article\u201d", "https://example.com/article", false},
+		{`"<https://example.com/article>"`, "https://example.com/article", false},
+		{"example.com/article", "example.com/article", false},
+		{"localhost:8080/article", "localhost:8080/article", false},
+		{"HTTP://example.com/", "HTTP://example.com/", false},
+		{"file:///tmp/saved.html", "file:///tmp/saved.html", false},
+		{"./saved.html", "./saved.html", false},
+		{`C:\pages\saved.html`, `C:\pages\saved.html`, false},
+		{"-", "-", false},
+		{"ftp://example.com/article", "", true},
+		{"<mailto:editor@example.com>", "", true},
+		{"javascript:alert(1)", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeSource(tt.source)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: expected error %v, got %v", tt.source, tt.err, err)
+			continue
+		}
+		if got != tt.expect {
+			t.Errorf("%q: expected %q, got %q", tt.source, tt.expect, got)
+		}
+	}
+}
+
+func TestBareHostURL(t *testing.T) {
+	// A file named like a host is read, not fetched
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+	if err := ioutil.WriteFile("example.net", []byte("<p>saved</p>"), 0644); err != nil {
+		t.Fatalf("Could not write fixture: %v", err)
+	}
+
+	for source, expect := range map[string]string{
+		"example.com/article":   "https://example.com/article",
+		"localhost:8080":        "https://localhost:8080",
+		"http://example.com/":   "",
+		"saved.html":            "",
+		"-":                     "",
+		"example.net":           "",
+		"file:///tmp/saved.htm": "",
+	} {
+		if got, _ := bareHostURL(source); got != expect {
+			t.Errorf("%q: expected %q, got %q", source, expect, got)
+		}
+	}
+}
+
+func TestParseSyslog(t *testing.T) {
+	for value, expect := range map[string][2]string{
+		"local":               {"", ""},
+		"udp://localhost:514": {"udp", "localhost:514"},
+		"unixgram:///dev/log": {"unixgram", "/dev/log"},
+	} {
+		network, addr, err := parseSyslog(value)
+		if err != nil || network != expect[0] || addr != expect[1] {
+			t.Errorf("%q: expected %q, got %q, %q, %v", value, expect, network, addr, err)
+		}
+	}
+	for _, value := range []string{"localhost:514", "udp://", "://localhost"} {
+		if _, _, err := parseSyslog(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
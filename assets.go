@@ -0,0 +1,178 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// Values of the "images" flag
+var imageModes = map[string]bool{
+	"none":     true, // images are left out
+	"keep":     true, // images link to their original location
+	"download": true, // images are saved to the asset directory
+	"inline":   true, // images are embedded as data URIs
+}
+
+// assetSaver replaces the srcs of rendered images with copies
+// saved to a directory, or embedded as data URIs. An image which
+// can't be fetched keeps its original src. It may be used by the
+// pages of a batch being cleaned at once.
+type assetSaver struct {
+	ctx    context.Context
+	opts   cleanhtml.FetchOptions
+	inline bool                   // embed as data URIs rather than save
+	dir    string                 // directory images are saved in
+	relDir string                 // dir relative to the documents
+	mu     sync.Mutex             // guards srcs and used
+	srcs   map[string]*savedAsset // replacement src of each image
+	used   map[string]bool        // file names saved so far
+}
+
+// savedAsset is the replacement src of an image, set once
+type savedAsset struct {
+	once sync.Once
+	src  string
+}
+
+// newAssetSaver returns a saver for documents written to docDir;
+// dir is where images are saved, unused when inline is set
+func newAssetSaver(ctx context.Context, opts cleanhtml.FetchOptions, inline bool, dir, docDir string) *assetSaver {
+	a := &assetSaver{ctx: ctx, opts: opts, inline: inline, dir: dir,
+		srcs: make(map[string]*savedAsset), used: make(map[string]bool)}
+	if !inline {
+		a.relDir = dir
+		if rel, err := filepath.Rel(docDir, dir); err == nil {
+			a.relDir = rel
+		}
+	}
+	return a
+}
+
+// sourceFor returns the image source function of a document read
+// from docURL; see source
+func (a *assetSaver) sourceFor(docURL string) func(src string) string {
+	return func(src string) string {
+		return a.source(src, docURL)
+	}
+}
+
+// source returns the replacement for the image src of a document
+// read from docURL, which is fetched once however many times it is
+// rendered. The credentials, headers and cookies the document was
+// fetched with are only sent to its own host.
+func (a *assetSaver) source(src, docURL string) string {
+	a.mu.Lock()
+	saved, ok := a.srcs[src]
+	if !ok {
+		saved = &savedAsset{}
+		a.srcs[src] = saved
+	}
+	a.mu.Unlock()
+
+	saved.once.Do(func() {
+		opts := a.opts
+		if !sameHost(src, docURL) {
+			opts = opts.WithoutCredentials()
+		}
+		replaced, err := a.replace(src, opts)
+		if err != nil {
+			logger.Write(logger.WARNING, "could not get image [%s]: %s", src, err)
+			replaced = src
+		}
+		saved.src = replaced
+	})
+	return saved.src
+}
+
+// sameHost determines if the URLs a and b are on the same host
+func sameHost(a, b string) bool {
+	u, err := url.Parse(a)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	v, err := url.Parse(b)
+	return err == nil && strings.EqualFold(u.Hostname(), v.Hostname())
+}
+
+// replace fetches the image at src with opts and saves or encodes it
+func (a *assetSaver) replace(src string, opts cleanhtml.FetchOptions) (string, error) {
+	if strings.HasPrefix(src, "data:") {
+		return src, nil
+	}
+
+	asset, err := cleanhtml.FetchAsset(a.ctx, src, opts)
+	if err != nil {
+		return "", err
+	}
+	if a.inline {
+		return "data:" + asset.ContentType + ";base64," + base64.StdEncoding.EncodeToString(asset.Data), nil
+	}
+
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	name := uniqueName(a.used, assetName(asset))
+	a.mu.Unlock()
+	fileName := filepath.Join(a.dir, name)
+	if err := ioutil.WriteFile(fileName, asset.Data, 0644); err != nil {
+		return "", err
+	}
+	logger.Write(logger.INFO, "saved image [%s] to [%s]", src, fileName)
+
+	return path.Join(filepath.ToSlash(a.relDir), url.PathEscape(name)), nil
+}
+
+// assetName returns a file name for the asset from the last
+// element of its URL, with an extension for its type if it has none
+func assetName(asset *cleanhtml.Asset) string {
+	name := "image"
+	if u, err := url.Parse(asset.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = path.Base(u.Path)
+	}
+
+	name = strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-.")
+	if len(name) > maxNameLen {
+		name = strings.TrimRight(name[:maxNameLen], "-.")
+	}
+	if name == "" {
+		name = "image"
+	}
+	if path.Ext(name) == "" {
+		name += imageExt(asset.ContentType)
+	}
+	return name
+}
+
+// imageExts holds the usual extension of common image types,
+// where there are several to choose from
+var imageExts = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/svg+xml": ".svg",
+}
+
+// imageExt returns the extension for the media type mt, if any
+func imageExt(mt string) string {
+	if ext, ok := imageExts[mt]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
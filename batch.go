@@ -0,0 +1,407 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+	"github.com/scu/cleanpg/warc"
+)
+
+// defaultWorkers is the number of documents fetched at
+// once when cleaning a batch
+const defaultWorkers = 4
+
+// urlFilter selects which URLs of a batch are cleaned
+type urlFilter struct {
+	include *regexp.Regexp // nil = all
+	exclude *regexp.Regexp // nil = none
+	max     int            // 0 = no limit
+}
+
+// newURLFilter builds the filter given by the
+// "url-include", "url-exclude" and "max-pages" flags
+func newURLFilter() (*urlFilter, error) {
+	filter := &urlFilter{}
+
+	for _, f := range []struct {
+		flag string
+		re   **regexp.Regexp
+	}{
+		{"url-include", &filter.include},
+		{"url-exclude", &filter.exclude},
+	} {
+		pattern, err := fs.GetString(f.flag)
+		if err != nil {
+			panic(err)
+		}
+		if pattern == "" {
+			continue
+		}
+		if *f.re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid --%s pattern [%s]: %s", f.flag, pattern, err)
+		}
+	}
+
+	max, err := fs.GetInt("max-pages")
+	if err != nil {
+		panic(err)
+	}
+	if max < 0 {
+		return nil, fmt.Errorf("invalid page count [%d]", max)
+	}
+	filter.max = int(max)
+
+	return filter, nil
+}
+
+// apply returns the URLs the filter selects, in order
+func (f *urlFilter) apply(urls []string) []string {
+	var selected []string
+	for _, u := range urls {
+		if f.max > 0 && len(selected) == f.max {
+			logger.Write(logger.NOTICE, "cleaning the first %d of %d URLs", f.max, len(urls))
+			break
+		}
+		if f.matches(u) {
+			selected = append(selected, u)
+		}
+	}
+	return selected
+}
+
+// matches determines if u passes the include and exclude patterns
+func (f *urlFilter) matches(u string) bool {
+	if f.include != nil && !f.include.MatchString(u) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(u)
+}
+
+// batch holds the settings shared by the pages of a batch
+type batch struct {
+	outputDir string
+	workers   int
+	saveDir   string // a copy of each source is saved here ("" = none)
+	saveAuto  bool   // saved sources are named with the time they're saved
+	filter    *urlFilter
+	feedLinks bool   // clean the pages feed entries link to
+	feedState string // file of feed entries already cleaned
+	format    outputFormat
+	clobber   clobberPolicy            // for files which already exist
+	images    string                   // "images" flag mode
+	assetDir  string                   // where downloaded images are saved
+	assets    *assetSaver              // nil until an image is downloaded or inlined
+	failure   int                      // exit code of the first document which failed
+	progress  func(total int) progress // starts a report of each fetch (nil = none)
+	opts      cleanhtml.FetchOptions
+	mu        sync.Mutex // guards failure, assets and the files written
+}
+
+// newBatch returns a batch writing to outputDir with the
+// default settings
+func newBatch(outputDir string, opts cleanhtml.FetchOptions) *batch {
+	return &batch{outputDir: outputDir, workers: defaultWorkers, filter: &urlFilter{}, format: htmlFormat, opts: opts}
+}
+
+// mkdirs creates the output and save directories
+func (b *batch) mkdirs() error {
+	for _, dir := range []string{b.outputDir, b.saveDir} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logger.Write(logger.FATAL, "could not create output directory [%s]: %s", dir, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanSitemap cleans the pages listed by the sitemap at
+// sitemapURL, returning the exit code
+func (b *batch) cleanSitemap(ctx context.Context, sitemapURL string) int {
+	pages, code := b.sitemapPages(ctx, sitemapURL)
+	if code != 0 {
+		return code
+	}
+	return b.cleanURLs(ctx, pages)
+}
+
+// sitemapPages returns the pages listed by the sitemap at
+// sitemapURL which pass the filter, or the exit code if it
+// can't be read or lists none
+func (b *batch) sitemapPages(ctx context.Context, sitemapURL string) ([]string, int) {
+	pages, err := cleanhtml.ReadSitemap(ctx, sitemapURL, b.opts)
+	if err != nil {
+		logger.Write(logger.FATAL, "Cannot read sitemap [%s]: %s", sitemapURL, err)
+		return nil, fetchExitCode(err)
+	}
+
+	pages = b.filter.apply(pages)
+	if len(pages) == 0 {
+		logger.Write(logger.FATAL, "sitemap [%s] lists no pages to clean", sitemapURL)
+		return nil, exitUsage
+	}
+	return pages, 0
+}
+
+// cleanURLs fetches and cleans each of urls into a file named
+// after its title; a feed has each of its entries cleaned instead.
+// Each page is cleaned and written by the worker which fetched it,
+// so only as many are held at once as there are workers. A page
+// which fails is reported and skipped; the exit code is exitPartial
+// if any did.
+func (b *batch) cleanURLs(ctx context.Context, urls []string) int {
+	if err := b.mkdirs(); err != nil {
+		return exitOutput
+	}
+
+	report := progress(noProgress{})
+	if b.progress != nil {
+		report = b.progress(len(urls))
+	}
+	var mu sync.Mutex // guards used
+	used := make(map[string]bool)
+	codes := make([]int, len(urls))
+	err := cleanhtml.FetchEach(ctx, urls, b.workers, b.opts, func(i int, result cleanhtml.FetchResult) {
+		report.fetched(urls[i], result)
+		codes[i] = b.cleanResult(ctx, urls[i], result, func(name string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			return uniqueName(used, name)
+		})
+	})
+	report.finish()
+	if err != nil {
+		logger.Write(logger.FATAL, "batch abandoned: %s", err)
+	}
+
+	// Failures are recorded in the order of urls, not as they finish
+	var failed int
+	for _, code := range codes {
+		if code != 0 {
+			b.fail(code)
+			failed++
+		}
+	}
+
+	fmt.Printf("%d of %d documents rendered to %q\n", len(urls)-failed, len(urls), b.outputDir)
+	return b.exitCode(len(urls)-failed, failed)
+}
+
+// cleanResult cleans the document fetched from rawurl into a file
+// named after its title, made unique by unique, or each entry of
+// a feed, returning the exit code of a failure
+func (b *batch) cleanResult(ctx context.Context, rawurl string, result cleanhtml.FetchResult, unique func(string) string) int {
+	var typeErr *cleanhtml.ContentTypeError
+	if errors.As(result.Err, &typeErr) && isFeedType(typeErr.ContentType) {
+		return b.cleanFeed(ctx, rawurl)
+	}
+	log := logger.With(logger.Fields{"url": rawurl})
+	if result.Err != nil {
+		log.WriteFields(logger.ERROR, "cannot read", logger.Fields{"error": result.Err})
+		return fetchExitCode(result.Err)
+	}
+	logFetchResult(log, &result)
+
+	name := unique(b.format.withExt(pageName(cleanhtml.Title(result.Body), rawurl)))
+	s := summary{Source: rawurl, ETag: result.ETag, LastModified: result.LastModified, Snapshot: result.Snapshot}
+	_, code := b.renderPage(ctx, result.Body, result.URL, name, s)
+	return code
+}
+
+// renderPage cleans a document, resolving its links against base,
+// and writes it to the file name in the output directory, returning
+// the cleaned document, or logging any failure and returning its
+// exit code
+func (b *batch) renderPage(ctx context.Context, body []byte, base, name string, s summary) (cleanData string, code int) {
+	// A page which panics the cleaner fails on its own, leaving the others
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Write(logger.ERROR, "Could not clean [%s]: panic: %v\n%s", s.Source, r, debug.Stack())
+			cleanData, code = "", exitClean
+		}
+	}()
+
+	if b.saveDir != "" {
+		saveName := strings.TrimSuffix(name, b.format.ext()) + ".html"
+		if b.saveAuto {
+			saveName = timestamped(saveName, time.Now())
+		}
+		saveFile, code := b.writeFile(filepath.Join(b.saveDir, saveName), func(saveFile string) error {
+			return ioutil.WriteFile(saveFile, body, 0644)
+		})
+		if code != 0 {
+			return "", code
+		}
+		logger.Write(logger.INFO, "saving a copy of the source document to %s", saveFile)
+	}
+
+	cleanOpts := cleanhtml.DefaultOptions()
+	cleanOpts.BaseURL = base
+	if b.images == "download" || b.images == "inline" {
+		b.mu.Lock()
+		if b.assets == nil {
+			b.assets = newAssetSaver(ctx, b.opts, b.images == "inline", b.assetDir, b.outputDir)
+		}
+		cleanOpts.ImageSource = b.assets.sourceFor(base)
+		b.mu.Unlock()
+	}
+	cleanData, err := cleanhtml.CleanHTMLWithOptions(ctx, body, cleanOpts)
+	if err != nil {
+		logger.Write(logger.ERROR, "Could not clean [%s]: %s", s.Source, err)
+		return "", exitClean
+	}
+
+	outputFile, code := b.writeFile(filepath.Join(b.outputDir, name), func(outputFile string) error {
+		return writeOutput(outputFile, cleanData, s, b.format)
+	})
+	if code != 0 {
+		return "", code
+	}
+	logger.Write(logger.INFO, "Document from %q rendered to %q", s.Source, outputFile)
+	return cleanData, 0
+}
+
+// writeFile writes the file in place of fileName under the batch's
+// clobber policy with write, returning the file written, or logging
+// any failure and returning its exit code. Files are chosen and
+// written one at a time, so pages cleaned at once don't choose the
+// same one.
+func (b *batch) writeFile(fileName string, write func(string) error) (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	target, err := b.target(fileName)
+	if err != nil {
+		return "", exitExists
+	}
+	if err := write(target); err != nil {
+		logger.Write(logger.ERROR, "could not write [%s]: %s", target, err)
+		return "", exitOutput
+	}
+	return target, 0
+}
+
+// target returns the file to write in place of fileName under
+// the batch's clobber policy, logging a refusal
+func (b *batch) target(fileName string) (string, error) {
+	target, err := b.clobber.target(fileName)
+	if err != nil {
+		logger.Write(logger.ERROR, "not replacing %s", err)
+	}
+	return target, err
+}
+
+// fail records the exit code of a document which failed,
+// keeping that of the first
+func (b *batch) fail(code int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failure == 0 {
+		b.failure = code
+	}
+}
+
+// exitCode returns the exit code of a batch in which rendered
+// documents were rendered and failed were not: exitPartial if
+// there were both, otherwise that of the first failure
+func (b *batch) exitCode(rendered, failed int) int {
+	switch {
+	case failed == 0:
+		return 0
+	case rendered > 0:
+		return exitPartial
+	}
+	return b.failure
+}
+
+// cleanWARC cleans the HTML response records of the WARC file
+// fileName, returning the exit code. Records of other types,
+// and responses which aren't HTML, are skipped.
+func (b *batch) cleanWARC(ctx context.Context, fileName string) int {
+	f, err := os.Open(fileName)
+	if err != nil {
+		logger.Write(logger.FATAL, "Cannot read [%s]: %s", fileName, err)
+		return exitFetch
+	}
+	defer f.Close()
+
+	wr, err := warc.NewReader(f)
+	if err != nil {
+		logger.Write(logger.FATAL, "Cannot read [%s]: %s", fileName, err)
+		return exitFetch
+	}
+	if err := b.mkdirs(); err != nil {
+		return exitOutput
+	}
+
+	used := make(map[string]bool)
+	var rendered, failed int
+	for {
+		rec, err := wr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Write(logger.FATAL, "Cannot read [%s]: %s", fileName, err)
+			return exitFetch
+		}
+		if rec.Type() != "response" || !b.filter.matches(rec.TargetURI()) {
+			continue
+		}
+		if b.filter.max > 0 && rendered+failed == b.filter.max {
+			logger.Write(logger.NOTICE, "stopping after %d records", b.filter.max)
+			break
+		}
+
+		target := rec.TargetURI()
+		resp, err := rec.HTTPResponse()
+		if err != nil {
+			logger.Write(logger.WARNING, "skipping record for [%s]: %s", target, err)
+			continue
+		}
+		if resp.StatusCode != 200 {
+			logger.Write(logger.INFO, "skipping [%s]: status %d", target, resp.StatusCode)
+			continue
+		}
+		body, err := cleanhtml.ReadHTMLResponse(resp, b.opts)
+		if errors.Is(err, cleanhtml.ErrNotHTML) {
+			logger.Write(logger.INFO, "skipping [%s]: %s", target, err)
+			continue
+		}
+		if err != nil {
+			logger.Write(logger.ERROR, "Cannot read record for [%s]: %s", target, err)
+			b.fail(exitFetch)
+			failed++
+			continue
+		}
+
+		name := uniqueName(used, b.format.withExt(pageName(cleanhtml.Title(body), target)))
+		if _, code := b.renderPage(ctx, body, target, name, summary{Source: target}); code != 0 {
+			b.fail(code)
+			failed++
+			continue
+		}
+		rendered++
+	}
+
+	fmt.Printf("%d of %d documents rendered to %q\n", rendered, rendered+failed, b.outputDir)
+	return b.exitCode(rendered, failed)
+}
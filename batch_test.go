@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+func TestCleanSitemap(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>` + ts.URL + `/docs/intro.html</loc></url>
+  <url><loc>` + ts.URL + `/docs/install</loc></url>
+  <url><loc>` + ts.URL + `/blog/news</loc></url>
+</urlset>`))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body><h1>Page " + r.URL.Path + "</h1><p><a href=\"/\">home</a></p></body></html>"))
+		}
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	code := newBatch(dir, cleanhtml.DefaultFetchOptions()).cleanSitemap(context.Background(), ts.URL+"/sitemap.xml")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+	sort.Strings(files)
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	expect := []string{"127.0.0.1-blog-news.html", "127.0.0.1-docs-install.html", "127.0.0.1-docs-intro.html"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Fatalf("Expected %q, got %q", expect, names)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "127.0.0.1-docs-install.html"))
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	for _, want := range []string{"Page /docs/install", `href="` + ts.URL + `/"`, "<!-- cleanpg source="} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in the output, got %q", want, data)
+		}
+	}
+
+	// A missing page is reported without stopping the rest
+	code = newBatch(dir, cleanhtml.FetchOptions{}).cleanURLs(context.Background(), []string{ts.URL + "/a", "http://127.0.0.1:1/dead"})
+	if code != exitPartial {
+		t.Errorf("Expected exit code %d for a failed page, got %d", exitPartial, code)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "127.0.0.1-a.html")); err != nil {
+		t.Errorf("Expected the other page to be written: %v", err)
+	}
+}
+
+func TestURLFilter(t *testing.T) {
+	urls := []string{"https://a.test/docs/1", "https://a.test/blog/1", "https://a.test/docs/2", "https://a.test/docs/3"}
+
+	tests := []struct {
+		filter urlFilter
+		expect []string
+	}{
+		{urlFilter{}, urls},
+		{urlFilter{include: regexp.MustCompile("/docs/")}, []string{urls[0], urls[2], urls[3]}},
+		{urlFilter{exclude: regexp.MustCompile(`/2$`)}, []string{urls[0], urls[1], urls[3]}},
+		{urlFilter{include: regexp.MustCompile("/docs/"), max: 2}, []string{urls[0], urls[2]}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.apply(urls); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+	}
+}
+
+func TestCleanWARC(t *testing.T) {
+	dir := t.TempDir()
+	code := newBatch(dir, cleanhtml.DefaultFetchOptions()).cleanWARC(context.Background(), "warc/testdata/crawl.warc")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(files)
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	expect := []string{"first.html", "second.html"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Fatalf("Expected %q, got %q", expect, names)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "first.html"))
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	if expect := `href="https://example.com/articles/second"`; !strings.Contains(string(data), expect) {
+		t.Errorf("Expected %q in the output, got %q", expect, data)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, "second.html"))
+	if !strings.Contains(string(data), "Café") {
+		t.Errorf("Expected the record converted to UTF-8, got %q", data)
+	}
+
+	// Records can be selected by URL
+	dir = t.TempDir()
+	b := newBatch(dir, cleanhtml.DefaultFetchOptions())
+	b.filter = &urlFilter{exclude: regexp.MustCompile("second")}
+	if code := b.cleanWARC(context.Background(), "warc/testdata/crawl.warc"); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("Expected one page after filtering, got %q", files)
+	}
+}
+
+// Ten URLs on as many hosts are fetched three at a time
+func TestCleanpgMain_Workers(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+
+	var mu sync.Mutex
+	var inFlight, peak int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		time.Sleep(30 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Page " + r.Host + "</title></head><body><h1>Page</h1></body></html>"))
+	})
+
+	args := []string{"cleanpg", "--workers", "3", "-O", t.TempDir()}
+	for i := 0; i < 10; i++ {
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+		args = append(args, ts.URL+"/")
+	}
+	dir := args[4]
+
+	if code := cleanpgMain(args); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.html")); len(files) != 10 {
+		t.Errorf("Expected 10 pages rendered, got %d", len(files))
+	}
+	if peak > 3 || peak < 2 {
+		t.Errorf("Expected up to 3 fetches at once, got a peak of %d", peak)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// Asset is a resource a document refers to, such as an image,
+// as it was served
+type Asset struct {
+	URL         string // location read from, after any redirects
+	Data        []byte // body, unconverted
+	ContentType string // declared or detected media type
+}
+
+// FetchAsset fetches the resource at assetURL as-is, subject to
+// opts.MaxBodyBytes, opts.Retries and the other settings of opts
+// which apply to sending the request. Unlike Fetch, the body is
+// returned whatever its type, without conversion to UTF-8.
+func FetchAsset(ctx context.Context, assetURL string, opts FetchOptions) (*Asset, error) {
+	for attempt := 1; ; attempt++ {
+		asset, retryAfter, err := fetchAssetOnce(ctx, assetURL, opts)
+		if err == nil || attempt > opts.Retries || !isRetryable(err) {
+			return asset, err
+		}
+
+		delay := retryDelay(opts.RetryDelay, attempt, retryAfter)
+		logTo.Write(logger.WARNING, "attempt %d of %d for [%s] failed, retrying in %v: %s",
+			attempt, opts.Retries+1, assetURL, delay.Round(time.Millisecond), err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchAssetOnce makes a single attempt at fetching assetURL,
+// returning the asset, or the error and any Retry-After delay
+func fetchAssetOnce(ctx context.Context, assetURL string, opts FetchOptions) (*Asset, time.Duration, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	req, err := newRequest(ctx, http.MethodGet, assetURL, &opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	client, err := clientFor(&opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	url := resp.Request.URL.String()
+	if resp.StatusCode >= 400 {
+		return nil, parseRetryAfter(resp), &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if opts.MaxBodyBytes > 0 && resp.ContentLength > opts.MaxBodyBytes {
+		return nil, 0, &BodySizeError{URL: url, Limit: opts.MaxBodyBytes, ContentLength: resp.ContentLength}
+	}
+
+	body, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := readBody(body, opts.MaxBodyBytes, url, resp.ContentLength)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &Asset{
+		URL:         url,
+		Data:        data,
+		ContentType: detectContentType(resp.Header.Get("Content-Type"), data),
+	}, 0, nil
+}
@@ -0,0 +1,41 @@
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchAsset(t *testing.T) {
+	// Not valid UTF-8, which must come back unchanged
+	png := append([]byte("\x89PNG\r\n\x1a\n"), 0xff, 0xfe, 0x00, 0x80)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Write(png)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	asset, err := FetchAsset(context.Background(), ts.URL+"/logo.png", FetchOptions{})
+	if err != nil {
+		t.Fatalf("Could not fetch asset: %v", err)
+	}
+	if !bytes.Equal(asset.Data, png) || asset.ContentType != "image/png" {
+		t.Errorf("Expected the PNG unchanged, got %q %q", asset.ContentType, asset.Data)
+	}
+
+	var sizeErr *BodySizeError
+	if _, err := FetchAsset(context.Background(), ts.URL+"/logo.png", FetchOptions{MaxBodyBytes: 4}); !errors.As(err, &sizeErr) {
+		t.Errorf("Expected a BodySizeError, got %v", err)
+	}
+	var statusErr *StatusError
+	if _, err := FetchAsset(context.Background(), ts.URL+"/missing.png", FetchOptions{}); !errors.As(err, &statusErr) {
+		t.Errorf("Expected a StatusError, got %v", err)
+	}
+}
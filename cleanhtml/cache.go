@@ -0,0 +1,190 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// cacheEntry is a fetched document as stored in the cache directory
+type cacheEntry struct {
+	URL          string    `json:"url"`       // normalized request URL
+	FinalURL     string    `json:"final_url"` // after redirects
+	StatusCode   int       `json:"status"`
+	ContentType  string    `json:"content_type"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Stored       time.Time `json:"stored"`
+	Body         []byte    `json:"body"`              // already converted to UTF-8
+	Charset      string    `json:"charset,omitempty"` // forced for the conversion
+}
+
+// result rebuilds the FetchResult the entry was stored from
+func (e *cacheEntry) result() *FetchResult {
+	return &FetchResult{
+		URL:          e.FinalURL,
+		Body:         e.Body,
+		StatusCode:   e.StatusCode,
+		ContentType:  e.ContentType,
+		ETag:         e.ETag,
+		LastModified: e.LastModified,
+	}
+}
+
+// NormalizeURL returns the form of rawurl which tells documents
+// apart, as the cache is keyed by: lowercase scheme and host, no
+// default port or fragment
+func NormalizeURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+
+	return u.String()
+}
+
+// cacheFile returns the name of the entry for rawurl in dir
+func cacheFile(dir, rawurl string) string {
+	sum := sha256.Sum256([]byte(NormalizeURL(rawurl)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// readCacheEntry loads the entry for rawurl. A missing entry and
+// an unreadable one are both misses; the latter is logged.
+func readCacheEntry(dir, rawurl string) (*cacheEntry, bool) {
+	fileName := cacheFile(dir, rawurl)
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+
+	var e cacheEntry
+	if err == nil {
+		err = json.Unmarshal(data, &e)
+	}
+	if err == nil && e.URL != NormalizeURL(rawurl) {
+		err = fmt.Errorf("entry is for [%s]", e.URL)
+	}
+	if err != nil {
+		logTo.Write(logger.WARNING, "ignoring cache entry [%s]: %s", fileName, err)
+		return nil, false
+	}
+
+	return &e, true
+}
+
+// writeCacheEntry stores e, writing a temporary file and renaming
+// it into place so readers never see a partial entry
+func writeCacheEntry(dir string, e *cacheEntry) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), cacheFile(dir, e.URL)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// fetchCached fetches rawurl through the cache in opts.CacheDir.
+// Entries younger than opts.CacheMaxAge are served as they are;
+// older ones are revalidated with a conditional request.
+func fetchCached(ctx context.Context, rawurl string, opts FetchOptions) (*FetchResult, error) {
+	entry, ok := readCacheEntry(opts.CacheDir, rawurl)
+	// A body decoded from another charset must be fetched again
+	if opts.RefreshCache || (ok && entry.Charset != opts.Charset) {
+		ok = false
+	}
+
+	if ok && time.Since(entry.Stored) < opts.CacheMaxAge {
+		logTo.Write(logger.INFO, "cache hit for [%s]", rawurl)
+		return entry.result(), nil
+	}
+
+	// Revalidate, unless the caller is making its own condition
+	revalidating := ok && opts.IfNoneMatch == "" && opts.IfModifiedSince == "" &&
+		(entry.ETag != "" || entry.LastModified != "")
+	if revalidating {
+		opts.IfNoneMatch = entry.ETag
+		opts.IfModifiedSince = entry.LastModified
+	}
+
+	result, err := fetchRetry(ctx, rawurl, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case result.NotModified && revalidating:
+		logTo.Write(logger.INFO, "cache entry for [%s] revalidated", rawurl)
+		entry.Stored = time.Now()
+		result = entry.result()
+	case result.NotModified:
+		// The caller's copy is current, there is nothing to store
+		return result, nil
+	default:
+		entry = &cacheEntry{
+			URL:          NormalizeURL(rawurl),
+			FinalURL:     result.URL,
+			StatusCode:   result.StatusCode,
+			ContentType:  result.ContentType,
+			ETag:         result.ETag,
+			LastModified: result.LastModified,
+			Body:         result.Body,
+			Charset:      opts.Charset,
+			Stored:       time.Now(),
+		}
+	}
+
+	if opts.CacheReadOnly {
+		return result, nil
+	}
+	// A cache which can't be written is no reason to fail the fetch
+	if err := writeCacheEntry(opts.CacheDir, entry); err != nil {
+		logTo.Write(logger.ERROR, "could not cache [%s]: %s", rawurl, err)
+	}
+	return result, nil
+}
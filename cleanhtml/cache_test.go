@@ -0,0 +1,100 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetch_Cache(t *testing.T) {
+	var requests, conditional int
+	version := "v1"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"`+version+`"`)
+		if r.Header.Get("If-None-Match") != "" {
+			conditional++
+			if r.Header.Get("If-None-Match") == `"`+version+`"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>" + version + "</p>"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	fresh := FetchOptions{CacheDir: dir, CacheMaxAge: time.Hour}
+	stale := FetchOptions{CacheDir: dir}
+
+	tests := []struct {
+		name        string
+		url         string
+		opts        FetchOptions
+		change      string // new server version before the fetch
+		expect      string
+		requests    int
+		conditional int
+	}{
+		{"miss", ts.URL, fresh, "", "<p>v1</p>", 1, 0},
+		{"hit", ts.URL, fresh, "", "<p>v1</p>", 1, 0},
+		{"normalized hit", ts.URL + "/#top", fresh, "", "<p>v1</p>", 1, 0},
+		{"revalidated", ts.URL, stale, "", "<p>v1</p>", 2, 1},
+		{"changed", ts.URL, stale, "v2", "<p>v2</p>", 3, 2},
+		{"refresh", ts.URL, FetchOptions{CacheDir: dir, CacheMaxAge: time.Hour, RefreshCache: true}, "v3", "<p>v3</p>", 4, 2},
+		{"hit after refresh", ts.URL, fresh, "", "<p>v3</p>", 4, 2},
+	}
+
+	for _, tt := range tests {
+		if tt.change != "" {
+			version = tt.change
+		}
+		result, err := fetch(context.Background(), tt.url, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: could not fetch: %v", tt.name, err)
+		}
+		if string(result.Body) != tt.expect {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expect, result.Body)
+		}
+		if result.NotModified {
+			t.Errorf("%s: expected the cached document, not a 304", tt.name)
+		}
+		if requests != tt.requests || conditional != tt.conditional {
+			t.Errorf("%s: expected %d requests (%d conditional), got %d (%d)",
+				tt.name, tt.requests, tt.conditional, requests, conditional)
+		}
+	}
+
+	// A corrupt entry is a miss
+	if err := ioutil.WriteFile(cacheFile(dir, ts.URL), []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Could not corrupt entry: %v", err)
+	}
+	result, err := fetch(context.Background(), ts.URL, fresh)
+	if err != nil {
+		t.Fatalf("Could not fetch after corruption: %v", err)
+	}
+	if string(result.Body) != "<p>v3</p>" || requests != 5 {
+		t.Errorf("Expected a fresh fetch after corruption, got %q after %d requests", result.Body, requests)
+	}
+	if _, ok := readCacheEntry(dir, ts.URL); !ok {
+		t.Errorf("Expected the corrupt entry to be replaced")
+	}
+
+	// A read-only cache serves its entries but stores nothing
+	readOnly := FetchOptions{CacheDir: dir, CacheMaxAge: time.Hour, CacheReadOnly: true}
+	if result, err := fetch(context.Background(), ts.URL, readOnly); err != nil || string(result.Body) != "<p>v3</p>" || requests != 5 {
+		t.Errorf("Expected a read-only hit, got %v after %d requests", err, requests)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := fetch(context.Background(), ts.URL+"/other", readOnly); err != nil {
+			t.Fatalf("Could not fetch read-only: %v", err)
+		}
+	}
+	if _, ok := readCacheEntry(dir, ts.URL+"/other"); ok || requests != 7 {
+		t.Errorf("Expected nothing stored by a read-only cache, got %d requests", requests)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/scu/cleanpg/logger"
+	"golang.org/x/net/html"
+)
+
+// H1Selection determines which heading starts rendering
+// in canonical mode, among those of the PostHeadingLevel
+// (h1 unless set otherwise).
+// Possible values:
+// FirstH1 | LastH1 | ContentH1
+type H1Selection int
+
+const (
+	// FirstH1 starts rendering at the first heading in the document
+	FirstH1 H1Selection = iota
+	// LastH1 starts rendering at the last heading in the document
+	LastH1
+	// ContentH1 starts rendering at the first heading followed by at
+	// least contentH1MinText characters of paragraph text, falling
+	// back to the first heading when none qualifies
+	ContentH1
+)
+
+// AnyHeading is the PostHeadingLevel at which any heading,
+// h1 through h6, can start rendering in canonical mode
+const AnyHeading = -1
+
+// contentH1MinText is the amount of paragraph text (in characters)
+// which must follow a heading for it to be selected by ContentH1
+const contentH1MinText = 200
+
+// headingCandidate holds a heading element and the amount of
+// paragraph text which follows it (up to the next candidate)
+type headingCandidate struct {
+	node      *html.Node
+	paragraph int
+}
+
+// headingLevel returns the level of heading tag, e.g. 2 for
+// "h2", or 0 if tag isn't a heading
+func headingLevel(tag string) int {
+	if len(tag) == 2 && (tag[0] == 'h' || tag[0] == 'H') && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+// collectHeadingCandidates walks the document in order and returns
+// each heading of level (1 if unset, or AnyHeading) with its
+// trailing paragraph text length
+func collectHeadingCandidates(doc *html.Node, level int) []headingCandidate {
+	var candidates []headingCandidate
+	if level == 0 {
+		level = 1
+	}
+
+	var walk func(n *html.Node, inParagraph bool)
+	walk = func(n *html.Node, inParagraph bool) {
+		if n.Type == html.ElementNode {
+			if l := headingLevel(n.Data); l != 0 && (level == AnyHeading || l == level) {
+				candidates = append(candidates, headingCandidate{node: n})
+			} else if strings.ToLower(n.Data) == "p" {
+				inParagraph = true
+			}
+		}
+		if n.Type == html.TextNode && inParagraph && len(candidates) > 0 {
+			text := strings.Join(strings.Fields(n.Data), " ")
+			candidates[len(candidates)-1].paragraph += utf8.RuneCountInString(text)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inParagraph)
+		}
+	}
+	walk(doc, false)
+
+	return candidates
+}
+
+// textContent returns the whitespace-collapsed text beneath n
+func textContent(n *html.Node) string {
+	var b strings.Builder
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Heading is a heading element of a document
+type Heading struct {
+	Tag  string // e.g. "h1"
+	Text string // white space collapsed
+}
+
+// CanonicalStart returns the heading at which rendering data
+// with opts starts in canonical mode, or nil when there is none.
+// It is also nil when opts.PostH1Render is off, or canonical
+// mode doesn't apply because of opts.Include.
+func CanonicalStart(data []byte, opts Options) (*Heading, error) {
+	if !opts.PostH1Render || opts.Include != "" {
+		return nil, nil
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, &CleanError{Phase: "parse", Err: err}
+	}
+	if len(opts.Exclude) > 0 {
+		if err := removeExcluded(doc, opts.Exclude); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.DenySubtrees) > 0 {
+		removeDenied(doc, opts.DenySubtrees)
+	}
+
+	n := selectStartHeading(doc, opts.PostHeadingLevel, opts.PostH1Selection)
+	if n == nil {
+		return nil, nil
+	}
+	return &Heading{Tag: strings.ToLower(n.Data), Text: textContent(n)}, nil
+}
+
+// selectStartHeading returns the heading element of level which
+// starts rendering in canonical mode, or nil if the document has none
+func selectStartHeading(doc *html.Node, level int, sel H1Selection) *html.Node {
+	candidates := collectHeadingCandidates(doc, level)
+	if len(candidates) == 0 {
+		logTo.Write(logger.NOTICE, "canonical mode: document has no %s tag", headingName(level))
+		return nil
+	}
+
+	selected := 0
+	switch sel {
+	case LastH1:
+		selected = len(candidates) - 1
+	case ContentH1:
+		for i, c := range candidates {
+			if c.paragraph >= contentH1MinText {
+				selected = i
+				break
+			}
+		}
+	}
+
+	logTo.Write(logger.INFO, "canonical mode: rendering from <%s> %d of %d %q",
+		strings.ToLower(candidates[selected].node.Data), selected+1, len(candidates),
+		textContent(candidates[selected].node))
+
+	return candidates[selected].node
+}
+
+// headingName describes the headings of level for the log,
+// e.g. "<h2>"
+func headingName(level int) string {
+	switch {
+	case level == AnyHeading:
+		return "heading"
+	case level == 0:
+		level = 1
+	}
+	return fmt.Sprintf("<h%d>", level)
+}
@@ -0,0 +1,331 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in a cookies.txt file
+// (lines otherwise starting with '#' are comments)
+const httpOnlyPrefix = "#HttpOnly_"
+
+// cookieEntry holds a single cookie as stored in a cookies.txt file
+type cookieEntry struct {
+	domain   string    // domain without a leading dot
+	hostOnly bool      // only send to domain itself, not subdomains
+	path     string    // path prefix the cookie applies to
+	secure   bool      // only send over https
+	httpOnly bool      // not visible to scripts (kept for the file)
+	expires  time.Time // zero for a session cookie
+	name     string
+	value    string
+}
+
+// expired determines if the entry has passed its expiry time
+func (e *cookieEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !e.expires.After(now)
+}
+
+// matches determines if the entry should be sent to u
+func (e *cookieEntry) matches(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if e.hostOnly {
+		if host != e.domain {
+			return false
+		}
+	} else if host != e.domain && !strings.HasSuffix(host, "."+e.domain) {
+		return false
+	}
+
+	if e.secure && u.Scheme != "https" {
+		return false
+	}
+
+	reqPath := u.Path
+	if reqPath == "" {
+		reqPath = "/"
+	}
+	if reqPath != e.path && !strings.HasPrefix(reqPath, e.path) {
+		return false
+	}
+	if reqPath != e.path && !strings.HasSuffix(e.path, "/") && reqPath[len(e.path)] != '/' {
+		return false
+	}
+
+	return true
+}
+
+// CookieJar is an http.CookieJar which can be loaded from and
+// saved to a Netscape-format cookies.txt file, the format
+// browser cookie exporters and curl use. Expired cookies are
+// never sent or saved. It is safe for concurrent use.
+type CookieJar struct {
+	mu      sync.Mutex
+	entries []cookieEntry
+}
+
+// NewCookieJar returns an empty CookieJar
+func NewCookieJar() *CookieJar {
+	return &CookieJar{}
+}
+
+// LoadCookieJar reads a cookies.txt file into a new CookieJar.
+// A file which doesn't exist yet gives an empty jar, so it
+// can be created by Save.
+func LoadCookieJar(fileName string) (*CookieJar, error) {
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return NewCookieJar(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	jar, err := readCookieJar(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return jar, nil
+}
+
+// readCookieJar parses cookies.txt lines, skipping expired cookies
+func readCookieJar(r io.Reader) (*CookieJar, error) {
+	jar := NewCookieJar()
+	now := time.Now()
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		var e cookieEntry
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			e.httpOnly = true
+			line = line[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNum, len(fields))
+		}
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", lineNum, fields[4])
+		}
+		if expiry > 0 {
+			e.expires = time.Unix(expiry, 0)
+		}
+
+		e.domain = strings.ToLower(strings.TrimPrefix(fields[0], "."))
+		e.hostOnly = !strings.EqualFold(fields[1], "TRUE")
+		e.path = fields[2]
+		e.secure = strings.EqualFold(fields[3], "TRUE")
+		e.name = fields[5]
+		e.value = fields[6]
+
+		if e.domain == "" || e.name == "" {
+			return nil, fmt.Errorf("line %d: missing domain or cookie name", lineNum)
+		}
+		if e.expired(now) {
+			continue
+		}
+		jar.entries = append(jar.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return jar, nil
+}
+
+// Save writes the jar's unexpired cookies to a cookies.txt file
+func (j *CookieJar) Save(fileName string) error {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := j.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// write renders the jar in cookies.txt format
+func (j *CookieJar) write(w io.Writer) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# Netscape HTTP Cookie File")
+
+	now := time.Now()
+	for _, e := range j.entries {
+		if e.expired(now) {
+			continue
+		}
+
+		prefix, domain := "", e.domain
+		if e.httpOnly {
+			prefix = httpOnlyPrefix
+		}
+		if !e.hostOnly {
+			domain = "." + domain
+		}
+		var expiry int64
+		if !e.expires.IsZero() {
+			expiry = e.expires.Unix()
+		}
+
+		fmt.Fprintf(bw, "%s%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			prefix, domain, boolField(!e.hostOnly), e.path, boolField(e.secure),
+			expiry, e.name, e.value)
+	}
+
+	return bw.Flush()
+}
+
+// boolField renders a cookies.txt boolean
+func boolField(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// defaultCookiePath returns the path a cookie applies to when the
+// server omits one (RFC 6265 section 5.1.4)
+func defaultCookiePath(u *url.URL) string {
+	i := strings.LastIndexByte(u.Path, '/')
+	if i <= 0 {
+		return "/"
+	}
+	return u.Path[:i]
+}
+
+// cookieDomain returns the domain a cookie with the Domain attribute
+// domain, set by host, applies to, and whether only to host itself,
+// or false if host may not set it: as net/http/cookiejar does, the
+// domain must be host or one it is under, and not a public suffix
+// such as com or co.uk (RFC 6265 section 5.3)
+func cookieDomain(host, domain string) (string, bool, bool) {
+	host = strings.ToLower(host)
+	if domain == "" {
+		return host, true, true
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || strings.HasSuffix(domain, ".") {
+		return "", false, false
+	}
+
+	// An IP address only sets cookies for itself
+	if net.ParseIP(host) != nil {
+		return host, true, domain == host
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain {
+		// Such as for the host localhost, or a suffix's own site
+		return host, true, host == domain
+	}
+	return domain, false, true
+}
+
+// SetCookies implements the http.CookieJar interface. Cookies for
+// a domain the URL's host may not set, such as another site's or a
+// public suffix's, are ignored.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range cookies {
+		domain, hostOnly, ok := cookieDomain(u.Hostname(), c.Domain)
+		if !ok {
+			continue
+		}
+		e := cookieEntry{
+			domain:   domain,
+			hostOnly: hostOnly,
+			path:     c.Path,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+			name:     c.Name,
+			value:    c.Value,
+		}
+		if e.path == "" || e.path[0] != '/' {
+			e.path = defaultCookiePath(u)
+		}
+		switch {
+		case c.MaxAge < 0:
+			e.expires = now
+		case c.MaxAge > 0:
+			e.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			e.expires = c.Expires
+		}
+
+		j.store(e, now)
+	}
+}
+
+// store replaces any entry with the same domain, path and name,
+// dropping the cookie altogether once it has expired
+func (j *CookieJar) store(e cookieEntry, now time.Time) {
+	for i, old := range j.entries {
+		if old.domain == e.domain && old.path == e.path && old.name == e.name {
+			j.entries = append(j.entries[:i], j.entries[i+1:]...)
+			break
+		}
+	}
+	if !e.expired(now) {
+		j.entries = append(j.entries, e)
+	}
+}
+
+// Cookies implements the http.CookieJar interface
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var matched []cookieEntry
+	now := time.Now()
+	for _, e := range j.entries {
+		if !e.expired(now) && e.matches(u) {
+			matched = append(matched, e)
+		}
+	}
+
+	// Longer (more specific) paths are sent first
+	sort.SliceStable(matched, func(a, b int) bool {
+		return len(matched[a].path) > len(matched[b].path)
+	})
+
+	cookies := make([]*http.Cookie, len(matched))
+	for i, e := range matched {
+		cookies[i] = &http.Cookie{Name: e.name, Value: e.value}
+	}
+	return cookies
+}
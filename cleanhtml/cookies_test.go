@@ -0,0 +1,141 @@
+package cleanhtml
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// consentHandler serves the article only with a consent cookie,
+// which /accept sets
+func consentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if r.URL.Path == "/accept" {
+		http.SetCookie(w, &http.Cookie{Name: "consent", Value: "yes", Path: "/",
+			Expires: time.Now().Add(time.Hour)})
+		return
+	}
+	if c, err := r.Cookie("consent"); err != nil || c.Value != "yes" {
+		w.Write([]byte("consent wall"))
+		return
+	}
+	w.Write([]byte("article"))
+}
+
+func TestFetch_Cookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(consentHandler))
+	defer ts.Close()
+
+	opts := FetchOptions{Cookies: []*http.Cookie{{Name: "consent", Value: "yes"}}}
+	result, err := fetch(context.Background(), ts.URL, opts)
+	if err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if string(result.Body) != "article" {
+		t.Errorf("Expected %q, got %q", "article", result.Body)
+	}
+}
+
+func TestCookieJar_RoundTrip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(consentHandler))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cleanpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jarFile := filepath.Join(dir, "cookies.txt")
+
+	// Accept, then save the jar
+	jar, err := LoadCookieJar(jarFile)
+	if err != nil {
+		t.Fatalf("Could not load missing jar: %v", err)
+	}
+	if _, err := fetch(context.Background(), ts.URL+"/accept", FetchOptions{CookieJar: jar}); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if err := jar.Save(jarFile); err != nil {
+		t.Fatalf("Could not save jar: %v", err)
+	}
+
+	// Add an expired cookie which must be skipped on load
+	f, err := os.OpenFile(jarFile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, "127.0.0.1\tFALSE\t/\tFALSE\t%d\tstale\tgone\n", time.Now().Add(-time.Hour).Unix())
+	f.Close()
+
+	// A fresh jar from the file gets past the consent wall
+	jar, err = LoadCookieJar(jarFile)
+	if err != nil {
+		t.Fatalf("Could not load jar: %v", err)
+	}
+	if len(jar.entries) != 1 {
+		t.Errorf("Expected 1 unexpired cookie, got %d", len(jar.entries))
+	}
+	result, err := fetch(context.Background(), ts.URL, FetchOptions{CookieJar: jar})
+	if err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if string(result.Body) != "article" {
+		t.Errorf("Expected %q, got %q", "article", result.Body)
+	}
+}
+
+func TestCookieJar_SetCookiesDomain(t *testing.T) {
+	jar := &CookieJar{}
+	evil, _ := url.Parse("https://www.evil.example/")
+	jar.SetCookies(evil, []*http.Cookie{
+		{Name: "foreign", Value: "1", Domain: "bank.example"},
+		{Name: "tld", Value: "1", Domain: ".example"},
+		{Name: "com", Value: "1", Domain: "com"},
+		{Name: "parent", Value: "1", Domain: ".evil.example"},
+		{Name: "host", Value: "1"},
+	})
+
+	for rawurl, expect := range map[string]string{
+		"https://bank.example/":      "",
+		"https://other.example/":     "",
+		"https://shop.com/":          "",
+		"https://evil.example/":      "parent",
+		"https://www.evil.example/":  "host parent",
+		"https://cdn.evil.example/x": "parent",
+	} {
+		u, _ := url.Parse(rawurl)
+		var names []string
+		for _, c := range jar.Cookies(u) {
+			names = append(names, c.Name)
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != expect {
+			t.Errorf("%s: expected cookies %q, got %q", rawurl, expect, got)
+		}
+	}
+
+	// A public suffix's own site may set cookies for itself alone
+	jar = &CookieJar{}
+	site, _ := url.Parse("https://github.io/")
+	jar.SetCookies(site, []*http.Cookie{{Name: "own", Value: "1", Domain: "github.io"}})
+	user, _ := url.Parse("https://someone.github.io/")
+	if len(jar.Cookies(site)) != 1 || len(jar.Cookies(user)) != 0 {
+		t.Errorf("Expected the cookie for github.io itself only, got %v and %v", jar.Cookies(site), jar.Cookies(user))
+	}
+}
+
+func TestLoadCookieJar_ParseError(t *testing.T) {
+	_, err := readCookieJar(strings.NewReader("# comment\n\nexample.com\tTRUE\t/\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected an error naming line 3, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/scu/cleanpg/logger"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress wraps r to undo the Content-Encoding named by encoding.
+// With no encoding the stream is still checked for gzip magic bytes,
+// since some servers compress without saying so.
+func decompress(r io.Reader, encoding string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		if magic, _ := br.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+			return br, nil
+		}
+		return gzip.NewReader(br)
+	case "gzip", "x-gzip":
+		return gzip.NewReader(br)
+	case "deflate":
+		// "deflate" should be zlib-wrapped, but raw deflate is common
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(br), nil
+	}
+
+	return nil, fmt.Errorf("cleanhtml: unsupported Content-Encoding %q", encoding)
+}
+
+// isZlibHeader determines if b starts a zlib stream (RFC 1950)
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// LookupCharset returns the canonical name of the encoding
+// label (e.g. "windows-1252" for "latin1"), as FetchOptions.Charset
+// takes it, or an error if it isn't one golang.org/x/text supports
+func LookupCharset(label string) (string, error) {
+	enc, name := charset.Lookup(label)
+	if enc == nil {
+		return "", fmt.Errorf("cleanhtml: unknown charset %q", label)
+	}
+	return name, nil
+}
+
+// toUTF8 converts a document to UTF-8, choosing the source encoding
+// from the charset parameter of contentType, then a <meta> charset
+// in the document, then detection (see charset.DetermineEncoding),
+// unless forced names it. Documents which are already UTF-8 are
+// returned unmodified.
+func toUTF8(data []byte, contentType, forced string) ([]byte, error) {
+	var enc encoding.Encoding
+	var name string
+	if forced != "" {
+		if enc, name = charset.Lookup(forced); enc == nil {
+			return nil, fmt.Errorf("cleanhtml: unknown charset %q", forced)
+		}
+	} else {
+		var certain bool
+		enc, name, certain = charset.DetermineEncoding(data, contentType)
+		// Detection looks only at the first 1024 bytes, defaulting to
+		// windows-1252 when they're ASCII; an undeclared document
+		// valid as UTF-8 throughout is taken to be that
+		if !certain && !declaresCharset(data) && utf8.Valid(data) {
+			return data, nil
+		}
+	}
+	if name == "utf-8" {
+		return data, nil
+	}
+
+	logTo.Write(logger.INFO, "converting document from %s to UTF-8", name)
+	converted, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("cleanhtml: could not convert from %s: %w", name, err)
+	}
+	return converted, nil
+}
+
+// declaresCharset reports whether a <meta> in the first 1024 bytes
+// of a document, where charset.DetermineEncoding looks for one,
+// declares its charset
+func declaresCharset(data []byte) bool {
+	if len(data) > 1024 {
+		data = data[:1024]
+	}
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "meta" {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "charset":
+					return true
+				case "content":
+					if strings.Contains(strings.ToLower(string(val)), "charset=") {
+						return true
+					}
+				}
+			}
+		}
+	}
+}
+
+// decodeBody turns a document body into UTF-8 HTML under opts:
+// it undoes the encoding, enforces the size limit, checks the
+// document is HTML (sniffing when contentType is generic) and
+// converts its charset. size is the declared length, or -1.
+func decodeBody(r io.Reader, encoding, contentType string, size int64, url string, opts FetchOptions) ([]byte, error) {
+	body, err := decompress(r, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readBody(body, opts.MaxBodyBytes, url, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.AllowNonHTML {
+		if mt := detectContentType(contentType, data); !isHTMLType(mt) {
+			return nil, &ContentTypeError{URL: url, ContentType: mt}
+		}
+	}
+
+	// The renderer works in UTF-8
+	return toUTF8(data, contentType, opts.Charset)
+}
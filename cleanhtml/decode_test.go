@@ -0,0 +1,152 @@
+package cleanhtml
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestFetch_Decompress(t *testing.T) {
+	expect := "<!DOCTYPE html><html><body><p>compressed page</p></body></html>"
+
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(expect))
+		w.Close()
+		return buf.Bytes()
+	}
+	gzipped := compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(expect)},
+		{"", gzipped},
+		{"gzip", gzipped},
+		{"deflate", compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"deflate", compress(func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})},
+		{"br", compress(func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) })},
+	}
+
+	// Asking for an encoding ourselves stops the transport decompressing
+	headers := http.Header{}
+	headers.Set("Accept-Encoding", "gzip, deflate, br")
+
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if tt.encoding != "" {
+				w.Header().Set("Content-Encoding", tt.encoding)
+			}
+			w.Write(tt.body)
+		}))
+
+		result, err := fetch(context.Background(), ts.URL, FetchOptions{Headers: headers})
+		ts.Close()
+
+		if err != nil {
+			t.Errorf("%q: could not fetch: %v", tt.encoding, err)
+			continue
+		}
+		if string(result.Body) != expect {
+			t.Errorf("%q: expected %q, got %q", tt.encoding, expect, result.Body)
+		}
+	}
+}
+
+func TestFetch_Charset(t *testing.T) {
+	utf8Document := []byte("<html><body><p>Grüße, naïve café</p></body></html>")
+
+	tests := []struct {
+		fixture     string
+		contentType string
+		expect      []string
+	}{
+		{"testdata/windows-1251.html", "text/html; charset=windows-1251",
+			[]string{"Привет, мир", "французских булок"}},
+		{"testdata/shift_jis.html", "text/html", []string{"こんにちは世界", "日本語のテキストです。"}},
+	}
+
+	for _, tt := range tests {
+		data, err := ioutil.ReadFile(tt.fixture)
+		if err != nil {
+			t.Fatalf("Could not read fixture: %v", err)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			w.Write(data)
+		}))
+
+		result, err := fetch(context.Background(), ts.URL, FetchOptions{})
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: could not fetch: %v", tt.fixture, err)
+			continue
+		}
+		for _, e := range tt.expect {
+			if !strings.Contains(string(result.Body), e) {
+				t.Errorf("%s: expected %q in %q", tt.fixture, e, result.Body)
+			}
+		}
+	}
+
+	// A forced charset overrides what the server says
+	lying := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body><p>Caf\xe9 cr\xe8me</p></body></html>"))
+	}))
+	defer lying.Close()
+	result, err := fetch(context.Background(), lying.URL, FetchOptions{Charset: "latin1"})
+	if err != nil || !strings.Contains(string(result.Body), "Café crème") {
+		t.Errorf("Expected the forced charset decoded, got %q (%v)", result.Body, err)
+	}
+
+	// UTF-8 passes through byte for byte
+	got, err := toUTF8(utf8Document, "text/html; charset=utf-8", "")
+	if err != nil || !bytes.Equal(got, utf8Document) {
+		t.Errorf("Expected UTF-8 document unmodified, got %q (%v)", got, err)
+	}
+
+	// Undeclared UTF-8 whose first 1024 bytes are ASCII isn't taken
+	// for windows-1252
+	undeclared := []byte("<html><head><title>Menu</title>" + strings.Repeat("<!-- padding -->", 80) +
+		"</head><body><p>Caf\u00e9 \u201cnaïve\u201d cr\u00e8me</p></body></html>")
+	got, err = ReadHTMLFrom(bytes.NewReader(undeclared), FetchOptions{})
+	if err != nil || !bytes.Equal(got, undeclared) {
+		t.Errorf("Expected undeclared UTF-8 document unmodified, got %q (%v)", got, err)
+	}
+
+	// A <meta> charset still applies to ASCII-led documents
+	declared := []byte("<html><head><meta charset=\"windows-1252\">" + strings.Repeat("<!-- padding -->", 80) +
+		"</head><body><p>Caf\xe9</p></body></html>")
+	got, err = ReadHTMLFrom(bytes.NewReader(declared), FetchOptions{})
+	if err != nil || !strings.Contains(string(got), "Café") {
+		t.Errorf("Expected the meta charset decoded, got %q (%v)", got, err)
+	}
+}
+
+func TestLookupCharset(t *testing.T) {
+	for label, expect := range map[string]string{"latin1": "windows-1252", "Shift_JIS": "shift_jis", "utf8": "utf-8"} {
+		if name, err := LookupCharset(label); err != nil || name != expect {
+			t.Errorf("%s: expected %q, got %q (%v)", label, expect, name, err)
+		}
+	}
+	if _, err := LookupCharset("klingon"); err == nil {
+		t.Errorf("Expected an error for an unknown charset")
+	}
+}
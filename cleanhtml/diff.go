@@ -0,0 +1,259 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Change is a block of content which Diff found added or removed
+type Change struct {
+	Added bool   // the block is new, otherwise it was removed
+	Block string // the block as Markdown, white space collapsed
+}
+
+// Diff compares two documents produced by CleanHTML block by
+// block (headings, paragraphs, list items, etc.), ignoring
+// differences in markup and white space which don't change how
+// they read. It returns the blocks removed from old and added
+// in new, in document order; none when the content is the same.
+func Diff(old, new string) ([]Change, error) {
+	a, err := contentBlocks(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := contentBlocks(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, e := range diffBlocks(a, b) {
+		blocks := a
+		if e.added {
+			blocks = b
+		}
+		changes = append(changes, Change{Added: e.added, Block: blocks[e.index]})
+	}
+	return changes, nil
+}
+
+// edit is a block removed from the old blocks, or added
+// from the new, at index
+type edit struct {
+	added bool
+	index int
+}
+
+// diffBlocks returns the edits turning a into b, in order
+func diffBlocks(a, b []string) []edit {
+	// Only the blocks between a common prefix and suffix differ
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA, endB = endA-1, endB-1
+	}
+	a, b = a[start:endA], b[start:endB]
+
+	// lcs[i][j] is the length of the longest common
+	// subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{index: start + i})
+			i++
+		default:
+			edits = append(edits, edit{added: true, index: start + j})
+			j++
+		}
+	}
+	return edits
+}
+
+// DiffSummary describes changes in a few words,
+// e.g. "2 blocks added, 1 removed"
+func DiffSummary(changes []Change) string {
+	var added, removed int
+	for _, c := range changes {
+		if c.Added {
+			added++
+		} else {
+			removed++
+		}
+	}
+
+	plural := func(n int) string {
+		if n == 1 {
+			return "block"
+		}
+		return "blocks"
+	}
+	switch {
+	case added == 0 && removed == 0:
+		return "no changes"
+	case removed == 0:
+		return fmt.Sprintf("%d %s added", added, plural(added))
+	case added == 0:
+		return fmt.Sprintf("%d %s removed", removed, plural(removed))
+	}
+	return fmt.Sprintf("%d %s added, %d removed", added, plural(added), removed)
+}
+
+// contentBlocks returns the blocks of a cleaned document as
+// Markdown, with runs of white space collapsed
+func contentBlocks(cleaned string) ([]string, error) {
+	md, err := RenderMarkdown(cleaned)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []string
+	for _, block := range strings.Split(md, "\n\n") {
+		if block = strings.Join(strings.Fields(block), " "); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks, nil
+}
+
+// DiffOptions tunes which differences DiffSections reports
+type DiffOptions struct {
+	IgnoreHeadings bool // headings, as opposed to the text under them
+	IgnoreLinks    bool // link targets, as opposed to the link text
+}
+
+// Kinds of SectionChange
+const (
+	SectionAdded   = "added"
+	SectionRemoved = "removed"
+	SectionChanged = "changed"
+)
+
+// SectionChange is a section of a document, the blocks under
+// a heading, which DiffSections found added, removed or changed
+type SectionChange struct {
+	Heading string   // the heading text, "" for the blocks before the first
+	Kind    string   // SectionAdded, SectionRemoved or SectionChanged
+	Changes []Change // the blocks of the section added and removed
+}
+
+// DiffSections compares two documents produced by CleanHTML as
+// Diff does, returning the sections with blocks added or removed
+// in the order they were first changed. A section whose heading
+// is only in new is added, and one whose heading is only in old
+// removed; one whose heading just moved level is changed. With
+// opts.IgnoreHeadings, sections are still found by their headings
+// but a changed heading alone isn't a change.
+func DiffSections(old, new string, opts DiffOptions) ([]SectionChange, error) {
+	a, err := sectionBlocks(old, opts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := sectionBlocks(new, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []SectionChange
+	byHeading := make(map[string]int) // index in sections
+	for _, e := range diffBlocks(a.compared, b.compared) {
+		blocks := a
+		if e.added {
+			blocks = b
+		}
+		heading := blocks.sections[e.index]
+
+		i, ok := byHeading[heading]
+		if !ok {
+			i = len(sections)
+			byHeading[heading] = i
+			sections = append(sections, SectionChange{Heading: heading, Kind: SectionChanged})
+		}
+		s := &sections[i]
+		if blocks.headings[e.index] {
+			kind := SectionRemoved
+			if e.added {
+				kind = SectionAdded
+			}
+			switch {
+			case len(s.Changes) == 0:
+				s.Kind = kind
+			case s.Kind != kind:
+				s.Kind = SectionChanged
+			}
+		}
+		s.Changes = append(s.Changes, Change{Added: e.added, Block: blocks.blocks[e.index]})
+	}
+	return sections, nil
+}
+
+// sectioned is the blocks of a document and, for each, the
+// section heading it's under, whether it's a heading itself,
+// and the form in which it's compared
+type sectioned struct {
+	blocks, compared, sections []string
+	headings                   []bool
+}
+
+// markdownLink matches a link as written by RenderMarkdown,
+// with its text as the first group
+var markdownLink = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+
+// sectionBlocks returns the blocks of a cleaned document along
+// with the sections they're in, leaving out the headings when
+// they're ignored
+func sectionBlocks(cleaned string, opts DiffOptions) (*sectioned, error) {
+	blocks, err := contentBlocks(cleaned)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sectioned{}
+	var heading string
+	for _, block := range blocks {
+		compared := block
+		if opts.IgnoreLinks {
+			compared = markdownLink.ReplaceAllString(compared, "$1")
+		}
+		isHeading := strings.HasPrefix(block, "#")
+		if isHeading {
+			heading = strings.TrimSpace(strings.TrimLeft(compared, "#"))
+			if opts.IgnoreHeadings {
+				continue
+			}
+		}
+		s.blocks = append(s.blocks, block)
+		s.compared = append(s.compared, compared)
+		s.sections = append(s.sections, heading)
+		s.headings = append(s.headings, isHeading)
+	}
+	return s, nil
+}
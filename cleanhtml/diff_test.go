@@ -0,0 +1,77 @@
+package cleanhtml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := "<html><body><h1>Title</h1><p>One.</p><p>Two.</p><p>Three.</p></body></html>"
+
+	tests := []struct {
+		new     string
+		changes []Change
+		summary string
+	}{
+		// Markup and white space which don't change the text
+		{"<html><head><style>p{}</style></head><body>\n<h1>Title</h1>\n<p>One.</p>\n<p>Two.\n</p><p>  Three.</p></body></html>", nil, "no changes"},
+		{"<html><body><h1>Title</h1><p>One.</p><p>Two, edited.</p><p>Three.</p></body></html>",
+			[]Change{{Block: "Two."}, {Added: true, Block: "Two, edited."}}, "1 block added, 1 removed"},
+		{"<html><body><h1>Title</h1><p>One.</p><p>Two.</p><p>Three.</p><p>Four.</p><p>Five.</p></body></html>",
+			[]Change{{Added: true, Block: "Four."}, {Added: true, Block: "Five."}}, "2 blocks added"},
+		{"<html><body><h1>Title</h1><p>Three.</p></body></html>",
+			[]Change{{Block: "One."}, {Block: "Two."}}, "2 blocks removed"},
+		{"<html><body><h2>Title</h2><p>One.</p><p>Two.</p><p>Three.</p></body></html>",
+			[]Change{{Block: "# Title"}, {Added: true, Block: "## Title"}}, "1 block added, 1 removed"},
+	}
+
+	for _, tt := range tests {
+		changes, err := Diff(old, tt.new)
+		if err != nil {
+			t.Fatalf("Could not diff: %v", err)
+		}
+		if !reflect.DeepEqual(changes, tt.changes) {
+			t.Errorf("%q: expected %+v, got %+v", tt.new, tt.changes, changes)
+		}
+		if summary := DiffSummary(changes); summary != tt.summary {
+			t.Errorf("%q: expected summary %q, got %q", tt.new, tt.summary, summary)
+		}
+	}
+}
+
+func TestDiffSections(t *testing.T) {
+	old := `<html><body><p>Intro.</p><h2>Planting</h2><p>In autumn.</p><p>See <a href="/a">the guide</a>.</p>` +
+		`<h2>Feeding</h2><p>Monthly.</p><h2>Pruning</h2><p>In spring.</p></body></html>`
+
+	tests := []struct {
+		new    string
+		opts   DiffOptions
+		expect []SectionChange
+	}{
+		{old, DiffOptions{}, nil},
+		{`<html><body><p>Intro, edited.</p><h2>Planting</h2><p>In autumn.</p><p>See <a href="/b">the guide</a>.</p>` +
+			`<h2>Pruning</h2><p>In spring.</p><h2>Watering</h2><p>Daily.</p></body></html>`, DiffOptions{},
+			[]SectionChange{
+				{"", SectionChanged, []Change{{Block: "Intro."}, {Added: true, Block: "Intro, edited."}}},
+				{"Planting", SectionChanged, []Change{{Block: "See [the guide](/a)."}, {Added: true, Block: "See [the guide](/b)."}}},
+				{"Feeding", SectionRemoved, []Change{{Block: "## Feeding"}, {Block: "Monthly."}}},
+				{"Watering", SectionAdded, []Change{{Added: true, Block: "## Watering"}, {Added: true, Block: "Daily."}}},
+			}},
+		// Link targets and headings can be ignored
+		{`<html><body><p>Intro.</p><h3>Planting</h3><p>In autumn.</p><p>See <a href="/b">the guide</a>.</p>` +
+			`<h2>Feeding</h2><p>Monthly.</p><h2>Pruning</h2><p>In spring.</p></body></html>`, DiffOptions{IgnoreLinks: true},
+			[]SectionChange{{"Planting", SectionChanged, []Change{{Block: "## Planting"}, {Added: true, Block: "### Planting"}}}}},
+		{`<html><body><p>Intro.</p><h3>Planting</h3><p>In autumn.</p><p>See <a href="/b">the guide</a>.</p>` +
+			`<h2>Feeding</h2><p>Monthly.</p><h2>Pruning</h2><p>In spring.</p></body></html>`, DiffOptions{IgnoreHeadings: true, IgnoreLinks: true}, nil},
+	}
+
+	for _, tt := range tests {
+		sections, err := DiffSections(old, tt.new, tt.opts)
+		if err != nil {
+			t.Fatalf("Could not diff: %v", err)
+		}
+		if !reflect.DeepEqual(sections, tt.expect) {
+			t.Errorf("%q %+v: expected %+v, got %+v", tt.new, tt.opts, tt.expect, sections)
+		}
+	}
+}
@@ -4,7 +4,12 @@
 
 package cleanhtml
 
-import "strings"
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
 
 // nodeElements holds html.ElementNode objects
 type nodeElements struct {
@@ -12,42 +17,47 @@ type nodeElements struct {
 	style      string
 }
 
-var encounteredBodyElement bool = false
-var encounteredFirstH1Element bool = false
-
-// isElementRenderable determines if the key "node"
+// isElementRenderable determines if the key "node.Data"
 // exists in the renderableHTML map
-func isElementRenderable(node string) bool {
-	lcaseTag := strings.ToLower(node)
+func (r *renderer) isElementRenderable(node *html.Node) bool {
+	lcaseTag := strings.ToLower(node.Data)
 	var doRender bool = false
 
 	// Is it in the map
 	if _, ok := renderableHTML[lcaseTag]; ok {
 		doRender = true
 	}
+	if formElements[lcaseTag] && r.opts.FormsAsText {
+		doRender = true
+	}
 
 	// Skip link rendering
-	if lcaseTag == "a" && doRender && !renderLinks {
+	if lcaseTag == "a" && doRender && !r.opts.LinksRender {
+		return false
+	}
+
+	// Images are only rendered on request
+	if lcaseTag == "img" && !r.opts.ImageRender {
 		return false
 	}
 
 	// Special processing directives for "canonical mode"
 	// which indicates only body & div elements are to be
-	// rendered until the first h1 tag is encountered
-	if renderCanonicalMode && doRender {
+	// rendered until the selected heading is encountered
+	if r.opts.PostH1Render && doRender {
 
 		if lcaseTag == "body" {
-			encounteredBodyElement = true
+			r.encounteredBodyElement = true
 		}
 
-		if encounteredBodyElement &&
-			!encounteredFirstH1Element &&
+		if r.encounteredBodyElement &&
+			!r.encounteredStartHeadingElement &&
 			lcaseTag != "body" {
 			doRender = false
 		}
 
-		if lcaseTag == "h1" {
-			encounteredFirstH1Element = true
+		if node == r.startHeading {
+			r.encounteredStartHeadingElement = true
 			doRender = true
 		}
 	}
@@ -78,6 +88,67 @@ func isElementAttributeRenderable(node string, attr string) bool {
 	return false
 }
 
+// DefaultDenySubtrees returns the tag names of the interactive
+// and page chrome elements the cleanpg command denies: forms and
+// their controls, dialogs and templates, whose text is no part
+// of the page's content. A form holding the page is kept all the
+// same, without its controls.
+func DefaultDenySubtrees() []string {
+	return []string{"form", "button", "select", "input", "label", "dialog", "template"}
+}
+
+// removeDenied removes the elements of doc with the tag names of
+// tags, along with their contents. A form holding the page itself,
+// as ASP.NET WebForms and many CMS themes wrap the whole body in
+// one, is kept (see holdsPage), though any denied controls in it go.
+func removeDenied(doc *html.Node, tags []string) {
+	denied := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		denied[strings.ToLower(tag)] = true
+	}
+
+	// Forms go last, once the text left to tell which holds the page
+	var forms []*html.Node
+	var remove func(n *html.Node)
+	remove = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch {
+			case c.Type != html.ElementNode || !denied[strings.ToLower(c.Data)]:
+				remove(c)
+			case c.DataAtom == atom.Form:
+				forms = append(forms, c)
+				remove(c)
+			default:
+				n.RemoveChild(c)
+			}
+			c = next
+		}
+	}
+	remove(doc)
+	if len(forms) == 0 {
+		return
+	}
+
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		body = doc
+	}
+	bodyText := len(textContent(body))
+	for _, form := range forms {
+		if form.Parent != nil && !holdsPage(form, bodyText) {
+			form.Parent.RemoveChild(form)
+		}
+	}
+}
+
+// holdsPage reports whether form holds the page's content rather
+// than being a part of it: its h1, or most of the text of its body,
+// which has bodyText bytes
+func holdsPage(form *html.Node, bodyText int) bool {
+	return findElement(form, atom.H1) != nil || len(textContent(form)) > bodyText/2
+}
+
 // Void elements (can't have any contents)
 // See section 12.1.2 of HTML reference
 var voidElements = map[string]bool{
@@ -103,6 +174,9 @@ var voidElements = map[string]bool{
 var renderableHTML = map[string]nodeElements{
 	// Main root
 	"html": {
+		attributes: []string{
+			"lang",
+		},
 		style: `
 		margin: auto;
 		height: 100%;
@@ -180,9 +254,16 @@ var renderableHTML = map[string]nodeElements{
 	"i":  {},
 	"br": {},
 
-	// Image and multimedia
-	// TODO: option to catalog images
-	//"img": {"src"},
+	// Image and multimedia (with Options.ImageRender)
+	"img": {
+		attributes: []string{
+			"src",
+			"alt",
+			"title",
+			"width",
+			"height",
+		},
+	},
 
 	// Table content
 	"caption":  {},
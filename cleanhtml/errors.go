@@ -0,0 +1,124 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeoutError is returned when a fetch exceeds
+// FetchOptions.Timeout or FetchOptions.HeaderTimeout
+type TimeoutError struct {
+	URL   string        // URL being fetched
+	Phase string        // "request" or "response headers"
+	Limit time.Duration // Timeout which was exceeded
+}
+
+// Error implements the error interface for TimeoutError
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("cleanhtml: timed out after %v waiting for %s from [%s]",
+		e.Limit, e.Phase, e.URL)
+}
+
+// Timeout reports whether the error is a timeout (always true),
+// matching the net.Error interface
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// ErrNotHTML is returned (wrapped in a *ContentTypeError)
+// when the fetched document isn't HTML
+var ErrNotHTML = errors.New("cleanhtml: document is not HTML")
+
+// ContentTypeError reports the type of a non-HTML document
+type ContentTypeError struct {
+	URL         string // URL fetched
+	ContentType string // declared or detected media type
+}
+
+// Error implements the error interface for ContentTypeError
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("cleanhtml: [%s] is %s, not HTML", e.URL, e.ContentType)
+}
+
+// Unwrap returns ErrNotHTML so errors.Is matches it
+func (e *ContentTypeError) Unwrap() error {
+	return ErrNotHTML
+}
+
+// ErrPrivateAddress is returned when FetchOptions.PublicOnly
+// refuses a connection to a non-public address
+var ErrPrivateAddress = errors.New("cleanhtml: address is not public")
+
+// ErrBodyTooLarge is returned (wrapped in a *BodySizeError)
+// when a document exceeds FetchOptions.MaxBodyBytes
+var ErrBodyTooLarge = errors.New("cleanhtml: document too large")
+
+// BodySizeError reports a document larger than the limit
+type BodySizeError struct {
+	URL           string // URL fetched
+	Limit         int64  // FetchOptions.MaxBodyBytes
+	ContentLength int64  // declared by the server, -1 if unknown
+}
+
+// Error implements the error interface for BodySizeError
+func (e *BodySizeError) Error() string {
+	if e.ContentLength >= 0 {
+		return fmt.Sprintf("cleanhtml: [%s] is %d bytes, larger than the %d byte limit",
+			e.URL, e.ContentLength, e.Limit)
+	}
+	return fmt.Sprintf("cleanhtml: [%s] is larger than the %d byte limit", e.URL, e.Limit)
+}
+
+// Unwrap returns ErrBodyTooLarge so errors.Is matches it
+func (e *BodySizeError) Unwrap() error {
+	return ErrBodyTooLarge
+}
+
+// RedirectError is returned when a fetch is redirected more
+// than FetchOptions.MaxRedirects times, or to another host when
+// FetchOptions.SameHostRedirects is set
+type RedirectError struct {
+	Chain  []string // URLs requested, in order
+	Reason string   // why the redirect was refused
+}
+
+// Error implements the error interface for RedirectError
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("cleanhtml: %s: %s", e.Reason, strings.Join(e.Chain, " -> "))
+}
+
+// StatusError is returned when the server responds with
+// an error status (400 and above)
+type StatusError struct {
+	URL        string // URL fetched
+	StatusCode int    // e.g. 404
+	Status     string // e.g. "404 Not Found"
+}
+
+// Error implements the error interface for StatusError
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("cleanhtml: [%s] returned %s", e.URL, e.Status)
+}
+
+// CleanError is returned when a document can't be parsed
+// or rendered
+type CleanError struct {
+	Phase string // "parse" or "render"
+	Err   error  // underlying error
+}
+
+// Error implements the error interface for CleanError
+func (e *CleanError) Error() string {
+	return fmt.Sprintf("cleanhtml: could not %s document: %s", e.Phase, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *CleanError) Unwrap() error {
+	return e.Err
+}
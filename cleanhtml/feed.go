@@ -0,0 +1,252 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Feed is an RSS 2.0 or Atom feed
+type Feed struct {
+	Title   string
+	Entries []FeedEntry
+}
+
+// FeedEntry is an RSS item or Atom entry
+type FeedEntry struct {
+	ID        string // guid or id, else the link
+	Title     string
+	Link      string    // the entry's page
+	Published time.Time // zero when the feed gives no date
+	Content   string    // full HTML content, when the feed has it
+	Summary   string    // description or summary HTML
+}
+
+// rssDocument is the subset of RSS 2.0 used here
+type rssDocument struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			GUID        string `xml:"guid"`
+			PubDate     string `xml:"pubDate"`
+			Description string `xml:"description"`
+			Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// atomDocument is the subset of Atom (RFC 4287) used here
+type atomDocument struct {
+	Title   atomText `xml:"title"`
+	Entries []struct {
+		ID    string   `xml:"id"`
+		Title atomText `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Published string   `xml:"published"`
+		Updated   string   `xml:"updated"`
+		Content   atomText `xml:"content"`
+		Summary   atomText `xml:"summary"`
+	} `xml:"entry"`
+}
+
+// atomText is an Atom text construct, holding text, escaped
+// HTML or inline XHTML according to its type attribute
+type atomText struct {
+	Type  string // "text", "html" or "xhtml"
+	Value string // the HTML or XHTML markup for those types
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface
+func (t *atomText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	t.Type = "text"
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" {
+			t.Type = attr.Value
+		}
+	}
+
+	if t.Type == "xhtml" {
+		var inner struct {
+			XML string `xml:",innerxml"`
+		}
+		if err := d.DecodeElement(&inner, &start); err != nil {
+			return err
+		}
+		t.Value = strings.TrimSpace(inner.XML)
+		return nil
+	}
+
+	var text struct {
+		Text string `xml:",chardata"`
+	}
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return err
+	}
+	t.Value = strings.TrimSpace(text.Text)
+	return nil
+}
+
+// text returns the construct as plain text, for titles
+func (t atomText) text() string {
+	if t.Type == "text" {
+		return t.Value
+	}
+	return strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(t.Value, "")))
+}
+
+// markup returns the construct as HTML, for content
+func (t atomText) markup() string {
+	if t.Type == "text" {
+		return html.EscapeString(t.Value)
+	}
+	return t.Value
+}
+
+// tagPattern matches the tags stripped from HTML titles
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// feedDateLayouts are the date formats found in feeds
+var feedDateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02",
+}
+
+// parseFeedDate parses a feed date, returning the zero time
+// when the format isn't recognized
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ParseFeed parses an RSS 2.0 or Atom feed
+func ParseFeed(data []byte) (*Feed, error) {
+	// Find the root element to know which kind of feed it is
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+		return r, nil
+	}
+	var root xml.StartElement
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("cleanhtml: invalid feed: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+
+	feed := &Feed{}
+	switch root.Name.Local {
+	case "rss":
+		var doc rssDocument
+		if err := dec.DecodeElement(&doc, &root); err != nil {
+			return nil, fmt.Errorf("cleanhtml: invalid RSS feed: %w", err)
+		}
+		feed.Title = strings.TrimSpace(doc.Channel.Title)
+		for _, item := range doc.Channel.Items {
+			e := FeedEntry{
+				ID:        strings.TrimSpace(item.GUID),
+				Title:     strings.TrimSpace(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				Published: parseFeedDate(item.PubDate),
+				Content:   strings.TrimSpace(item.Encoded),
+				Summary:   strings.TrimSpace(item.Description),
+			}
+			if e.ID == "" {
+				e.ID = e.Link
+			}
+			feed.Entries = append(feed.Entries, e)
+		}
+	case "feed":
+		var doc atomDocument
+		if err := dec.DecodeElement(&doc, &root); err != nil {
+			return nil, fmt.Errorf("cleanhtml: invalid Atom feed: %w", err)
+		}
+		feed.Title = doc.Title.text()
+		for _, entry := range doc.Entries {
+			e := FeedEntry{
+				ID:        strings.TrimSpace(entry.ID),
+				Title:     entry.Title.text(),
+				Published: parseFeedDate(entry.Published),
+				Content:   entry.Content.markup(),
+				Summary:   entry.Summary.markup(),
+			}
+			if e.Published.IsZero() {
+				e.Published = parseFeedDate(entry.Updated)
+			}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					e.Link = strings.TrimSpace(link.Href)
+					break
+				}
+			}
+			if e.ID == "" {
+				e.ID = e.Link
+			}
+			feed.Entries = append(feed.Entries, e)
+		}
+	default:
+		return nil, fmt.Errorf("cleanhtml: invalid feed: unexpected <%s> element", root.Name.Local)
+	}
+
+	return feed, nil
+}
+
+// ReadFeed fetches and parses the feed at feedURL, resolving
+// the entries' links against it
+func ReadFeed(ctx context.Context, feedURL string, opts FetchOptions) (*Feed, error) {
+	opts.AllowNonHTML = true
+	opts.FollowMetaRefresh = false
+
+	result, err := fetch(ctx, feedURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Feeds are often served with generic types, so anything
+	// but a page is given to the parser to decide
+	if mt := detectContentType(result.ContentType, result.Body); isHTMLType(mt) {
+		return nil, fmt.Errorf("cleanhtml: [%s] is %s, not a feed", result.URL, mt)
+	}
+	feed, err := ParseFeed(result.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entry links may be relative to the feed
+	if base, err := url.Parse(result.URL); err == nil {
+		for i, e := range feed.Entries {
+			if ref, err := url.Parse(e.Link); err == nil && e.Link != "" {
+				feed.Entries[i].Link = base.ResolveReference(ref).String()
+			}
+		}
+	}
+	return feed, nil
+}
@@ -0,0 +1,123 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		file   string
+		title  string
+		expect []FeedEntry
+	}{
+		{"testdata/feed.rss", "Gardening Weekly", []FeedEntry{
+			{
+				ID:        "garden-101",
+				Title:     "Late winter pruning",
+				Link:      "https://garden.example/posts/pruning",
+				Published: time.Date(2020, 6, 1, 8, 30, 0, 0, time.UTC),
+				Content:   `<p>Prune your <b>roses</b> before the buds break.</p><p><a href="/tools">Tools</a></p>`,
+				Summary:   "When to prune roses.",
+			},
+			{
+				ID:        "https://garden.example/posts/seeds",
+				Title:     "Seed catalogues",
+				Link:      "https://garden.example/posts/seeds",
+				Published: time.Date(2020, 6, 2, 9, 0, 0, 0, time.UTC),
+				Summary:   "<p>The best of this year's catalogues.</p>",
+			},
+		}},
+		{"testdata/feed.atom", "Release Notes", []FeedEntry{
+			{
+				ID:        "tag:notes.example,2020:1.2",
+				Title:     "Version 1.2 & more",
+				Link:      "/notes/1.2",
+				Published: time.Date(2020, 6, 3, 18, 30, 2, 0, time.UTC),
+				Summary:   "Faster rendering.",
+			},
+			{
+				ID:        "tag:notes.example,2020:1.1",
+				Title:     "Version 1.1",
+				Link:      "/notes/1.1",
+				Published: time.Date(2020, 5, 20, 12, 0, 0, 0, time.UTC),
+				Content:   `<div xmlns="http://www.w3.org/1999/xhtml"><p>First <em>stable</em> release.</p></div>`,
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		data, err := ioutil.ReadFile(tt.file)
+		if err != nil {
+			t.Fatalf("Could not read fixture: %v", err)
+		}
+		feed, err := ParseFeed(data)
+		if err != nil {
+			t.Fatalf("%s: could not parse feed: %v", tt.file, err)
+		}
+		if feed.Title != tt.title {
+			t.Errorf("%s: expected title %q, got %q", tt.file, tt.title, feed.Title)
+		}
+		if len(feed.Entries) != len(tt.expect) {
+			t.Fatalf("%s: expected %d entries, got %d", tt.file, len(tt.expect), len(feed.Entries))
+		}
+		for i, e := range feed.Entries {
+			expect := tt.expect[i]
+			if !e.Published.Equal(expect.Published) {
+				t.Errorf("%s: expected date %v, got %v", tt.file, expect.Published, e.Published)
+			}
+			e.Published, expect.Published = time.Time{}, time.Time{}
+			if e != expect {
+				t.Errorf("%s: expected %+v, got %+v", tt.file, expect, e)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "<html><body>not a feed</body></html>", "<rss><channel><item>"} {
+		if _, err := ParseFeed([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestReadFeed(t *testing.T) {
+	rss, err := ioutil.ReadFile("testdata/feed.rss")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write(rss)
+		case "/plain":
+			// Served without a feed type, as many are
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(rss)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body><h1>Page</h1></body></html>"))
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/feed", "/plain"} {
+		feed, err := ReadFeed(context.Background(), ts.URL+path, FetchOptions{})
+		if err != nil {
+			t.Fatalf("Could not read feed %s: %v", path, err)
+		}
+		if len(feed.Entries) != 2 {
+			t.Errorf("Expected 2 entries from %s, got %d", path, len(feed.Entries))
+		}
+	}
+
+	_, err = ReadFeed(context.Background(), ts.URL+"/page", FetchOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not a feed") {
+		t.Errorf("Expected a not a feed error for a page, got %v", err)
+	}
+}
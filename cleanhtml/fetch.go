@@ -0,0 +1,737 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// Version is the version of cleanhtml reported by DefaultUserAgent
+const Version = "1.0.0"
+
+// DefaultUserAgent identifies cleanpg to the servers it fetches from
+const DefaultUserAgent = "cleanpg/" + Version + " (+https://github.com/scu/cleanpg)"
+
+const (
+	// DefaultTimeout bounds an entire fetch, including the body read
+	DefaultTimeout = 30 * time.Second
+	// DefaultHeaderTimeout bounds the wait for response headers
+	DefaultHeaderTimeout = 15 * time.Second
+	// DefaultMaxBodyBytes bounds the size of a fetched document
+	DefaultMaxBodyBytes = 20 << 20
+	// DefaultMaxRedirects bounds the redirects followed by a fetch
+	DefaultMaxRedirects = 10
+	// DefaultRetryDelay is the base delay between fetch retries
+	DefaultRetryDelay = time.Second
+	// DefaultMetaRefreshMaxDelay is the longest meta refresh
+	// treated as a redirect where following them is asked for
+	DefaultMetaRefreshMaxDelay = 5 * time.Second
+	// DefaultHostDelay spaces out FetchAll's requests to a host
+	DefaultHostDelay = 250 * time.Millisecond
+	// DefaultCacheMaxAge bounds the age of cached documents
+	// served without revalidation
+	DefaultCacheMaxAge = 10 * time.Minute
+)
+
+// FetchOptions holds the settings which control how documents
+// are fetched. ReadHTML and ReadHTMLContext share a single
+// default FetchOptions, set through SetFetchOptions.
+type FetchOptions struct {
+	// Timeout bounds the whole fetch, from connecting through
+	// reading the last byte of the body (0 = no limit)
+	Timeout time.Duration
+
+	// HeaderTimeout bounds the wait for the response headers,
+	// i.e. the time to first byte (0 = no limit)
+	HeaderTimeout time.Duration
+
+	// UserAgent is sent with every request, including
+	// redirects (empty = DefaultUserAgent)
+	UserAgent string
+
+	// Headers are added to every request, replacing any
+	// default of the same name (Host is never replaced)
+	Headers http.Header
+
+	// Cookies are added to every request
+	Cookies []*http.Cookie
+
+	// Username and Password are sent as HTTP basic auth when
+	// Username is set, in preference to any in the URL
+	Username string
+	Password string
+
+	// CookieJar, if set, supplies cookies for each request
+	// and stores any the server sets (see LoadCookieJar)
+	CookieJar http.CookieJar
+
+	// AllowNonHTML processes documents whose Content-Type
+	// isn't HTML instead of returning ErrNotHTML
+	AllowNonHTML bool
+
+	// Charset forces the documents to be decoded from the named
+	// encoding, ignoring what the Content-Type, a <meta> charset
+	// and detection say (empty = detect); see LookupCharset
+	Charset string
+
+	// MaxBodyBytes bounds the size of the response body;
+	// larger documents return ErrBodyTooLarge (0 = no limit)
+	MaxBodyBytes int64
+
+	// MaxRedirects bounds the redirects followed
+	// (0 = DefaultMaxRedirects, negative = none)
+	MaxRedirects int
+
+	// SameHostRedirects refuses redirects to another host
+	SameHostRedirects bool
+
+	// Retries is the number of times a transient failure
+	// (network error, 5xx or 429 status) is retried
+	Retries int
+
+	// RetryDelay is the base of the exponential backoff
+	// between retries (0 = DefaultRetryDelay)
+	RetryDelay time.Duration
+
+	// ProxyURL routes requests through an http://, https://
+	// or socks5:// proxy, overriding HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY (empty = use the environment)
+	ProxyURL string
+
+	// NoProxy fetches directly, ignoring the proxies of the
+	// environment; ProxyURL takes precedence
+	NoProxy bool
+
+	// CACertFile names a PEM bundle of certificate authorities
+	// trusted in addition to the system pool
+	CACertFile string
+
+	// ClientCertFile and ClientKeyFile name a PEM certificate
+	// and key presented to servers which require mutual TLS
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// InsecureSkipVerify accepts any server certificate.
+	// Only for development servers; a WARNING is logged.
+	InsecureSkipVerify bool
+
+	// IfNoneMatch and IfModifiedSince hold the ETag and
+	// Last-Modified of a copy the caller already has; when the
+	// server reports it unchanged the result is NotModified
+	IfNoneMatch     string
+	IfModifiedSince string
+
+	// Preflight sends a HEAD request before each GET, so a
+	// document declared as non-HTML or too large is refused
+	// without being downloaded
+	Preflight bool
+
+	// FollowMetaRefresh follows a <meta http-equiv="refresh">
+	// redirect in the document like an HTTP redirect, when its
+	// delay is at most MetaRefreshMaxDelay
+	FollowMetaRefresh   bool
+	MetaRefreshMaxDelay time.Duration
+
+	// WaybackFallback fetches the Wayback Machine's copy of a
+	// page which is gone (404, 410 or an unknown host), using
+	// the availability API at WaybackAPI (empty = DefaultWaybackAPI)
+	WaybackFallback bool
+	WaybackAPI      string
+
+	// HostDelay is the least time between FetchAll starting
+	// requests to the same host (0 = no limit)
+	HostDelay time.Duration
+
+	// Limiter, when set, spaces out FetchAll's requests in place
+	// of HostDelay, so that several calls share its limits
+	Limiter *HostLimiter
+
+	// CacheDir names a directory where fetched documents are
+	// kept, so repeated fetches of a URL are served locally
+	// (empty = no cache)
+	CacheDir string
+
+	// CacheMaxAge is how long a cached document is used without
+	// asking the server; older entries are revalidated with a
+	// conditional request (0 = always revalidate)
+	CacheMaxAge time.Duration
+
+	// RefreshCache skips cached documents, fetching anew and
+	// replacing the cache entry
+	RefreshCache bool
+
+	// CacheReadOnly serves documents from the cache without
+	// storing those fetched, e.g. to leave no files behind
+	CacheReadOnly bool
+
+	// PublicOnly refuses connections to loopback, private,
+	// link-local and unspecified addresses with ErrPrivateAddress,
+	// checked as each connection is dialed so redirects and DNS
+	// can't reach them either. With ProxyURL the proxy's address
+	// is the one checked.
+	PublicOnly bool
+
+	// Client, when set, makes every request in place of the
+	// package's own client. Its Transport and redirect policy
+	// are kept, so ProxyURL and the TLS settings don't apply.
+	Client *http.Client
+}
+
+// FetchResult holds a fetched document
+type FetchResult struct {
+	// URL is the location the document was finally read
+	// from, after any redirects; use it as the document base
+	URL string
+
+	// Body holds the document, converted to UTF-8
+	Body []byte
+
+	// StatusCode is the HTTP status of the final response
+	// (0 for a local file)
+	StatusCode int
+
+	// ContentType is the Content-Type the document was served
+	// with, or the type implied by a local file's extension
+	ContentType string
+
+	// ETag and LastModified are the validators the document was
+	// served with, empty when the server sent none
+	ETag         string
+	LastModified string
+
+	// Elapsed is the time taken by the fetch, including retries
+	Elapsed time.Duration
+
+	// Snapshot is the Wayback Machine copy the document was
+	// read from when the page itself is gone, otherwise empty
+	Snapshot string
+
+	// Err holds the reason a URL couldn't be fetched by FetchAll
+	Err error
+
+	// NotModified reports the server answered a conditional
+	// request with 304 Not Modified; Body is then empty and
+	// the caller's copy is current
+	NotModified bool
+}
+
+// fetchOptions holds the package-level defaults
+var fetchOptions = FetchOptions{
+	Timeout:       DefaultTimeout,
+	HeaderTimeout: DefaultHeaderTimeout,
+	UserAgent:     DefaultUserAgent,
+	MaxBodyBytes:  DefaultMaxBodyBytes,
+	MaxRedirects:  DefaultMaxRedirects,
+	HostDelay:     DefaultHostDelay,
+	CacheMaxAge:   DefaultCacheMaxAge,
+}
+
+// DefaultFetchOptions returns a copy of the package-level
+// options used by ReadHTML and ReadHTMLContext
+func DefaultFetchOptions() FetchOptions {
+	return fetchOptions
+}
+
+// SetFetchOptions replaces the package-level fetch options
+func SetFetchOptions(o FetchOptions) {
+	fetchOptions = o
+}
+
+// SetHTTPTimeout sets the overall time allowed for a fetch
+// (0 = no limit)
+// [default = DefaultTimeout]
+func SetHTTPTimeout(d time.Duration) {
+	fetchOptions.Timeout = d
+}
+
+// SetUserAgent sets the User-Agent header sent with each request
+// [default = DefaultUserAgent]
+func SetUserAgent(ua string) {
+	fetchOptions.UserAgent = ua
+}
+
+// SetHeaders sets additional headers sent with each request
+// [default = none]
+func SetHeaders(headers http.Header) {
+	fetchOptions.Headers = headers
+}
+
+// SetAllowNonHTML sets flag indicating whether documents
+// which aren't HTML are processed anyway
+// [default = false]
+func SetAllowNonHTML(flag bool) {
+	fetchOptions.AllowNonHTML = flag
+}
+
+// SetCharset sets the encoding documents are decoded from,
+// overriding detection ("" = detect)
+// [default = detected]
+func SetCharset(name string) {
+	fetchOptions.Charset = name
+}
+
+// SetMaxBodyBytes sets the largest document which will be
+// read (0 = no limit)
+// [default = DefaultMaxBodyBytes]
+func SetMaxBodyBytes(n int64) {
+	fetchOptions.MaxBodyBytes = n
+}
+
+// SetMaxRedirects sets the most redirects a fetch follows
+// (negative = none)
+// [default = DefaultMaxRedirects]
+func SetMaxRedirects(n int) {
+	fetchOptions.MaxRedirects = n
+}
+
+// SetSameHostRedirects sets flag indicating whether
+// redirects to another host are refused
+// [default = false]
+func SetSameHostRedirects(flag bool) {
+	fetchOptions.SameHostRedirects = flag
+}
+
+// SetRetries sets the number of times a transient fetch
+// failure is retried, waiting an exponentially increasing
+// multiple of delay (0 = DefaultRetryDelay) between attempts
+// [default = 0]
+func SetRetries(retries int, delay time.Duration) {
+	fetchOptions.Retries = retries
+	fetchOptions.RetryDelay = delay
+}
+
+// SetProxy sets the proxy all fetches are routed through
+// [default = from the environment]
+func SetProxy(proxyURL string) {
+	fetchOptions.ProxyURL = proxyURL
+}
+
+// SetNoProxy sets flag indicating whether fetches ignore the
+// proxies of the environment (HTTP_PROXY and the like)
+// [default = false]
+func SetNoProxy(flag bool) {
+	fetchOptions.NoProxy = flag
+}
+
+// SetTLS sets the extra certificate authorities (caCertFile),
+// client certificate and key, and whether server certificates
+// are verified at all (insecure); empty names are ignored
+// [default = system pool, no client certificate, verified]
+func SetTLS(caCertFile, clientCertFile, clientKeyFile string, insecure bool) {
+	fetchOptions.CACertFile = caCertFile
+	fetchOptions.ClientCertFile = clientCertFile
+	fetchOptions.ClientKeyFile = clientKeyFile
+	fetchOptions.InsecureSkipVerify = insecure
+}
+
+// SetHTTPClient sets the client used for every fetch, such as
+// one instrumented with tracing; nil restores the default
+// [default = package client with the default timeouts]
+func SetHTTPClient(client *http.Client) {
+	fetchOptions.Client = client
+}
+
+// SetFollowMetaRefresh sets whether meta refresh redirects
+// with a delay of at most maxDelay are followed
+// [default = false]
+func SetFollowMetaRefresh(follow bool, maxDelay time.Duration) {
+	fetchOptions.FollowMetaRefresh = follow
+	fetchOptions.MetaRefreshMaxDelay = maxDelay
+}
+
+// SetWaybackFallback sets whether the Wayback Machine's copy
+// of a page is fetched when the page itself is gone
+// [default = false]
+func SetWaybackFallback(fallback bool) {
+	fetchOptions.WaybackFallback = fallback
+}
+
+// SetPreflight sets whether a HEAD request checks the
+// type and size of each document before it is fetched
+// [default = false]
+func SetPreflight(preflight bool) {
+	fetchOptions.Preflight = preflight
+}
+
+// SetCache sets the directory fetched documents are cached in
+// (empty = no cache) and whether cached documents are skipped
+// in favor of a fresh fetch (refresh)
+// [default = no cache]
+func SetCache(dir string, refresh bool) {
+	fetchOptions.CacheDir = dir
+	fetchOptions.RefreshCache = refresh
+}
+
+// SetCookies sets cookies sent with each request
+// [default = none]
+func SetCookies(cookies []*http.Cookie) {
+	fetchOptions.Cookies = cookies
+}
+
+// SetBasicAuth sets the credentials sent as HTTP basic auth;
+// an empty user sends none
+// [default = none]
+func SetBasicAuth(user, password string) {
+	fetchOptions.Username = user
+	fetchOptions.Password = password
+}
+
+// SetCookieJar sets the jar which supplies and stores
+// cookies for each request
+// [default = none]
+func SetCookieJar(jar http.CookieJar) {
+	fetchOptions.CookieJar = jar
+}
+
+// readBody reads at most limit bytes from r (0 = no limit),
+// returning an error if there is more
+func readBody(r io.Reader, limit int64, url string, contentLength int64) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	// Read one byte past the limit to detect an oversized body
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, &BodySizeError{URL: url, Limit: limit, ContentLength: contentLength}
+	}
+
+	return data, nil
+}
+
+// sniffLen is the number of bytes examined when sniffing
+// the content type (see http.DetectContentType)
+const sniffLen = 512
+
+// mediaType returns the lowercase media type of a Content-Type
+// value without its parameters
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// isHTMLType determines if a media type is an HTML document
+func isHTMLType(mt string) bool {
+	return mt == "text/html" || mt == "application/xhtml+xml"
+}
+
+// isGenericType determines if a media type says too little
+// about the document, so that the content must be sniffed
+func isGenericType(mt string) bool {
+	return mt == "" || mt == "application/octet-stream" || mt == "text/plain"
+}
+
+// detectContentType returns the media type of a document from its
+// Content-Type header, sniffing data when the header is generic
+func detectContentType(header string, data []byte) string {
+	mt := mediaType(header)
+	if !isGenericType(mt) {
+		return mt
+	}
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	return mediaType(http.DetectContentType(data))
+}
+
+// setHeaders merges headers into the request headers,
+// replacing the defaults but never the Host
+func setHeaders(req *http.Request, headers http.Header) {
+	for key, values := range headers {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			logTo.Write(logger.WARNING, "ignoring Host header for [%s]", req.URL)
+			continue
+		}
+		req.Header.Del(key)
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+}
+
+// checkRedirect enforces the redirect policy in opts; it
+// has the signature of http.Client.CheckRedirect
+func (opts *FetchOptions) checkRedirect(req *http.Request, via []*http.Request) error {
+	max := opts.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+
+	chain := func() []string {
+		var urls []string
+		for _, r := range via {
+			urls = append(urls, r.URL.String())
+		}
+		return append(urls, req.URL.String())
+	}
+
+	if len(via) > max || max < 0 {
+		return &RedirectError{Chain: chain(), Reason: fmt.Sprintf("stopped after %d redirects", len(via))}
+	}
+	if opts.SameHostRedirects && !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		return &RedirectError{Chain: chain(), Reason: "refused redirect to another host"}
+	}
+
+	logTo.Write(logger.INFO, "following redirect to [%s]", req.URL)
+	return nil
+}
+
+// Fetch reads the document at url using opts rather than the
+// package-level fetch options, returning the document along
+// with the final URL it was read from.
+func Fetch(url string, opts FetchOptions) (*FetchResult, error) {
+	return FetchContext(context.Background(), url, opts)
+}
+
+// FetchContext is like Fetch but abandons the request when
+// ctx is done and returns ctx.Err().
+func FetchContext(ctx context.Context, url string, opts FetchOptions) (*FetchResult, error) {
+	return fetch(ctx, url, opts)
+}
+
+// fetch performs a GET of url and reads the body according
+// to opts, retrying transient failures. Local files and file://
+// URLs are read directly; with a CacheDir, repeat fetches are
+// served from the cache.
+func fetch(ctx context.Context, url string, opts FetchOptions) (*FetchResult, error) {
+	start := time.Now()
+
+	result, err := fetchDocument(ctx, url, opts)
+	if err != nil && opts.WaybackFallback && isDeadLink(err) {
+		result, err = fetchSnapshot(ctx, url, err, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.FollowMetaRefresh {
+		if result, err = followMetaRefresh(ctx, url, result, opts); err != nil {
+			return nil, err
+		}
+	} else if _, target := metaRefresh(result.Body); target != "" {
+		logTo.Write(logger.NOTICE, "[%s] has a meta refresh to [%s] which was not followed", result.URL, target)
+	}
+
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
+
+// fetchDocument reads url from a file, the cache or the network
+func fetchDocument(ctx context.Context, url string, opts FetchOptions) (*FetchResult, error) {
+	if path, ok := localPath(url); ok {
+		result, err := readLocal(ctx, path, opts)
+		if err != nil {
+			logTo.Write(logger.FATAL, "Could not read file [%s]: %s", path, err)
+			return nil, err
+		}
+		return result, nil
+	}
+
+	if opts.CacheDir != "" {
+		return fetchCached(ctx, url, opts)
+	}
+	return fetchRetry(ctx, url, opts)
+}
+
+// fetchRetry fetches url from the network, retrying transient failures
+func fetchRetry(ctx context.Context, url string, opts FetchOptions) (*FetchResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, retryAfter, err := fetchOnce(ctx, url, opts)
+		if err == nil {
+			return result, nil
+		}
+
+		if attempt > opts.Retries || !isRetryable(err) {
+			logTo.Write(logger.FATAL, "Could not get url [%s]: %s", url, err)
+			return nil, err
+		}
+
+		delay := retryDelay(opts.RetryDelay, attempt, retryAfter)
+		logTo.Write(logger.WARNING, "attempt %d of %d for [%s] failed, retrying in %v: %s",
+			attempt, opts.Retries+1, url, delay.Round(time.Millisecond), err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WithoutCredentials returns a copy of opts without the Username,
+// Password, Headers and Cookies sent with every request, to fetch
+// from a host they aren't meant for. A CookieJar, which only sends
+// a host its own cookies, is kept.
+func (opts FetchOptions) WithoutCredentials() FetchOptions {
+	opts.Username, opts.Password = "", ""
+	opts.Headers, opts.Cookies = nil, nil
+	return opts
+}
+
+// newRequest creates a request for url carrying the
+// User-Agent, headers, cookies and credentials in opts
+func newRequest(ctx context.Context, method, url string, opts *FetchOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	setHeaders(req, opts.Headers)
+	for _, c := range opts.Cookies {
+		req.AddCookie(c)
+	}
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+
+	return req, nil
+}
+
+// fetchOnce makes a single attempt at fetching url, returning the
+// delay requested by a Retry-After header along with any error
+func fetchOnce(ctx context.Context, url string, opts FetchOptions) (*FetchResult, time.Duration, error) {
+	parent := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// The request is cancelled separately when the headers are late
+	reqCtx, cancelReq := context.WithCancel(ctx)
+	defer cancelReq()
+
+	var headerTimedOut int32
+	fetchErr := func(err error) error {
+		switch {
+		case parent.Err() != nil:
+			return parent.Err()
+		case ctx.Err() == context.DeadlineExceeded:
+			return &TimeoutError{URL: url, Phase: "request", Limit: opts.Timeout}
+		case atomic.LoadInt32(&headerTimedOut) == 1:
+			return &TimeoutError{URL: url, Phase: "response headers", Limit: opts.HeaderTimeout}
+		}
+		return err
+	}
+
+	req, err := newRequest(reqCtx, http.MethodGet, url, &opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if opts.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+	if opts.IfModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", opts.IfModifiedSince)
+	}
+
+	client, err := clientFor(&opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if opts.Preflight {
+		if err := preflight(reqCtx, client, url, &opts); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var timer *time.Timer
+	if opts.HeaderTimeout > 0 {
+		timer = time.AfterFunc(opts.HeaderTimeout, func() {
+			atomic.StoreInt32(&headerTimedOut, 1)
+			cancelReq()
+		})
+	}
+
+	resp, err := client.Do(req)
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		// Report a refused redirect directly
+		var rerr *RedirectError
+		if errors.As(err, &rerr) {
+			return nil, 0, rerr
+		}
+		return nil, 0, fetchErr(err)
+	}
+	defer resp.Body.Close()
+
+	// Errors from here on name the final URL
+	url = resp.Request.URL.String()
+
+	if resp.StatusCode >= 400 {
+		err := &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+		return nil, parseRetryAfter(resp), err
+	}
+
+	// The caller's copy is still current
+	if resp.StatusCode == http.StatusNotModified {
+		logTo.Write(logger.INFO, "[%s] not modified", url)
+		result := &FetchResult{
+			URL:          url,
+			StatusCode:   resp.StatusCode,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			NotModified:  true,
+		}
+		if result.ETag == "" {
+			result.ETag = opts.IfNoneMatch
+		}
+		if result.LastModified == "" {
+			result.LastModified = opts.IfModifiedSince
+		}
+		return result, 0, nil
+	}
+
+	// Reject an explicit non-HTML type before reading the body
+	declaredType := mediaType(resp.Header.Get("Content-Type"))
+	if !opts.AllowNonHTML && !isGenericType(declaredType) && !isHTMLType(declaredType) {
+		return nil, 0, &ContentTypeError{URL: url, ContentType: declaredType}
+	}
+
+	// Don't start reading a body declared too large
+	if opts.MaxBodyBytes > 0 && resp.ContentLength > opts.MaxBodyBytes {
+		return nil, 0, &BodySizeError{URL: url, Limit: opts.MaxBodyBytes, ContentLength: resp.ContentLength}
+	}
+
+	html, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"),
+		resp.Header.Get("Content-Type"), resp.ContentLength, url, opts)
+	if errors.Is(err, ErrBodyTooLarge) || errors.Is(err, ErrNotHTML) {
+		return nil, 0, err
+	}
+	if err != nil {
+		return nil, 0, fetchErr(err)
+	}
+
+	return &FetchResult{
+		URL:          url,
+		Body:         html,
+		StatusCode:   resp.StatusCode,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, 0, nil
+}
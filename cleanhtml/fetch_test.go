@@ -0,0 +1,382 @@
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetch_Timeouts(t *testing.T) {
+	// Stalls before sending any headers
+	lateHeaders := func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}
+
+	tests := []struct {
+		handler http.HandlerFunc
+		opts    FetchOptions
+		phase   string
+	}{
+		{lateHeaders, FetchOptions{HeaderTimeout: 50 * time.Millisecond}, "response headers"},
+		{slowHandler, FetchOptions{Timeout: 50 * time.Millisecond}, "request"},
+	}
+
+	for _, tt := range tests {
+		ts := httptest.NewServer(tt.handler)
+
+		start := time.Now()
+		_, err := fetch(context.Background(), ts.URL, tt.opts)
+		elapsed := time.Since(start)
+		ts.Close()
+
+		terr, ok := err.(*TimeoutError)
+		if !ok {
+			t.Errorf("Expected *TimeoutError, got %T: %v", err, err)
+			continue
+		}
+		if terr.Phase != tt.phase {
+			t.Errorf("Expected phase %q, got %q", tt.phase, terr.Phase)
+		}
+		if elapsed > time.Second {
+			t.Errorf("Expected timeout after 50ms, took %v", elapsed)
+		}
+	}
+}
+
+func TestFetch_Result(t *testing.T) {
+	const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		w.WriteHeader(http.StatusNonAuthoritativeInfo)
+		w.Write([]byte("<p>moved</p>"))
+	}))
+	defer ts.Close()
+
+	result, err := Fetch(ts.URL+"/old", FetchOptions{})
+	if err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+
+	expect := FetchResult{
+		URL:          ts.URL + "/new",
+		Body:         []byte("<p>moved</p>"),
+		StatusCode:   http.StatusNonAuthoritativeInfo,
+		ContentType:  "text/html; charset=utf-8",
+		ETag:         `"v1"`,
+		LastModified: lastModified,
+	}
+	if result.Elapsed < 10*time.Millisecond {
+		t.Errorf("Expected an elapsed time of at least 10ms, got %v", result.Elapsed)
+	}
+	result.Elapsed = 0
+	if !reflect.DeepEqual(*result, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, *result)
+	}
+}
+
+func TestFetch_Conditional(t *testing.T) {
+	const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	etag := `"v1"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>" + etag + "</p>"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		opts        FetchOptions
+		notModified bool
+	}{
+		{"unconditional", FetchOptions{}, false},
+		{"matching etag", FetchOptions{IfNoneMatch: `"v1"`}, true},
+		{"matching date", FetchOptions{IfModifiedSince: lastModified}, true},
+		{"changed etag", FetchOptions{IfNoneMatch: `"v0"`}, false},
+	}
+
+	for _, tt := range tests {
+		result, err := fetch(context.Background(), ts.URL, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: could not fetch: %v", tt.name, err)
+		}
+		if result.NotModified != tt.notModified {
+			t.Errorf("%s: expected NotModified %v, got %v", tt.name, tt.notModified, result.NotModified)
+		}
+		if tt.notModified && len(result.Body) != 0 {
+			t.Errorf("%s: expected no body, got %q", tt.name, result.Body)
+		}
+		if !tt.notModified && string(result.Body) != "<p>\"v1\"</p>" {
+			t.Errorf("%s: expected the document, got %q", tt.name, result.Body)
+		}
+		if result.ETag != etag || result.LastModified != lastModified {
+			t.Errorf("%s: expected validators %s and %s, got %s and %s",
+				tt.name, etag, lastModified, result.ETag, result.LastModified)
+		}
+	}
+}
+
+func TestFetch_UserAgent(t *testing.T) {
+	var got []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.UserAgent())
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.UserAgent())
+		w.Header().Set("Content-Type", "text/html")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		userAgent string
+		expect    string
+	}{
+		{"", DefaultUserAgent},
+		{"test-agent/2.0", "test-agent/2.0"},
+	}
+
+	for _, tt := range tests {
+		got = nil
+		if _, err := fetch(context.Background(), ts.URL+"/moved", FetchOptions{UserAgent: tt.userAgent}); err != nil {
+			t.Fatalf("Could not fetch: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("Expected 2 requests, got %d", len(got))
+		}
+		for _, ua := range got {
+			if ua != tt.expect {
+				t.Errorf("Expected User-Agent %q, got %q", tt.expect, ua)
+			}
+		}
+	}
+}
+
+func TestFetch_Headers(t *testing.T) {
+	var got http.Header
+	var gotHost string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		gotHost = r.Host
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer ts.Close()
+
+	headers := http.Header{}
+	headers.Set("Accept-Language", "de")
+	headers.Set("Referer", "https://example.com/")
+	headers.Set("User-Agent", "custom/1.0")
+	headers.Set("Host", "evil.example.com")
+
+	if _, err := fetch(context.Background(), ts.URL, FetchOptions{Headers: headers}); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+
+	for _, key := range []string{"Accept-Language", "Referer", "User-Agent"} {
+		if got.Get(key) != headers.Get(key) {
+			t.Errorf("Expected %s %q, got %q", key, headers.Get(key), got.Get(key))
+		}
+	}
+	if gotHost == "evil.example.com" {
+		t.Errorf("Expected Host to be left alone, got %q", gotHost)
+	}
+}
+
+// pdfDocument is the start of a PDF file
+var pdfDocument = []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+func TestFetch_BasicAuth(t *testing.T) {
+	var gotAuth []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer ts.Close()
+
+	// base64("bob:hunter2")
+	expect := "Basic Ym9iOmh1bnRlcjI="
+
+	if _, err := fetch(context.Background(), ts.URL, FetchOptions{Username: "bob", Password: "hunter2"}); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	withUserinfo := strings.Replace(ts.URL, "://", "://bob:hunter2@", 1)
+	if _, err := fetch(context.Background(), withUserinfo, FetchOptions{}); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if _, err := fetch(context.Background(), ts.URL, FetchOptions{}); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+
+	for i, want := range []string{expect, expect, ""} {
+		if gotAuth[i] != want {
+			t.Errorf("Request %d: expected Authorization %q, got %q", i+1, want, gotAuth[i])
+		}
+	}
+}
+
+func TestFetch_ContentType(t *testing.T) {
+	htmlDocument := []byte("<!DOCTYPE html><html><body><p>hello</p></body></html>")
+
+	tests := []struct {
+		contentType  string // "" sends no Content-Type header
+		body         []byte
+		allowNonHTML bool
+		expectType   string // "" expects success
+	}{
+		{"text/html; charset=utf-8", htmlDocument, false, ""},
+		{"application/xhtml+xml", htmlDocument, false, ""},
+		{"", htmlDocument, false, ""},
+		{"application/octet-stream", htmlDocument, false, ""},
+		{"application/pdf", pdfDocument, false, "application/pdf"},
+		{"", pdfDocument, false, "application/pdf"},
+		{"application/json", []byte(`{"a": 1}`), false, "application/json"},
+		{"application/pdf", pdfDocument, true, ""},
+	}
+
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType == "" {
+				w.Header()["Content-Type"] = nil
+			} else {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			w.Write(tt.body)
+		}))
+
+		_, err := fetch(context.Background(), ts.URL, FetchOptions{AllowNonHTML: tt.allowNonHTML})
+		ts.Close()
+
+		if tt.expectType == "" {
+			if err != nil {
+				t.Errorf("%q: expected success, got %v", tt.contentType, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrNotHTML) {
+			t.Errorf("%q: expected ErrNotHTML, got %v", tt.contentType, err)
+			continue
+		}
+		if got := err.(*ContentTypeError).ContentType; got != tt.expectType {
+			t.Errorf("%q: expected detected type %q, got %q", tt.contentType, tt.expectType, got)
+		}
+	}
+}
+
+func TestFetch_MaxBodyBytes(t *testing.T) {
+	const limit = 1024
+	const total = 64 << 20
+	chunk := bytes.Repeat([]byte("<p>filler</p>"), 5000)
+
+	tests := []struct {
+		declareLength bool
+		expectLength  int64
+	}{
+		{false, -1},
+		{true, total},
+	}
+
+	for _, tt := range tests {
+		written := make(chan int, 1)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if tt.declareLength {
+				w.Header().Set("Content-Length", strconv.Itoa(total))
+			}
+			n := 0
+			for n < total {
+				m, err := w.Write(chunk)
+				n += m
+				if err != nil {
+					break
+				}
+			}
+			written <- n
+		}))
+
+		_, err := fetch(context.Background(), ts.URL, FetchOptions{MaxBodyBytes: limit})
+		var serr *BodySizeError
+		if !errors.As(err, &serr) {
+			t.Errorf("Expected *BodySizeError, got %v", err)
+		} else if serr.Limit != limit || serr.ContentLength != tt.expectLength {
+			t.Errorf("Expected limit %d and length %d, got %d and %d",
+				limit, tt.expectLength, serr.Limit, serr.ContentLength)
+		}
+
+		// The server must have been cut off well before the end
+		if n := <-written; n >= total {
+			t.Errorf("Expected the body read to stop early, server wrote %d bytes", n)
+		}
+		ts.Close()
+	}
+}
+
+func TestFetch_Redirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer target.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusMovedPermanently))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusFound))
+	mux.Handle("/c", http.RedirectHandler("/page", http.StatusSeeOther))
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	})
+	// Redirects to the target server under another host name
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// Three hops are followed within the limit
+	result, err := fetch(context.Background(), ts.URL+"/a", FetchOptions{MaxRedirects: 3})
+	if err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if expect := ts.URL + "/page"; result.URL != expect {
+		t.Errorf("Expected final URL %q, got %q", expect, result.URL)
+	}
+
+	// Exceeding the limit lists the chain
+	_, err = fetch(context.Background(), ts.URL+"/a", FetchOptions{MaxRedirects: 2})
+	rerr, ok := err.(*RedirectError)
+	if !ok {
+		t.Fatalf("Expected *RedirectError, got %T: %v", err, err)
+	}
+	expectChain := []string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/c", ts.URL + "/page"}
+	if !reflect.DeepEqual(rerr.Chain, expectChain) {
+		t.Errorf("Expected chain %q, got %q", expectChain, rerr.Chain)
+	}
+
+	// Cross-host redirects are refused only when asked
+	if _, err := fetch(context.Background(), ts.URL+"/away", FetchOptions{}); err != nil {
+		t.Errorf("Expected cross-host redirect to be followed, got %v", err)
+	}
+	_, err = fetch(context.Background(), ts.URL+"/away", FetchOptions{SameHostRedirects: true})
+	if _, ok := err.(*RedirectError); !ok {
+		t.Errorf("Expected *RedirectError, got %T: %v", err, err)
+	}
+}
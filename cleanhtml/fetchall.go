@@ -0,0 +1,158 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// HostLimiter spaces out the requests made to each host. It is
+// safe for concurrent use, so one limiter can be shared by several
+// calls of FetchAll through FetchOptions.Limiter.
+type HostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time // earliest start of the next request
+}
+
+// NewHostLimiter returns a limiter starting requests to the same
+// host no more often than interval (0 = no limit)
+func NewHostLimiter(interval time.Duration) *HostLimiter {
+	return &HostLimiter{interval: interval, next: make(map[string]time.Time)}
+}
+
+// Wait blocks until a request for rawurl may start, or returns
+// ctx.Err() if ctx is done first
+func (l *HostLimiter) Wait(ctx context.Context, rawurl string) error {
+	return l.wait(ctx, hostOf(rawurl))
+}
+
+// wait blocks until a request to host may start, or ctx is done
+func (l *HostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval <= 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next[host]
+	if start.Before(now) {
+		start = now
+	}
+	l.next[host] = start.Add(l.interval)
+	l.mu.Unlock()
+
+	return sleepContext(ctx, start.Sub(now))
+}
+
+// hostOf returns the host a fetch of rawurl is made to
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return strings.ToLower(u.Host)
+}
+
+// FetchAll fetches urls concurrently using up to workers fetches
+// at a time (at least 1), starting requests to the same host no
+// more often than opts.HostDelay, or as opts.Limiter allows. Results
+// are in the order of urls; a URL which couldn't be fetched has its
+// error in the result's Err, so one failure doesn't stop the rest,
+// not even a panic. The error returned is
+// ctx.Err() once ctx is done, when the URLs not yet fetched are
+// given the same error.
+func FetchAll(ctx context.Context, urls []string, workers int, opts FetchOptions) ([]FetchResult, error) {
+	return FetchAllFunc(ctx, urls, workers, opts, nil)
+}
+
+// FetchAllFunc is like FetchAll, and also calls done with the
+// index and result of each URL as its fetch completes, e.g. to
+// report progress. Calls may come from several goroutines at once.
+// A failed fetch has its Elapsed set too.
+func FetchAllFunc(ctx context.Context, urls []string, workers int, opts FetchOptions, done func(int, FetchResult)) ([]FetchResult, error) {
+	results := make([]FetchResult, len(urls))
+	err := FetchEach(ctx, urls, workers, opts, func(i int, result FetchResult) {
+		results[i] = result
+		if done != nil {
+			done(i, result)
+		}
+	})
+	return results, err
+}
+
+// FetchEach is like FetchAllFunc but keeps no results: each is only
+// passed to fn, which is called by the worker that fetched it before
+// it fetches another, so no more than workers documents are held at
+// once however many URLs there are. fn is called for every URL,
+// including those given ctx.Err() once ctx is done, and calls may
+// come from several goroutines at once.
+func FetchEach(ctx context.Context, urls []string, workers int, opts FetchOptions, fn func(int, FetchResult)) error {
+	if workers < 1 {
+		workers = 1
+	}
+	limiter := opts.Limiter
+	if limiter == nil {
+		limiter = NewHostLimiter(opts.HostDelay)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				start := time.Now()
+				result := fetchOne(ctx, limiter, urls[i], opts)
+				if result.Err != nil {
+					result.Elapsed = time.Since(start)
+				}
+				fn(i, result)
+			}
+		}()
+	}
+
+	for i := range urls {
+		if ctx.Err() != nil {
+			fn(i, FetchResult{URL: urls[i], Err: ctx.Err()})
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// fetchOne fetches a single URL for FetchAll
+func fetchOne(ctx context.Context, limiter *HostLimiter, rawurl string, opts FetchOptions) (result FetchResult) {
+	// A fetch which panics fails on its own, leaving the others
+	defer func() {
+		if r := recover(); r != nil {
+			logTo.Write(logger.ERROR, "panic fetching [%s]: %v\n%s", rawurl, r, debug.Stack())
+			result = FetchResult{URL: rawurl, Err: fmt.Errorf("cleanhtml: panic fetching [%s]: %v", rawurl, r)}
+		}
+	}()
+
+	if err := limiter.wait(ctx, hostOf(rawurl)); err != nil {
+		return FetchResult{URL: rawurl, Err: err}
+	}
+
+	fetched, err := fetch(ctx, rawurl, opts)
+	if err != nil {
+		return FetchResult{URL: rawurl, Err: err}
+	}
+	return *fetched
+}
@@ -0,0 +1,255 @@
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchAll(t *testing.T) {
+	var inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		// Finish out of order
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if i%5 == 3 {
+			http.Error(w, "gone", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<p>%d</p>", i)
+	}))
+	defer ts.Close()
+
+	var urls []string
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+
+	results, err := FetchAll(context.Background(), urls, 4, FetchOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != len(urls) {
+		t.Fatalf("Expected %d results, got %d", len(urls), len(results))
+	}
+	for i, result := range results {
+		if i%5 == 3 {
+			var serr *StatusError
+			if !errors.As(result.Err, &serr) || serr.StatusCode != http.StatusNotFound {
+				t.Errorf("Result %d: expected a 404 StatusError, got %v", i, result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("Result %d: unexpected error %v", i, result.Err)
+		}
+		if expect := fmt.Sprintf("<p>%d</p>", i); string(result.Body) != expect {
+			t.Errorf("Result %d: expected %q, got %q", i, expect, result.Body)
+		}
+	}
+	if maxInFlight > 4 {
+		t.Errorf("Expected at most 4 concurrent fetches, got %d", maxInFlight)
+	}
+}
+
+func TestFetchAll_HostDelay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer ts.Close()
+
+	urls := []string{ts.URL, ts.URL, ts.URL, ts.URL, ts.URL}
+	start := time.Now()
+	if _, err := FetchAll(context.Background(), urls, 5, FetchOptions{HostDelay: 20 * time.Millisecond}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected requests to one host to be spaced out, took %v", elapsed)
+	}
+}
+
+// A limiter shared by several calls spaces out requests across them
+func TestFetchAll_Limiter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer ts.Close()
+
+	opts := FetchOptions{Limiter: NewHostLimiter(40 * time.Millisecond)}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := FetchAll(context.Background(), []string{ts.URL}, 1, opts); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected the calls' requests to be spaced out, took %v", elapsed)
+	}
+}
+
+// A fetch which panics fails alone
+type panicTransport struct{}
+
+func (panicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/panic" {
+		panic("transport broke")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFetchAll_Panic(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<p>%s</p>", r.URL.Path)
+	}))
+	defer ts.Close()
+
+	urls := []string{ts.URL + "/a", ts.URL + "/panic", ts.URL + "/b"}
+	results, err := FetchAll(context.Background(), urls, 2, FetchOptions{Client: &http.Client{Transport: panicTransport{}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "panic fetching") {
+		t.Errorf("Expected the panic as the error, got %v", results[1].Err)
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Errorf("Result %d: unexpected error %v", i, results[i].Err)
+		}
+	}
+}
+
+func TestFetchAll_Cancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(slowHandler))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	urls := make([]string, 20)
+	for i := range urls {
+		urls[i] = ts.URL
+	}
+
+	start := time.Now()
+	results, err := FetchAll(ctx, urls, 4, FetchOptions{})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected FetchAll to stop promptly, took %v", elapsed)
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	for i, result := range results {
+		if result.Err == nil {
+			t.Errorf("Result %d: expected an error", i)
+		}
+	}
+}
+
+func TestFetchAllFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>page</p>"))
+	}))
+	defer ts.Close()
+
+	urls := []string{ts.URL + "/a", ts.URL + "/missing", ts.URL + "/b"}
+	var mu sync.Mutex
+	done := make(map[int]FetchResult)
+	results, err := FetchAllFunc(context.Background(), urls, 2, FetchOptions{}, func(i int, r FetchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		done[i] = r
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Each fetch is reported once, failures with their time too
+	if len(done) != len(urls) {
+		t.Fatalf("Expected %d fetches reported, got %d", len(urls), len(done))
+	}
+	for i, result := range results {
+		if string(done[i].Body) != string(result.Body) || (done[i].Err == nil) != (result.Err == nil) {
+			t.Errorf("Result %d: expected %+v reported, got %+v", i, result, done[i])
+		}
+		if result.Elapsed <= 0 {
+			t.Errorf("Result %d: expected an elapsed time", i)
+		}
+	}
+}
+
+func TestFetchEach(t *testing.T) {
+	var served int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>page</p>"))
+	}))
+	defer ts.Close()
+
+	var urls []string
+	for i := 0; i < 12; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+
+	// A worker fetches nothing more until its result is handled
+	const workers = 3
+	var handled, inFlight, maxInFlight int32
+	seen := make([]int32, len(urls))
+	err := FetchEach(context.Background(), urls, workers, FetchOptions{}, func(i int, r FetchResult) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		if ahead := atomic.LoadInt32(&served) - atomic.LoadInt32(&handled); ahead > workers {
+			t.Errorf("Result %d: %d documents held at once, expected at most %d", i, ahead, workers)
+		}
+		if r.Err != nil || string(r.Body) != "<p>page</p>" {
+			t.Errorf("Result %d: unexpected %+v", i, r)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&seen[i], 1)
+		atomic.AddInt32(&handled, 1)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i, n := range seen {
+		if n != 1 {
+			t.Errorf("Result %d: handled %d times, expected once", i, n)
+		}
+	}
+	if maxInFlight > workers {
+		t.Errorf("Expected at most %d results handled at once, got %d", workers, maxInFlight)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// Fetcher retrieves documents for cleaning. HTTPFetcher is used
+// by default; other implementations can render pages which need
+// JavaScript, e.g. with a headless browser.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (*FetchResult, error)
+}
+
+// HTTPFetcher fetches documents over HTTP, from local files
+// or through the cache as FetchContext does
+type HTTPFetcher struct {
+	Options FetchOptions
+}
+
+// Fetch implements the Fetcher interface
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string) (*FetchResult, error) {
+	return fetch(ctx, url, f.Options)
+}
+
+// ExecFetcher runs a command to fetch each document, passing the
+// URL as its last argument and reading the HTML from its stdout.
+// The output is checked and converted to UTF-8 under Options as
+// a fetched body would be; the network settings don't apply.
+type ExecFetcher struct {
+	Command []string // program and leading arguments
+	Options FetchOptions
+}
+
+// Fetch implements the Fetcher interface
+func (f *ExecFetcher) Fetch(ctx context.Context, url string) (*FetchResult, error) {
+	if len(f.Command) == 0 {
+		return nil, fmt.Errorf("cleanhtml: no fetcher command")
+	}
+
+	args := append(append([]string{}, f.Command[1:]...), url)
+	cmd := exec.CommandContext(ctx, f.Command[0], args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logTo.Write(logger.INFO, "running [%s] to fetch [%s]", strings.Join(f.Command, " "), url)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("cleanhtml: fetcher [%s] failed: %w: %s", f.Command[0], err, msg)
+		}
+		return nil, fmt.Errorf("cleanhtml: fetcher [%s] failed: %w", f.Command[0], err)
+	}
+
+	html, err := decodeBody(&stdout, "", "", int64(stdout.Len()), url, f.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &FetchResult{URL: url, Body: html}, nil
+}
+
+// fetcher is used by ReadHTML and ReadHTMLContext when set
+var fetcher Fetcher
+
+// SetFetcher sets the Fetcher used by ReadHTML and ReadHTMLContext;
+// nil restores fetching under the package-level fetch options
+// [default = nil]
+func SetFetcher(f Fetcher) {
+	fetcher = f
+}
@@ -0,0 +1,70 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeFetcher serves a fixed document, recording the URLs asked for
+type fakeFetcher struct {
+	urls []string
+}
+
+func (f *fakeFetcher) Fetch(ctx context.Context, url string) (*FetchResult, error) {
+	f.urls = append(f.urls, url)
+	return &FetchResult{URL: url, Body: []byte("<p>rendered by script</p>")}, nil
+}
+
+func TestSetFetcher(t *testing.T) {
+	f := &fakeFetcher{}
+	SetFetcher(f)
+	defer SetFetcher(nil)
+
+	got, err := ReadHTML("https://app.example.com/")
+	if err != nil {
+		t.Fatalf("Could not read: %v", err)
+	}
+	if string(got) != "<p>rendered by script</p>" {
+		t.Errorf("Expected the fake fetcher's document, got %q", got)
+	}
+	if len(f.urls) != 1 || f.urls[0] != "https://app.example.com/" {
+		t.Errorf("Expected the fake fetcher to be asked for the URL, got %q", f.urls)
+	}
+}
+
+func TestExecFetcher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub fetcher is a shell script")
+	}
+
+	script := filepath.Join(t.TempDir(), "render.sh")
+	stub := "#!/bin/sh\n" +
+		"case \"$2\" in\n" +
+		"*fail*) echo \"cannot render $2\" >&2; exit 3 ;;\n" +
+		"esac\n" +
+		"echo \"<html><body><p>$1 rendered $2</p></body></html>\"\n"
+	if err := ioutil.WriteFile(script, []byte(stub), 0755); err != nil {
+		t.Fatalf("Could not write stub: %v", err)
+	}
+
+	f := &ExecFetcher{Command: []string{script, "--dump"}, Options: DefaultFetchOptions()}
+	result, err := f.Fetch(context.Background(), "https://app.example.com/")
+	if err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if expect := "<p>--dump rendered https://app.example.com/</p>"; !strings.Contains(string(result.Body), expect) {
+		t.Errorf("Expected %q in %q", expect, result.Body)
+	}
+	if result.URL != "https://app.example.com/" {
+		t.Errorf("Expected the URL asked for, got %q", result.URL)
+	}
+
+	_, err = f.Fetch(context.Background(), "https://app.example.com/fail")
+	if err == nil || !strings.Contains(err.Error(), "cannot render") {
+		t.Errorf("Expected the command's error output, got %v", err)
+	}
+}
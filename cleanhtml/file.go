@@ -0,0 +1,98 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// localPath returns the file named by rawurl when it is a file://
+// URL or the path of an existing file, so saved pages can be
+// cleaned without a server
+func localPath(rawurl string) (string, bool) {
+	if strings.HasPrefix(strings.ToLower(rawurl), "file:") {
+		u, err := url.Parse(rawurl)
+		if err != nil || (u.Host != "" && u.Host != "localhost") || u.Path == "" {
+			return "", false
+		}
+		return filepath.FromSlash(u.Path), true
+	}
+
+	// Anything else with a scheme is for the network
+	if strings.Contains(rawurl, "://") {
+		return "", false
+	}
+	info, err := os.Stat(rawurl)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	path, err := filepath.Abs(rawurl)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// fileURL returns the file:// URL of an absolute path
+func fileURL(path string) string {
+	slashed := filepath.ToSlash(path)
+	if !strings.HasPrefix(slashed, "/") {
+		// Windows paths begin with a drive letter
+		slashed = "/" + slashed
+	}
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+// readLocal reads the document in file path, applying the same
+// size limit, type check and charset conversion as a fetch. The
+// result's URL is the file's, so relative links resolve against
+// its directory.
+func readLocal(ctx context.Context, path string, opts FetchOptions) (*FetchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fileName := fileURL(path)
+	logTo.Write(logger.INFO, "reading local file [%s]", path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size := int64(-1)
+	var lastModified string
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+		lastModified = info.ModTime().UTC().Format(http.TimeFormat)
+	}
+	if opts.MaxBodyBytes > 0 && size > opts.MaxBodyBytes {
+		return nil, &BodySizeError{URL: fileName, Limit: opts.MaxBodyBytes, ContentLength: size}
+	}
+
+	// The extension stands in for a Content-Type header, though
+	// its charset is a guess and is left to the document itself
+	declaredType := mediaType(mime.TypeByExtension(filepath.Ext(path)))
+
+	html, err := decodeBody(f, "", declaredType, size, fileName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FetchResult{
+		URL:          fileName,
+		Body:         html,
+		ContentType:  declaredType,
+		LastModified: lastModified,
+	}, nil
+}
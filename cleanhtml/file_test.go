@@ -0,0 +1,76 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetch_LocalFile(t *testing.T) {
+	// A saved page in a directory with a space in its name
+	dir := filepath.Join(t.TempDir(), "saved pages")
+	savedPage := filepath.Join(dir, "my page.html")
+	if err := mkdirWrite(savedPage, "<p>saved <a href=\"other.html\">other</a></p>"); err != nil {
+		t.Fatalf("Could not write fixture: %v", err)
+	}
+	absFixture, err := filepath.Abs("testdata/shift_jis.html")
+	if err != nil {
+		t.Fatalf("Could not find fixture: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		expect  string
+		baseDir string
+	}{
+		{"relative path", "testdata/logo-h1.html", "Late winter", "testdata"},
+		{"absolute path", absFixture, "こんにちは世界", "testdata"},
+		{"file URL", "file://" + strings.Replace(filepath.ToSlash(savedPage), " ", "%20", -1), "saved", "saved%20pages"},
+	}
+
+	for _, tt := range tests {
+		result, err := fetch(context.Background(), tt.input, FetchOptions{})
+		if err != nil {
+			t.Errorf("%s: could not read %q: %v", tt.name, tt.input, err)
+			continue
+		}
+		if !strings.Contains(string(result.Body), tt.expect) {
+			t.Errorf("%s: expected body to contain %q, got %q", tt.name, tt.expect, result.Body)
+		}
+		if !strings.HasPrefix(result.URL, "file:///") || !strings.Contains(result.URL, "/"+tt.baseDir+"/") {
+			t.Errorf("%s: expected a file URL in %q, got %q", tt.name, tt.baseDir, result.URL)
+		}
+	}
+
+	// Relative links resolve against the file's directory
+	result, err := fetch(context.Background(), savedPage, FetchOptions{})
+	if err != nil {
+		t.Fatalf("Could not read saved page: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.BaseURL = result.URL
+	got, err := CleanHTMLWithOptions(context.Background(), result.Body, opts)
+	if err != nil {
+		t.Fatalf("Could not clean saved page: %v", err)
+	}
+	expect := fileURL(filepath.Join(dir, "other.html"))
+	if !strings.Contains(got, expect) {
+		t.Errorf("Expected link to %q, got %q", expect, got)
+	}
+
+	if _, err := fetch(context.Background(), "file:///no/such/page.html", FetchOptions{}); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+// mkdirWrite writes data to fileName, creating its directory
+func mkdirWrite(fileName, data string) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, []byte(data), 0644)
+}
@@ -0,0 +1,402 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SetFootnotes sets flag indicating whether footnote markers are
+// renumbered and their notes collected into a References list
+// [default = false]
+func SetFootnotes(flag bool) {
+	options.Footnotes = flag
+}
+
+// markerText matches the text of a footnote reference anchor
+// outside a <sup>, e.g. [3] or [note 2]
+var markerText = regexp.MustCompile(`^\[[^\[\]]{1,12}\]$`)
+
+// footnote is a note referenced by one or more markers
+type footnote struct {
+	number  int
+	content *html.Node // the note's text is beneath it
+	marked  bool       // a marker with the back-link target was rendered
+}
+
+// footnotes holds the footnotes of a document, by marker: those of
+// markers whose note is missing are nil, so the marker is rendered
+// as text
+type footnotes struct {
+	markers map[*html.Node]*footnote
+	notes   []*footnote // numbered in the order they're first referenced
+}
+
+// indexIDs returns the elements of doc by id, the first of each
+func indexIDs(doc *html.Node) map[string]*html.Node {
+	ids := make(map[string]*html.Node)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if id, ok := attr(n, "id"); ok && id != "" && ids[id] == nil {
+				ids[id] = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return ids
+}
+
+// markerFragment returns the fragment an in-page anchor which looks
+// like a footnote reference links to: one within a <sup>, or whose
+// text is a bracketed number or name
+func markerFragment(n *html.Node) (string, bool) {
+	if n.Type != html.ElementNode || n.DataAtom != atom.A {
+		return "", false
+	}
+	href, _ := attr(n, "href")
+	href = strings.TrimSpace(href)
+	if !strings.HasPrefix(href, "#") || len(href) == 1 {
+		return "", false
+	}
+
+	inSup := false
+	for p, depth := n.Parent, 0; p != nil && depth < 3; p, depth = p.Parent, depth+1 {
+		if p.Type == html.ElementNode && p.DataAtom == atom.Sup {
+			inSup = true
+			break
+		}
+	}
+	if !inSup && !markerText.MatchString(textContent(n)) {
+		return "", false
+	}
+	return href[1:], true
+}
+
+// noteContainerWords are those in the class or id of an element
+// holding notes, e.g. MediaWiki's <ol class="references">
+var noteContainerWords = []string{"note", "reference", "citation", "bibliography"}
+
+// maxNoteDepth is how far within its list or notes container a
+// note target may be
+const maxNoteDepth = 3
+
+// isNoteContainer reports whether n is an element of notes: a list,
+// a DPUB-ARIA doc-endnotes or doc-footnote, or one whose class or id
+// names notes
+func isNoteContainer(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if n.DataAtom == atom.Ol {
+		return true
+	}
+	if role, _ := attr(n, "role"); role == "doc-endnotes" || role == "doc-footnote" || role == "doc-endnote" {
+		return true
+	}
+	class, _ := attr(n, "class")
+	id, _ := attr(n, "id")
+	names := strings.ToLower(class + " " + id)
+	for _, word := range noteContainerWords {
+		if strings.Contains(names, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNoteTarget reports whether the target of a footnote marker looks
+// like a note: a list item, or an element of or within a list or
+// notes container, rather than, say, a section a link jumps to
+func isNoteTarget(n *html.Node) bool {
+	if n.DataAtom == atom.Li || isNoteContainer(n) {
+		return true
+	}
+	for p, depth := n.Parent, 0; p != nil && depth < maxNoteDepth; p, depth = p.Parent, depth+1 {
+		if isNoteContainer(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether n is one of nodes or beneath one
+func isWithin(n *html.Node, nodes map[*html.Node]bool) bool {
+	for ; n != nil; n = n.Parent {
+		if nodes[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFootnotes finds the footnote markers of doc, numbering the
+// notes they reference in order, and removes the notes from doc to
+// be rendered at its end instead. ids indexes the document as it was
+// before Include and Exclude, so notes left out of doc are found.
+func collectFootnotes(doc *html.Node, ids map[string]*html.Node) *footnotes {
+	type candidate struct {
+		anchor, target *html.Node
+	}
+	var candidates []candidate
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if fragment, ok := markerFragment(n); ok {
+			candidates = append(candidates, candidate{n, ids[fragment]})
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Anchors linking back to a marker, rather than to a note, are
+	// part of a note
+	anchors := make(map[*html.Node]bool, len(candidates))
+	for _, c := range candidates {
+		anchors[c.anchor] = true
+	}
+	notes := make(map[*html.Node]bool)
+	for _, c := range candidates {
+		if c.target != nil && isNoteTarget(c.target) && !containsAny(c.target, anchors) {
+			notes[c.target] = true
+		}
+	}
+
+	f := &footnotes{markers: make(map[*html.Node]*footnote)}
+	byTarget := make(map[*html.Node]*footnote)
+	for _, c := range candidates {
+		switch {
+		case isWithin(c.anchor, notes):
+			// Within a note itself
+		case c.target == nil:
+			f.markers[c.anchor] = nil
+		case !notes[c.target]:
+			// Linking to something other than a note, so no marker
+		case byTarget[c.target] != nil:
+			f.markers[c.anchor] = byTarget[c.target]
+		default:
+			note := &footnote{number: len(f.notes) + 1, content: noteContent(c.target)}
+			f.notes = append(f.notes, note)
+			byTarget[c.target] = note
+			f.markers[c.anchor] = note
+		}
+	}
+
+	for target := range byTarget {
+		removeNote(target)
+	}
+	return f
+}
+
+// containsAny reports whether one of nodes is beneath n, or n itself
+func containsAny(n *html.Node, nodes map[*html.Node]bool) bool {
+	if nodes[n] {
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if containsAny(c, nodes) {
+			return true
+		}
+	}
+	return false
+}
+
+// noteContent returns the node beneath which the text of the note
+// target is, leaving out back-links: MediaWiki's reference-text span,
+// or else the target itself
+func noteContent(target *html.Node) *html.Node {
+	var find func(n *html.Node) *html.Node
+	find = func(n *html.Node) *html.Node {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if class, _ := attr(c, "class"); strings.Contains(class, "reference-text") {
+				return c
+			}
+			if found := find(c); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	if content := find(target); content != nil {
+		return content
+	}
+	return target
+}
+
+// removeNote removes a note target from its document, along with
+// the elements, such as the list of references, left empty, and the
+// heading of that list
+func removeNote(target *html.Node) {
+	for n := target; n.Parent != nil; {
+		parent := n.Parent
+		prev, next := siblingElement(n, false), siblingElement(n, true)
+		parent.RemoveChild(n)
+		if parent.Type == html.ElementNode && parent.DataAtom != atom.Body && isEmptyElement(parent) {
+			n = parent
+			continue
+		}
+
+		// A heading with nothing under it but the list goes too
+		if n != target && prev != nil && headingLevel(prev.Data) > 0 &&
+			(next == nil || headingLevel(next.Data) > 0) {
+			parent.RemoveChild(prev)
+		}
+		return
+	}
+}
+
+// siblingElement returns the element after n, or with next false
+// before it, or nil if there's none
+func siblingElement(n *html.Node, next bool) *html.Node {
+	for {
+		if next {
+			n = n.NextSibling
+		} else {
+			n = n.PrevSibling
+		}
+		if n == nil || n.Type == html.ElementNode {
+			return n
+		}
+	}
+}
+
+// isEmptyElement reports whether n holds no elements nor any text
+func isEmptyElement(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode || c.Type == html.TextNode && !isTextWhitespace(c.Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBacklink reports whether n is a back-link from a note to its
+// markers, left out of the rendered note: MediaWiki's mw-cite-backlink,
+// or an in-page anchor such as ^ or a letter for each marker
+func isBacklink(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if class, _ := attr(n, "class"); strings.Contains(class, "backlink") {
+		return true
+	}
+	if href, _ := attr(n, "href"); n.DataAtom != atom.A || !strings.HasPrefix(href, "#") {
+		return false
+	}
+	return len([]rune(textContent(n))) <= 1
+}
+
+// renderFootnoteMarker renders n if it's a footnote marker, as its
+// note's number linking to the note, or as its text when there's no
+// note, reporting whether it was one
+func (r *renderer) renderFootnoteMarker(w writer, n *html.Node) (bool, error) {
+	note, ok := r.footnotes.markers[n]
+	if !ok {
+		return false, nil
+	}
+	if r.beforeStartHeading() {
+		return true, nil
+	}
+	if note == nil {
+		return true, escape(w, textContent(n))
+	}
+
+	number := strconv.Itoa(note.number)
+	if !r.opts.LinksRender {
+		_, err := w.WriteString("[" + number + "]")
+		return true, err
+	}
+	id := ""
+	if !note.marked {
+		id = ` id="fnref-` + number + `"`
+		note.marked = true
+	}
+	_, err := w.WriteString(`<sup` + id + `><a href="#fn-` + number + `">[` + number + `]</a></sup>`)
+	return true, err
+}
+
+// renderFootnotes renders the notes of the document, if any, as a
+// References list at the end of its body, each with a back-link to
+// its first marker
+func (r *renderer) renderFootnotes(w writer) error {
+	if r.footnotes == nil || len(r.footnotes.notes) == 0 || r.beforeStartHeading() {
+		return nil
+	}
+
+	heading := "\n<h2"
+	if r.opts.StyleRender {
+		heading += cleanStyle(` style="` + renderableHTML["h2"].style + `"`)
+	}
+	if _, err := w.WriteString(heading + ">References</h2>\n<ol>"); err != nil {
+		return err
+	}
+	for _, note := range r.footnotes.notes {
+		number := strconv.Itoa(note.number)
+		if _, err := w.WriteString("\n<li id=\"fn-" + number + "\">"); err != nil {
+			return err
+		}
+		if err := r.renderNoteText(w, note.content); err != nil {
+			return err
+		}
+		if r.opts.LinksRender && note.marked {
+			if _, err := w.WriteString(` <a href="#fnref-` + number + `">↩</a>`); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteString("</li>"); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("\n</ol>")
+	return err
+}
+
+// renderNoteText renders the children of n as the text of a note:
+// all of their text, within those elements which are renderable
+func (r *renderer) renderNoteText(w writer, n *html.Node) error {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode:
+			if err := escape(w, c.Data); err != nil {
+				return err
+			}
+		case c.Type != html.ElementNode || isBacklink(c):
+		case !r.isElementRenderable(c):
+			if err := r.renderNoteText(w, c); err != nil {
+				return err
+			}
+		default:
+			if err := r.renderStartTag(w, c); err != nil {
+				return err
+			}
+			if voidElements[c.Data] {
+				continue
+			}
+			if err := r.renderNoteText(w, c); err != nil {
+				return err
+			}
+			if err := r.renderCloseTag(w, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFootnotes(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/wikipedia-footnotes.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.StyleRender, opts.Footnotes = false, true
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+
+	for _, expect := range []string{
+		// Renumbered in order, the repeated one keeping its number
+		`another.<sup id="fnref-1"><a href="#fn-1">[1]</a></sup> It has`,
+		`years.<sup id="fnref-2"><a href="#fn-2">[2]</a></sup>`,
+		`diameter,<sup><a href="#fn-1">[1]</a></sup> and`,
+		`trees.<sup id="fnref-3"><a href="#fn-3">[3]</a></sup>`,
+		// Without a note, just text
+		`drying out.[12]`,
+		// The notes, without their back-links
+		"<h2>References</h2>\n<ol>\n<li id=\"fn-1\">Smith, J. (2001). \n<a href=\"https://example.org/grafting\">\n<i>The Grafter&#39;s Handbook</i></a>. London: Orchard Press.",
+		"<li id=\"fn-2\">Pliny the Elder, \n<i>Natural History</i>, book XVII. <a href=\"#fnref-2\">↩</a></li>",
+		"<li id=\"fn-3\">Cleft grafting &amp; top-working, extension leaflet 112. <a href=\"#fnref-3\">↩</a></li>\n</ol></body>",
+	} {
+		if !strings.Contains(got, expect) {
+			t.Errorf("Expected %q, got %q", expect, got)
+		}
+	}
+	for _, reject := range []string{"cite_note", "cite_ref", "^", "[7]", "fn-4"} {
+		if strings.Contains(got, reject) {
+			t.Errorf("Expected no %q, got %q", reject, got)
+		}
+	}
+	if strings.Count(got, "Pliny") != 1 || strings.Count(got, "References") != 1 {
+		t.Errorf("Expected the notes once, got %q", got)
+	}
+
+	text, err := RenderMarkdown(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "\n\n2. Pliny the Elder, _Natural History_, book XVII. ↩\n\n3. Cleft") {
+		t.Errorf("Expected the notes as a numbered list, got %q", text)
+	}
+
+	// Notes left out by an include are found all the same
+	opts.Include = "p"
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, `<a href="#fn-2">[2]</a>`) || !strings.Contains(got, "Pliny") {
+		t.Errorf("Expected the notes of the included paragraph, got %q", got)
+	}
+
+	// Without links, the markers are plain numbers
+	opts.Include, opts.LinksRender = "", false
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, "another.[1] It has") || strings.Contains(got, "fnref") {
+		t.Errorf("Expected markers as text, got %q", got)
+	}
+
+	// Or with Footnotes off, the markers are left as they are
+	opts.Footnotes, opts.LinksRender = false, true
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, `<a href="#cite_note-3">[3]</a>`) || strings.Contains(got, "References</h2>\n<ol>") {
+		t.Errorf("Expected the markers untouched, got %q", got)
+	}
+
+	// Nor are they collected by default
+	if got, err = CleanHTMLWithOptions(context.Background(), data, DefaultOptions()); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, `<a href="#cite_note-3">[3]</a>`) || strings.Contains(got, "fnref") {
+		t.Errorf("Expected the markers untouched by default, got %q", got)
+	}
+}
+
+func TestFootnotes_NotNotes(t *testing.T) {
+	data := []byte(`<html><body><h1 id="top">Pruning</h1>` +
+		`<p>Cut above the bud.<sup><a href="#tools">see tools</a></sup> <a href="#top">[top]</a></p>` +
+		`<div id="tools"><h2>Tools</h2><p>Secateurs and a saw.</p></div></body></html>`)
+
+	opts := DefaultOptions()
+	opts.Footnotes = true
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	for _, expect := range []string{`<a href="#tools">see tools</a>`, `<a href="#top">[top]</a>`, "Secateurs and a saw."} {
+		if !strings.Contains(got, expect) {
+			t.Errorf("Expected %q left in place, got %q", expect, got)
+		}
+	}
+	if strings.Contains(got, "References") {
+		t.Errorf("Expected no References list, got %q", got)
+	}
+}
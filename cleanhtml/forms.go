@@ -0,0 +1,216 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// formElements are the elements rendered with Options.FormsAsText,
+// without their attributes, as the structure of a form; its
+// controls are rendered as text by renderFormControl
+var formElements = map[string]bool{
+	"form":     true,
+	"fieldset": true,
+	"legend":   true,
+	"label":    true,
+}
+
+// buttonStyle styles the span a button is rendered as
+const buttonStyle = `
+	border: 1px solid #888a85;
+	border-radius: 3px;
+	padding: 0 4px;
+	`
+
+// emptyField is the text of a text input with neither a value
+// nor a placeholder
+const emptyField = "[_____]"
+
+// SetFormsAsText sets flag indicating whether forms are rendered
+// as readable text rather than left out
+// [default = false]
+func SetFormsAsText(flag bool) {
+	options.FormsAsText = flag
+}
+
+// attr returns the value of the attribute key of n, and whether
+// it has one
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// renderFormControl renders n as text if it's a form control, as
+// with Options.FormsAsText, reporting whether it was one
+func (r *renderer) renderFormControl(w writer, n *html.Node) (bool, error) {
+	var text string
+	switch strings.ToLower(n.Data) {
+	case "input":
+		var button bool
+		if text, button = inputText(n); button {
+			return true, r.renderButton(w, text)
+		}
+	case "select":
+		text = "[" + selectedOption(n) + "]"
+	case "button":
+		return true, r.renderButton(w, textContent(n))
+	default:
+		return false, nil
+	}
+
+	// Canonical mode skips what's before the start heading
+	if r.beforeStartHeading() || text == "" {
+		return true, nil
+	}
+	return true, escape(w, " "+text)
+}
+
+// inputButtons are the default text of the input types which are
+// buttons
+var inputButtons = map[string]string{
+	"submit": "Submit",
+	"reset":  "Reset",
+	"button": "",
+}
+
+// inputText returns the text an input is rendered as: [x] or [ ]
+// for a checkbox or radio button, and its value or else placeholder
+// in brackets for a text field; hidden inputs have none. For a
+// button, it's the button's text, and button is true.
+func inputText(n *html.Node) (text string, button bool) {
+	inputType, _ := attr(n, "type")
+	inputType = strings.ToLower(inputType)
+	value, _ := attr(n, "value")
+	if defaultText, ok := inputButtons[inputType]; ok {
+		if value == "" {
+			value = defaultText
+		}
+		return value, true
+	}
+
+	switch inputType {
+	case "hidden", "file", "image":
+		return "", false
+	case "checkbox", "radio":
+		if _, checked := attr(n, "checked"); checked {
+			return "[x]", false
+		}
+		return "[ ]", false
+	}
+	if value == "" {
+		value, _ = attr(n, "placeholder")
+	}
+	if strings.TrimSpace(value) == "" {
+		return emptyField, false
+	}
+	return "[" + value + "]", false
+}
+
+// selectedOption returns the text of the selected option of a
+// select element, or its first when none is
+func selectedOption(n *html.Node) string {
+	var first, selected *html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && selected == nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if strings.EqualFold(c.Data, "option") {
+				if first == nil {
+					first = c
+				}
+				if _, ok := attr(c, "selected"); ok {
+					selected = c
+				}
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+
+	if selected == nil {
+		selected = first
+	}
+	if selected == nil {
+		return "_____"
+	}
+	return textContent(selected)
+}
+
+// renderButton renders a button as its text in a span, styled to
+// look like one
+func (r *renderer) renderButton(w writer, text string) error {
+	if r.beforeStartHeading() || text == "" {
+		return nil
+	}
+	if _, err := w.WriteString(" <span"); err != nil {
+		return err
+	}
+	if r.opts.StyleRender {
+		if _, err := w.WriteString(cleanStyle(` style="` + buttonStyle + `"`)); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteByte('>'); err != nil {
+		return err
+	}
+	if err := escape(w, text); err != nil {
+		return err
+	}
+	_, err := w.WriteString("</span>")
+	return err
+}
+
+// beforeStartHeading reports whether canonical mode has yet to reach
+// the start heading within the body, so nothing is rendered
+func (r *renderer) beforeStartHeading() bool {
+	return r.opts.PostH1Render && r.encounteredBodyElement && !r.encounteredStartHeadingElement
+}
+
+// renderTextarea renders the content of a textarea verbatim, as the
+// text of a <pre> or, with Options.FormsAsText, of a read-only
+// textarea, so that code and configuration shown in one isn't lost
+func (r *renderer) renderTextarea(w writer, n *html.Node) error {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	if r.beforeStartHeading() || isTextWhitespace(text.String()) {
+		return nil
+	}
+
+	tag, attrs := "pre", ""
+	if r.opts.FormsAsText {
+		tag, attrs = "textarea", " readonly"
+	} else if r.opts.StyleRender {
+		attrs = cleanStyle(` style="` + renderableHTML["pre"].style + `"`)
+	}
+	if _, err := w.WriteString("\n<" + tag + attrs + ">"); err != nil {
+		return err
+	}
+	// A newline just after the start tag is dropped by parsers,
+	// so a leading one needs another
+	if strings.HasPrefix(text.String(), "\n") {
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := escape(w, text.String()); err != nil {
+		return err
+	}
+	_, err := w.WriteString("</" + tag + ">")
+	return err
+}
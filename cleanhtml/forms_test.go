@@ -0,0 +1,115 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestFormsAsText(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/form.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.FormsAsText = true
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+
+	for _, expect := range []string{
+		"<form>", "<fieldset>", "<legend>Your details</legend>", "<label>Name</label>",
+		" [Ada Gardener]\n",      // text field value
+		" [you@example.com]\n",   // placeholder
+		" [_____]</fieldset>",    // empty field
+		" [x] Tomatoes",          // checked checkbox
+		" [ ] Basil",             // unchecked checkbox
+		" [ ] Small packet",      // unchecked radio
+		" [x] Large packet",      // checked radio
+		" [Express]\n",           // selected option
+		" [North]</fieldset>",    // first option, within an optgroup
+		">Submit</span>",         // submit without a value
+		">Start over</span>",     // reset with one
+		">Save for later</span>", // button, by its text
+	} {
+		if !strings.Contains(got, expect) {
+			t.Errorf("Expected %q, got %q", expect, got)
+		}
+	}
+	for _, reject := range []string{"s3cret-token", "photo", "Standard", "South", "<input", "<select", "<button", "<option", `action=`, `for=`} {
+		if strings.Contains(got, reject) {
+			t.Errorf("Expected no %q, got %q", reject, got)
+		}
+	}
+
+	// The spans are styled as buttons only with style rendering
+	if !strings.Contains(got, `<span style="border: 1px solid #888a85;`) {
+		t.Errorf("Expected styled buttons, got %q", got)
+	}
+	opts.StyleRender = false
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, "<span>Submit</span>") {
+		t.Errorf("Expected an unstyled button, got %q", got)
+	}
+
+	// Without FormsAsText, there is no form, just the labels' text
+	opts = DefaultOptions()
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Contains(got, "<form") || strings.Contains(got, "[x]") || strings.Contains(got, "Submit") {
+		t.Errorf("Expected no form controls, got %q", got)
+	}
+}
+
+func TestRenderTextarea(t *testing.T) {
+	content := "\n\n[server]\n\tlisten = \"0.0.0.0:80\"  # <all> & more\n\n  body = '&lt;/textarea&gt; <b>not bold</b>'\n"
+	data := []byte(`<html><body><h1>Config</h1><textarea readonly rows="6">` + content + `</textarea>` +
+		`<textarea>  </textarea></body></html>`)
+	// As parsed, the first newline is dropped and the entities decoded
+	want := strings.Replace(strings.TrimPrefix(content, "\n"), "&lt;/textarea&gt;", "</textarea>", 1)
+
+	for _, formsAsText := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.FormsAsText = formsAsText
+		got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+		if err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+
+		tag, other := "pre", "textarea"
+		if formsAsText {
+			tag, other = other, tag
+		}
+		if strings.Count(got, "<"+tag) != 1 || strings.Contains(got, "<"+other) || strings.Contains(got, "<b>") {
+			t.Errorf("FormsAsText %v: expected the content in a single %s, got %q", formsAsText, tag, got)
+		}
+
+		// Read back, the content is as it was
+		doc, err := html.Parse(strings.NewReader(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text string
+		var find func(n *html.Node)
+		find = func(n *html.Node) {
+			if n.Type == html.ElementNode && n.Data == tag && n.FirstChild != nil {
+				text = n.FirstChild.Data
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				find(c)
+			}
+		}
+		find(doc)
+		if text != want {
+			t.Errorf("FormsAsText %v: read back %q, want %q", formsAsText, text, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// galleryStyle lays out the gallery as a grid of captioned images
+const galleryStyle = `
+	display: flex;
+	flex-wrap: wrap;
+	gap: 16px;
+	`
+
+// galleryFigureStyle styles each image of the gallery with its caption
+const galleryFigureStyle = `
+	margin: 0;
+	width: 240px;
+	font-size: 90%;
+	`
+
+// galleryImageStyle fits an image to its place in the gallery
+const galleryImageStyle = `
+	max-width: 100%;
+	height: auto;
+	`
+
+// SetGallery sets flag indicating whether images are rendered in a
+// gallery at the end of the document rather than in place, and the
+// most rendered there (0 = no limit)
+// [default = false, 0]
+func SetGallery(flag bool, max int) {
+	options.Gallery = flag
+	options.GalleryMax = max
+}
+
+// galleryImage is an image of the gallery
+type galleryImage struct {
+	img     *html.Node
+	caption string
+}
+
+// collectGalleryImage adds n to the gallery if it's an image or a
+// figure holding one, with the figure's caption or the image's alt
+// text, reporting whether it was one. An image already in the
+// gallery isn't added again, nor any past Options.GalleryMax.
+func (r *renderer) collectGalleryImage(n *html.Node) bool {
+	var img *html.Node
+	switch n.DataAtom {
+	case atom.Img:
+		img = n
+	case atom.Figure:
+		if img = findElement(n, atom.Img); img == nil {
+			return false
+		}
+	default:
+		return false
+	}
+	if r.beforeStartHeading() {
+		return true
+	}
+
+	src, _ := attr(img, "src")
+	src = r.resolveURL(src)
+	if strings.TrimSpace(src) == "" || r.gallerySrcs[src] ||
+		r.opts.GalleryMax > 0 && len(r.gallery) >= r.opts.GalleryMax {
+		return true
+	}
+	if r.gallerySrcs == nil {
+		r.gallerySrcs = make(map[string]bool)
+	}
+	r.gallerySrcs[src] = true
+
+	caption, _ := attr(img, "alt")
+	if figcaption := findElement(n, atom.Figcaption); figcaption != nil && textContent(figcaption) != "" {
+		caption = textContent(figcaption)
+	}
+	r.gallery = append(r.gallery, galleryImage{img: img, caption: strings.TrimSpace(caption)})
+	return true
+}
+
+// renderGallery renders the images collected for the gallery, if
+// any, as a Gallery section of captioned figures
+func (r *renderer) renderGallery(w writer) error {
+	if len(r.gallery) == 0 {
+		return nil
+	}
+
+	heading, div, figure, img := "\n<h2", "\n<div", "\n<figure", "\n<img"
+	if r.opts.StyleRender {
+		heading += cleanStyle(` style="` + renderableHTML["h2"].style + `"`)
+		div += cleanStyle(` style="` + galleryStyle + `"`)
+		figure += cleanStyle(` style="` + galleryFigureStyle + `"`)
+		img += cleanStyle(` style="` + galleryImageStyle + `"`)
+	}
+	if _, err := w.WriteString(heading + ">Gallery</h2>" + div + ">"); err != nil {
+		return err
+	}
+	for _, image := range r.gallery {
+		if _, err := w.WriteString(figure + ">" + img); err != nil {
+			return err
+		}
+		if err := r.renderAttributes(w, image.img); err != nil {
+			return err
+		}
+		if _, err := w.WriteString("/>"); err != nil {
+			return err
+		}
+		if image.caption != "" {
+			if _, err := w.WriteString("\n<figcaption>"); err != nil {
+				return err
+			}
+			if err := escape(w, image.caption); err != nil {
+				return err
+			}
+			if _, err := w.WriteString("</figcaption>"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteString("</figure>"); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("</div>")
+	return err
+}
@@ -0,0 +1,50 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGallery(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/photo-essay.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("testdata/photo-essay.golden.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.PostH1Render = true
+	opts.ImageRender = true
+	opts.Gallery = true
+	opts.BaseURL = "https://orchard.example.org/essays/year.html"
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Rendered\n%s\nwant\n%s", got, want)
+	}
+
+	// Capped
+	opts.GalleryMax = 2
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if n := strings.Count(got, "<figure"); n != 2 || !strings.Contains(got, "Blossom on the Cox trees") {
+		t.Errorf("Expected the first 2 images in the gallery, got %d in %q", n, got)
+	}
+
+	// Without images, there's no gallery
+	opts.ImageRender = false
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Contains(got, "Gallery") || strings.Contains(got, "<img") {
+		t.Errorf("Expected no gallery, got %q", got)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/scu/cleanpg/logger"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ErrNoIncludeMatch is returned (wrapped) when no element matches
+// Options.Include and IncludeFallback is off
+var ErrNoIncludeMatch = errors.New("cleanhtml: no element matches the include selector")
+
+// selectIncluded returns a document holding the head of doc and,
+// in its body, the first element matching the selector text or
+// every match if all is set. Elements inside a match aren't
+// matched again. ok is false if nothing matches.
+func selectIncluded(doc *html.Node, text string, all bool) (included *html.Node, ok bool, err error) {
+	sel, err := ParseSelector(text)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var matches []*html.Node
+	var find func(n *html.Node) bool
+	find = func(n *html.Node) bool {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if sel.Match(c) {
+				matches = append(matches, c)
+				if !all {
+					return true
+				}
+				continue
+			}
+			if find(c) {
+				return true
+			}
+		}
+		return false
+	}
+	find(doc)
+	if len(matches) == 0 {
+		return nil, false, nil
+	}
+
+	// Build the standard document shell around the matches
+	htmlNode := &html.Node{Type: html.ElementNode, DataAtom: atom.Html, Data: "html"}
+	head := &html.Node{Type: html.ElementNode, DataAtom: atom.Head, Data: "head"}
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	included = &html.Node{Type: html.DocumentNode}
+	included.AppendChild(&html.Node{Type: html.DoctypeNode, Data: "html"})
+	included.AppendChild(htmlNode)
+	htmlNode.AppendChild(head)
+	htmlNode.AppendChild(body)
+
+	if srcHead := findElement(doc, atom.Head); srcHead != nil {
+		for c := srcHead.FirstChild; c != nil; {
+			next := c.NextSibling
+			srcHead.RemoveChild(c)
+			head.AppendChild(c)
+			c = next
+		}
+	}
+	for _, m := range matches {
+		m.Parent.RemoveChild(m)
+		body.AppendChild(m)
+	}
+
+	logTo.Write(logger.INFO, "rendering %d element(s) matching [%s]", len(matches), text)
+	return included, true, nil
+}
+
+// findElement returns the first element of type a in n, or nil
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// applyInclude replaces doc with the elements matching opts.Include,
+// if any. Canonical mode doesn't apply to the included elements.
+func applyInclude(doc *html.Node, opts *Options) (*html.Node, error) {
+	included, ok, err := selectIncluded(doc, opts.Include, opts.IncludeAll)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if !opts.IncludeFallback {
+			return nil, fmt.Errorf("%w: [%s]", ErrNoIncludeMatch, opts.Include)
+		}
+		logTo.Write(logger.NOTICE, "no element matches [%s]: rendering the whole document", opts.Include)
+		return doc, nil
+	}
+
+	opts.PostH1Render = false
+	return included, nil
+}
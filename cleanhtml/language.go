@@ -0,0 +1,270 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/text/language"
+)
+
+// DefaultLanguageThreshold is the confidence a detected language
+// needs to be set, unless Options.LanguageThreshold is
+const DefaultLanguageThreshold = 0.25
+
+// minDetectionLetters is the fewest letters of content text which
+// a language is detected from, half as many of kana and kanji
+const minDetectionLetters = 40
+
+// LanguageDetector detects the language of a document's text, such
+// as one of golang.org/x/text/language's Matchers over the results
+// of a statistical model
+type LanguageDetector interface {
+	// DetectLanguage returns the language text is written in, and
+	// the confidence of that from 0 to 1; language.Und and 0 if it
+	// can't tell
+	DetectLanguage(text string) (tag language.Tag, confidence float64)
+}
+
+// SetDetectLanguage sets flag indicating whether the language of
+// a document without a lang attribute is detected from its text
+// [default = false]
+func SetDetectLanguage(flag bool) {
+	options.DetectLanguage = flag
+}
+
+// nonContentElements hold no content text to detect a language
+// from, only navigation and the like
+var nonContentElements = map[atom.Atom]bool{
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Form:     true,
+}
+
+// textBlocks hold the content text a language is detected from
+var textBlocks = map[atom.Atom]bool{
+	atom.P: true, atom.Blockquote: true, atom.Li: true, atom.Dd: true,
+	atom.Td: true, atom.Figcaption: true, atom.Pre: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// contentText returns the text of the paragraphs, headings and so
+// on of doc's body, outside its navigation, header and footer; from
+// start on if it isn't nil, as in canonical mode
+func contentText(doc, start *html.Node) string {
+	var b strings.Builder
+	started := start == nil
+	var walk func(n *html.Node, inBlock bool)
+	walk = func(n *html.Node, inBlock bool) {
+		switch {
+		case n == start:
+			started = true
+		case n.Type == html.TextNode:
+			if started && inBlock {
+				b.WriteString(n.Data)
+				b.WriteByte(' ')
+			}
+			return
+		case n.Type == html.ElementNode && nonContentElements[n.DataAtom]:
+			return
+		case n.Type == html.ElementNode && n.DataAtom == atom.Head:
+			return
+		}
+		if role, _ := attr(n, "role"); role == "navigation" || role == "banner" || role == "contentinfo" {
+			return
+		}
+		inBlock = inBlock || n.Type == html.ElementNode && textBlocks[n.DataAtom]
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inBlock)
+		}
+	}
+	walk(doc, false)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// hasLang reports whether the html element of doc has a lang attribute
+func hasLang(doc *html.Node) bool {
+	root := findElement(doc, atom.Html)
+	if root == nil {
+		return false
+	}
+	lang, _ := attr(root, "lang")
+	return strings.TrimSpace(lang) != ""
+}
+
+// detectLanguage returns the BCP 47 code of the language of doc's
+// content text, detected as set by opts, or "" if it can't be told
+// with the confidence needed
+func detectLanguage(doc, start *html.Node, opts *Options) string {
+	detector := opts.LanguageDetector
+	if detector == nil {
+		detector = trigramDetector{}
+	}
+	threshold := opts.LanguageThreshold
+	if threshold == 0 {
+		threshold = DefaultLanguageThreshold
+	}
+
+	tag, confidence := detector.DetectLanguage(contentText(doc, start))
+	if tag == language.Und || confidence < threshold {
+		return ""
+	}
+	return tag.String()
+}
+
+// trigramDetector is the LanguageDetector used unless another is
+// set. It tells languages written in scripts of their own, such as
+// Japanese and Greek, by their letters, and languages written in
+// Latin letters by their commonest trigrams.
+type trigramDetector struct{}
+
+// scriptLanguages are the languages told by their script
+var scriptLanguages = []struct {
+	script *unicode.RangeTable
+	tag    language.Tag
+}{
+	{unicode.Hangul, language.Korean},
+	{unicode.Greek, language.Greek},
+	{unicode.Arabic, language.Arabic},
+	{unicode.Hebrew, language.Hebrew},
+	{unicode.Thai, language.Thai},
+	{unicode.Devanagari, language.Hindi},
+}
+
+// trigramProfiles hold the commonest trigrams of the languages
+// written in Latin letters, a space marking the start or end of a word
+var trigramProfiles = map[language.Tag][]string{
+	language.English: {
+		" th", "the", "he ", "and", " an", "nd ", "ing", "ng ", " of", "of ",
+		" to", "ion", "ed ", "er ", "at ", " in", "is ", "on ", "ent", "tio",
+		"re ", "es ", " is", "in ", "hat", "for", " fo", "al ", "to ", " wh",
+	},
+	language.German: {
+		"en ", "er ", "der", "ch ", "ein", "ie ", " de", "sch", "ich", "die",
+		" di", "und", " un", "nd ", "cht", "in ", " ei", "te ", "den", "ung",
+		"ng ", " da", "ine", "gen", "ter", "che", " ge", "das", "ist", " zu",
+	},
+	language.French: {
+		"es ", " de", "de ", "le ", "ent", " le", "nt ", "la ", " la", "ion",
+		"on ", "re ", " pa", "les", " et", "et ", "ne ", "que", " qu", "ue ",
+		"des", " co", "tio", "ans", "our", " un", "est", "une", " po", "ait",
+	},
+	language.Spanish: {
+		"de ", " de", "os ", " la", "la ", "el ", "es ", " qu", "que", "ue ",
+		"en ", " el", "as ", " en", "ion", "on ", "ent", "del", " co", "nte",
+		" lo", "los", "ado", "con", " se", "ar ", " y ", "cio", "ra ", "las",
+	},
+	language.Italian: {
+		"di ", " di", "to ", "la ", " la", "che", " ch", "he ", "re ", "ell",
+		"ion", "ne ", "one", "del", "lla", " de", "no ", "ent", " co", "ta ",
+		"le ", "per", " pe", " il", "il ", "zio", "ato", "ia ", "are", "gli",
+	},
+	language.Dutch: {
+		"en ", "de ", " de", "an ", "et ", " he", "het", "van", " va", "een",
+		" ee", "ing", "er ", "ijk", " in", "aar", "oor", " ge", "ver", "den",
+		" en", "nde", "ten", "cht", " zi", "sch", " op", "ij ", "dat", " vo",
+	},
+	language.Portuguese: {
+		"de ", " de", "os ", "do ", " qu", "que", "ue ", "ão ", "ção", "da ",
+		"es ", " co", "ent", " a ", "as ", "em ", " do", " pa", "ara", "com",
+		"nte", "ado", "ões", " se", " da", "ra ", "ma ", " um", "um ", "não",
+	},
+}
+
+// DetectLanguage implements the LanguageDetector interface
+func (trigramDetector) DetectLanguage(text string) (language.Tag, float64) {
+	var letters, latin, kana, han int
+	scripts := make([]int, len(scriptLanguages))
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		default:
+			for i, s := range scriptLanguages {
+				if unicode.Is(s.script, r) {
+					scripts[i]++
+				}
+			}
+		}
+	}
+	if letters < minDetectionLetters && kana+han < minDetectionLetters/2 {
+		return language.Und, 0
+	}
+
+	// Japanese mixes kana with kanji; Chinese has only the latter
+	share := func(n int) float64 { return float64(n) / float64(letters) }
+	switch {
+	case kana > letters/20:
+		return language.Japanese, share(kana + han)
+	case han > letters/2:
+		return language.Chinese, share(han)
+	}
+	for i, s := range scriptLanguages {
+		if scripts[i] > letters/2 {
+			return s.tag, share(scripts[i])
+		}
+	}
+	if latin <= letters/2 {
+		return language.Und, 0
+	}
+
+	// The language whose trigrams are the most of the text's,
+	// confidently so by as far ahead as it is of the next best
+	counts := trigramCounts(text)
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	best, bestScore, nextScore := language.Und, 0, 0
+	for tag, profile := range trigramProfiles {
+		score := 0
+		for _, trigram := range profile {
+			score += counts[trigram]
+		}
+		switch {
+		case score > bestScore || score == bestScore && tag.String() < best.String():
+			best, bestScore, nextScore = tag, score, bestScore
+		case score > nextScore:
+			nextScore = score
+		}
+	}
+	if bestScore == 0 {
+		return language.Und, 0
+	}
+	return best, share(latin) * (1 - float64(nextScore)/float64(bestScore))
+}
+
+// trigramCounts returns the number of times each trigram occurs in
+// the lower-cased words of text, each padded with a space
+func trigramCounts(text string) map[string]int {
+	counts := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,118 @@
+package cleanhtml
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		lang string
+	}{
+		{"testdata/lang-en.html", "en"}, // with German navigation
+		{"testdata/lang-de.html", "de"}, // with an English header and footer
+		{"testdata/lang-ja.html", "ja"},
+	} {
+		data, err := ioutil.ReadFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := DefaultOptions()
+		got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+		if err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+		if strings.Contains(got, "lang=") {
+			t.Errorf("%s: expected no lang without DetectLanguage, got %q", test.file, got)
+		}
+
+		opts.DetectLanguage = true
+		if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+		if want := ` lang="` + test.lang + `">`; !strings.Contains(got, want) {
+			t.Errorf("%s: expected %q, got %q", test.file, want, got)
+		}
+	}
+}
+
+func TestDetectLanguage_Source(t *testing.T) {
+	opts := DefaultOptions()
+	opts.DetectLanguage = true
+
+	// The source's lang is kept as it is
+	data := []byte(`<html lang="en-GB"><body><p>Die Äste werden im Winter geschnitten, wenn die Bäume ruhen und die Form gut zu erkennen ist.</p></body></html>`)
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Count(got, "lang=") != 1 || !strings.Contains(got, `lang="en-GB"`) {
+		t.Errorf("Expected the source's lang, got %q", got)
+	}
+
+	// Escaped, however odd
+	data = []byte(`<html lang='en" onload="alert(1)'><body><p>Winter pruning</p></body></html>`)
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, `lang="en&#34; onload=&#34;alert(1)"`) {
+		t.Errorf("Expected the lang escaped, got %q", got)
+	}
+
+	// Too little text to tell
+	data = []byte(`<html><body><p>Winter pruning</p></body></html>`)
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Contains(got, "lang=") {
+		t.Errorf("Expected no lang, got %q", got)
+	}
+
+	// A detector of one's own, held to the threshold
+	opts.LanguageDetector = detectorFunc(func(text string) (language.Tag, float64) {
+		return language.MustParse("pt-BR"), 0.6
+	})
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, `lang="pt-BR"`) {
+		t.Errorf("Expected the detector's language, got %q", got)
+	}
+	opts.LanguageThreshold = 0.9
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Contains(got, "lang=") {
+		t.Errorf("Expected no lang below the threshold, got %q", got)
+	}
+}
+
+// detectorFunc is a LanguageDetector calling itself
+type detectorFunc func(text string) (language.Tag, float64)
+
+func (f detectorFunc) DetectLanguage(text string) (language.Tag, float64) { return f(text) }
+
+func TestTrigramDetector(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		tag  language.Tag
+	}{
+		{"The orchard changes with every season, and the work of the gardener changes with it.", language.English},
+		{"Der Obstgarten verändert sich mit jeder Jahreszeit, und die Arbeit des Gärtners mit ihm.", language.German},
+		{"Le verger change avec chaque saison, et le travail du jardinier change avec lui.", language.French},
+		{"El huerto cambia con cada estación, y el trabajo del jardinero cambia con él.", language.Spanish},
+		{"果樹園は季節ごとに変わり、庭師の仕事もそれとともに変わります。", language.Japanese},
+		{"Ο οπωρώνας αλλάζει με κάθε εποχή, και η δουλειά του κηπουρού αλλάζει μαζί του.", language.Greek},
+	} {
+		tag, confidence := trigramDetector{}.DetectLanguage(test.text)
+		if tag != test.tag || confidence < DefaultLanguageThreshold {
+			t.Errorf("%q: detected %v with confidence %.2f, want %v", test.text, tag, confidence, test.tag)
+		}
+	}
+}
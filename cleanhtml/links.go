@@ -0,0 +1,103 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Link is a link found in a document by ExtractLinks
+type Link struct {
+	URL  string // absolute, without a fragment
+	Text string // the anchor text, white space collapsed
+}
+
+// ExtractLinks returns the http and https links <a href...> in
+// data, resolved against the absolute URL base (normally
+// FetchResult.URL), in document order. Each URL is listed once,
+// with the text of its first link, and without its fragment.
+// Links to a fragment of the page itself are left out, along with
+// javascript: and other targets which can't be fetched, and
+// relative links when base is "".
+func ExtractLinks(data []byte, base string) ([]Link, error) {
+	var baseURL *url.URL
+	if base != "" {
+		var err error
+		if baseURL, err = url.Parse(base); err != nil || !baseURL.IsAbs() {
+			return nil, fmt.Errorf("cleanhtml: base URL [%s] must be absolute", base)
+		}
+	}
+
+	var links []Link
+	seen := make(map[string]bool)
+	var text *strings.Builder // of the link being read, nil if none
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links, nil
+		case html.TextToken:
+			if text != nil {
+				text.Write(z.Text())
+				text.WriteByte(' ')
+			}
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if atom.Lookup(name) != atom.A {
+				continue
+			}
+			text = nil
+			var href string
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					href = string(val)
+				}
+			}
+			target := resolveLink(baseURL, href)
+			if target == "" || seen[target] {
+				continue
+			}
+			seen[target] = true
+			links = append(links, Link{URL: target})
+			text = &strings.Builder{}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.A && text != nil {
+				links[len(links)-1].Text = strings.Join(strings.Fields(text.String()), " ")
+				text = nil
+			}
+		}
+	}
+}
+
+// resolveLink returns href resolved against base without its
+// fragment, or "" if it isn't an http or https link elsewhere
+func resolveLink(base *url.URL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil || (base == nil && !u.IsAbs()) {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	u.Fragment = ""
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,46 @@
+package cleanhtml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	doc := `<html><head><link href="/style.css"></head><body>
+<p><a href="/docs/intro">Getting
+  <b>started</b></a> and <a href="https://other.example/page#part">elsewhere</a>.</p>
+<a href="#comments">Comments</a>
+<a href="javascript:void(0)">Menu</a>
+<a href="mailto:me@example.com">Mail</a>
+<a href="/docs/intro#install">Install</a>
+<a href="../about?lang=en">About</a>
+<a>No href</a>
+<a href=" HTTPS://other.example/page ">Again</a>
+</body></html>`
+
+	expect := []Link{
+		{URL: "https://example.com/docs/intro", Text: "Getting started"},
+		{URL: "https://other.example/page", Text: "elsewhere"},
+		{URL: "https://example.com/about?lang=en", Text: "About"},
+	}
+	links, err := ExtractLinks([]byte(doc), "https://example.com/blog/post")
+	if err != nil {
+		t.Fatalf("Could not extract links: %v", err)
+	}
+	if !reflect.DeepEqual(links, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, links)
+	}
+
+	// Without a base only absolute links are kept
+	links, err = ExtractLinks([]byte(doc), "")
+	if err != nil {
+		t.Fatalf("Could not extract links: %v", err)
+	}
+	if len(links) != 1 || links[0].URL != "https://other.example/page" {
+		t.Errorf("Expected only the absolute link, got %+v", links)
+	}
+
+	if _, err := ExtractLinks([]byte(doc), "/relative"); err == nil {
+		t.Errorf("Expected an error for a relative base")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Metadata describes a document, as read by ExtractMetadata.
+// Each field is empty when the document doesn't give it.
+type Metadata struct {
+	Title       string // of the <title>
+	Description string // <meta name="description">
+	Canonical   string // <link rel="canonical">, resolved against the base
+
+	// OpenGraph holds the og: properties by name without the
+	// prefix, e.g. "title" for og:title
+	OpenGraph map[string]string
+
+	Author    string // <meta name="author"> or article:author
+	Published string // article:published_time or the like, as given
+
+	// WordCount is the number of words in the text of the body
+	WordCount int
+}
+
+// wordsPerMinute is the reading speed assumed by ReadingTime
+const wordsPerMinute = 200
+
+// ReadingTime estimates the time taken to read the body
+// of the document, rounded up to a minute
+func (m *Metadata) ReadingTime() time.Duration {
+	minutes := (m.WordCount + wordsPerMinute - 1) / wordsPerMinute
+	return time.Duration(minutes) * time.Minute
+}
+
+// authorNames and publishedNames are the <meta> names and
+// properties giving the author and published time, in order
+// of preference
+var (
+	authorNames    = []string{"author", "article:author", "dc.creator"}
+	publishedNames = []string{"article:published_time", "datepublished", "date", "pubdate", "publish-date", "dc.date.issued"}
+)
+
+// ExtractMetadata returns the metadata in the head of data, and
+// the number of words in its body. The canonical URL is resolved
+// against the absolute URL base (normally FetchResult.URL), or
+// left as-is when base is "".
+func ExtractMetadata(data []byte, base string) (*Metadata, error) {
+	var baseURL *url.URL
+	if base != "" {
+		var err error
+		if baseURL, err = url.Parse(base); err != nil || !baseURL.IsAbs() {
+			return nil, fmt.Errorf("cleanhtml: base URL [%s] must be absolute", base)
+		}
+	}
+
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, &CleanError{Phase: "parse", Err: err}
+	}
+
+	m := &Metadata{Title: Title(data)}
+	metas := make(map[string]string) // content by lowercase name or property, first given
+	var words int
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			words += countWords(n.Data)
+		}
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Title:
+				return
+			case atom.Meta:
+				content := strings.Join(strings.Fields(getAttr(n, "content")), " ")
+				for _, key := range []string{"name", "property", "itemprop"} {
+					name := strings.ToLower(strings.TrimSpace(getAttr(n, key)))
+					if _, ok := metas[name]; name != "" && content != "" && !ok {
+						metas[name] = content
+					}
+				}
+			case atom.Link:
+				if m.Canonical == "" && hasToken(getAttr(n, "rel"), "canonical") {
+					m.Canonical = resolveURL(baseURL, strings.TrimSpace(getAttr(n, "href")))
+				}
+			case atom.Time:
+				if _, ok := metas["datepublished"]; !ok && strings.EqualFold(getAttr(n, "itemprop"), "datePublished") {
+					metas["datepublished"] = strings.TrimSpace(getAttr(n, "datetime"))
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	m.WordCount = words
+	m.Description = metas["description"]
+	m.Author = firstMeta(metas, authorNames)
+	m.Published = firstMeta(metas, publishedNames)
+	for name, content := range metas {
+		if strings.HasPrefix(name, "og:") {
+			if m.OpenGraph == nil {
+				m.OpenGraph = make(map[string]string)
+			}
+			m.OpenGraph[strings.TrimPrefix(name, "og:")] = content
+		}
+	}
+	return m, nil
+}
+
+// countWords returns the number of words in text, leaving out
+// punctuation on its own such as the "." after a link
+func countWords(text string) int {
+	n := 0
+	for _, field := range strings.Fields(text) {
+		if strings.IndexFunc(field, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// hasToken determines if the space-separated list has token
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveURL returns ref resolved against base, or as-is when
+// base is nil or ref can't be parsed
+func resolveURL(base *url.URL, ref string) string {
+	u, err := url.Parse(ref)
+	if base == nil || err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// firstMeta returns the content of the first of names in metas
+func firstMeta(metas map[string]string, names []string) string {
+	for _, name := range names {
+		if content := metas[name]; content != "" {
+			return content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package cleanhtml
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/opengraph.html")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+
+	m, err := ExtractMetadata(data, "https://allotment.example/2020/garlic?ref=feed")
+	if err != nil {
+		t.Fatalf("Could not extract metadata: %v", err)
+	}
+	expect := &Metadata{
+		Title:       "Planting Garlic | The Allotment",
+		Description: "When and how to plant garlic for a summer harvest.",
+		Canonical:   "https://allotment.example/garlic",
+		OpenGraph: map[string]string{
+			"title":       "Planting Garlic",
+			"description": "Autumn is the time to plant garlic.",
+			"type":        "article",
+			"url":         "https://allotment.example/garlic",
+			"image":       "https://allotment.example/images/garlic.jpg",
+			"site_name":   "The Allotment",
+		},
+		Author:    "Ada Fenwick",
+		Published: "2020-10-01T08:00:00Z",
+		WordCount: 15,
+	}
+	if !reflect.DeepEqual(m, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, m)
+	}
+	if got := m.ReadingTime(); got != time.Minute {
+		t.Errorf("Expected a reading time of a minute, got %v", got)
+	}
+
+	// A page with none has none
+	m, err = ExtractMetadata([]byte("<html><body><p>Just text.</p><time itemprop=datePublished datetime=2020-09-30>Yesterday</time></body></html>"), "")
+	if err != nil {
+		t.Fatalf("Could not extract metadata: %v", err)
+	}
+	if expect := (&Metadata{Published: "2020-09-30", WordCount: 3}); !reflect.DeepEqual(m, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, m)
+	}
+	if got := (&Metadata{}).ReadingTime(); got != 0 {
+		t.Errorf("Expected no reading time without words, got %v", got)
+	}
+
+	if _, err := ExtractMetadata(data, "relative/page"); err == nil {
+		t.Errorf("Expected an error for a relative base")
+	}
+}
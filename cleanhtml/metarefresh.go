@@ -0,0 +1,156 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scu/cleanpg/logger"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// metaRefresh returns the delay and URL of a meta refresh in the
+// head of data, or "" when there is none or it reloads the page
+func metaRefresh(data []byte) (time.Duration, string) {
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return 0, ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				// A refresh belongs in the head
+				return 0, ""
+			case atom.Meta:
+				var httpEquiv, content string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "http-equiv":
+						httpEquiv = string(val)
+					case "content":
+						content = string(val)
+					}
+				}
+				if strings.EqualFold(httpEquiv, "refresh") {
+					return parseRefresh(content)
+				}
+			}
+		}
+	}
+}
+
+// parseRefresh parses a refresh value such as "0; url='/next'"
+func parseRefresh(content string) (time.Duration, string) {
+	content = strings.TrimSpace(content)
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return 0, ""
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(content[:i]), 64)
+	if err != nil || seconds < 0 {
+		return 0, ""
+	}
+	delay := time.Duration(seconds * float64(time.Second))
+
+	target := strings.TrimSpace(content[i+1:])
+	if len(target) >= 4 && strings.EqualFold(target[:3], "url") {
+		if rest := strings.TrimSpace(target[3:]); strings.HasPrefix(rest, "=") {
+			target = strings.TrimSpace(rest[1:])
+		}
+	}
+	target = strings.Trim(target, `"'`)
+
+	return delay, target
+}
+
+// isHTTP determines if u is an http or https URL
+func isHTTP(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// followMetaRefresh follows meta refreshes from the document in
+// result, fetched from rawurl, until one leads to a document
+// without one. Each counts against opts.MaxRedirects, and a
+// refresh back to a page already seen is refused as a loop. As
+// with redirects, only http and https documents are followed, to
+// http and https URLs, refreshes to another host are refused with
+// opts.SameHostRedirects, and the headers, cookies and credentials
+// of opts are only sent to the host of rawurl.
+func followMetaRefresh(ctx context.Context, rawurl string, result *FetchResult, opts FetchOptions) (*FetchResult, error) {
+	max := opts.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+
+	first, err := url.Parse(result.URL)
+	if err != nil || !isHTTP(first) {
+		return result, nil
+	}
+	chain := []string{rawurl}
+	seen := map[string]bool{rawurl: true, result.URL: true}
+	for {
+		delay, target := metaRefresh(result.Body)
+		if target == "" || delay > opts.MetaRefreshMaxDelay {
+			return result, nil
+		}
+
+		base, err := url.Parse(result.URL)
+		if err != nil || !isHTTP(base) {
+			return result, nil
+		}
+		next, err := base.Parse(target)
+		if err == nil && !isHTTP(next) {
+			err = fmt.Errorf("not an http or https URL")
+		}
+		if err != nil {
+			logTo.Write(logger.WARNING, "ignoring meta refresh to [%s]: %s", target, err)
+			return result, nil
+		}
+		next.Fragment = ""
+		nextURL := next.String()
+		sameHost := strings.EqualFold(next.Hostname(), first.Hostname())
+
+		chain = append(chain, nextURL)
+		if seen[nextURL] {
+			err := &RedirectError{Chain: chain, Reason: "meta refresh loop"}
+			logTo.Write(logger.FATAL, "Could not get url [%s]: %s", rawurl, err)
+			return nil, err
+		}
+		if len(chain)-1 > max || max < 0 {
+			err := &RedirectError{Chain: chain, Reason: fmt.Sprintf("stopped after %d redirects", len(chain)-2)}
+			logTo.Write(logger.FATAL, "Could not get url [%s]: %s", rawurl, err)
+			return nil, err
+		}
+		if opts.SameHostRedirects && !sameHost {
+			err := &RedirectError{Chain: chain, Reason: "refused redirect to another host"}
+			logTo.Write(logger.FATAL, "Could not get url [%s]: %s", rawurl, err)
+			return nil, err
+		}
+		seen[nextURL] = true
+
+		logTo.Write(logger.INFO, "following meta refresh to [%s]", nextURL)
+		nextOpts := opts
+		if !sameHost {
+			nextOpts = opts.WithoutCredentials()
+		}
+		result, err = fetchDocument(ctx, nextURL, nextOpts)
+		if err != nil {
+			return nil, err
+		}
+		seen[result.URL] = true
+	}
+}
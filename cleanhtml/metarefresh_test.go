@@ -0,0 +1,159 @@
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRefresh(t *testing.T) {
+	tests := []struct {
+		content string
+		delay   time.Duration
+		target  string
+	}{
+		{"0;url=/next", 0, "/next"},
+		{"0; URL='https://example.com/'", 0, "https://example.com/"},
+		{" 2.5 , url = \"a b.html\"", 2500 * time.Millisecond, "a b.html"},
+		{"3;/plain", 3 * time.Second, "/plain"},
+		{"0;urls.html", 0, "urls.html"},
+		{"5", 0, ""},
+		{"soon;url=/x", 0, ""},
+	}
+
+	for _, tt := range tests {
+		delay, target := parseRefresh(tt.content)
+		if delay != tt.delay || target != tt.target {
+			t.Errorf("%q: expected %v %q, got %v %q", tt.content, tt.delay, tt.target, delay, target)
+		}
+	}
+}
+
+func TestFetch_MetaRefresh(t *testing.T) {
+	pages := map[string]string{
+		"/a":     `<html><head><meta http-equiv="Refresh" content="0; url=/b"></head><body>stub a</body></html>`,
+		"/b":     `<html><head><meta http-equiv="refresh" content="0;URL='c'"></head><body>stub b</body></html>`,
+		"/c":     `<html><body><p>content</p></body></html>`,
+		"/self":  `<html><head><meta http-equiv="refresh" content="0;url=/self#x"></head></html>`,
+		"/slow":  `<html><head><meta http-equiv="refresh" content="30;url=/c"></head><body>wait</body></html>`,
+		"/other": `<html><body><meta http-equiv="refresh" content="0;url=/c">in body</body></html>`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(pages[r.URL.Path]))
+	}))
+	defer ts.Close()
+
+	opts := FetchOptions{FollowMetaRefresh: true, MetaRefreshMaxDelay: time.Second}
+
+	result, err := fetch(context.Background(), ts.URL+"/a", opts)
+	if err != nil {
+		t.Fatalf("Could not follow the refreshes: %v", err)
+	}
+	if result.URL != ts.URL+"/c" || string(result.Body) != pages["/c"] {
+		t.Errorf("Expected %s to be fetched, got %s %q", ts.URL+"/c", result.URL, result.Body)
+	}
+
+	var rerr *RedirectError
+	if _, err := fetch(context.Background(), ts.URL+"/self", opts); !errors.As(err, &rerr) {
+		t.Errorf("Expected a RedirectError for a refresh loop, got %v", err)
+	}
+
+	limited := opts
+	limited.MaxRedirects = 1
+	if _, err := fetch(context.Background(), ts.URL+"/a", limited); !errors.As(err, &rerr) {
+		t.Errorf("Expected a RedirectError past the redirect limit, got %v", err)
+	}
+
+	// Refreshes which are too slow or not in the head stay put,
+	// as does everything when following is off
+	for _, tt := range []struct {
+		path string
+		opts FetchOptions
+	}{
+		{"/slow", opts},
+		{"/other", opts},
+		{"/a", FetchOptions{}},
+	} {
+		result, err := fetch(context.Background(), ts.URL+tt.path, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: could not fetch: %v", tt.path, err)
+		}
+		if result.URL != ts.URL+tt.path {
+			t.Errorf("%s: expected no refresh, got %s", tt.path, result.URL)
+		}
+	}
+}
+
+func TestFetch_MetaRefreshPolicy(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "notes.html")
+	if err := ioutil.WriteFile(secret, []byte(`<html><body><p>private notes</p></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><p>elsewhere</p></body></html>`))
+	}))
+	defer other.Close()
+	// The same server by another name is another host
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/file":
+			fmt.Fprintf(w, `<html><head><meta http-equiv="refresh" content="0;url=file://%s"></head><body>stub</body></html>`, filepath.ToSlash(secret))
+		case "/away":
+			fmt.Fprintf(w, `<html><head><meta http-equiv="refresh" content="0;url=%s/page"></head><body>stub</body></html>`, otherURL)
+		}
+	}))
+	defer ts.Close()
+
+	opts := FetchOptions{
+		FollowMetaRefresh: true,
+		Username:          "bob",
+		Password:          "hunter2",
+		Headers:           http.Header{"X-Token": {"abc"}},
+		Cookies:           []*http.Cookie{{Name: "session", Value: "s3cret"}},
+	}
+
+	// A local file is never refreshed to
+	result, err := fetch(context.Background(), ts.URL+"/file", opts)
+	if err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if result.URL != ts.URL+"/file" || strings.Contains(string(result.Body), "private notes") {
+		t.Errorf("Expected the refresh to a file ignored, got %s %q", result.URL, result.Body)
+	}
+
+	// Another host is refreshed to without the credentials
+	result, err = fetch(context.Background(), ts.URL+"/away", opts)
+	if err != nil {
+		t.Fatalf("Could not follow the refresh: %v", err)
+	}
+	if result.URL != otherURL+"/page" {
+		t.Errorf("Expected %s/page, got %s", otherURL, result.URL)
+	}
+	for _, header := range []string{"Authorization", "Cookie", "X-Token"} {
+		if seen.Get(header) != "" {
+			t.Errorf("Expected no %s sent to another host, got %q", header, seen.Get(header))
+		}
+	}
+
+	// Or refused, as are redirects
+	opts.SameHostRedirects = true
+	var rerr *RedirectError
+	if _, err := fetch(context.Background(), ts.URL+"/away", opts); !errors.As(err, &rerr) {
+		t.Errorf("Expected a RedirectError for a refresh to another host, got %v", err)
+	}
+}
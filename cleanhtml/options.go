@@ -0,0 +1,257 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"path"
+	"strings"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// Options holds the settings which control how a document
+// is rendered. The package-level functions (CleanHTML,
+// SetStyleRender, etc.) share a single default Options;
+// CleanHTMLWithOptions renders with its own copy.
+type Options struct {
+	// PostH1Render skips BODY elements until the selected
+	// heading is reached ("canonical mode")
+	PostH1Render bool
+
+	// PostHeadingLevel is the level of the headings (1-6) which
+	// can start rendering, or AnyHeading; 0 is taken as 1
+	PostHeadingLevel int
+
+	// PostH1Selection chooses which of those headings starts rendering
+	PostH1Selection H1Selection
+
+	// StyleRender embeds tag-level styles automatically
+	StyleRender bool
+
+	// LinksRender renders links <a... href...>
+	LinksRender bool
+
+	// ImageRender renders images <img... src...>
+	ImageRender bool
+
+	// ImageSource, if set, replaces the src of each rendered
+	// image, e.g. with a local copy. It is called with the src
+	// resolved against BaseURL.
+	ImageSource func(src string) string
+
+	// KeepDataAttributes lists data-* attribute names to keep
+	// on rendered elements. Entries are path.Match patterns,
+	// so "data-lang" keeps one and "data-*" keeps them all.
+	KeepDataAttributes []string
+
+	// BaseURL is the absolute URL of the document (normally
+	// FetchResult.URL), against which relative links are
+	// resolved. Links are left as-is when empty.
+	BaseURL string
+
+	// Stylesheet is CSS embedded in a <style> element at the
+	// end of the document head
+	Stylesheet string
+
+	// StylesheetURL is linked as a <link rel="stylesheet"> at
+	// the end of the document head, after any Stylesheet
+	StylesheetURL string
+
+	// Include is a CSS selector (see ParseSelector) of the
+	// element rendered on its own, in place of the whole body.
+	// Canonical mode doesn't apply to it.
+	Include string
+
+	// IncludeAll renders every element matching Include
+	// rather than just the first
+	IncludeAll bool
+
+	// IncludeFallback renders the whole document when nothing
+	// matches Include, rather than failing with ErrNoIncludeMatch
+	IncludeFallback bool
+
+	// Exclude lists CSS selectors (see ParseSelector) of
+	// elements removed, with their contents, before rendering.
+	// They apply after Include, within the included elements.
+	Exclude []string
+
+	// DenySubtrees lists the tag names of elements never rendered,
+	// nor anything within them, text included, even what would
+	// otherwise be; see DefaultDenySubtrees
+	DenySubtrees []string
+
+	// FormsAsText renders forms as readable text: labels, the
+	// values of text fields in brackets, [x] and [ ] for checkboxes
+	// and radio buttons, the selected options and the buttons' text.
+	// Textareas are rendered as read-only ones rather than <pre>.
+	// Their elements mustn't be in DenySubtrees.
+	FormsAsText bool
+
+	// Footnotes renumbers the footnote markers, such as
+	// <sup><a href="#cite_note-3">[3]</a></sup>, and renders the notes
+	// they link to, wherever they are in the document, as a References
+	// list at the end. Markers whose note is missing are rendered as
+	// text. Only targets in a list or notes container are taken as
+	// notes; links to anything else are left as they are.
+	Footnotes bool
+
+	// Gallery renders the images, with ImageRender, in a gallery at
+	// the end of the document rather than in place, each captioned
+	// with its figure's figcaption or else its alt text. An image
+	// appearing more than once is in the gallery once.
+	Gallery bool
+
+	// GalleryMax is the most images in the gallery (0 = no limit)
+	GalleryMax int
+
+	// DetectLanguage sets the lang attribute of a document without
+	// one to the language detected from its content text, that of
+	// its paragraphs, headings and the like but not its navigation,
+	// when LanguageThreshold is met
+	DetectLanguage bool
+
+	// LanguageDetector detects the language with DetectLanguage;
+	// nil uses one built in, telling more than a dozen languages
+	// by their scripts and commonest trigrams
+	LanguageDetector LanguageDetector
+
+	// LanguageThreshold is the confidence, from 0 to 1, a detected
+	// language needs; 0 is taken as DefaultLanguageThreshold
+	LanguageThreshold float64
+
+	// TitleHeading starts Markdown and text, as converted by
+	// RenderMarkdown and RenderText, with the title as a heading,
+	// unless it repeats the first h1 (see SameTitle)
+	TitleHeading bool
+
+	// TrimTitle renders the title without any site name after it
+	// (see TrimSiteSuffix), and so in every format
+	TrimTitle bool
+
+	// WrapColumn wraps the lines of the rendered HTML at about this
+	// column (0 = no wrapping), breaking them only at spaces in text
+	// outside preformatted elements, where it makes no difference to
+	// how the document is shown
+	WrapColumn int
+}
+
+// options holds the package-level defaults
+var options = Options{
+	PostHeadingLevel: 1,
+	PostH1Selection:  FirstH1,
+	StyleRender:      true,
+	LinksRender:      true,
+}
+
+// DefaultOptions returns a copy of the package-level
+// options used by CleanHTML and CleanHTMLContext
+func DefaultOptions() Options {
+	return options
+}
+
+// SetOptions replaces the package-level options
+func SetOptions(o Options) {
+	options = o
+}
+
+// SetImageRender sets flag indicating whether
+// images <img... src...> will be rendered
+// [default = false]
+func SetImageRender(flag bool) {
+	options.ImageRender = flag
+}
+
+// SetImageSource sets the function replacing the src of
+// each rendered image
+// [default = none]
+func SetImageSource(source func(src string) string) {
+	options.ImageSource = source
+}
+
+// SetKeepDataAttributes sets the data-* attribute
+// patterns kept on rendered elements
+// [default = none]
+func SetKeepDataAttributes(patterns ...string) {
+	options.KeepDataAttributes = patterns
+}
+
+// SetBaseURL sets the URL relative links are resolved against
+// [default = none]
+func SetBaseURL(base string) {
+	options.BaseURL = base
+}
+
+// SetStylesheet sets CSS embedded in the document head
+// [default = none]
+func SetStylesheet(css string) {
+	options.Stylesheet = css
+}
+
+// SetStylesheetURL sets a stylesheet linked from the document head
+// [default = none]
+func SetStylesheetURL(href string) {
+	options.StylesheetURL = href
+}
+
+// SetInclude sets the CSS selector of the element rendered
+// in place of the whole body, and whether every match is
+// rendered rather than the first
+// [default = none]
+func SetInclude(selector string, all bool) {
+	options.Include = selector
+	options.IncludeAll = all
+}
+
+// SetIncludeFallback sets flag indicating whether the whole
+// document is rendered when nothing matches the include selector
+// [default = false]
+func SetIncludeFallback(flag bool) {
+	options.IncludeFallback = flag
+}
+
+// SetExclude sets the CSS selectors of elements
+// removed before rendering
+// [default = none]
+func SetExclude(selectors ...string) {
+	options.Exclude = selectors
+}
+
+// DenySubtree sets the tag names of elements never rendered,
+// along with their descendants and text
+// [default = none]
+func DenySubtree(tags ...string) {
+	options.DenySubtrees = tags
+}
+
+// logTo is the Logger messages are written to
+var logTo = logger.Default()
+
+// SetLogger sets the Logger messages are written to, e.g. one
+// made by logger.New to keep them apart from the application's;
+// nil goes back to the default
+// [default = logger.Default()]
+func SetLogger(l *logger.Logger) {
+	if l == nil {
+		l = logger.Default()
+	}
+	logTo = l
+}
+
+// keepDataAttribute determines if attr is a data-* attribute
+// matching one of the KeepDataAttributes patterns
+func (o *Options) keepDataAttribute(attr string) bool {
+	lcAttr := strings.ToLower(attr)
+	if !strings.HasPrefix(lcAttr, "data-") {
+		return false
+	}
+
+	for _, pattern := range o.KeepDataAttributes {
+		if ok, _ := path.Match(strings.ToLower(pattern), lcAttr); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,195 @@
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// TestMain gives the tests a cache directory of their own, for the
+// default Logger's log file
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "cleanhtml-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", dir)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, ".cache"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestKeepDataAttributes(t *testing.T) {
+	data := []byte(`<html><body>` +
+		`<pre data-lang="go" data-tracking-id="x1">code</pre>` +
+		`<p data-footnote-ref="3" data-quote='say "hi" &amp; bye'>text</p>` +
+		`</body></html>`)
+
+	tests := []struct {
+		patterns []string
+		expect   []string
+		unexpect []string
+	}{
+		{nil, nil, []string{"data-"}},
+		{[]string{"data-lang", "data-footnote-*"},
+			[]string{`data-lang="go"`, `data-footnote-ref="3"`},
+			[]string{"data-tracking-id", "data-quote"}},
+		{[]string{"data-*"},
+			[]string{`data-tracking-id="x1"`, `data-quote="say &#34;hi&#34; &amp; bye"`},
+			nil},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.KeepDataAttributes = tt.patterns
+		got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+		if err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+		for _, e := range tt.expect {
+			if !strings.Contains(got, e) {
+				t.Errorf("Patterns %q: expected %q in %q", tt.patterns, e, got)
+			}
+		}
+		for _, u := range tt.unexpect {
+			if strings.Contains(got, u) {
+				t.Errorf("Patterns %q: unexpected %q in %q", tt.patterns, u, got)
+			}
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	data := []byte(`<html><body><p>` +
+		`<a href="other.html">relative</a>` +
+		`<a href="/root">rooted</a>` +
+		`<a href="#section">fragment</a>` +
+		`<a href="https://example.org/x">absolute</a>` +
+		`</p></body></html>`)
+
+	opts := DefaultOptions()
+	opts.BaseURL = "https://example.com/articles/page.html"
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+
+	for _, e := range []string{
+		`href="https://example.com/articles/other.html"`,
+		`href="https://example.com/root"`,
+		`href="#section"`,
+		`href="https://example.org/x"`,
+	} {
+		if !strings.Contains(got, e) {
+			t.Errorf("Expected %q in %q", e, got)
+		}
+	}
+
+	opts.BaseURL = "relative/base"
+	if _, err := CleanHTMLWithOptions(context.Background(), data, opts); err == nil {
+		t.Errorf("Expected an error for a relative base URL")
+	}
+}
+
+func TestStylesheet(t *testing.T) {
+	data := []byte(`<html><head><title>Styled</title></head><body><p>text</p></body></html>`)
+
+	opts := DefaultOptions()
+	opts.Stylesheet = "p { color: navy; }\n/* </style> */\n"
+	opts.StylesheetURL = "https://example.com/print.css?a=1&b=2"
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+
+	expect := "\n<style>\np { color: navy; }\n/* <\\/style> */\n</style>" +
+		"\n<link rel=\"stylesheet\" href=\"https://example.com/print.css?a=1&amp;b=2\"/></head>"
+	if !strings.Contains(got, expect) {
+		t.Errorf("Expected %q in %q", expect, got)
+	}
+}
+
+func TestImageRender(t *testing.T) {
+	data := []byte(`<html><body><p><img src="figs/1.png" alt="One" onload="x()" srcset="a.png 2x"></p></body></html>`)
+
+	opts := DefaultOptions()
+	opts.BaseURL = "https://example.com/a/page.html"
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Contains(got, "<img") {
+		t.Errorf("Expected no images by default, got %q", got)
+	}
+
+	opts.ImageRender = true
+	if got, _ = CleanHTMLWithOptions(context.Background(), data, opts); !strings.Contains(got, `<img src="https://example.com/a/figs/1.png" alt="One"/>`) {
+		t.Errorf("Expected the image with its src resolved, got %q", got)
+	}
+
+	opts.ImageSource = func(src string) string { return "local/" + path.Base(src) }
+	if got, _ = CleanHTMLWithOptions(context.Background(), data, opts); !strings.Contains(got, `<img src="local/1.png" alt="One"/>`) {
+		t.Errorf("Expected the src replaced, got %q", got)
+	}
+}
+
+// A Logger of its own keeps cleanhtml's messages apart
+func TestSetLogger(t *testing.T) {
+	var own, app bytes.Buffer
+	logger.SetOutput(&app)
+	defer logger.SetLogFile("")
+	SetLogger(logger.New(logger.WithOutput(&own)))
+	defer SetLogger(nil)
+
+	file := filepath.Join(t.TempDir(), "page.html")
+	if err := ioutil.WriteFile(file, []byte("<h1>Own</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fetch(file, DefaultFetchOptions()); err != nil {
+		t.Fatalf("Could not read %s: %v", file, err)
+	}
+	logger.Write(logger.INFO, "the application's")
+
+	if !strings.Contains(own.String(), "reading local file") || strings.Contains(own.String(), "application") {
+		t.Errorf("Expected only cleanhtml's messages in its Logger, got %q", own.String())
+	}
+	if strings.Contains(app.String(), "reading local file") || !strings.Contains(app.String(), "the application's") {
+		t.Errorf("Expected only the application's messages in the default Logger, got %q", app.String())
+	}
+}
+
+func TestSetLogger_Capture(t *testing.T) {
+	l := logger.New(logger.WithOutput(ioutil.Discard))
+	c := logger.NewCapture()
+	l.AddSink(c)
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	file := filepath.Join(t.TempDir(), "page.html")
+	if err := ioutil.WriteFile(file, []byte("<h1>Captured</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fetch(file, DefaultFetchOptions()); err != nil {
+		t.Fatalf("Could not read %s: %v", file, err)
+	}
+
+	infos := c.FilterByLevel(logger.INFO)
+	if len(infos) == 0 || infos[0].Message != "reading local file ["+file+"]" {
+		t.Errorf("Expected to capture the file being read, got %v", infos)
+	}
+	for _, e := range c.Entries() {
+		if e.Time.IsZero() {
+			t.Errorf("Expected the time of %+v", e)
+		}
+	}
+}
@@ -6,37 +6,47 @@ package cleanhtml
 
 import (
 	"bytes"
-	"io"
+	"context"
+	"fmt"
+	"net/url"
 
 	"github.com/scu/cleanpg/logger"
 	"golang.org/x/net/html"
 )
 
-var renderCanonicalMode bool = false
-
 // SetPostH1Render sets flag indicating whether
-// the renderer will process BODY elements until the
-// first H1 tag is reached
+// the renderer will skip BODY elements until the
+// selected heading (by default the first H1) is reached
 func SetPostH1Render(flag bool) {
-	renderCanonicalMode = flag
+	options.PostH1Render = flag
+}
+
+// SetPostH1Selection sets which h1 element starts
+// rendering when SetPostH1Render is on
+// [default = FirstH1]
+func SetPostH1Selection(sel H1Selection) {
+	options.PostH1Selection = sel
 }
 
-var renderStyle bool = true
+// SetPostHeadingLevel sets the level of the heading (1-6, or
+// AnyHeading) which starts rendering when SetPostH1Render is on
+// [default = 1]
+func SetPostHeadingLevel(level int) {
+	options.PostHeadingLevel = level
+}
 
 // SetStyleRender sets flag indicating whether
 // the renderer embeds tag-level styles automatically
 // [default = true]
 func SetStyleRender(flag bool) {
-	renderStyle = flag
+	options.StyleRender = flag
 }
 
-var renderLinks bool = true
-
 // SetLinksRender sets flag indicating whether
 // links <a... href...> will be rendered
 // [default = true]
 func SetLinksRender(flag bool) {
-	renderLinks = flag
+	options.LinksRender = flag
 }
 
 // CleanHTML provides a rendered HTML document.
@@ -44,15 +54,100 @@ func SetLinksRender(flag bool) {
 // parses and renders the data through a set of filters to produce
 // readable HTML output, which is returned as a string.
 func CleanHTML(data []byte) (string, error) {
+	return CleanHTMLContext(context.Background(), data)
+}
+
+// CleanHTMLContext is like CleanHTML but abandons the render
+// when ctx is done, returning ctx.Err() and discarding any
+// partial output.
+func CleanHTMLContext(ctx context.Context, data []byte) (string, error) {
+	return CleanHTMLWithOptions(ctx, data, options)
+}
+
+// CleanHTMLWithOptions is like CleanHTMLContext but renders
+// with opts rather than the package-level options.
+func CleanHTMLWithOptions(ctx context.Context, data []byte, opts Options) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	// Parse the document
 	docNodes, err := html.Parse(bytes.NewReader(data))
 	if err != nil {
-		logger.Write(logger.FATAL, "Could not parse HTML: %s", err)
+		logTo.Write(logger.FATAL, "Could not parse HTML: %s", err)
+		return "", &CleanError{Phase: "parse", Err: err}
+	}
+
+	// Parsing can't be interrupted, so check again before rendering
+	if err := ctx.Err(); err != nil {
 		return "", err
 	}
 
+	// Footnotes are found wherever they are, even left out
+	var ids map[string]*html.Node
+	if opts.Footnotes {
+		ids = indexIDs(docNodes)
+	}
+
+	// Excludes apply within the included elements
+	if opts.Include != "" {
+		if docNodes, err = applyInclude(docNodes, &opts); err != nil {
+			logTo.Write(logger.FATAL, "%s", err)
+			return "", err
+		}
+	}
+	if len(opts.Exclude) > 0 {
+		if err := removeExcluded(docNodes, opts.Exclude); err != nil {
+			logTo.Write(logger.FATAL, "%s", err)
+			return "", err
+		}
+	}
+	if len(opts.DenySubtrees) > 0 {
+		removeDenied(docNodes, opts.DenySubtrees)
+	}
+
 	var buf bytes.Buffer
-	w := io.Writer(&buf)
-	render(w.(writer), docNodes)
+	r := &renderer{ctx: ctx, opts: opts}
+	if opts.Footnotes {
+		r.footnotes = collectFootnotes(docNodes, ids)
+	}
+	if opts.BaseURL != "" {
+		base, err := url.Parse(opts.BaseURL)
+		if err != nil || !base.IsAbs() {
+			err = fmt.Errorf("cleanhtml: base URL [%s] must be absolute", opts.BaseURL)
+			logTo.Write(logger.FATAL, "%s", err)
+			return "", err
+		}
+		r.base = base
+	}
+	if opts.PostH1Render {
+		r.startHeading = selectStartHeading(docNodes, opts.PostHeadingLevel, opts.PostH1Selection)
+	}
+	if opts.DetectLanguage && !hasLang(docNodes) {
+		if r.lang = detectLanguage(docNodes, r.startHeading, &opts); r.lang != "" {
+			logTo.Write(logger.INFO, "detected the document language [%s]", r.lang)
+		}
+	}
+
+	// Long lines are wrapped as they're written
+	var w writer = &buf
+	var ww *wrapWriter
+	if opts.WrapColumn > 0 {
+		ww = newWrapWriter(&buf, opts.WrapColumn)
+		w = ww
+	}
+	if err := r.render(w, docNodes); err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		logTo.Write(logger.FATAL, "Could not render HTML: %s", err)
+		return "", &CleanError{Phase: "render", Err: err}
+	}
+	if ww != nil {
+		if err := ww.Flush(); err != nil {
+			logTo.Write(logger.FATAL, "Could not render HTML: %s", err)
+			return "", &CleanError{Phase: "render", Err: err}
+		}
+	}
 	return buf.String(), nil
 }
@@ -0,0 +1,245 @@
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// largeDocument generates a document with n paragraphs
+func largeDocument(n int) []byte {
+	var b strings.Builder
+	b.WriteString("<html><head><title>Large</title></head><body><h1>Large</h1>")
+	for i := 0; i < n; i++ {
+		b.WriteString("<p>Lorem ipsum <b>dolor</b> sit amet</p>")
+	}
+	b.WriteString("</body></html>")
+	return []byte(b.String())
+}
+
+// cancelWriter cancels its context once limit bytes have been written
+type cancelWriter struct {
+	bytes.Buffer
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (cw *cancelWriter) WriteString(s string) (int, error) {
+	if cw.Len() > cw.limit {
+		cw.cancel()
+	}
+	return cw.Buffer.WriteString(s)
+}
+
+func TestCleanHTMLContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got, err := CleanHTMLContext(ctx, largeDocument(10))
+	if err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+	if got != "" {
+		t.Errorf("Expected no output, got %q", got)
+	}
+}
+
+func TestCleanHTMLContext_MidRender(t *testing.T) {
+	docNodes, err := html.Parse(bytes.NewReader(largeDocument(50000)))
+	if err != nil {
+		t.Fatalf("Could not parse document: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cw := &cancelWriter{limit: 4096, cancel: cancel}
+
+	start := time.Now()
+	r := &renderer{ctx: ctx}
+	err = r.render(cw, docNodes)
+	elapsed := time.Since(start)
+
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected render to stop promptly, took %v", elapsed)
+	}
+	// The walk must stop within a check interval of the cancel
+	if max := cw.limit + renderCheckInterval*64; cw.Len() > max {
+		t.Errorf("Expected at most %d bytes rendered, got %d", max, cw.Len())
+	}
+}
+
+func TestSetPostH1Selection(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/logo-h1.html")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+
+	SetPostH1Render(true)
+	defer SetPostH1Render(false)
+	defer SetPostH1Selection(FirstH1)
+
+	tests := []struct {
+		sel      H1Selection
+		expect   string
+		unexpect string
+	}{
+		{FirstH1, "Sign up for our newsletter", ""},
+		{LastH1, "Copyright Gardening Weekly", "Late winter"},
+		{ContentH1, "Late winter", "Sign up for our newsletter"},
+	}
+
+	for _, tt := range tests {
+		SetPostH1Selection(tt.sel)
+		got, err := CleanHTML(data)
+		if err != nil {
+			t.Fatalf("Could not clean fixture: %v", err)
+		}
+		if !strings.Contains(got, tt.expect) {
+			t.Errorf("Selection %d: expected output to contain %q", tt.sel, tt.expect)
+		}
+		if tt.unexpect != "" && strings.Contains(got, tt.unexpect) {
+			t.Errorf("Selection %d: expected output not to contain %q", tt.sel, tt.unexpect)
+		}
+	}
+}
+
+func TestSetPostHeadingLevel(t *testing.T) {
+	data := []byte("<html><body><h1>Site</h1><p>Tagline</p><h2>Article</h2><p>" +
+		strings.Repeat("Some text. ", 20) + "</p><h3>Aside</h3><p>More.</p></body></html>")
+
+	SetPostH1Render(true)
+	defer SetPostH1Render(false)
+	defer SetPostHeadingLevel(1)
+	defer SetPostH1Selection(FirstH1)
+
+	tests := []struct {
+		level    int
+		sel      H1Selection
+		expect   string
+		unexpect string
+	}{
+		{0, FirstH1, "Site", ""},
+		{2, FirstH1, "Article", "Tagline"},
+		{3, LastH1, "Aside", "Article"},
+		{AnyHeading, FirstH1, "Site", ""},
+		{AnyHeading, ContentH1, "Article", "Tagline"},
+	}
+
+	for _, tt := range tests {
+		SetPostHeadingLevel(tt.level)
+		SetPostH1Selection(tt.sel)
+		got, err := CleanHTML(data)
+		if err != nil {
+			t.Fatalf("Could not clean: %v", err)
+		}
+		if !strings.Contains(got, tt.expect) {
+			t.Errorf("Level %d, selection %d: expected output to contain %q", tt.level, tt.sel, tt.expect)
+		}
+		if tt.unexpect != "" && strings.Contains(got, tt.unexpect) {
+			t.Errorf("Level %d, selection %d: expected output not to contain %q", tt.level, tt.sel, tt.unexpect)
+		}
+	}
+}
+
+func TestCanonicalStart(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/logo-h1.html")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.PostH1Render = true
+	opts.PostH1Selection = ContentH1
+	start, err := CanonicalStart(data, opts)
+	if err != nil {
+		t.Fatalf("Could not find the start: %v", err)
+	}
+	if expect := (&Heading{Tag: "h1", Text: "Pruning Roses"}); !reflect.DeepEqual(start, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, start)
+	}
+
+	opts.PostHeadingLevel = 2
+	if start, err := CanonicalStart(data, opts); err != nil || start != nil {
+		t.Errorf("Expected no h2 to start at, got %+v %v", start, err)
+	}
+	opts.PostHeadingLevel = 1
+	opts.Include = "div.article"
+	if start, err := CanonicalStart(data, opts); err != nil || start != nil {
+		t.Errorf("Expected no start with --include, got %+v %v", start, err)
+	}
+}
+
+func TestDenySubtrees(t *testing.T) {
+	data := []byte(`<html><body><h1>Article</h1><p>The article is kept, with its paragraphs.</p>` +
+		`<form action="/search"><label for="q"><span>Search the site</span></label>` +
+		`<input id="q" placeholder="Search articles"><button><b>Go</b></button>` +
+		`<p>Try a few words</p><select><option>All sections</option></select></form>` +
+		`<dialog open><p>Subscribe now</p></dialog>` +
+		`<template><p>Template row</p></template></body></html>`)
+
+	opts := DefaultOptions()
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, "Try a few words") || !strings.Contains(got, "Subscribe now") {
+		t.Errorf("Expected the text within forms and dialogs without a denylist, got %q", got)
+	}
+
+	opts.DenySubtrees = DefaultDenySubtrees()
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, "kept") {
+		t.Errorf("Expected the rest of the document, got %q", got)
+	}
+	for _, denied := range []string{"Search", "Go", "Try a few words", "All sections", "Subscribe now", "Template row"} {
+		if strings.Contains(got, denied) {
+			t.Errorf("Expected %q denied, got %q", denied, got)
+		}
+	}
+
+	// Tags in any case, on their own
+	opts.DenySubtrees = []string{"BUTTON"}
+	if got, err = CleanHTMLWithOptions(context.Background(), data, opts); err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if strings.Contains(got, "Go") || !strings.Contains(got, "Try a few words") {
+		t.Errorf("Expected only the button denied, got %q", got)
+	}
+}
+
+func TestDenySubtrees_FormWrappedPage(t *testing.T) {
+	// As ASP.NET WebForms renders every page
+	data := []byte(`<html><body><form method="post" action="./article.aspx" id="form1">` +
+		`<input type="hidden" name="__VIEWSTATE" value="dDwtMTA4MTY1">` +
+		`<div class="header"><label for="q">Search</label><input id="q" placeholder="Search articles"><button>Go</button></div>` +
+		`<h1>Pruning apple trees</h1><p>Prune in late winter, while the tree is dormant.</p>` +
+		`<p>Cut back to an outward-facing bud.</p></form></body></html>`)
+
+	opts := DefaultOptions()
+	opts.DenySubtrees = DefaultDenySubtrees()
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	for _, expect := range []string{"Pruning apple trees", "while the tree is dormant", "outward-facing bud"} {
+		if !strings.Contains(got, expect) {
+			t.Errorf("Expected %q kept, got %q", expect, got)
+		}
+	}
+	for _, denied := range []string{"__VIEWSTATE", "Search", "Go<", "<form"} {
+		if strings.Contains(got, denied) {
+			t.Errorf("Expected %q denied, got %q", denied, got)
+		}
+	}
+}
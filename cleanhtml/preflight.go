@@ -0,0 +1,48 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// preflight asks for the headers of url with a HEAD request and
+// returns a ContentTypeError or BodySizeError when the GET would
+// be refused anyway. Anything inconclusive (the server doesn't
+// support HEAD, omits the headers, or the request fails) is left
+// for the GET to decide.
+func preflight(ctx context.Context, client *http.Client, url string, opts *FetchOptions) error {
+	req, err := newRequest(ctx, http.MethodHead, url, opts)
+	if err != nil {
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logTo.Write(logger.INFO, "preflight of [%s] failed, continuing: %s", url, err)
+		return nil
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Includes 405 and 501 from servers without HEAD support
+		logTo.Write(logger.INFO, "preflight of [%s] answered %q, continuing", url, resp.Status)
+		return nil
+	}
+
+	url = resp.Request.URL.String()
+	declaredType := mediaType(resp.Header.Get("Content-Type"))
+	if !opts.AllowNonHTML && !isGenericType(declaredType) && !isHTMLType(declaredType) {
+		return &ContentTypeError{URL: url, ContentType: declaredType}
+	}
+	if opts.MaxBodyBytes > 0 && resp.ContentLength > opts.MaxBodyBytes {
+		return &BodySizeError{URL: url, Limit: opts.MaxBodyBytes, ContentLength: resp.ContentLength}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetch_Preflight(t *testing.T) {
+	var gets int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets++
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>page</p>"))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/report.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/huge":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "1000000")
+		case "/no-head":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "/not-implemented":
+			w.WriteHeader(http.StatusNotImplemented)
+		case "/bare":
+			// No type or length to go on
+		default:
+			w.Header().Set("Content-Type", "text/html")
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		expect error // nil when the document is fetched
+		gets   int   // GETs made so far
+	}{
+		{"/report.pdf", ErrNotHTML, 0},
+		{"/huge", ErrBodyTooLarge, 0},
+		{"/no-head", nil, 1},
+		{"/not-implemented", nil, 2},
+		{"/bare", nil, 3},
+		{"/page", nil, 4},
+	}
+
+	opts := FetchOptions{Preflight: true, MaxBodyBytes: 1024}
+	for _, tt := range tests {
+		result, err := fetch(context.Background(), ts.URL+tt.path, opts)
+		if tt.expect != nil {
+			if !errors.Is(err, tt.expect) {
+				t.Errorf("%s: expected %v, got %v", tt.path, tt.expect, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.path, err)
+		} else if string(result.Body) != "<p>page</p>" {
+			t.Errorf("%s: expected the page, got %q", tt.path, result.Body)
+		}
+		if gets != tt.gets {
+			t.Errorf("%s: expected %d GETs, got %d", tt.path, tt.gets, gets)
+		}
+	}
+}
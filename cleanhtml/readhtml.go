@@ -5,30 +5,51 @@
 package cleanhtml
 
 import (
-	"io/ioutil"
+	"context"
+	"io"
 	"net/http"
-
-	"github.com/scu/cleanpg/logger"
 )
 
 // ReadHTML reads a web page and returns a string
 // containing the unfiltered document, which is then
 // passed to cleanhtml.CleanHTML to render the result.
 func ReadHTML(url string) ([]byte, error) {
+	return ReadHTMLContext(context.Background(), url)
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		logger.Write(logger.FATAL, "Could not get url [%s]: %s", url, err)
-		return nil, err
+// ReadHTMLContext is like ReadHTML but abandons the
+// request, including a body read in progress, when
+// ctx is done and returns ctx.Err().
+func ReadHTMLContext(ctx context.Context, url string) ([]byte, error) {
+	var f Fetcher = &HTTPFetcher{Options: fetchOptions}
+	if fetcher != nil {
+		f = fetcher
 	}
-	defer resp.Body.Close()
 
-	// read html as a slice of bytes
-	html, err := ioutil.ReadAll(resp.Body)
+	result, err := f.Fetch(ctx, url)
 	if err != nil {
-		logger.Write(logger.FATAL, "Could not read bytes from [%s]: %s", url, err)
 		return nil, err
 	}
+	return result.Body, nil
+}
+
+// ReadHTMLFrom reads a document from r, such as a body already
+// fetched by another HTTP stack, and returns it as UTF-8 HTML.
+// opts.MaxBodyBytes and opts.AllowNonHTML apply as for a fetch,
+// gzip input is decompressed and the charset is detected from
+// the document itself. The network settings in opts are ignored.
+func ReadHTMLFrom(r io.Reader, opts FetchOptions) ([]byte, error) {
+	return decodeBody(r, "", "", -1, "reader", opts)
+}
 
-	return html, nil
+// ReadHTMLResponse is like ReadHTMLFrom but reads the body of
+// resp, such as one stored in an archive, using its headers
+// to undo any Content-Encoding and find the charset
+func ReadHTMLResponse(resp *http.Response, opts FetchOptions) ([]byte, error) {
+	url := "response"
+	if resp.Request != nil {
+		url = resp.Request.URL.String()
+	}
+	return decodeBody(resp.Body, resp.Header.Get("Content-Encoding"),
+		resp.Header.Get("Content-Type"), resp.ContentLength, url, opts)
 }
@@ -0,0 +1,108 @@
+package cleanhtml
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowHandler writes the start of a document then stalls
+// until the client goes away
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte("<html><body><p>partial"))
+	w.(http.Flusher).Flush()
+	<-r.Context().Done()
+}
+
+func TestReadHTMLContext_Cancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(slowHandler))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	got, err := ReadHTMLContext(ctx, ts.URL)
+	elapsed := time.Since(start)
+
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if got != nil {
+		t.Errorf("Expected no data, got %q", got)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("Expected prompt return after cancel, took %v", elapsed)
+	}
+}
+
+func TestReadHTML(t *testing.T) {
+	expect := "<html><body><p>hello</p></body></html>"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(expect))
+	}))
+	defer ts.Close()
+
+	got, err := ReadHTML(ts.URL)
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", ts.URL, err)
+	}
+	if string(got) != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestReadHTMLFrom(t *testing.T) {
+	// "Café déjà vu" in ISO-8859-1, gzipped
+	latin1 := []byte("<html><head><meta charset=\"iso-8859-1\"></head><body><p>Caf\xe9 d\xe9j\xe0 vu</p></body></html>")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(latin1)
+	zw.Close()
+
+	got, err := ReadHTMLFrom(bytes.NewReader(gz.Bytes()), DefaultFetchOptions())
+	if err != nil {
+		t.Fatalf("Could not read: %v", err)
+	}
+	if expect := "<p>Café déjà vu</p>"; !strings.Contains(string(got), expect) {
+		t.Errorf("Expected %q in %q", expect, got)
+	}
+
+	// The size limit and HTML check still apply
+	opts := DefaultFetchOptions()
+	opts.MaxBodyBytes = 16
+	if _, err := ReadHTMLFrom(bytes.NewReader(latin1), opts); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected %v, got %v", ErrBodyTooLarge, err)
+	}
+	if _, err := ReadHTMLFrom(strings.NewReader("%PDF-1.4 not html"), DefaultFetchOptions()); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("Expected %v, got %v", ErrNotHTML, err)
+	}
+}
+
+func TestReadHTMLResponse(t *testing.T) {
+	resp := &http.Response{
+		Header:        http.Header{"Content-Type": {"text/html; charset=iso-8859-1"}},
+		Body:          ioutil.NopCloser(strings.NewReader("<p>Caf\xe9</p>")),
+		ContentLength: -1,
+	}
+	got, err := ReadHTMLResponse(resp, DefaultFetchOptions())
+	if err != nil {
+		t.Fatalf("Could not read: %v", err)
+	}
+	if string(got) != "<p>Café</p>" {
+		t.Errorf("Expected %q, got %q", "<p>Café</p>", got)
+	}
+
+	resp.Header.Set("Content-Type", "image/png")
+	if _, err := ReadHTMLResponse(resp, DefaultFetchOptions()); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("Expected %v, got %v", ErrNotHTML, err)
+	}
+}
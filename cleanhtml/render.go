@@ -5,9 +5,11 @@
 package cleanhtml
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"strings"
 	"unicode"
 
@@ -20,6 +22,61 @@ type writer interface {
 	WriteString(string) (int, error)
 }
 
+// renderCheckInterval is the number of nodes visited
+// between checks of the render context
+const renderCheckInterval = 256
+
+// renderer holds the state of a single render walk
+type renderer struct {
+	ctx   context.Context // render is abandoned when ctx is done
+	opts  Options         // settings for this render
+	base  *url.URL        // parsed opts.BaseURL, nil if unset
+	nodes int             // nodes visited so far
+
+	// In canonical mode, body elements are skipped until startHeading
+	startHeading                   *html.Node
+	encounteredBodyElement         bool
+	encounteredStartHeadingElement bool
+
+	footnotes *footnotes // with Options.Footnotes, nil if there are none
+
+	// With Options.Gallery, the images rendered at the end
+	gallery     []galleryImage
+	gallerySrcs map[string]bool
+
+	lang string // with Options.DetectLanguage, the lang detected for the html element
+}
+
+// checkContext returns the context error, if any, each
+// time another renderCheckInterval nodes have been visited
+func (r *renderer) checkContext() error {
+	r.nodes++
+	if r.nodes%renderCheckInterval != 0 {
+		return nil
+	}
+	return r.ctx.Err()
+}
+
+// urlAttributes holds the attributes whose values are URLs
+var urlAttributes = map[string]bool{
+	"href": true,
+	"src":  true,
+}
+
+// resolveURL resolves a link against the document base, leaving
+// it unchanged when there is no base or it can't be parsed
+func (r *renderer) resolveURL(link string) string {
+	// In-page fragments keep working in the cleaned document
+	if r.base == nil || strings.HasPrefix(strings.TrimSpace(link), "#") {
+		return link
+	}
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return link
+	}
+	return r.base.ResolveReference(ref).String()
+}
+
 // escape writes escaped characters correctly
 func escape(w writer, s string) error {
 	const escapedChars = "&'<>\"\r"
@@ -86,7 +143,7 @@ func cleanStyle(text string) string {
 }
 
 // renderStartTag renders the start tag "\n<tag attr...>"
-func renderStartTag(w writer, n *html.Node) error {
+func (r *renderer) renderStartTag(w writer, n *html.Node) error {
 	// Begin element with a NL (for readability)
 	if err := w.WriteByte('\n'); err != nil {
 		return err
@@ -100,7 +157,7 @@ func renderStartTag(w writer, n *html.Node) error {
 	}
 
 	// Add style attribute if present
-	if renderStyle && renderableHTML[n.Data].style != "" {
+	if r.opts.StyleRender && renderableHTML[n.Data].style != "" {
 		styleAttrib := fmt.Sprintf(" style=\"%s\"", renderableHTML[n.Data].style)
 		if _, err := w.WriteString(cleanStyle(styleAttrib)); err != nil {
 			return err
@@ -108,9 +165,20 @@ func renderStartTag(w writer, n *html.Node) error {
 	}
 
 	// Render any attributes
-	if err := renderAttributes(w, n); err != nil {
+	if err := r.renderAttributes(w, n); err != nil {
 		return err
 	}
+	if n.Data == "html" && r.lang != "" {
+		if _, err := w.WriteString(` lang="`); err != nil {
+			return err
+		}
+		if err := escape(w, r.lang); err != nil {
+			return err
+		}
+		if err := w.WriteByte('"'); err != nil {
+			return err
+		}
+	}
 
 	if voidElements[n.Data] {
 		if n.FirstChild != nil {
@@ -127,7 +195,7 @@ func renderStartTag(w writer, n *html.Node) error {
 }
 
 // renderCloseTag renders the closing tag "</tag>"
-func renderCloseTag(w writer, n *html.Node) error {
+func (r *renderer) renderCloseTag(w writer, n *html.Node) error {
 	closeTag := fmt.Sprintf("</%s>", n.Data)
 	if _, err := w.WriteString(closeTag); err != nil {
 		return err
@@ -135,11 +203,36 @@ func renderCloseTag(w writer, n *html.Node) error {
 	return nil
 }
 
+// renderStylesheets renders the stylesheets of the options
+// as "\n<style>...</style>" and "\n<link rel=...>"
+func (r *renderer) renderStylesheets(w writer) error {
+	if r.opts.Stylesheet != "" {
+		// The CSS can't be allowed to end the element early
+		css := strings.ReplaceAll(r.opts.Stylesheet, "</", `<\/`)
+		if _, err := fmt.Fprintf(w, "\n<style>\n%s\n</style>", strings.TrimSpace(css)); err != nil {
+			return err
+		}
+	}
+	if r.opts.StylesheetURL != "" {
+		if _, err := w.WriteString("\n<link rel=\"stylesheet\" href=\""); err != nil {
+			return err
+		}
+		if err := escape(w, r.resolveURL(r.opts.StylesheetURL)); err != nil {
+			return err
+		}
+		if _, err := w.WriteString("\"/>"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // renderAttributes renders an html.ElementNode's attributes
-func renderAttributes(w writer, n *html.Node) error {
+func (r *renderer) renderAttributes(w writer, n *html.Node) error {
 	// Check attributes on html.ElementNode
 	for _, a := range n.Attr {
-		if isElementAttributeRenderable(n.Data, a.Key) {
+		if isElementAttributeRenderable(n.Data, a.Key) ||
+			r.opts.keepDataAttribute(a.Key) {
 			if err := w.WriteByte(' '); err != nil {
 				return err
 			}
@@ -154,8 +247,23 @@ func renderAttributes(w writer, n *html.Node) error {
 				}
 			}
 			// Render element key="value" attributes
-			keyVal := fmt.Sprintf("%s=\"%s\"", a.Key, a.Val)
-			if _, err := w.WriteString(keyVal); err != nil {
+			if _, err := w.WriteString(a.Key); err != nil {
+				return err
+			}
+			if _, err := w.WriteString("=\""); err != nil {
+				return err
+			}
+			val := a.Val
+			if urlAttributes[a.Key] {
+				val = r.resolveURL(val)
+			}
+			if n.Data == "img" && a.Key == "src" && r.opts.ImageSource != nil {
+				val = r.opts.ImageSource(val)
+			}
+			if err := escape(w, val); err != nil {
+				return err
+			}
+			if err := w.WriteByte('"'); err != nil {
 				return err
 			}
 		}
@@ -164,12 +272,19 @@ func renderAttributes(w writer, n *html.Node) error {
 }
 
 // render is the main entry point for the rendering engine
-func render(w writer, n *html.Node) error {
+func (r *renderer) render(w writer, n *html.Node) error {
+	if err := r.checkContext(); err != nil {
+		return err
+	}
+
 	// Render all nodes except ElementNode
 	switch n.Type {
 	case html.ErrorNode:
 		return fmt.Errorf("cleanhtml: error node [%s]", n.Data)
 	case html.TextNode:
+		if r.opts.TrimTitle && n.Parent != nil && n.Parent.Data == "title" {
+			return escape(w, TrimSiteSuffix(strings.Join(strings.Fields(n.Data), " ")))
+		}
 		if !isTextWhitespace(n.Data) {
 			escape(w, n.Data)
 		}
@@ -177,7 +292,7 @@ func render(w writer, n *html.Node) error {
 	case html.DocumentNode:
 		// Starts here, render each node in doc nodes
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			if err := render(w, c); err != nil {
+			if err := r.render(w, c); err != nil {
 				return err
 			}
 		}
@@ -200,11 +315,34 @@ func render(w writer, n *html.Node) error {
 		return errors.New("html: unknown node type")
 	}
 
+	if r.footnotes != nil {
+		if marker, err := r.renderFootnoteMarker(w, n); marker {
+			return err
+		}
+	}
+
+	// Images go to the gallery rather than in place
+	if r.opts.Gallery && r.opts.ImageRender && r.collectGalleryImage(n) {
+		return nil
+	}
+
+	// Textareas keep their content, whitespace and all
+	if strings.EqualFold(n.Data, "textarea") {
+		return r.renderTextarea(w, n)
+	}
+
+	// Form controls are rendered as text, on request
+	if r.opts.FormsAsText {
+		if control, err := r.renderFormControl(w, n); control {
+			return err
+		}
+	}
+
 	// Determine if renderable
-	renderElement := isElementRenderable(n.Data)
+	renderElement := r.isElementRenderable(n)
 
 	if renderElement {
-		if err := renderStartTag(w, n); err != nil {
+		if err := r.renderStartTag(w, n); err != nil {
 			return err
 		}
 	}
@@ -212,17 +350,30 @@ func render(w writer, n *html.Node) error {
 	// Render child nodes.
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
 		// Don't render a TextNode if the parent element is unrenderable (i.e. <script>...</script>)
-		if c.Type == html.TextNode && !isElementRenderable(c.Parent.Data) {
+		if c.Type == html.TextNode && !r.isElementRenderable(c.Parent) {
 			continue
 		}
-		if err := render(w, c); err != nil {
+		if err := r.render(w, c); err != nil {
 			return err
 		}
 	}
 
 	// Close out the tag
 	if renderElement {
-		if err := renderCloseTag(w, n); err != nil {
+		if n.Data == "head" {
+			if err := r.renderStylesheets(w); err != nil {
+				return err
+			}
+		}
+		if n.Data == "body" {
+			if err := r.renderGallery(w); err != nil {
+				return err
+			}
+			if err := r.renderFootnotes(w); err != nil {
+				return err
+			}
+		}
+		if err := r.renderCloseTag(w, n); err != nil {
 			return err
 		}
 	}
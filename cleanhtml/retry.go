@@ -0,0 +1,108 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter caps the wait requested by a Retry-After header
+const maxRetryAfter = 2 * time.Minute
+
+// isRetryable determines if a failed fetch may succeed when
+// repeated: network errors, timeouts, 5xx and 429 statuses.
+// Other 4xx statuses and policy errors are never retried.
+func isRetryable(err error) bool {
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		return serr.StatusCode >= 500 || serr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var terr *TimeoutError
+	var operr *net.OpError
+	var dnserr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// The caller gave up
+		return false
+	case errors.As(err, &dnserr):
+		return dnserr.IsTimeout || dnserr.IsTemporary
+	case errors.As(err, &terr), errors.As(err, &operr):
+		// Timeouts, refused and reset connections
+		return true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		// The server hung up mid-response
+		return true
+	}
+
+	return false
+}
+
+// parseRetryAfter returns the delay requested by the Retry-After
+// header of a 429 or 503 response, or 0 if there isn't one
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	// Either delay-seconds or an HTTP-date
+	var delay time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = time.Until(t)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return delay
+}
+
+// retryDelay returns the wait before retrying after the given
+// (1-based) failed attempt: the server's Retry-After if it sent
+// one, otherwise base doubled for each attempt, with jitter
+func retryDelay(base time.Duration, attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	if base <= 0 {
+		base = DefaultRetryDelay
+	}
+
+	backoff := base << uint(attempt-1)
+	// Spread retries across [backoff/2, backoff*3/2)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// sleepContext waits for d, returning early with ctx.Err()
+// if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package cleanhtml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetch_Retries(t *testing.T) {
+	tests := []struct {
+		failures     []int // statuses returned before succeeding
+		retries      int
+		expectCalls  int
+		expectStatus int // 0 expects success
+	}{
+		{[]int{502, 503}, 3, 3, 0},
+		{[]int{429}, 1, 2, 0},
+		{[]int{500, 500, 500}, 2, 3, 500},
+		{[]int{404}, 3, 1, 404},
+	}
+
+	// Pages and assets are retried alike
+	fetchers := map[string]func(url string, opts FetchOptions) error{
+		"page": func(url string, opts FetchOptions) error {
+			_, err := fetch(context.Background(), url, opts)
+			return err
+		},
+		"asset": func(url string, opts FetchOptions) error {
+			_, err := FetchAsset(context.Background(), url, opts)
+			return err
+		},
+	}
+
+	for name, fetchURL := range fetchers {
+		for _, tt := range tests {
+			calls := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls <= len(tt.failures) {
+					w.WriteHeader(tt.failures[calls-1])
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<p>ok</p>"))
+			}))
+
+			opts := FetchOptions{Retries: tt.retries, RetryDelay: time.Millisecond}
+			err := fetchURL(ts.URL, opts)
+			ts.Close()
+
+			if calls != tt.expectCalls {
+				t.Errorf("%s %v: expected %d requests, got %d", name, tt.failures, tt.expectCalls, calls)
+			}
+			if tt.expectStatus == 0 {
+				if err != nil {
+					t.Errorf("%s %v: expected success, got %v", name, tt.failures, err)
+				}
+				continue
+			}
+			serr, ok := err.(*StatusError)
+			if !ok || serr.StatusCode != tt.expectStatus {
+				t.Errorf("%s %v: expected status %d, got %v", name, tt.failures, tt.expectStatus, err)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		expect     time.Duration
+	}{
+		{503, "3", 3 * time.Second},
+		{429, "120", 2 * time.Minute},
+		{429, "100000", maxRetryAfter},
+		{500, "3", 0},
+		{503, "soon", 0},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		resp.Header.Set("Retry-After", tt.retryAfter)
+		if got := parseRetryAfter(resp); got != tt.expect {
+			t.Errorf("%d %q: expected %v, got %v", tt.status, tt.retryAfter, tt.expect, got)
+		}
+	}
+}
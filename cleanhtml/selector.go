@@ -0,0 +1,366 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector matches elements against a group of CSS selectors,
+// e.g. "article.main, #content > div". Type, universal, id, class
+// and attribute selectors are supported, combined by descendant
+// and child combinators.
+type Selector struct {
+	text    string
+	complex [][]compound // each alternative, rightmost compound last
+}
+
+// compound is a sequence of simple selectors on one element,
+// and the combinator relating it to the compound before it
+type compound struct {
+	tag     string // "" matches any element
+	id      string
+	classes []string
+	attrs   []attrSelector
+	child   bool // ">" rather than a descendant combinator
+}
+
+// attrSelector is an attribute selector such as [rel~="nofollow"]
+type attrSelector struct {
+	key, op, value string // op is "" when only presence is tested
+}
+
+// ParseSelector parses a group of CSS selectors
+func ParseSelector(text string) (*Selector, error) {
+	sel := &Selector{text: text}
+	for _, alt := range splitGroup(text) {
+		p := &selectorParser{s: strings.TrimSpace(alt)}
+		c, err := p.parseComplex()
+		if err != nil {
+			return nil, fmt.Errorf("cleanhtml: invalid selector [%s]: %s", strings.TrimSpace(alt), err)
+		}
+		sel.complex = append(sel.complex, c)
+	}
+	return sel, nil
+}
+
+// splitGroup splits a selector group at the commas outside
+// of quoted attribute values
+func splitGroup(text string) []string {
+	var alts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == ',':
+			alts = append(alts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(alts, text[start:])
+}
+
+// String returns the selector text
+func (sel *Selector) String() string {
+	return sel.text
+}
+
+// Match determines if n is an element matching the selector
+func (sel *Selector) Match(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, c := range sel.complex {
+		if matchComplex(c, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchComplex matches the compounds of c right to left, against
+// n and then its parent or ancestors as the combinators require
+func matchComplex(c []compound, n *html.Node) bool {
+	last := c[len(c)-1]
+	if !last.match(n) {
+		return false
+	}
+	if len(c) == 1 {
+		return true
+	}
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if matchComplex(c[:len(c)-1], p) {
+			return true
+		}
+		if last.child {
+			break
+		}
+	}
+	return false
+}
+
+// match determines if the element n matches every simple selector
+func (c *compound) match(n *html.Node) bool {
+	if c.tag != "" && !strings.EqualFold(n.Data, c.tag) {
+		return false
+	}
+	if c.id != "" && getAttr(n, "id") != c.id {
+		return false
+	}
+	classes := strings.Fields(getAttr(n, "class"))
+	for _, class := range c.classes {
+		if !containsString(classes, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// match determines if the element n has a matching attribute
+func (a *attrSelector) match(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if !strings.EqualFold(attr.Key, a.key) {
+			continue
+		}
+		switch a.op {
+		case "":
+			return true
+		case "=":
+			return attr.Val == a.value
+		case "~=":
+			return containsString(strings.Fields(attr.Val), a.value)
+		case "^=":
+			return a.value != "" && strings.HasPrefix(attr.Val, a.value)
+		case "$=":
+			return a.value != "" && strings.HasSuffix(attr.Val, a.value)
+		case "*=":
+			return a.value != "" && strings.Contains(attr.Val, a.value)
+		}
+	}
+	return false
+}
+
+// containsString determines if list includes s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorParser reads one complex selector
+type selectorParser struct {
+	s   string
+	pos int
+}
+
+// parseComplex reads compounds separated by combinators
+func (p *selectorParser) parseComplex() ([]compound, error) {
+	if p.s == "" {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	var c []compound
+	child := false
+	for {
+		comp, err := p.parseCompound()
+		if err != nil {
+			return nil, err
+		}
+		comp.child = child
+		c = append(c, comp)
+
+		space := p.skipSpace()
+		if p.pos == len(p.s) {
+			return c, nil
+		}
+		switch p.s[p.pos] {
+		case '>':
+			p.pos++
+			p.skipSpace()
+			child = true
+		case '+', '~':
+			return nil, fmt.Errorf("combinator %q is not supported", p.s[p.pos])
+		default:
+			if !space {
+				return nil, fmt.Errorf("unexpected %q", p.s[p.pos:])
+			}
+			child = false
+		}
+		if p.pos == len(p.s) {
+			return nil, fmt.Errorf("missing selector after combinator")
+		}
+	}
+}
+
+// parseCompound reads a type selector and the simple selectors after it
+func (p *selectorParser) parseCompound() (compound, error) {
+	var c compound
+	start := p.pos
+
+	if p.pos < len(p.s) && p.s[p.pos] == '*' {
+		p.pos++
+	} else if name := p.parseIdent(); name != "" {
+		c.tag = strings.ToLower(name)
+	}
+
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case '#':
+			p.pos++
+			if c.id = p.parseIdent(); c.id == "" {
+				return c, fmt.Errorf("missing id after #")
+			}
+		case '.':
+			p.pos++
+			class := p.parseIdent()
+			if class == "" {
+				return c, fmt.Errorf("missing class after .")
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			a, err := p.parseAttr()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, a)
+		case ':':
+			return c, fmt.Errorf("pseudo-class %q is not supported", p.s[p.pos:])
+		default:
+			if p.pos == start {
+				return c, fmt.Errorf("unexpected %q", p.s[p.pos:])
+			}
+			return c, nil
+		}
+	}
+	return c, nil
+}
+
+// parseAttr reads an attribute selector, e.g. [href^="https:"]
+func (p *selectorParser) parseAttr() (attrSelector, error) {
+	var a attrSelector
+	p.pos++ // '['
+	p.skipSpace()
+	if a.key = p.parseIdent(); a.key == "" {
+		return a, fmt.Errorf("missing attribute name")
+	}
+	p.skipSpace()
+
+	for _, op := range []string{"=", "~=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			a.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if a.op != "" {
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return a, err
+		}
+		a.value = value
+		p.skipSpace()
+	}
+
+	if p.pos == len(p.s) || p.s[p.pos] != ']' {
+		return a, fmt.Errorf("unterminated attribute selector")
+	}
+	p.pos++
+	return a, nil
+}
+
+// parseValue reads a quoted or unquoted attribute value
+func (p *selectorParser) parseValue() (string, error) {
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		q := p.s[p.pos]
+		end := strings.IndexByte(p.s[p.pos+1:], q)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		value := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return value, nil
+	}
+	if value := p.parseIdent(); value != "" {
+		return value, nil
+	}
+	return "", fmt.Errorf("missing attribute value")
+}
+
+// parseIdent reads a name made of letters, digits, "-" and "_"
+func (p *selectorParser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		ch := p.s[p.pos]
+		if ch == '-' || ch == '_' || ch >= 0x80 ||
+			('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.pos]
+}
+
+// skipSpace skips whitespace, reporting whether there was any
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n\r\f", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// removeExcluded removes the elements of doc matching any of the
+// selectors, along with their contents
+func removeExcluded(doc *html.Node, selectors []string) error {
+	var sels []*Selector
+	for _, text := range selectors {
+		sel, err := ParseSelector(text)
+		if err != nil {
+			return err
+		}
+		sels = append(sels, sel)
+	}
+
+	var remove func(n *html.Node)
+	remove = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			excluded := false
+			for _, sel := range sels {
+				if sel.Match(c) {
+					excluded = true
+					break
+				}
+			}
+			if excluded {
+				n.RemoveChild(c)
+			} else {
+				remove(c)
+			}
+			c = next
+		}
+	}
+	remove(doc)
+	return nil
+}
@@ -0,0 +1,155 @@
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSelector(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>` +
+		`<div id="main" class="post wide"><p class="lead">one</p><section><p>two</p></section></div>` +
+		`<nav><a href="https://example.com/x" rel="nofollow noopener">x</a></nav>` +
+		`</body></html>`))
+	if err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	tests := []struct {
+		selector string
+		expect   string // text of the matched elements, in order
+	}{
+		{"p", "one two"},
+		{"#main", "onetwo"},
+		{"div.post.wide > p", "one"},
+		{"div p", "one two"},
+		{"div > p, nav a", "one x"},
+		{".lead, section", "one two"},
+		{"*.post", "onetwo"},
+		{`a[rel~=nofollow]`, "x"},
+		{`a[href^="https:"][href$='/x']`, "x"},
+		{"[href*=example]", "x"},
+		{"P.LEAD", ""},
+		{"nav > p", ""},
+	}
+
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.selector)
+		if err != nil {
+			t.Errorf("%q: could not parse: %v", tt.selector, err)
+			continue
+		}
+		var got []string
+		var walk func(n *html.Node)
+		walk = func(n *html.Node) {
+			if sel.Match(n) {
+				got = append(got, rawText(n))
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(doc)
+		if strings.Join(got, " ") != tt.expect {
+			t.Errorf("%q: expected %q, got %q", tt.selector, tt.expect, got)
+		}
+	}
+}
+
+func TestParseSelector_Errors(t *testing.T) {
+	tests := []struct {
+		selector string
+		expect   string
+	}{
+		{"", "invalid selector []: empty selector"},
+		{"div,", "invalid selector []: empty selector"},
+		{"a:hover", "pseudo-class"},
+		{"h1 + p", "not supported"},
+		{"div >", "missing selector after combinator"},
+		{"a[href", "unterminated attribute selector"},
+		{"#", "missing id"},
+		{"div)", `invalid selector [div)]: unexpected ")"`},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseSelector(tt.selector); err == nil || !strings.Contains(err.Error(), tt.expect) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.selector, tt.expect, err)
+		}
+	}
+}
+
+func TestExclude(t *testing.T) {
+	data := []byte(`<html><body><h1>Title</h1><p>kept</p>` +
+		`<div class="comments"><p>comment</p></div><div id="sidebar">sidebar</div>` +
+		`<nav>nav</nav></body></html>`)
+
+	opts := DefaultOptions()
+	opts.Exclude = []string{".comments, #sidebar", "nav"}
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, "kept") {
+		t.Errorf("Expected the rest of the document, got %q", got)
+	}
+	for _, r := range []string{"comment", "sidebar", "nav"} {
+		if strings.Contains(got, r) {
+			t.Errorf("Expected %q excluded, got %q", r, got)
+		}
+	}
+
+	opts.Exclude = []string{"div["}
+	if _, err := CleanHTMLWithOptions(context.Background(), data, opts); err == nil {
+		t.Errorf("Expected an error for an invalid selector")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	data := []byte(`<html><head><title>Page</title></head><body><nav>menu</nav>` +
+		`<article class="main"><h2>First</h2><p>one <span class="ad">ad</span></p></article>` +
+		`<aside>aside</aside><article class="main"><p>two</p></article></body></html>`)
+
+	tests := []struct {
+		all    bool
+		expect []string
+		reject []string
+	}{
+		{false, []string{"<title>Page</title>", "<body", "First", "one"}, []string{"menu", "aside", "two", "ad<"}},
+		{true, []string{"one", "two"}, []string{"menu", "aside"}},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.PostH1Render = true
+		opts.Include = "article.main"
+		opts.IncludeAll = tt.all
+		opts.Exclude = []string{".ad"}
+		got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+		if err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+		for _, e := range tt.expect {
+			if !strings.Contains(got, e) {
+				t.Errorf("all=%v: expected %q in %q", tt.all, e, got)
+			}
+		}
+		for _, r := range tt.reject {
+			if strings.Contains(got, r) {
+				t.Errorf("all=%v: expected no %q in %q", tt.all, r, got)
+			}
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.Include = "#missing"
+	if _, err := CleanHTMLWithOptions(context.Background(), data, opts); !errors.Is(err, ErrNoIncludeMatch) {
+		t.Errorf("Expected ErrNoIncludeMatch, got %v", err)
+	}
+	opts.IncludeFallback = true
+	if got, err := CleanHTMLWithOptions(context.Background(), data, opts); err != nil || !strings.Contains(got, "two") {
+		t.Errorf("Expected the whole document, got %q %v", got, err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// sitemapDocument holds either a urlset or a sitemapindex
+// (see https://www.sitemaps.org/protocol.html)
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+// sitemapLoc is a url or sitemap entry
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// parseSitemap returns the page URLs and child sitemap URLs in data
+func parseSitemap(data []byte) (pages, sitemaps []string, err error) {
+	var doc sitemapDocument
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// data has already been converted to UTF-8
+	dec.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+		return r, nil
+	}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("cleanhtml: invalid sitemap: %w", err)
+	}
+
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				pages = append(pages, loc)
+			}
+		}
+	case "sitemapindex":
+		for _, s := range doc.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				sitemaps = append(sitemaps, loc)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("cleanhtml: invalid sitemap: unexpected <%s> element", doc.XMLName.Local)
+	}
+
+	return pages, sitemaps, nil
+}
+
+// fetchSitemap fetches and parses one sitemap, gzipped or not
+func fetchSitemap(ctx context.Context, url string, opts FetchOptions) (pages, sitemaps []string, err error) {
+	opts.AllowNonHTML = true
+	opts.FollowMetaRefresh = false
+
+	result, err := fetch(ctx, url, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseSitemap(result.Body)
+}
+
+// ReadSitemap returns the page URLs listed by the sitemap at url,
+// in order. A sitemap index is followed one level, to the sitemaps
+// it lists; indexes nested deeper are skipped with a WARNING, as
+// are child sitemaps which can't be read.
+func ReadSitemap(ctx context.Context, url string, opts FetchOptions) ([]string, error) {
+	pages, sitemaps, err := fetchSitemap(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range sitemaps {
+		childPages, nested, err := fetchSitemap(ctx, child, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logTo.Write(logger.WARNING, "skipping sitemap [%s]: %s", child, err)
+			continue
+		}
+		if len(nested) > 0 {
+			logTo.Write(logger.WARNING, "skipping %d sitemaps nested in index [%s]", len(nested), child)
+		}
+		pages = append(pages, childPages...)
+	}
+
+	logTo.Write(logger.INFO, "sitemap [%s] lists %d pages", url, len(pages))
+	return pages, nil
+}
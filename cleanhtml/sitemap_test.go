@@ -0,0 +1,53 @@
+package cleanhtml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestReadSitemap(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + ts.URL + `/docs.xml</loc></sitemap>
+  <sitemap><loc>` + ts.URL + `/missing.xml</loc></sitemap>
+  <sitemap><loc>` + ts.URL + `/nested.xml</loc></sitemap>
+</sitemapindex>`))
+		case "/docs.xml":
+			w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://example.com/a </loc><lastmod>2020-01-01</lastmod></url>
+  <url><loc>https://example.com/b</loc></url>
+</urlset>`))
+		case "/nested.xml":
+			w.Write([]byte(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + ts.URL + `/docs.xml</loc></sitemap>
+</sitemapindex>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	got, err := ReadSitemap(context.Background(), ts.URL+"/sitemap.xml", FetchOptions{})
+	if err != nil {
+		t.Fatalf("Could not read sitemap: %v", err)
+	}
+	expect := []string{"https://example.com/a", "https://example.com/b"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	if _, err := ReadSitemap(context.Background(), ts.URL+"/missing.xml", FetchOptions{}); err == nil {
+		t.Errorf("Expected an error for a missing sitemap")
+	}
+	if _, _, err := parseSitemap([]byte("<html><body>not a sitemap</body></html>")); err == nil {
+		t.Errorf("Expected an error for a document which isn't a sitemap")
+	}
+}
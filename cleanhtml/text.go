@@ -0,0 +1,355 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// textRenderer converts a cleaned document to Markdown or plain
+// text, collecting its blocks (paragraphs, headings, etc.) in order
+type textRenderer struct {
+	markdown     bool
+	width        int  // plain text wrap width (0 = no wrapping)
+	titleHeading bool // with Options.TitleHeading
+	blocks       []string
+	line         strings.Builder // inline text of the current block
+}
+
+// RenderMarkdown converts a document produced by CleanHTML to
+// Markdown, keeping its headings, emphasis, links, code and tables,
+// and starting with its title as set by SetTitleHeading
+func RenderMarkdown(cleaned string) (string, error) {
+	return renderBlocks(cleaned, &textRenderer{markdown: true, titleHeading: options.TitleHeading})
+}
+
+// RenderText converts a document produced by CleanHTML to plain
+// text, wrapping paragraphs at width columns (0 = no wrapping), and
+// starting with its title as set by SetTitleHeading
+func RenderText(cleaned string, width int) (string, error) {
+	return renderBlocks(cleaned, &textRenderer{width: width, titleHeading: options.TitleHeading})
+}
+
+// SetTitleHeading sets flag indicating whether Markdown and text
+// start with the document's title as a heading, unless it repeats
+// the first h1
+// [default = false]
+func SetTitleHeading(flag bool) {
+	options.TitleHeading = flag
+}
+
+// renderBlocks parses cleaned and renders its body with tr
+func renderBlocks(cleaned string, tr *textRenderer) (string, error) {
+	doc, err := html.Parse(strings.NewReader(cleaned))
+	if err != nil {
+		return "", err
+	}
+
+	if tr.titleHeading {
+		title := findElement(doc, atom.Title)
+		if h1 := findElement(doc, atom.H1); title != nil && (h1 == nil || !SameTitle(textContent(title), textContent(h1))) {
+			tr.heading(1, textContent(title))
+		}
+	}
+	tr.walk(doc)
+	tr.flush()
+	if len(tr.blocks) == 0 {
+		return "", nil
+	}
+	return strings.Join(tr.blocks, "\n\n") + "\n", nil
+}
+
+// flush ends the current block
+func (tr *textRenderer) flush() {
+	text := strings.TrimSpace(tr.line.String())
+	tr.line.Reset()
+	if text == "" {
+		return
+	}
+	if !tr.markdown && tr.width > 0 {
+		text = wrapText(text, tr.width)
+	}
+	tr.blocks = append(tr.blocks, text)
+}
+
+// addBlock ends the current block and adds a finished one
+func (tr *textRenderer) addBlock(block string) {
+	tr.flush()
+	if block != "" {
+		tr.blocks = append(tr.blocks, block)
+	}
+}
+
+// inline renders the children of n as a single line of text
+func (tr *textRenderer) inline(n *html.Node) string {
+	sub := &textRenderer{markdown: tr.markdown}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sub.walk(c)
+	}
+	return strings.Join(strings.Fields(sub.line.String()), " ")
+}
+
+// nested renders the children of n as blocks of their own
+func (tr *textRenderer) nested(n *html.Node) []string {
+	sub := &textRenderer{markdown: tr.markdown, width: tr.width}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sub.walk(c)
+	}
+	sub.flush()
+	return sub.blocks
+}
+
+// walk renders n and its children
+func (tr *textRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		tr.writeText(n.Data)
+		return
+	case html.DocumentNode:
+		tr.walkChildren(n)
+		return
+	case html.ElementNode:
+		// Handled below
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head:
+		// The title is repeated by the first heading
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		tr.heading(int(n.Data[1]-'0'), tr.inline(n))
+	case atom.P, atom.Div:
+		tr.flush()
+		tr.walkChildren(n)
+		tr.flush()
+	case atom.Blockquote:
+		prefix := "    "
+		if tr.markdown {
+			prefix = "> "
+		}
+		var quoted []string
+		for _, block := range tr.nested(n) {
+			quoted = append(quoted, prefixLines(block, prefix))
+		}
+		tr.addBlock(strings.Join(quoted, "\n"+strings.TrimRight(prefix, " ")+"\n"))
+	case atom.Pre:
+		code := strings.Trim(rawText(n), "\n")
+		if tr.markdown {
+			tr.addBlock("```\n" + code + "\n```")
+		} else {
+			tr.addBlock(prefixLines(code, "    "))
+		}
+	case atom.Table:
+		tr.addBlock(tr.table(n))
+	case atom.Ol:
+		// As the References list of the footnotes
+		tr.flush()
+		number := 0
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Li {
+				number++
+				tr.addBlock(strconv.Itoa(number) + ". " + tr.inline(c))
+			}
+		}
+	case atom.Br:
+		if tr.markdown {
+			tr.line.WriteString("  \n")
+		} else {
+			tr.line.WriteString("\n")
+		}
+	case atom.Code:
+		tr.wrapInline(n, "`")
+	case atom.B, atom.Strong:
+		tr.wrapInline(n, "**")
+	case atom.I, atom.Em:
+		tr.wrapInline(n, "_")
+	case atom.A:
+		text := tr.inline(n)
+		href := getAttr(n, "href")
+		switch {
+		case href == "" || strings.HasPrefix(href, "#"):
+			tr.line.WriteString(text)
+		case tr.markdown:
+			tr.line.WriteString("[" + text + "](" + href + ")")
+		case text == "" || text == href:
+			tr.line.WriteString(href)
+		default:
+			tr.line.WriteString(text + " (" + href + ")")
+		}
+	case atom.Img:
+		// Only the alternative text of an image is readable as text
+		alt := strings.TrimSpace(getAttr(n, "alt"))
+		switch {
+		case tr.markdown:
+			tr.line.WriteString("![" + alt + "](" + getAttr(n, "src") + ")")
+		case alt != "":
+			tr.line.WriteString("[" + alt + "]")
+		}
+	default:
+		tr.walkChildren(n)
+	}
+}
+
+// heading adds a heading of level
+func (tr *textRenderer) heading(level int, text string) {
+	switch {
+	case text == "":
+	case tr.markdown:
+		tr.addBlock(strings.Repeat("#", level) + " " + text)
+	case level == 1:
+		tr.addBlock(text + "\n" + strings.Repeat("=", len([]rune(text))))
+	case level == 2:
+		tr.addBlock(text + "\n" + strings.Repeat("-", len([]rune(text))))
+	default:
+		tr.addBlock(text)
+	}
+}
+
+// walkChildren renders the children of n
+func (tr *textRenderer) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		tr.walk(c)
+	}
+}
+
+// writeText adds text to the current block, collapsing white space
+func (tr *textRenderer) writeText(text string) {
+	if strings.TrimSpace(text) == "" {
+		if tr.line.Len() > 0 && text != "" {
+			tr.line.WriteString(" ")
+		}
+		return
+	}
+	collapsed := strings.Join(strings.Fields(text), " ")
+	if strings.TrimLeft(text, " \t\r\n") != text && tr.line.Len() > 0 {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(text, " \t\r\n") != text {
+		collapsed += " "
+	}
+	tr.line.WriteString(collapsed)
+}
+
+// wrapInline renders the children of n between marker, for
+// Markdown emphasis; plain text only keeps the words
+func (tr *textRenderer) wrapInline(n *html.Node, marker string) {
+	text := tr.inline(n)
+	if text == "" {
+		return
+	}
+	if tr.markdown {
+		text = marker + text + marker
+	}
+	tr.line.WriteString(text)
+}
+
+// table renders a table's rows, the first as the header
+func (tr *textRenderer) table(n *html.Node) string {
+	var rows [][]string
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.DataAtom != atom.Tr {
+				visit(c)
+				continue
+			}
+			var row []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.DataAtom != atom.Td && cell.DataAtom != atom.Th {
+					continue
+				}
+				text := tr.inline(cell)
+				if tr.markdown {
+					text = strings.Replace(text, "|", "\\|", -1)
+				}
+				row = append(row, text)
+			}
+			if len(row) > 0 {
+				rows = append(rows, row)
+			}
+		}
+	}
+	visit(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var lines []string
+	for i, row := range rows {
+		if !tr.markdown {
+			lines = append(lines, strings.Join(row, " | "))
+			continue
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", len(row)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rawText returns all the text beneath n, white space included
+func rawText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(rawText(c))
+	}
+	return b.String()
+}
+
+// getAttr returns the value of n's attribute key, or ""
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// prefixLines starts each line of text with prefix
+func prefixLines(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrapText breaks each line of text at spaces so that none
+// is longer than width, unless a single word is
+func wrapText(text string, width int) string {
+	var wrapped []string
+	for _, line := range strings.Split(text, "\n") {
+		var b strings.Builder
+		col := 0
+		for _, word := range strings.Fields(line) {
+			n := len([]rune(word))
+			if col > 0 && col+1+n > width {
+				b.WriteString("\n")
+				col = 0
+			}
+			if col > 0 {
+				b.WriteString(" ")
+				col++
+			}
+			b.WriteString(word)
+			col += n
+		}
+		wrapped = append(wrapped, b.String())
+	}
+	return strings.Join(wrapped, "\n")
+}
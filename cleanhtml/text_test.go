@@ -0,0 +1,99 @@
+package cleanhtml
+
+import "testing"
+
+// textDocument is a cleaned document using each element rendered
+const textDocument = `<!DOCTYPE html><html><head><title>Guide</title></head><body>
+<h1>The Guide</h1>
+<p>Some <b>bold</b> and <em>slanted</em> words with
+   <code>go run</code> and <a href="https://example.com/more">a link</a>.</p>
+<h2>Steps</h2>
+<blockquote><p>Quoted once.</p><p>And twice.</p></blockquote>
+<pre>func main() {
+	fmt.Println("hi")
+}</pre>
+<table><tr><th>Name</th><th>Value</th></tr><tr><td>a|b</td><td>1</td></tr></table>
+<p>Line one<br>line two</p>
+<p><img src="https://example.com/fig.png" alt="A figure"/></p>
+</body></html>`
+
+func TestRenderMarkdown(t *testing.T) {
+	expect := "# The Guide\n\n" +
+		"Some **bold** and _slanted_ words with `go run` and [a link](https://example.com/more).\n\n" +
+		"## Steps\n\n" +
+		"> Quoted once.\n>\n> And twice.\n\n" +
+		"```\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\n\n" +
+		"| Name | Value |\n| --- | --- |\n| a\\|b | 1 |\n\n" +
+		"Line one  \nline two\n\n" +
+		"![A figure](https://example.com/fig.png)\n"
+
+	got, err := RenderMarkdown(textDocument)
+	if err != nil {
+		t.Fatalf("Could not render: %v", err)
+	}
+	if got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	expect := "The Guide\n=========\n\n" +
+		"Some bold and slanted words with go run\nand a link (https://example.com/more).\n\n" +
+		"Steps\n-----\n\n" +
+		"    Quoted once.\n\n    And twice.\n\n" +
+		"    func main() {\n    \tfmt.Println(\"hi\")\n    }\n\n" +
+		"Name | Value\na|b | 1\n\n" +
+		"Line one\nline two\n\n" +
+		"[A figure]\n"
+
+	got, err := RenderText(textDocument, 40)
+	if err != nil {
+		t.Fatalf("Could not render: %v", err)
+	}
+	if got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	if got, _ := RenderText("<p>"+"word "+"word</p>", 0); got != "word word\n" {
+		t.Errorf("Expected no wrapping, got %q", got)
+	}
+}
+
+func TestRenderMarkdown_TitleHeading(t *testing.T) {
+	SetTitleHeading(true)
+	defer SetTitleHeading(false)
+
+	tests := []struct {
+		doc    string
+		expect string
+	}{
+		// Exact, and site suffix, matches aren't repeated
+		{"<html><head><title>Winter pruning</title></head><body><h1>Winter  Pruning</h1><p>Text.</p></body></html>",
+			"# Winter Pruning\n\nText.\n"},
+		{"<html><head><title>Winter pruning – Orchard News</title></head><body><h1>Winter pruning</h1><p>Text.</p></body></html>",
+			"# Winter pruning\n\nText.\n"},
+		// A different heading, or none, is
+		{"<html><head><title>Orchard News</title></head><body><h1>Winter pruning</h1><p>Text.</p></body></html>",
+			"# Orchard News\n\n# Winter pruning\n\nText.\n"},
+		{"<html><head><title>Winter pruning</title></head><body><p>Text.</p></body></html>",
+			"# Winter pruning\n\nText.\n"},
+	}
+
+	for _, tt := range tests {
+		got, err := RenderMarkdown(tt.doc)
+		if err != nil {
+			t.Fatalf("Could not render: %v", err)
+		}
+		if got != tt.expect {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+	}
+
+	got, err := RenderText(tests[2].doc, 0)
+	if err != nil {
+		t.Fatalf("Could not render: %v", err)
+	}
+	if expect := "Orchard News\n============\n\nWinter pruning\n==============\n\nText.\n"; got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
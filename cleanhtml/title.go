@@ -0,0 +1,95 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Title returns the text of the <title> in the head of data,
+// with runs of white space collapsed, or "" when it has none
+func Title(data []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				// The title belongs in the head
+				return ""
+			case atom.Svg:
+				// An SVG has titles of its own
+				return ""
+			case atom.Title:
+				if z.Next() != html.TextToken {
+					return ""
+				}
+				return strings.Join(strings.Fields(string(z.Text())), " ")
+			}
+		}
+	}
+}
+
+// titleSeparators are those between a page's title and the name of
+// its site, e.g. "Apple grafting - Wikipedia"
+var titleSeparators = []string{" | ", " – ", " — ", " - ", " · ", " :: ", " » "}
+
+// maxSiteNameWords is the most words taken as a site name
+const maxSiteNameWords = 4
+
+// TrimSiteSuffix returns title without the site name after its last
+// separator, e.g. "Apple grafting" for "Apple grafting - Wikipedia".
+// Only a short suffix after a longer title is taken as a site name;
+// otherwise title is returned as it is.
+func TrimSiteSuffix(title string) string {
+	cut := -1
+	var sep string
+	for _, s := range titleSeparators {
+		if i := strings.LastIndex(title, s); i > cut {
+			cut, sep = i, s
+		}
+	}
+	if cut <= 0 {
+		return title
+	}
+
+	page, site := strings.TrimSpace(title[:cut]), strings.TrimSpace(title[cut+len(sep):])
+	if site == "" || len(strings.Fields(site)) > maxSiteNameWords || len(site) > len(page) {
+		return title
+	}
+	return page
+}
+
+// normalizeTitle reduces a title or heading to compare with another:
+// lower case, with white space collapsed and dashes made alike
+func normalizeTitle(title string) string {
+	title = strings.NewReplacer("–", "-", "—", "-").Replace(strings.ToLower(title))
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// SameTitle reports whether a page's title repeats its heading, as
+// they're normally seen: ignoring case and white space, and any site
+// name after the title (see TrimSiteSuffix)
+func SameTitle(title, heading string) bool {
+	heading = normalizeTitle(heading)
+	if heading == "" {
+		return false
+	}
+	return normalizeTitle(title) == heading || normalizeTitle(TrimSiteSuffix(title)) == heading
+}
+
+// SetTrimTitle sets flag indicating whether the title is rendered
+// without the site name after it
+// [default = false]
+func SetTrimTitle(flag bool) {
+	options.TrimTitle = flag
+}
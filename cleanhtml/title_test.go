@@ -0,0 +1,85 @@
+package cleanhtml
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		doc    string
+		expect string
+	}{
+		{"<html><head><title>Getting started</title></head><body></body></html>", "Getting started"},
+		{"<title>\n  Fish &amp; chips\n  </title>", "Fish & chips"},
+		{"<html><head><title></title></head></html>", ""},
+		{"<html><head></head><body><title>Late</title></body></html>", ""},
+		{"<p>no head</p>", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Title([]byte(tt.doc)); got != tt.expect {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+	}
+}
+
+func TestTrimSiteSuffix(t *testing.T) {
+	tests := []struct {
+		title  string
+		expect string
+	}{
+		{"Apple grafting - Wikipedia", "Apple grafting"},
+		{"Storm closes coastal roads – Example News", "Storm closes coastal roads"},
+		{"Pruning | The Orchard Blog", "Pruning | The Orchard Blog"},          // the site name is longer
+		{"Pruning and training | Blog | Grow", "Pruning and training | Blog"}, // only the last
+		{"Cats - a short history of the domestic cat in Europe", "Cats - a short history of the domestic cat in Europe"},
+		{"Self-sufficiency", "Self-sufficiency"},
+		{"- Example News", "- Example News"},
+		{"Getting started", "Getting started"},
+	}
+
+	for _, tt := range tests {
+		if got := TrimSiteSuffix(tt.title); got != tt.expect {
+			t.Errorf("%q: expected %q, got %q", tt.title, tt.expect, got)
+		}
+	}
+}
+
+func TestSameTitle(t *testing.T) {
+	tests := []struct {
+		title, heading string
+		expect         bool
+	}{
+		{"Getting started", "Getting started", true},
+		{"Getting  STARTED", "getting started\n", true},
+		{"Storm closes coastal roads – Example News", "Storm closes coastal roads", true},
+		{"Apple grafting - Wikipedia", "Apple Grafting", true},
+		{"Storm closes coastal roads – Example News", "Storm closes coastal roads – example news", true},
+		{"Example News", "Storm closes coastal roads", false},
+		{"Storm closes coastal roads – Example News", "Storm closes roads", false},
+		{"Pruning | The Orchard Blog", "Pruning", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := SameTitle(tt.title, tt.heading); got != tt.expect {
+			t.Errorf("%q, %q: expected %v, got %v", tt.title, tt.heading, tt.expect, got)
+		}
+	}
+}
+
+func TestTrimTitle(t *testing.T) {
+	data := []byte("<html><head><title>\n  Winter pruning – Orchard News\n</title></head><body><h1>Winter pruning</h1></body></html>")
+
+	opts := DefaultOptions()
+	opts.TrimTitle = true
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if !strings.Contains(got, "<title>Winter pruning</title>") || Title([]byte(got)) != "Winter pruning" {
+		t.Errorf("Expected the title without the site name, got %q", got)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/scu/cleanpg/logger"
+	"golang.org/x/net/proxy"
+)
+
+// defaultTransport bounds connection setup so a black-holed
+// host can't stall a fetch before the request timeouts apply
+var defaultTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	TLSHandshakeTimeout:   10 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+	MaxIdleConns:          100,
+}
+
+// defaultClient is used for fetches without a client of their own
+var defaultClient = &http.Client{Transport: defaultTransport}
+
+// clientFor returns the client to fetch with under opts
+func clientFor(opts *FetchOptions) (*http.Client, error) {
+	var client http.Client
+	if opts.Client != nil {
+		client = *opts.Client
+	} else {
+		transport, err := transportFor(opts)
+		if err != nil {
+			return nil, err
+		}
+		client = *defaultClient
+		client.Transport = transport
+	}
+
+	if opts.CookieJar != nil {
+		client.Jar = opts.CookieJar
+	}
+	if client.CheckRedirect == nil {
+		client.CheckRedirect = opts.checkRedirect
+	}
+	return &client, nil
+}
+
+// transports caches a Transport for each proxy and TLS
+// setting so connections are reused across fetches
+var transports = struct {
+	sync.Mutex
+	m map[string]*http.Transport
+}{m: make(map[string]*http.Transport)}
+
+// transportFor returns the Transport to fetch with under opts
+func transportFor(opts *FetchOptions) (*http.Transport, error) {
+	if opts.ProxyURL == "" && !opts.NoProxy && !opts.customTLS() && !opts.PublicOnly {
+		return defaultTransport, nil
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+
+	key := fmt.Sprintf("%s|%v|%s|%s|%s|%v|%v", opts.ProxyURL, opts.NoProxy, opts.CACertFile,
+		opts.ClientCertFile, opts.ClientKeyFile, opts.InsecureSkipVerify, opts.PublicOnly)
+	if t, ok := transports.m[key]; ok {
+		return t, nil
+	}
+
+	t := defaultTransport.Clone()
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if opts.PublicOnly {
+		dialer.Control = publicOnly
+		t.DialContext = dialer.DialContext
+	}
+	if opts.NoProxy {
+		t.Proxy = nil
+	}
+	if opts.ProxyURL != "" {
+		if err := setProxy(t, opts.ProxyURL, dialer); err != nil {
+			return nil, err
+		}
+	}
+	if opts.customTLS() {
+		tlsConfig, err := opts.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+
+	transports.m[key] = t
+	return t, nil
+}
+
+// publicOnly is a net.Dialer Control refusing to connect to
+// addresses which aren't public
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: [%s]", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// privateNets are the private and shared address ranges
+// (RFC 1918, RFC 6598 and RFC 4193 unique local addresses)
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPublicIP determines if ip is a public unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// customTLS determines if opts changes the default TLS settings
+func (opts *FetchOptions) customTLS() bool {
+	return opts.CACertFile != "" || opts.ClientCertFile != "" ||
+		opts.ClientKeyFile != "" || opts.InsecureSkipVerify
+}
+
+// tlsConfig builds the TLS settings described by opts
+func (opts *FetchOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if opts.CACertFile != "" {
+		pem, err := ioutil.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("cleanhtml: could not read CA certificates: %w", err)
+		}
+		// Extend rather than replace the system authorities
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cleanhtml: no certificates found in [%s]", opts.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, fmt.Errorf("cleanhtml: a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cleanhtml: could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.InsecureSkipVerify {
+		logTo.Write(logger.WARNING, "*** TLS CERTIFICATE VERIFICATION IS DISABLED: "+
+			"connections can be intercepted; use only with trusted development servers ***")
+		config.InsecureSkipVerify = true
+	}
+
+	return config, nil
+}
+
+// ParseProxyURL parses the URL of an http, https or socks5
+// proxy, as FetchOptions.ProxyURL takes it
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("cleanhtml: invalid proxy URL [%s]", proxyURL)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("cleanhtml: unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+}
+
+// setProxy routes all of a Transport's requests through proxyURL,
+// a SOCKS proxy being reached with forward
+func setProxy(t *http.Transport, proxyURL string, forward *net.Dialer) error {
+	u, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		t.Proxy = http.ProxyURL(u)
+	} else {
+		dialer, err := proxy.FromURL(u, forward)
+		if err != nil {
+			return fmt.Errorf("cleanhtml: invalid proxy URL [%s]: %w", proxyURL, err)
+		}
+		t.Proxy = nil
+		t.DialContext = dialer.(proxy.ContextDialer).DialContext
+	}
+	return nil
+}
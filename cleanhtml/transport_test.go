@@ -0,0 +1,203 @@
+package cleanhtml
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetch_Proxy(t *testing.T) {
+	// The proxy answers for every host itself, recording what it saw
+	var proxied []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>via proxy</p>"))
+	}))
+	defer proxyServer.Close()
+
+	target := "http://cleanpg.invalid/article"
+	result, err := fetch(context.Background(), target, FetchOptions{ProxyURL: proxyServer.URL})
+	if err != nil {
+		t.Fatalf("Could not fetch through proxy: %v", err)
+	}
+	if len(proxied) != 1 || proxied[0] != target {
+		t.Errorf("Expected the proxy to see %q, got %q", target, proxied)
+	}
+	if string(result.Body) != "<p>via proxy</p>" {
+		t.Errorf("Expected the proxy's response, got %q", result.Body)
+	}
+
+	// Transports are reused for the same proxy
+	t1, _ := transportFor(&FetchOptions{ProxyURL: proxyServer.URL})
+	t2, _ := transportFor(&FetchOptions{ProxyURL: proxyServer.URL})
+	if t1 != t2 {
+		t.Errorf("Expected the proxy transport to be cached")
+	}
+
+	for _, bad := range []string{"ftp://proxy:21", "not a url", "socks5://"} {
+		if _, err := transportFor(&FetchOptions{ProxyURL: bad}); err == nil {
+			t.Errorf("%q: expected an invalid proxy error", bad)
+		}
+	}
+
+	// Without the environment's proxies, the transport has none
+	direct, err := transportFor(&FetchOptions{NoProxy: true})
+	if err != nil || direct.Proxy != nil || direct == defaultTransport {
+		t.Errorf("Expected a transport without a proxy, got %v", err)
+	}
+	viaProxy, err := transportFor(&FetchOptions{NoProxy: true, ProxyURL: proxyServer.URL})
+	if err != nil || viaProxy.Proxy == nil {
+		t.Errorf("Expected ProxyURL to take precedence over NoProxy, got %v", err)
+	}
+}
+
+func TestFetch_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>secure</p>"))
+	}))
+	defer ts.Close()
+
+	// The test server's certificate is self-signed, so it is its own CA
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("Could not write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		opts   FetchOptions
+		expect bool
+	}{
+		{"system pool", FetchOptions{}, false},
+		{"custom CA", FetchOptions{CACertFile: caFile}, true},
+		{"insecure", FetchOptions{InsecureSkipVerify: true}, true},
+	}
+
+	for _, tt := range tests {
+		result, err := fetch(context.Background(), ts.URL, tt.opts)
+		if tt.expect {
+			if err != nil {
+				t.Errorf("%s: could not fetch: %v", tt.name, err)
+			} else if string(result.Body) != "<p>secure</p>" {
+				t.Errorf("%s: expected %q, got %q", tt.name, "<p>secure</p>", result.Body)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected a certificate error", tt.name)
+		}
+	}
+
+	bad := []FetchOptions{
+		{CACertFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{CACertFile: "transport_test.go"},
+		{ClientCertFile: caFile},
+	}
+	for _, opts := range bad {
+		if _, err := transportFor(&opts); err == nil {
+			t.Errorf("%+v: expected a TLS configuration error", opts)
+		}
+	}
+}
+
+// recordingTransport records each request it sends
+type recordingTransport struct {
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFetch_HTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>hello</p>"))
+	}))
+	defer ts.Close()
+
+	rt := &recordingTransport{}
+	SetHTTPClient(&http.Client{Transport: rt})
+	defer SetHTTPClient(nil)
+
+	if _, err := ReadHTML(ts.URL + "/old"); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	expect := []string{ts.URL + "/old", ts.URL + "/new"}
+	if !reflect.DeepEqual(rt.urls, expect) {
+		t.Errorf("Expected the client to send %q, got %q", expect, rt.urls)
+	}
+
+	// Nil restores the package client
+	SetHTTPClient(nil)
+	if _, err := ReadHTML(ts.URL); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+	if len(rt.urls) != 2 {
+		t.Errorf("Expected the default client after reset, got %q", rt.urls)
+	}
+}
+
+func TestFetch_PublicOnly(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>local</p>"))
+	}))
+	defer ts.Close()
+
+	if _, err := fetch(context.Background(), ts.URL, FetchOptions{PublicOnly: true}); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected ErrPrivateAddress for a loopback server, got %v", err)
+	}
+	if _, err := fetch(context.Background(), ts.URL, FetchOptions{}); err != nil {
+		t.Errorf("Expected the fetch allowed without PublicOnly, got %v", err)
+	}
+
+	// With a SOCKS proxy, its address is the one checked
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := socks.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conn.Close()
+		}
+	}()
+	opts := FetchOptions{PublicOnly: true, ProxyURL: "socks5://" + socks.Addr().String()}
+	if _, err := fetch(context.Background(), "http://93.184.216.34/", opts); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected ErrPrivateAddress for a loopback SOCKS proxy, got %v", err)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 0 {
+		t.Errorf("Expected no connection to the proxy, got %d", n)
+	}
+
+	for ip, public := range map[string]bool{
+		"93.184.216.34": true, "2606:4700::1111": true,
+		"127.0.0.1": false, "::1": false, "10.1.2.3": false, "172.20.0.1": false,
+		"192.168.1.1": false, "169.254.169.254": false, "0.0.0.0": false, "fd00::1": false,
+	} {
+		if got := isPublicIP(net.ParseIP(ip)); got != public {
+			t.Errorf("%s: expected public %v, got %v", ip, public, got)
+		}
+	}
+}
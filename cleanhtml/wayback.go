@@ -0,0 +1,123 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// DefaultWaybackAPI is the Wayback Machine availability API
+const DefaultWaybackAPI = "https://archive.org/wayback/available"
+
+// waybackResponse is the reply of the availability API
+type waybackResponse struct {
+	ArchivedSnapshots struct {
+		Closest *struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+			Status    string `json:"status"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// isDeadLink determines if err means the page is gone, rather
+// than unreachable for the moment
+func isDeadLink(err error) bool {
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		return serr.StatusCode == http.StatusNotFound || serr.StatusCode == http.StatusGone
+	}
+	var dnserr *net.DNSError
+	if errors.As(err, &dnserr) {
+		return !dnserr.IsTimeout && !dnserr.IsTemporary
+	}
+	return false
+}
+
+// findSnapshot asks the availability API for the archived copy of
+// rawurl closest to now, returning "" when there is none
+func findSnapshot(ctx context.Context, rawurl string, opts FetchOptions) (string, error) {
+	api := opts.WaybackAPI
+	if api == "" {
+		api = DefaultWaybackAPI
+	}
+	u, err := url.Parse(api)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("url", rawurl)
+	u.RawQuery = q.Encode()
+
+	client, err := clientFor(&opts)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("availability API returned %s", resp.Status)
+	}
+
+	var reply waybackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("invalid availability API reply: %s", err)
+	}
+	closest := reply.ArchivedSnapshots.Closest
+	if closest == nil || !closest.Available || closest.URL == "" {
+		return "", nil
+	}
+	return closest.URL, nil
+}
+
+// fetchSnapshot fetches the archived copy of rawurl after fetchErr,
+// returning fetchErr itself when there is no usable copy. The
+// archive is sent none of the credentials, headers and cookies
+// meant for rawurl's host, nor given the cookie jar.
+func fetchSnapshot(ctx context.Context, rawurl string, fetchErr error, opts FetchOptions) (*FetchResult, error) {
+	opts = opts.WithoutCredentials()
+	opts.CookieJar = nil
+	opts.WaybackFallback = false
+
+	snapshot, err := findSnapshot(ctx, rawurl, opts)
+	if err != nil {
+		logTo.Write(logger.WARNING, "could not look up an archived copy of [%s]: %s", rawurl, err)
+		return nil, fetchErr
+	}
+	if snapshot == "" {
+		logTo.Write(logger.NOTICE, "no archived copy of [%s]", rawurl)
+		return nil, fetchErr
+	}
+	if u, err := url.Parse(snapshot); err != nil || !isHTTP(u) {
+		logTo.Write(logger.WARNING, "ignoring archived copy [%s] of [%s]: not an http or https URL", snapshot, rawurl)
+		return nil, fetchErr
+	}
+
+	logTo.Write(logger.NOTICE, "[%s] is gone, fetching archived copy [%s]", rawurl, snapshot)
+	result, err := fetchDocument(ctx, snapshot, opts)
+	if err != nil {
+		return nil, fetchErr
+	}
+	result.Snapshot = snapshot
+	return result, nil
+}
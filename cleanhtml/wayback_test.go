@@ -0,0 +1,144 @@
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFetch_WaybackFallback(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			http.Error(w, "gone", http.StatusGone)
+		case "/live":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>live</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>archived " + r.URL.Path + "</p>"))
+	}))
+	defer archive.Close()
+
+	var lookups []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("url")
+		lookups = append(lookups, page)
+		switch {
+		case strings.HasSuffix(page, "/broken-api"):
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case strings.HasSuffix(page, "/never-archived"):
+			w.Write([]byte(`{"url": "` + page + `", "archived_snapshots": {}}`))
+		default:
+			w.Write([]byte(`{"archived_snapshots": {"closest": {"available": true, "status": "200",
+				"timestamp": "20200101000000", "url": "` + archive.URL + `/web/20200101000000/page"}}}`))
+		}
+	}))
+	defer api.Close()
+
+	opts := FetchOptions{WaybackFallback: true, WaybackAPI: api.URL}
+
+	for _, path := range []string{"/missing", "/gone"} {
+		result, err := fetch(context.Background(), origin.URL+path, opts)
+		if err != nil {
+			t.Fatalf("%s: expected the archived copy, got %v", path, err)
+		}
+		if string(result.Body) != "<p>archived /web/20200101000000/page</p>" {
+			t.Errorf("%s: expected the archived document, got %q", path, result.Body)
+		}
+		if expect := archive.URL + "/web/20200101000000/page"; result.Snapshot != expect || result.URL != expect {
+			t.Errorf("%s: expected snapshot %q, got %q (URL %q)", path, expect, result.Snapshot, result.URL)
+		}
+	}
+
+	// A live page is never looked up
+	lookups = nil
+	result, err := fetch(context.Background(), origin.URL+"/live", opts)
+	if err != nil || result.Snapshot != "" || len(lookups) != 0 {
+		t.Errorf("Expected the live page without a lookup, got %v %q after %q", err, result.Snapshot, lookups)
+	}
+
+	// Without a usable copy the original error stands
+	for _, path := range []string{"/never-archived", "/broken-api"} {
+		_, err := fetch(context.Background(), origin.URL+path, opts)
+		var serr *StatusError
+		if !errors.As(err, &serr) || serr.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected the original 404, got %v", path, err)
+		}
+	}
+
+	// And nothing is looked up unless asked
+	lookups = nil
+	if _, err := fetch(context.Background(), origin.URL+"/missing", FetchOptions{WaybackAPI: api.URL}); err == nil || len(lookups) != 0 {
+		t.Errorf("Expected a plain 404 without the fallback, got %v after %q", err, lookups)
+	}
+}
+
+func TestFetch_WaybackFallbackCredentials(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer origin.Close()
+
+	var seen []http.Header
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		http.SetCookie(w, &http.Cookie{Name: "archive", Value: "1"})
+		if r.URL.Path == "/available" {
+			w.Write([]byte(`{"archived_snapshots": {"closest": {"available": true, "status": "200",
+				"timestamp": "20200101000000", "url": "http://` + r.Host + `/web/20200101000000/page"}}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>archived</p>"))
+	}))
+	defer archive.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	originURL, _ := url.Parse(origin.URL)
+	jar.SetCookies(originURL, []*http.Cookie{{Name: "jarred", Value: "j4r"}})
+
+	opts := FetchOptions{
+		WaybackFallback: true,
+		WaybackAPI:      archive.URL + "/available",
+		Username:        "bob",
+		Password:        "hunter2",
+		Headers:         http.Header{"X-Token": {"abc"}},
+		Cookies:         []*http.Cookie{{Name: "session", Value: "s3cret"}},
+		CookieJar:       jar,
+	}
+	if _, err := fetch(context.Background(), origin.URL+"/missing", opts); err != nil {
+		t.Fatalf("Expected the archived copy, got %v", err)
+	}
+
+	if len(seen) != 2 {
+		t.Fatalf("Expected the lookup and the snapshot fetched, got %d requests", len(seen))
+	}
+	for _, header := range seen {
+		for _, name := range []string{"Authorization", "Cookie", "X-Token"} {
+			if header.Get(name) != "" {
+				t.Errorf("Expected no %s sent to the archive, got %q", name, header.Get(name))
+			}
+		}
+	}
+	archiveURL, _ := url.Parse(archive.URL)
+	for _, c := range jar.Cookies(archiveURL) {
+		if c.Name == "archive" {
+			t.Errorf("Expected no archive cookies in the jar, got %v", c)
+		}
+	}
+}
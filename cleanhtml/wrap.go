@@ -0,0 +1,157 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cleanhtml
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SetWrapColumn sets the column the lines of rendered HTML are
+// wrapped at (0 = no wrapping)
+// [default = 0]
+func SetWrapColumn(column int) {
+	options.WrapColumn = column
+}
+
+// preformattedElements are those whose text isn't wrapped, as its
+// white space is kept (or, for code, is best kept)
+var preformattedElements = map[string]bool{
+	"pre":      true,
+	"code":     true,
+	"textarea": true,
+	"style":    true,
+	"script":   true,
+}
+
+// wrapWriter is the writer the renderer writes through with
+// Options.WrapColumn. It breaks long lines of the HTML by turning
+// spaces of text into newlines, which HTML collapses alike, and so
+// only outside tags and preformatted elements, leaving punctuation
+// on the line of the word before. Flush must be called after the
+// last write.
+type wrapWriter struct {
+	w      writer
+	column int
+
+	line    []byte // the current line, held until it's known where it breaks
+	cols    int    // the characters in line
+	breakAt int    // index in line of the space to break at, -1 if none
+
+	inTag    bool         // between < and >
+	quote    byte         // the quote of the attribute value being written, if any
+	tag      bytes.Buffer // the name of the tag being written, with any /
+	nameDone bool         // the whole name is in tag
+	pre      int          // depth of preformatted elements
+}
+
+// newWrapWriter returns a wrapWriter writing to w, wrapping at column
+func newWrapWriter(w writer, column int) *wrapWriter {
+	return &wrapWriter{w: w, column: column, breakAt: -1}
+}
+
+// WriteByte implements the io.ByteWriter interface
+func (ww *wrapWriter) WriteByte(c byte) error {
+	switch {
+	case ww.inTag:
+		ww.writeTagByte(c)
+	case c == '<':
+		ww.inTag, ww.nameDone = true, false
+		ww.tag.Reset()
+	case c == '\n':
+		ww.line = append(ww.line, c)
+		return ww.flushLine()
+	case c == ' ' && ww.pre == 0:
+		ww.breakAt = len(ww.line)
+	case ww.breakAt == len(ww.line)-1 && strings.IndexByte(".,;:!?)]}", c) >= 0:
+		// Punctuation stays with the word before
+		ww.breakAt = -1
+	}
+
+	ww.line = append(ww.line, c)
+	if c&0xc0 != 0x80 {
+		ww.cols++
+	}
+	if ww.cols > ww.column && ww.breakAt >= 0 {
+		return ww.wrap()
+	}
+	return nil
+}
+
+// writeTagByte tracks the tag being written, to tell when it ends
+// an element opened or closed is preformatted
+func (ww *wrapWriter) writeTagByte(c byte) {
+	switch {
+	case ww.quote != 0:
+		if c == ww.quote {
+			ww.quote = 0
+		}
+	case c == '"' || c == '\'':
+		ww.quote = c
+	case c == '>':
+		ww.inTag = false
+		name := strings.ToLower(ww.tag.String())
+		switch {
+		case preformattedElements[name]:
+			ww.pre++
+		case strings.HasPrefix(name, "/") && preformattedElements[name[1:]] && ww.pre > 0:
+			ww.pre--
+		}
+	case ww.nameDone:
+	case c == ' ' || c == '\n' || c == '\t' || c == '/' && ww.tag.Len() > 0:
+		ww.nameDone = true
+	default:
+		ww.tag.WriteByte(c)
+	}
+}
+
+// wrap ends the line at the space to break at
+func (ww *wrapWriter) wrap() error {
+	ww.line[ww.breakAt] = '\n'
+	if _, err := ww.w.Write(ww.line[:ww.breakAt+1]); err != nil {
+		return err
+	}
+	ww.line = append(ww.line[:0], ww.line[ww.breakAt+1:]...)
+	ww.breakAt = -1
+	ww.cols = 0
+	for _, c := range ww.line {
+		if c&0xc0 != 0x80 {
+			ww.cols++
+		}
+	}
+	return nil
+}
+
+// flushLine writes the line held, which has ended
+func (ww *wrapWriter) flushLine() error {
+	_, err := ww.w.Write(ww.line)
+	ww.line, ww.cols, ww.breakAt = ww.line[:0], 0, -1
+	return err
+}
+
+// Write implements the io.Writer interface
+func (ww *wrapWriter) Write(p []byte) (int, error) {
+	for i, c := range p {
+		if err := ww.WriteByte(c); err != nil {
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
+// WriteString writes s
+func (ww *wrapWriter) WriteString(s string) (int, error) {
+	for i := 0; i < len(s); i++ {
+		if err := ww.WriteByte(s[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(s), nil
+}
+
+// Flush writes the rest of the last line
+func (ww *wrapWriter) Flush() error {
+	return ww.flushLine()
+}
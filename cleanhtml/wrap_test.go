@@ -0,0 +1,132 @@
+package cleanhtml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// equivalentTrees returns an error for the first difference in how
+// a browser would show documents a and b: their elements, attributes
+// or text, white space collapsed outside preformatted elements
+func equivalentTrees(a, b *html.Node, pre bool) error {
+	if a.Type != b.Type || a.Data != b.Data && a.Type != html.TextNode {
+		return fmt.Errorf("<%s> in place of <%s>", b.Data, a.Data)
+	}
+	if a.Type == html.TextNode {
+		textA, textB := a.Data, b.Data
+		if !pre {
+			textA, textB = strings.Join(strings.Fields(textA), " "), strings.Join(strings.Fields(textB), " ")
+		}
+		if textA != textB {
+			return fmt.Errorf("text %q in place of %q", textB, textA)
+		}
+	}
+	if fmt.Sprint(a.Attr) != fmt.Sprint(b.Attr) {
+		return fmt.Errorf("<%s> attributes %v in place of %v", a.Data, b.Attr, a.Attr)
+	}
+
+	pre = pre || a.Type == html.ElementNode && preformattedElements[a.Data]
+	ca, cb := a.FirstChild, b.FirstChild
+	for ; ca != nil && cb != nil; ca, cb = ca.NextSibling, cb.NextSibling {
+		if err := equivalentTrees(ca, cb, pre); err != nil {
+			return err
+		}
+	}
+	if ca != nil || cb != nil {
+		return fmt.Errorf("<%s> has different children", a.Data)
+	}
+	return nil
+}
+
+func TestWrapColumn(t *testing.T) {
+	for _, fixture := range []string{"testdata/wikipedia-footnotes.html", "testdata/photo-essay.html", "testdata/form.html", "../testdata/article.html"} {
+		data, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A long paragraph with preformatted text
+		data = []byte(strings.Replace(string(data), "</body>", "<p>"+strings.Repeat("Prune the branches, (then) the twigs; ", 40)+
+			"</p><pre>"+strings.Repeat("keep  this line whole ", 10)+"</pre><p>And <code>"+strings.Repeat("go vet ", 20)+"</code>.</p></body>", 1))
+
+		opts := DefaultOptions()
+		opts.ImageRender = true
+		unwrapped, err := CleanHTMLWithOptions(context.Background(), data, opts)
+		if err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+		opts.WrapColumn = 60
+		wrapped, err := CleanHTMLWithOptions(context.Background(), data, opts)
+		if err != nil {
+			t.Fatalf("Could not clean document: %v", err)
+		}
+
+		docA, err := html.Parse(strings.NewReader(unwrapped))
+		if err != nil {
+			t.Fatal(err)
+		}
+		docB, err := html.Parse(strings.NewReader(wrapped))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := equivalentTrees(docA, docB, false); err != nil {
+			t.Errorf("%s: wrapped differently: %v", fixture, err)
+		}
+
+		// Lines are only longer where there's no space to break at in text
+		for _, line := range strings.Split(wrapped, "\n") {
+			if len([]rune(line)) > 60 && !strings.HasPrefix(line, "<") && !strings.Contains(line, "keep  this") && !strings.Contains(line, "go vet") {
+				t.Errorf("%s: line of %d characters: %q", fixture, len([]rune(line)), line)
+			}
+		}
+		for _, line := range strings.Split(wrapped, "\n") {
+			if strings.HasPrefix(line, ",") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, ".") {
+				t.Errorf("%s: punctuation wrapped onto a line of its own: %q", fixture, line)
+			}
+		}
+	}
+}
+
+func TestWrapColumn_Golden(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/wikipedia-footnotes.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("testdata/wikipedia-footnotes.wrap60.golden.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Footnotes, opts.WrapColumn = true, 60
+	got, err := CleanHTMLWithOptions(context.Background(), data, opts)
+	if err != nil {
+		t.Fatalf("Could not clean document: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Rendered\n%s\nwant\n%s", got, want)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error)       { return 0, errors.New("disk full") }
+func (failingWriter) WriteByte(c byte) error            { return errors.New("disk full") }
+func (failingWriter) WriteString(s string) (int, error) { return 0, errors.New("disk full") }
+
+func TestWrapWriter_FlushError(t *testing.T) {
+	ww := newWrapWriter(failingWriter{}, 60)
+	// The last line is held until flushed
+	if _, err := ww.WriteString("<p>The last line</p>"); err != nil {
+		t.Fatalf("Expected the line held, got %v", err)
+	}
+	if err := ww.Flush(); err == nil {
+		t.Errorf("Expected the error writing the last line")
+	}
+}
@@ -6,9 +6,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/scu/cleanpg/cleanhtml"
 	"github.com/scu/cleanpg/logger"
@@ -17,39 +25,334 @@ import (
 
 func usage() {
 	fmt.Println(fs.Usage())
+	fmt.Printf("Environment:\n  %s sets the default of --flag, uppercased with dashes as underscores,\n  e.g. %s for --user-agent; boolean flags accept 1, true or yes\n",
+		envName("<FLAG>"), envName("user-agent"))
+	fmt.Printf("Exit status:\n  %d usage, %d fetch, %d HTTP status, %d parse or clean, %d output, %d timeout,\n  %d partial batch, %d file exists (--no-clobber), %d larger than --max-size,\n  %d changed (--compare)\n",
+		exitUsage, exitFetch, exitStatus, exitClean, exitOutput, exitTimeout, exitPartial, exitExists, exitTooBig, exitChanged)
 }
 
-func init() {
-	fs = flagplus.NewFlagSet("cleanpg")
+// newFlagSet returns the flag set defining cleanpg's flags
+func newFlagSet() *flagplus.FlagSet {
+	fs := flagplus.NewFlagSet("cleanpg")
 	fs.FlagSetDescription("Utility for rendering text-readable versions of HTML pages.")
 
 	// Add flags
 	fs.AddFlag("verbose", "v", "Print extra debugging information to stderr")
+	fs.AddFlag("quiet", "q", "Write no log file and print only failures to stderr")
+	fs.AddStringFlag("logfile", "g", "Write the log to `path`, or \"stderr\", or \"none\", in place of cleanpg/cleanpg.log in the user cache directory", "")
+	fs.AddStringFlag("syslog", "SL", "Also log to syslog at `address`, e.g. udp://host:514 or unixgram:///dev/log, or \"local\"", "")
+	fs.AddFlag("log-json", "LJ", "Write the log as JSON lines, one object per message")
+	fs.AddStringFlag("log-level", "LL", "Log only messages of `level` and above: info, notice, warning, error or fatal", "info")
 	fs.AddFlag("help", "h", "Help")
+	fs.AddStringFlag("config", "a", "Read default flag values from `file`", "~/.config/cleanpg/config")
 	fs.AddFlag("nocanon", "c", "Do not attempt to render canonically")
+	fs.AddStringFlag("post-heading", "PH", "Render canonically from the first `heading`: h1, h2, h3, or auto for the first of any level followed by 200+ characters of paragraph text", "h1")
 	fs.AddFlag("nostyle", "n", "Do not render embedded style")
 	fs.AddFlag("nolinks", "l", "Do not render links")
-	fs.AddStringFlag("output", "o", "Write output to `file.html`", "out.html")
-	fs.AddStringFlag("save", "s", "Save source document as `file.html`", "")
+	fs.AddFlag("title-heading", "TH", "Start --format markdown or text with the title as a heading, unless the first h1 repeats it")
+	fs.AddFlag("trim-title", "TS", "Leave the site name, e.g. \" - Example News\", off the end of the title")
+	fs.AddFlag("detect-lang", "DL", "Set the language of a page without one, detected from its text")
+	fs.AddFlag("nofootnotes", "NF", "Do not collect footnotes into a References list at the end")
+	fs.AddStringFlag("css", "D", "Style the output with the stylesheet `file` or URL, in place of embedded style", "")
+	fs.AddFlag("keep-default-style", "Q", "Keep the embedded style along with --css")
+	fs.AddStringFlag("include", "M", "Render only the first element matching CSS `selector`", "")
+	fs.AddFlag("include-all", "MA", "Render every element matching --include")
+	fs.AddStringFlag("include-fallback", "MF", "Render `mode` when nothing matches --include: none or full", "none")
+	fs.AddStringFlag("images", "Z", "Render images as `mode`: keep (--images alone), download or inline", "none")
+	fs.AddFlag("gallery", "GA", "Render the images in a gallery at the end, with their captions, rather than in place")
+	fs.AddIntFlag("gallery-max", "GM", "Render at most `count` images in the --gallery (0 = no limit)", 0)
+	fs.AddStringFlag("asset-dir", "ZD", "Save downloaded images in `directory`, by default named after the output", "")
+	fs.AddStringFlag("exclude", "X", "Remove the elements matching CSS `selectors` (repeatable)", "")
+	fs.AddFlag("forms-as-text", "FT", "Render forms as readable text: labels, field values, choices and buttons")
+	fs.AddStringFlag("charset", "CS", "Decode documents from `charset`, e.g. windows-1252, or auto to detect it", "auto")
+	fs.AddStringFlag("max-size", "m", "Refuse documents larger than `size`, e.g. 5MB (0 = no limit)", "20MiB")
+	fs.AddStringFlag("output", "o", "Write output to `file.html`, or the extension of the --format", defaultOutputFile)
+	fs.AddStringFlag("format", "f", "Write the output as `format`: html, markdown, text or json", "html")
+	fs.AddIntFlag("text-width", "T", "Wrap --format text at `columns` (0 = no wrapping)", defaultTextWidth)
+	fs.AddIntFlag("wrap-column", "WC", "Wrap the lines of html output at about `column`, only where it doesn't change the page (0 = no wrapping)", 0)
+	fs.AddStringFlag("links", "LK", "Write the page's links to `file`, one absolute URL per line (\"-\" = stdout)", "")
+	fs.AddStringFlag("links-format", "LF", "Write --links as `format`: url, or tsv for the URL and anchor text", "url")
+	fs.AddFlag("metadata", "MD", "Print the page's metadata as JSON, in place of the output unless --output is given")
+	fs.AddStringFlag("metadata-file", "MDF", "Write --metadata to `file.json`, not stdout or stderr (implies --metadata)", "")
+	fs.AddStringFlag("compare", "CP", "Compare the page with the earlier output `file.html`, printing the sections changed (exit status 10 if any)", "")
+	fs.AddStringFlag("compare-ignore", "CPI", "Leave `differences` out of --compare: headings, links, or \"headings|links\"", "")
+	fs.AddFlag("clipboard", "CB", "Copy the output to the clipboard, in place of writing it unless --output is given")
+	fs.AddFlag("open", "OP", "Open the output in the default browser; with an empty --output, a temporary file")
+	fs.AddStringFlag("output-dir", "O", "Write each page of a batch or feed to a file in `directory`", "")
+	fs.AddFlag("dry-run", "DR", "Fetch and clean the URLs, printing a report of each, but write no files")
+	fs.AddStringFlag("watch", "y", "Re-clean the URL every `interval`, e.g. 15m, replacing the output when it changes", "")
+	fs.AddIntFlag("watch-count", "YC", "Stop --watch after `count` checks (0 = until interrupted)", 0)
+	fs.AddStringFlag("serve", "V", "Serve cleaned pages over HTTP at `address`, e.g. :8080, rather than cleaning URLs", "")
+	fs.AddIntFlag("serve-cache", "VC", "Keep the last `count` pages served in memory (0 = none)", 0)
+	fs.AddFlag("allow-private", "VP", "Let --serve fetch from loopback and private network addresses")
+	fs.AddStringFlag("input-file", "I", "Also clean the URLs listed in `file`, one per line (\"-\" = stdin)", "")
+	fs.AddIntFlag("workers", "J", "Fetch up to `count` pages of a batch or --crawl at once", defaultWorkers)
+	fs.AddFlag("warc", "w", "Treat the argument as a WARC file and clean its HTML records")
+	fs.AddFlag("sitemap", "S", "Treat the URL as a sitemap and clean the pages it lists")
+	fs.AddFlag("feed-links", "L", "Clean the page each feed entry links to, not its content")
+	fs.AddStringFlag("feed-state", "G", "Skip feed entries listed in `file`, and add those cleaned", "")
+	fs.AddStringFlag("url-include", "i", "Only clean batch URLs matching `regexp`", "")
+	fs.AddStringFlag("url-exclude", "e", "Skip batch URLs matching `regexp`", "")
+	fs.AddIntFlag("max-pages", "p", "Clean at most `count` pages of a batch (0 = no limit)", 0)
+	fs.AddIntFlag("crawl", "CR", "Also clean the pages of the URL's site it links to, to `depth` links away, into --output-dir", 0)
+	fs.AddIntFlag("crawl-limit", "CRL", "Stop --crawl after `count` pages (0 = no limit)", defaultCrawlLimit)
+	fs.AddStringFlag("crawl-delay", "CRD", "Wait `duration` between --crawl fetches from a host", defaultCrawlDelay.String())
+	fs.AddStringFlag("proxy", "x", "Fetch through proxy `url` (http, https or socks5), in place of HTTP_PROXY and the like", "")
+	fs.AddFlag("no-proxy", "NX", "Fetch directly, ignoring HTTP_PROXY and the like")
+	fs.AddFlag("allow-http", "AH", "Fetch a host given without a scheme over http when https fails")
+	fs.AddIntFlag("retry", "r", "Retry a failed fetch up to `count` times", 0)
+	fs.AddStringFlag("retry-delay", "RD", "Wait `duration` before the first retry, doubling for each one after", cleanhtml.DefaultRetryDelay.String())
+	fs.AddStringFlag("save", "s", "Save source document as `file.html` (a directory for a batch), auto (--save alone) to name it from the URL, or - for stdout", "")
+	fs.AddFlag("no-clobber", "P", "Fail rather than replace an existing output or save file")
+	fs.AddFlag("force", "Y", "Replace existing output and save files, not writing to a new name")
+	fs.AddFlag("update", "U", "Only re-render --output when the source has changed since")
+	fs.AddFlag("wayback-fallback", "W", "Clean the Wayback Machine's copy of a page which is gone")
+	fs.AddStringFlag("user", "u", "Authenticate as `name`; the password is read from stdin", "")
+	fs.AddStringFlag("user-agent", "A", "Send `agent` as the User-Agent header", cleanhtml.DefaultUserAgent)
+	fs.AddStringFlag("cookie", "b", "Send cookie `name=value` (repeatable)", "")
+	fs.AddStringFlag("cookie-jar", "j", "Load and save cookies in Netscape cookies.txt `file`", "")
+	fs.AddStringFlag("fetcher", "F", "Fetch with `spec`: http, or exec:command to print the page", "http")
+	fs.AddFlag("follow-refresh", "R", "Follow meta refresh redirects")
+	fs.AddStringFlag("header", "H", "Add `\"Name: value\"` to the request headers (repeatable)", "")
+	fs.AddStringFlag("base", "B", "Resolve relative links against `url` instead of the document's own", "")
+	fs.AddStringFlag("cache-dir", "d", "Cache fetched documents in `directory`", "")
+	fs.AddFlag("no-cache", "N", "Fetch anew rather than using cached documents")
+	fs.AddStringFlag("cacert", "C", "Also trust the CA certificates in PEM `file`", "")
+	fs.AddStringFlag("cert", "E", "Present client certificate PEM `file` (with --key)", "")
+	fs.AddStringFlag("key", "K", "Private key PEM `file` for --cert", "")
+	fs.AddFlag("insecure", "k", "Do not verify TLS certificates (unsafe)")
+	fs.AddFlag("insecure-allow-cookies", "KC", "Let --insecure load and save a --cookie-jar")
+	fs.AddStringFlag("timeout", "t", "Abandon fetching and cleaning after `duration` (0 = never)", "30s")
 
-	fs.Parse()
+	return fs
 }
 
+// parseArgs defines the flags and parses them from args,
+// the command line including the program name
+func parseArgs(args []string) error {
+	fs = newFlagSet()
+
+	// --images alone keeps the images
+	args = expandBareFlag(args, "images", "Z", "keep")
+	// -posth1 is the original name of --post-heading h1
+	args = expandAlias(args, "posth1", "--post-heading=h1")
+	// --save alone names the file itself
+	args = expandOptionalFlag(args, "save", "s", saveAuto)
+
+	// Repeatable flags are collected before parsing
+	headerFlags, args = extractRepeatedFlag(args, "header", "H")
+	cookieFlags, args = extractRepeatedFlag(args, "cookie", "b")
+	excludeFlags, args = extractRepeatedFlag(args, "exclude", "X")
+
+	// The config file and then the environment set defaults,
+	// which the command line overrides
+	configWarnings = nil
+	configFlags, args := extractRepeatedFlag(args, "config", "a")
+	configValues, err := applyConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	envValues, err := applyEnv()
+	if err != nil {
+		return err
+	}
+	// Repeatable values on the command line add to the defaults
+	for name, values := range repeatableFlags {
+		*values = append(append(configValues[name], envValues[name]...), *values...)
+	}
+
+	return fs.Parse(args...)
+}
+
+// postHeadings maps the values of the "post-heading" flag to the
+// heading which starts rendering in canonical mode
+var postHeadings = map[string]struct {
+	level       int
+	selection   cleanhtml.H1Selection
+	description string
+}{
+	"h1":   {1, cleanhtml.FirstH1, "first <h1> tag"},
+	"h2":   {2, cleanhtml.FirstH1, "first <h2> tag"},
+	"h3":   {3, cleanhtml.FirstH1, "first <h3> tag"},
+	"auto": {cleanhtml.AnyHeading, cleanhtml.ContentH1, "first heading followed by paragraph text"},
+}
+
+// Exit codes, distinguishing the classes of failure
+const (
+	exitUsage   = 1  // invalid flags or arguments
+	exitFetch   = 2  // the document couldn't be fetched or read
+	exitStatus  = 3  // the server responded with an error status
+	exitClean   = 4  // the document couldn't be parsed or cleaned
+	exitOutput  = 5  // the output couldn't be written
+	exitTimeout = 6  // --timeout expired
+	exitPartial = 7  // some documents of a batch failed, others didn't
+	exitExists  = 8  // --no-clobber refused to replace a file
+	exitTooBig  = 9  // a document was larger than --max-size
+	exitChanged = 10 // --compare found the content changed
+)
+
+// fetchExitCode returns the exit code for an error fetching a document
+func fetchExitCode(err error) int {
+	var statusErr *cleanhtml.StatusError
+	switch {
+	case isTimeout(err):
+		return exitTimeout
+	case errors.Is(err, cleanhtml.ErrBodyTooLarge):
+		return exitTooBig
+	case errors.As(err, &statusErr):
+		return exitStatus
+	}
+	return exitFetch
+}
+
+// logFetchResult logs the status, size and time taken of a fetch,
+// as fields added to those of log
+func logFetchResult(log *logger.FieldLogger, result *cleanhtml.FetchResult) {
+	fields := logger.Fields{"bytes": len(result.Body), "elapsed": result.Elapsed.Round(time.Millisecond)}
+	message := "read"
+	if result.StatusCode != 0 {
+		message = "fetched"
+		fields["status"] = result.StatusCode
+	}
+	log.WriteFields(logger.INFO, message, fields)
+}
+
+// isTimeout determines if err is the timeout expiring
+func isTimeout(err error) bool {
+	var netErr net.Error
+	var timeoutErr *cleanhtml.TimeoutError
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &timeoutErr) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// Defaults for the "output" and "text-width" flags
+const (
+	defaultOutputFile = "out.html"
+	defaultTextWidth  = 80
+)
+
 var fs *flagplus.FlagSet
 
+// stdin is read for passwords and URL lists
+var stdin io.Reader = os.Stdin
+
+// stdout receives the source for --save - and otherwise the
+// outcome of cleaning a single document
+var stdout io.Writer = os.Stdout
+
+// Values given for repeatable flags
+var (
+	headerFlags  []string // FLAG "header"
+	cookieFlags  []string // FLAG "cookie"
+	excludeFlags []string // FLAG "exclude"
+)
+
+// repeatableFlags holds where the values of each repeatable flag are collected
+var repeatableFlags = map[string]*[]string{
+	"header":  &headerFlags,
+	"cookie":  &cookieFlags,
+	"exclude": &excludeFlags,
+}
+
 func main() {
 	// Call cleanpgMain in a separate function
 	// so that it deferred statements run before exit
-	exitCode := cleanpgMain()
+	exitCode := cleanpgMain(os.Args)
 	os.Exit(exitCode)
 }
 
-func cleanpgMain() int {
+// cleanpgMain runs cleanpg with the command line args,
+// returning the exit code
+func cleanpgMain(args []string) int {
+	// --completion is left out of the flag set, and so of the usage
+	if shells, _ := extractRepeatedFlag(args, "completion", "completion"); len(shells) > 0 {
+		return completion(shells[len(shells)-1])
+	}
+
+	if err := parseArgs(args); err != nil {
+		var cfgErr *configError
+		if errors.As(err, &cfgErr) {
+			fmt.Fprintf(os.Stderr, "Could not read defaults: %s\n", err)
+			return exitUsage
+		}
+		usage()
+		return exitUsage
+	}
+
+	// The sources are checked before anything is logged
+	sources := fs.GetArgs()
+	for i := range sources {
+		var err error
+		if sources[i], err = normalizeSource(sources[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid URL: %s\n", err)
+			return exitUsage
+		}
+	}
+
+	// FLAG "quiet", "logfile", "dry-run"
+	quiet, err := fs.Get("quiet")
+	if err != nil {
+		panic(err)
+	}
+	logFile, err := fs.GetString("logfile")
+	if err != nil {
+		panic(err)
+	}
+	dryRun, err := fs.Get("dry-run")
+	if err != nil {
+		panic(err)
+	}
+	// Quiet runs leave no log file, and only report failures;
+	// dry runs leave no file at all, and --logfile stderr never
+	// touches one
+	switch {
+	case quiet || dryRun || logFile == "none":
+		logger.SetDiscard(true)
+		if err := logger.SetStderrOnly(false); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	case logFile == "stderr":
+		logger.SetDiscard(false)
+		if err := logger.SetStderrOnly(true); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	default:
+		logger.SetDiscard(false)
+		// "" is the default, in the user cache directory
+		if err := logger.SetLogFile(logFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+
+	// Set up logging; a log which can't be truncated is appended to
+	if err := logger.Truncate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not truncate the log file: %s\n", err)
+	}
+	// Closed last, after anything deferred below has logged
+	defer func() {
+		if err := logger.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}()
 
-	var outFile *os.File
+	// FLAG "log-level"
+	logLevelName, err := fs.GetString("log-level")
+	if err != nil {
+		panic(err)
+	}
+	logLevel, err := logger.ParseLevel(logLevelName)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
+	}
+	logger.SetLevel(logLevel)
 
-	// Set up logging
-	logger.Truncate()
+	// FLAG "log-json"
+	logJSON, err := fs.Get("log-json")
+	if err != nil {
+		panic(err)
+	}
+	logger.SetJSON(logJSON)
 
 	// FLAG "help"
 	help, err := fs.Get("help")
@@ -66,79 +369,382 @@ func cleanpgMain() int {
 	if err != nil {
 		panic(err)
 	}
-	if printVerbose {
-		logger.LogToStderr(true)
+	logger.LogToStderr((printVerbose || logFile == "stderr") && !quiet)
+	if quiet {
+		logger.SetStderrLevel(logger.FATAL)
 	}
 
-	// FLAG "output"
-	outputFile, err := fs.GetString("output")
+	// FLAG "syslog"
+	syslogAddr, err := fs.GetString("syslog")
 	if err != nil {
 		panic(err)
 	}
-	if outputFile != "" {
-		// Verify is .html extension
-		if filepath.Ext(outputFile) != ".html" {
-			logger.Write(logger.FATAL, "file [%s] must have .html extension", outputFile)
-			return 1
+	if syslogAddr != "" {
+		network, addr, err := parseSyslog(syslogAddr)
+		if err == nil {
+			err = logger.SetSyslog(network, addr, "cleanpg")
+		}
+		if err != nil {
+			logger.Write(logger.FATAL, "could not log to syslog [%s]: %s", syslogAddr, err)
+			return exitUsage
 		}
+	}
 
-		var err error
-		// Create & open the file
-		outFile, err = os.Create(outputFile)
+	for _, warning := range configWarnings {
+		logger.Write(logger.WARNING, "%s", warning)
+	}
+
+	// FLAG "timeout"
+	timeoutStr, err := fs.GetString("timeout")
+	if err != nil {
+		panic(err)
+	}
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout < 0 {
+		logger.Write(logger.FATAL, "invalid timeout [%s]: must be a duration such as 30s", timeoutStr)
+		return exitUsage
+	}
+	cleanhtml.SetHTTPTimeout(timeout)
+
+	// FLAG "user-agent"
+	userAgent, err := fs.GetString("user-agent")
+	if err != nil {
+		panic(err)
+	}
+	cleanhtml.SetUserAgent(userAgent)
+
+	// FLAG "header"
+	headers, err := parseHeaders(headerFlags)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
+	}
+	// Headers given here replace the ones cleanpg sends itself
+	if agent := headers.Get("User-Agent"); agent != "" {
+		logger.Write(logger.NOTICE, "--header User-Agent [%s] overrides the --user-agent [%s]", agent, userAgent)
+	}
+	cleanhtml.SetHeaders(headers)
+
+	// FLAG "cookie"
+	cookies, err := parseCookies(cookieFlags)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
+	}
+	cleanhtml.SetCookies(cookies)
+
+	// FLAG "insecure", "insecure-allow-cookies"
+	insecure, err := fs.Get("insecure")
+	if err != nil {
+		panic(err)
+	}
+	insecureCookies, err := fs.Get("insecure-allow-cookies")
+	if err != nil {
+		panic(err)
+	}
+	if insecure {
+		// Printed once for the run, unless the log already is
+		logger.Write(logger.WARNING, "TLS certificates are not verified (--insecure)")
+		if !quiet && !printVerbose && logFile != "stderr" {
+			fmt.Fprintln(os.Stderr, "WARNING: --insecure: TLS certificates are not verified, so any server can pose as the site")
+		}
+	} else if insecureCookies {
+		logger.Write(logger.WARNING, "--insecure-allow-cookies has no effect without --insecure")
+	}
+
+	// FLAG "cookie-jar"
+	cookieJarFile, err := fs.GetString("cookie-jar")
+	if err != nil {
+		panic(err)
+	}
+	// Over unverified connections the jar's session cookies could
+	// be sent to an impostor, and its cookies saved from one
+	if cookieJarFile != "" && insecure && !insecureCookies {
+		logger.Write(logger.FATAL, "--cookie-jar could leak session cookies with --insecure: add --insecure-allow-cookies to allow it")
+		return exitUsage
+	}
+	var cookieJar *cleanhtml.CookieJar
+	if cookieJarFile != "" {
+		cookieJar, err = cleanhtml.LoadCookieJar(cookieJarFile)
 		if err != nil {
-			logger.Write(logger.FATAL, "could not open [%s]: %s", outputFile, err)
-			return 1
+			logger.Write(logger.FATAL, "could not load cookie jar: %s", err)
+			return exitUsage
 		}
+		cleanhtml.SetCookieJar(cookieJar)
+
+		// Keep any cookies the servers set, unless writing nothing
+		defer func() {
+			if dryRun {
+				return
+			}
+			if err := cookieJar.Save(cookieJarFile); err != nil {
+				logger.Write(logger.ERROR, "could not save cookie jar [%s]: %s", cookieJarFile, err)
+			}
+		}()
 	}
 
-	// Get url from argument
-	args := fs.GetArgs()
-	urlToClean := args[0]
-	if urlToClean == "" {
-		fmt.Fprintf(os.Stderr, "Missing URL\n")
-		usage()
-		return 1
+	// FLAG "max-size"
+	maxSizeStr, err := fs.GetString("max-size")
+	if err != nil {
+		panic(err)
+	}
+	maxSize, err := parseSize(maxSizeStr)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
 	}
+	cleanhtml.SetMaxBodyBytes(maxSize)
 
-	logger.Write(logger.INFO, "reading data from URL=%s", urlToClean)
+	// FLAG "charset"
+	charsetName, err := fs.GetString("charset")
+	if err != nil {
+		panic(err)
+	}
+	if charsetName == "auto" {
+		charsetName = ""
+	} else {
+		if charsetName, err = cleanhtml.LookupCharset(charsetName); err != nil {
+			logger.Write(logger.FATAL, "%s: use a name such as windows-1252, or auto", err)
+			return exitUsage
+		}
+		logger.Write(logger.INFO, "decoding documents as %s", charsetName)
+	}
+	cleanhtml.SetCharset(charsetName)
 
-	sourceData, err := cleanhtml.ReadHTML(urlToClean)
+	// FLAG "proxy", "no-proxy"
+	proxyURL, err := fs.GetString("proxy")
 	if err != nil {
-		logger.Write(logger.FATAL, "Cannot read [%s]: %s", urlToClean, err)
-		return 1
+		panic(err)
+	}
+	noProxy, err := fs.Get("no-proxy")
+	if err != nil {
+		panic(err)
+	}
+	switch {
+	case proxyURL != "" && noProxy:
+		logger.Write(logger.FATAL, "--proxy and --no-proxy can't be used together")
+		return exitUsage
+	case proxyURL != "":
+		u, err := cleanhtml.ParseProxyURL(proxyURL)
+		if err != nil {
+			logger.Write(logger.FATAL, "%s", err)
+			return exitUsage
+		}
+		logger.Write(logger.INFO, "fetching through proxy [%s]", u.Redacted())
+	case noProxy:
+		logger.Write(logger.INFO, "fetching directly, ignoring any proxy of the environment")
+	default:
+		logger.Write(logger.INFO, "%s", environmentProxy(os.Getenv))
 	}
+	cleanhtml.SetProxy(proxyURL)
+	cleanhtml.SetNoProxy(noProxy)
 
-	// FLAG "save"
-	saveFile, err := fs.GetString("save")
+	// FLAG "user"
+	user, err := fs.GetString("user")
 	if err != nil {
 		panic(err)
 	}
-	// Save a copy in source.html
-	if saveFile != "" {
-		// Verify is .html extension
-		if filepath.Ext(saveFile) != ".html" {
-			logger.Write(logger.FATAL, "file [%s] must have .html extension", saveFile)
-			return 1
+	if strings.Contains(user, ":") {
+		logger.Write(logger.FATAL, "--user takes a name only; the password is read from stdin")
+		return exitUsage
+	}
+	if user != "" {
+		// The password is the only thing read from stdin then
+		inputFile, err := fs.GetString("input-file")
+		if err != nil {
+			panic(err)
+		}
+		fromStdin := inputFile == "-"
+		for _, source := range sources {
+			fromStdin = fromStdin || source == "-"
+		}
+		if fromStdin {
+			logger.Write(logger.FATAL, "--user reads the password from stdin, which can't also be the document or --input-file")
+			return exitUsage
 		}
-		svFile, err := os.Create(saveFile)
+		password, err := readPassword(stdin, os.Stderr, user)
 		if err != nil {
-			logger.Write(logger.FATAL, "could not open save file [%s]: %s", saveFile, err)
-			return 1
+			logger.Write(logger.FATAL, "%s", err)
+			return exitUsage
+		}
+		cleanhtml.SetBasicAuth(user, password)
+	}
+
+	// FLAG "cacert", "cert", "key"
+	caCertFile, err := fs.GetString("cacert")
+	if err != nil {
+		panic(err)
+	}
+	certFile, err := fs.GetString("cert")
+	if err != nil {
+		panic(err)
+	}
+	keyFile, err := fs.GetString("key")
+	if err != nil {
+		panic(err)
+	}
+	if (certFile == "") != (keyFile == "") {
+		logger.Write(logger.FATAL, "--cert and --key must be given together")
+		return exitUsage
+	}
+	cleanhtml.SetTLS(caCertFile, certFile, keyFile, insecure)
+
+	// FLAG "follow-refresh"
+	followRefresh, err := fs.Get("follow-refresh")
+	if err != nil {
+		panic(err)
+	}
+	cleanhtml.SetFollowMetaRefresh(followRefresh, cleanhtml.DefaultMetaRefreshMaxDelay)
+
+	// FLAG "wayback-fallback"
+	wayback, err := fs.Get("wayback-fallback")
+	if err != nil {
+		panic(err)
+	}
+	cleanhtml.SetWaybackFallback(wayback)
+
+	// FLAG "cache-dir", "no-cache"
+	cacheDir, err := fs.GetString("cache-dir")
+	if err != nil {
+		panic(err)
+	}
+	noCache, err := fs.Get("no-cache")
+	if err != nil {
+		panic(err)
+	}
+	cleanhtml.SetCache(cacheDir, noCache)
+
+	// FLAG "retry"
+	retries, err := fs.GetInt("retry")
+	if err != nil {
+		panic(err)
+	}
+	if retries < 0 {
+		logger.Write(logger.FATAL, "invalid retry count [%d]", retries)
+		return exitUsage
+	}
+	// FLAG "retry-delay"
+	retryDelayStr, err := fs.GetString("retry-delay")
+	if err != nil {
+		panic(err)
+	}
+	retryDelay, err := time.ParseDuration(retryDelayStr)
+	if err != nil || retryDelay <= 0 {
+		logger.Write(logger.FATAL, "invalid retry delay [%s]: must be a duration such as 2s", retryDelayStr)
+		return exitUsage
+	}
+	cleanhtml.SetRetries(int(retries), retryDelay)
+
+	// FLAG "format", "text-width"
+	formatName, err := fs.GetString("format")
+	if err != nil {
+		panic(err)
+	}
+	if formatExts[formatName] == "" {
+		logger.Write(logger.FATAL, "invalid format [%s]: expected html, markdown, text or json", formatName)
+		return exitUsage
+	}
+	textWidth, err := fs.GetInt("text-width")
+	if err != nil {
+		panic(err)
+	}
+	if textWidth < 0 {
+		logger.Write(logger.FATAL, "invalid text width [%d]", textWidth)
+		return exitUsage
+	}
+	if textWidth != defaultTextWidth && formatName != "text" {
+		logger.Write(logger.WARNING, "--text-width has no effect with --format %s", formatName)
+	}
+	format := outputFormat{name: formatName, textWidth: int(textWidth)}
+
+	// FLAG "wrap-column"
+	wrapColumn, err := fs.GetInt("wrap-column")
+	if err != nil {
+		panic(err)
+	}
+	if wrapColumn < 0 {
+		logger.Write(logger.FATAL, "invalid wrap column [%d]", wrapColumn)
+		return exitUsage
+	}
+	if wrapColumn != 0 && (formatName == "markdown" || formatName == "text") {
+		logger.Write(logger.WARNING, "--wrap-column has no effect with --format %s", formatName)
+	}
+	cleanhtml.SetWrapColumn(int(wrapColumn))
+
+	// FLAG "output"
+	outputFile, err := fs.GetString("output")
+	if err != nil {
+		panic(err)
+	}
+	// The default output file takes the format's extension
+	if outputFile == defaultOutputFile {
+		outputFile = format.withExt(outputFile)
+	}
+	if outputFile != "" {
+		// Verify the extension matches the format, gzipped or not
+		if outputExt(outputFile) != format.ext() {
+			logger.Write(logger.FATAL, "file [%s] must have %s or %s%s extension", outputFile, format.ext(), format.ext(), gzipExt)
+			return exitUsage
 		}
-		defer svFile.Close()
-		logger.Write(logger.INFO, "saving a copy of the source document to %s", saveFile)
-		fmt.Fprintf(svFile, "%s", sourceData)
 	}
 
-	// FLAG "nocanon"
+	// FLAG "open"
+	open, err := fs.Get("open")
+	if err != nil {
+		panic(err)
+	}
+	if open && format.name != "html" {
+		logger.Write(logger.FATAL, "--open shows html output only, not --format %s", format.name)
+		return exitUsage
+	}
+	if open && isGzipped(outputFile) {
+		logger.Write(logger.FATAL, "--open can't show the gzipped output [%s]", outputFile)
+		return exitUsage
+	}
+
+	// FLAG "no-clobber", "force"
+	noClobber, err := fs.Get("no-clobber")
+	if err != nil {
+		panic(err)
+	}
+	force, err := fs.Get("force")
+	if err != nil {
+		panic(err)
+	}
+	clobber := renameExisting
+	switch {
+	case noClobber && force:
+		logger.Write(logger.FATAL, "--no-clobber and --force can't be used together")
+		return exitUsage
+	case noClobber:
+		clobber = refuseExisting
+	case force:
+		clobber = overwriteExisting
+	}
+
+	// FLAG "nocanon", "post-heading"
 	nocanon, err := fs.Get("nocanon")
 	if err != nil {
 		panic(err)
 	}
+	postHeading, err := fs.GetString("post-heading")
+	if err != nil {
+		panic(err)
+	}
+	heading, ok := postHeadings[postHeading]
+	if !ok {
+		logger.Write(logger.FATAL, "invalid post heading [%s]: expected h1, h2, h3 or auto", postHeading)
+		return exitUsage
+	}
+	if nocanon && postHeading != "h1" {
+		logger.Write(logger.WARNING, "--post-heading has no effect with --nocanon")
+	}
 	if !nocanon {
 		// Canonical is default
 		cleanhtml.SetPostH1Render(true)
-		logger.Write(logger.INFO, "processing body elements after first <h1> tag")
+		cleanhtml.SetPostHeadingLevel(heading.level)
+		cleanhtml.SetPostH1Selection(heading.selection)
+		logger.Write(logger.INFO, "processing body elements after the %s", heading.description)
 	}
 
 	// FLAG "nostyle"
@@ -146,33 +752,879 @@ func cleanpgMain() int {
 	if err != nil {
 		panic(err)
 	}
+	if noStyle && format.name != "html" {
+		logger.Write(logger.WARNING, "--nostyle has no effect with --format %s", format.name)
+	}
 	if noStyle {
 		cleanhtml.SetStyleRender(false)
 		logger.Write(logger.INFO, "skipping automatic tag-level style embedding")
 	}
 
-	// FLAG "nolinks"
-	noLinks, err := fs.Get("nolinks")
+	// FLAG "images", "asset-dir"
+	images, err := fs.GetString("images")
 	if err != nil {
 		panic(err)
 	}
-	if noLinks {
-		cleanhtml.SetLinksRender(false)
-		logger.Write(logger.INFO, "not rendering links")
+	if !imageModes[images] {
+		logger.Write(logger.FATAL, "invalid images mode [%s]: expected keep, download or inline", images)
+		return exitUsage
+	}
+	assetDir, err := fs.GetString("asset-dir")
+	if err != nil {
+		panic(err)
+	}
+	if assetDir != "" && images != "download" {
+		logger.Write(logger.WARNING, "--asset-dir has no effect without --images=download")
+	}
+	if images != "none" {
+		cleanhtml.SetImageRender(true)
+		logger.Write(logger.INFO, "rendering images (%s)", images)
 	}
 
-	// Create the cleanly-formatted page
-	cleanData, err := cleanhtml.CleanHTML(sourceData)
+	// FLAG "gallery", "gallery-max"
+	gallery, err := fs.Get("gallery")
 	if err != nil {
-		logger.Write(logger.FATAL, "Could not clean [%s]: %s", urlToClean, err)
-		return 1
+		panic(err)
+	}
+	galleryMax, err := fs.GetInt("gallery-max")
+	if err != nil {
+		panic(err)
+	}
+	if galleryMax < 0 {
+		logger.Write(logger.FATAL, "invalid gallery maximum [%d]", galleryMax)
+		return exitUsage
+	}
+	switch {
+	case gallery && images == "none":
+		logger.Write(logger.WARNING, "--gallery has no effect without --images")
+	case gallery:
+		cleanhtml.SetGallery(true, int(galleryMax))
+		logger.Write(logger.INFO, "rendering images in a gallery")
+	case galleryMax != 0:
+		logger.Write(logger.WARNING, "--gallery-max has no effect without --gallery")
 	}
 
-	// Write to designated output
-	fmt.Fprintf(outFile, "%s", cleanData)
-	fmt.Printf("Document rendered to %q\n", outputFile)
-	logger.Write(logger.INFO, "Document from %q rendered to %q", urlToClean, outputFile)
+	// FLAG "base"
+	baseURL, err := fs.GetString("base")
+	if err != nil {
+		panic(err)
+	}
+	if baseURL != "" {
+		if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			logger.Write(logger.FATAL, "invalid base URL [%s]: must be an absolute http or https URL", baseURL)
+			return exitUsage
+		}
+	}
+
+	// FLAG "css", "keep-default-style"
+	css, err := fs.GetString("css")
+	if err != nil {
+		panic(err)
+	}
+	keepDefaultStyle, err := fs.Get("keep-default-style")
+	if err != nil {
+		panic(err)
+	}
+	if css != "" && format.name != "html" {
+		logger.Write(logger.WARNING, "--css has no effect with --format %s", format.name)
+	}
+	if keepDefaultStyle && css == "" {
+		logger.Write(logger.WARNING, "--keep-default-style has no effect without --css")
+	}
+	if css != "" {
+		// A URL is linked, a local file embedded
+		if u, err := url.Parse(css); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			cleanhtml.SetStylesheetURL(css)
+			logger.Write(logger.INFO, "linking stylesheet [%s]", css)
+		} else {
+			data, err := ioutil.ReadFile(css)
+			if err != nil {
+				logger.Write(logger.FATAL, "could not read stylesheet: %s", err)
+				return exitUsage
+			}
+			cleanhtml.SetStylesheet(string(data))
+			logger.Write(logger.INFO, "embedding stylesheet [%s]", css)
+		}
+		if !keepDefaultStyle {
+			cleanhtml.SetStyleRender(false)
+		}
+	}
 
+	// FLAG "include", "include-all", "include-fallback"
+	include, err := fs.GetString("include")
+	if err != nil {
+		panic(err)
+	}
+	includeAll, err := fs.Get("include-all")
+	if err != nil {
+		panic(err)
+	}
+	includeFallback, err := fs.GetString("include-fallback")
+	if err != nil {
+		panic(err)
+	}
+	if includeFallback != "none" && includeFallback != "full" {
+		logger.Write(logger.FATAL, "invalid include fallback [%s]: expected none or full", includeFallback)
+		return exitUsage
+	}
+	if include != "" {
+		if _, err := cleanhtml.ParseSelector(include); err != nil {
+			logger.Write(logger.FATAL, "%s", err)
+			return exitUsage
+		}
+		cleanhtml.SetInclude(include, includeAll)
+		cleanhtml.SetIncludeFallback(includeFallback == "full")
+		logger.Write(logger.INFO, "rendering only elements matching [%s]", include)
+	} else if includeAll || includeFallback != "none" {
+		logger.Write(logger.WARNING, "--include-all and --include-fallback have no effect without --include")
+	}
+
+	// FLAG "exclude"
+	// Each value is a group of selectors; all are merged
+	var exclude []string
+	for _, v := range excludeFlags {
+		if _, err := cleanhtml.ParseSelector(v); err != nil {
+			logger.Write(logger.FATAL, "%s", err)
+			return exitUsage
+		}
+		exclude = append(exclude, v)
+	}
+	if len(exclude) > 0 {
+		cleanhtml.SetExclude(exclude...)
+		logger.Write(logger.INFO, "removing elements matching [%s]", strings.Join(exclude, ", "))
+	}
+	// FLAG "forms-as-text"
+	formsAsText, err := fs.Get("forms-as-text")
+	if err != nil {
+		panic(err)
+	}
+	// Forms and the like never belong in the output, unless
+	// they're rendered as text
+	denied := cleanhtml.DefaultDenySubtrees()
+	if formsAsText {
+		cleanhtml.SetFormsAsText(true)
+		denied = []string{"dialog", "template"}
+		logger.Write(logger.INFO, "rendering forms as text")
+	}
+	cleanhtml.DenySubtree(denied...)
+
+	// FLAG "nolinks"
+	noLinks, err := fs.Get("nolinks")
+	if err != nil {
+		panic(err)
+	}
+	if noLinks {
+		cleanhtml.SetLinksRender(false)
+		logger.Write(logger.INFO, "not rendering links")
+	}
+
+	// FLAG "title-heading"
+	titleHeading, err := fs.Get("title-heading")
+	if err != nil {
+		panic(err)
+	}
+	if titleHeading && format.name == "html" {
+		logger.Write(logger.WARNING, "--title-heading has no effect with --format html")
+	}
+	if titleHeading {
+		cleanhtml.SetTitleHeading(true)
+	}
+
+	// FLAG "trim-title"
+	trimTitle, err := fs.Get("trim-title")
+	if err != nil {
+		panic(err)
+	}
+	if trimTitle {
+		cleanhtml.SetTrimTitle(true)
+		logger.Write(logger.INFO, "trimming the site name from titles")
+	}
+
+	// FLAG "detect-lang"
+	detectLang, err := fs.Get("detect-lang")
+	if err != nil {
+		panic(err)
+	}
+	if detectLang {
+		cleanhtml.SetDetectLanguage(true)
+		logger.Write(logger.INFO, "detecting the language of pages without one")
+	}
+
+	// FLAG "nofootnotes"
+	noFootnotes, err := fs.Get("nofootnotes")
+	if err != nil {
+		panic(err)
+	}
+	if noFootnotes {
+		logger.Write(logger.INFO, "not collecting footnotes")
+	}
+	cleanhtml.SetFootnotes(!noFootnotes)
+
+	// FLAG "allow-http"
+	allowHTTP, err := fs.Get("allow-http")
+	if err != nil {
+		panic(err)
+	}
+
+	// Get the URLs from the arguments. Those without a scheme are
+	// local files, unless there's no such file and they name a
+	// host, which is fetched over https.
+	bareHosts := make(map[string]bool)
+	for i, source := range sources {
+		if u, ok := bareHostURL(source); ok {
+			logger.Write(logger.INFO, "fetching [%s] as [%s]", source, u)
+			sources[i], bareHosts[u] = u, true
+			continue
+		}
+		if code := checkLocalSource(source); code != 0 {
+			return code
+		}
+	}
+	urls := sources
+
+	// FLAG "serve"
+	serveAddr, err := fs.GetString("serve")
+	if err != nil {
+		panic(err)
+	}
+	if serveAddr != "" {
+		if open {
+			logger.Write(logger.FATAL, "--open can't be used with --serve")
+			return exitUsage
+		}
+		if len(urls) > 0 {
+			logger.Write(logger.FATAL, "--serve takes the URLs to clean from its requests, not the command line")
+			return exitUsage
+		}
+		if dryRun {
+			logger.Write(logger.FATAL, "--dry-run can't be used with --serve")
+			return exitUsage
+		}
+		return serveMain(serveAddr, format, images, timeout)
+	}
+
+	// FLAG "input-file"
+	inputFile, err := fs.GetString("input-file")
+	if err != nil {
+		panic(err)
+	}
+	if inputFile != "" {
+		list, err := readInputFile(inputFile)
+		if err != nil {
+			logger.Write(logger.FATAL, "%s", err)
+			return exitUsage
+		}
+		urls = append(urls, list...)
+	}
+
+	if len(urls) == 0 || urls[0] == "" {
+		fmt.Fprintf(os.Stderr, "Missing URL\n")
+		usage()
+		return exitUsage
+	}
+	urlToClean := urls[0]
+
+	// Piped input has no address to fetch its images from
+	if urlToClean == "-" && baseURL == "" && (images == "download" || images == "inline") {
+		logger.Write(logger.FATAL, "--images=%s needs a --base URL for stdin", images)
+		return exitUsage
+	}
+
+	logger.Write(logger.INFO, "reading data from URL=%s", strings.Join(urls, " "))
+
+	fetchOptions := cleanhtml.DefaultFetchOptions()
+	// A dry run may read the cache, but adds nothing to it
+	fetchOptions.CacheReadOnly = dryRun
+
+	// FLAG "sitemap", "warc", "output-dir", "url-include", "url-exclude", "max-pages"
+	sitemap, err := fs.Get("sitemap")
+	if err != nil {
+		panic(err)
+	}
+	warcFile, err := fs.Get("warc")
+	if err != nil {
+		panic(err)
+	}
+	outputDir, err := fs.GetString("output-dir")
+	if err != nil {
+		panic(err)
+	}
+	if (sitemap || warcFile || inputFile != "" || len(urls) > 1) && outputDir == "" && !dryRun {
+		logger.Write(logger.FATAL, "--sitemap, --warc, --input-file and several URLs need an --output-dir for the pages")
+		return exitUsage
+	}
+
+	// FLAG "crawl", "crawl-limit", "crawl-delay"
+	crawlDepth, err := fs.GetInt("crawl")
+	if err != nil {
+		panic(err)
+	}
+	crawlLimit, err := fs.GetInt("crawl-limit")
+	if err != nil {
+		panic(err)
+	}
+	crawlDelayStr, err := fs.GetString("crawl-delay")
+	if err != nil {
+		panic(err)
+	}
+	crawlDelay, err := time.ParseDuration(crawlDelayStr)
+	switch {
+	case crawlDepth < 0:
+		logger.Write(logger.FATAL, "invalid crawl depth [%d]", crawlDepth)
+		return exitUsage
+	case crawlLimit < 0:
+		logger.Write(logger.FATAL, "invalid crawl limit [%d]", crawlLimit)
+		return exitUsage
+	case err != nil || crawlDelay < 0:
+		logger.Write(logger.FATAL, "invalid crawl delay [%s]: must be a duration such as 1s", crawlDelayStr)
+		return exitUsage
+	case crawlDepth == 0:
+	case sitemap || warcFile || inputFile != "" || len(urls) > 1 || urlToClean == "-":
+		logger.Write(logger.FATAL, "--crawl starts from a single URL, not a batch or stdin")
+		return exitUsage
+	case outputDir == "" && !dryRun:
+		logger.Write(logger.FATAL, "--crawl needs an --output-dir for the pages")
+		return exitUsage
+	}
+
+	// FLAG "watch", "watch-count"
+	watchStr, err := fs.GetString("watch")
+	if err != nil {
+		panic(err)
+	}
+	watchCount, err := fs.GetInt("watch-count")
+	if err != nil {
+		panic(err)
+	}
+	var watchInterval time.Duration
+	if watchStr != "" {
+		if watchInterval, err = time.ParseDuration(watchStr); err != nil || watchInterval <= 0 {
+			logger.Write(logger.FATAL, "invalid watch interval [%s]: must be a duration such as 15m", watchStr)
+			return exitUsage
+		}
+		if outputDir != "" || urlToClean == "-" {
+			logger.Write(logger.FATAL, "--watch re-cleans a single URL, not a batch or stdin")
+			return exitUsage
+		}
+	}
+	if watchCount < 0 || (watchCount > 0 && watchInterval == 0) {
+		logger.Write(logger.FATAL, "invalid watch count [%d]: needs --watch", watchCount)
+		return exitUsage
+	}
+	if open && (outputDir != "" || watchInterval > 0) {
+		logger.Write(logger.FATAL, "--open opens a single page, not a batch or --watch output")
+		return exitUsage
+	}
+
+	// FLAG "links", "links-format"
+	linksFile, err := fs.GetString("links")
+	if err != nil {
+		panic(err)
+	}
+	linksFormat, err := fs.GetString("links-format")
+	if err != nil {
+		panic(err)
+	}
+	if linksFormat != "url" && linksFormat != "tsv" {
+		logger.Write(logger.FATAL, "invalid links format [%s]: expected url or tsv", linksFormat)
+		return exitUsage
+	}
+	if linksFile != "" && (outputDir != "" || watchInterval > 0) {
+		logger.Write(logger.FATAL, "--links writes the links of a single page, not a batch or --watch output")
+		return exitUsage
+	}
+
+	// FLAG "metadata", "metadata-file"
+	metadata, err := fs.Get("metadata")
+	if err != nil {
+		panic(err)
+	}
+	metadataFile, err := fs.GetString("metadata-file")
+	if err != nil {
+		panic(err)
+	}
+	if metadataFile != "" {
+		if filepath.Ext(metadataFile) != ".json" {
+			logger.Write(logger.FATAL, "file [%s] must have .json extension", metadataFile)
+			return exitUsage
+		}
+		metadata = true
+	}
+	if metadata && (outputDir != "" || watchInterval > 0) {
+		logger.Write(logger.FATAL, "--metadata describes a single page, not a batch or --watch output")
+		return exitUsage
+	}
+	// Without --output, the metadata on stdout takes its place
+	metadataOnly := metadata && metadataFile == "" && !flagGiven(args, "output", "o")
+	if metadataOnly && open {
+		logger.Write(logger.FATAL, "--open with --metadata needs an --output to open")
+		return exitUsage
+	}
+
+	// FLAG "compare", "compare-ignore"
+	compareFile, err := fs.GetString("compare")
+	if err != nil {
+		panic(err)
+	}
+	compareIgnore, err := fs.GetString("compare-ignore")
+	if err != nil {
+		panic(err)
+	}
+	diffOpts, err := parseCompareIgnore(compareIgnore)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
+	}
+	var compareOld []byte
+	if compareFile != "" {
+		if outputDir != "" || watchInterval > 0 {
+			logger.Write(logger.FATAL, "--compare compares a single page, not a batch or --watch output")
+			return exitUsage
+		}
+		if metadataOnly {
+			logger.Write(logger.FATAL, "--compare and --metadata can't both write to stdout: give a --metadata-file")
+			return exitUsage
+		}
+		if outputExt(compareFile) != ".html" {
+			logger.Write(logger.FATAL, "file [%s] must have .html or .html.gz extension", compareFile)
+			return exitUsage
+		}
+		if compareOld, err = readFile(compareFile); err != nil {
+			logger.Write(logger.FATAL, "could not read [%s]: %s", compareFile, err)
+			return exitUsage
+		}
+	} else if compareIgnore != "" {
+		logger.Write(logger.WARNING, "--compare-ignore has no effect without --compare")
+	}
+	// Without --output, the comparison is all there is
+	compareOnly := compareFile != "" && !flagGiven(args, "output", "o")
+	if compareOnly && open {
+		logger.Write(logger.FATAL, "--open with --compare needs an --output to open")
+		return exitUsage
+	}
+
+	// FLAG "clipboard"
+	copyToClipboard, err := fs.Get("clipboard")
+	if err != nil {
+		panic(err)
+	}
+	if copyToClipboard {
+		if format.name == "json" {
+			logger.Write(logger.FATAL, "--clipboard copies html, markdown or text, not --format %s", format.name)
+			return exitUsage
+		}
+		if outputDir != "" || watchInterval > 0 {
+			logger.Write(logger.FATAL, "--clipboard copies a single page, not a batch or --watch output")
+			return exitUsage
+		}
+	}
+	// Without --output, the clipboard takes its place
+	clipboardOnly := copyToClipboard && !flagGiven(args, "output", "o")
+	if clipboardOnly && open {
+		logger.Write(logger.FATAL, "--open with --clipboard needs an --output to open")
+		return exitUsage
+	}
+	// Nothing is written for these without --output
+	noOutput := metadataOnly || compareOnly || clipboardOnly
+
+	// A dry run cleans the URLs as a batch does, writing nothing,
+	// so there's no saving, opening or re-cleaning them
+	if dryRun {
+		var conflict string
+		switch {
+		case warcFile:
+			conflict = "--warc"
+		case crawlDepth > 0:
+			conflict = "--crawl"
+		case watchInterval > 0:
+			conflict = "--watch"
+		case open:
+			conflict = "--open"
+		case flagGiven(args, "save", "s"):
+			conflict = "--save"
+		case linksFile != "":
+			conflict = "--links"
+		case metadata:
+			conflict = "--metadata"
+		case compareFile != "":
+			conflict = "--compare"
+		case copyToClipboard:
+			conflict = "--clipboard"
+		}
+		for _, u := range urls {
+			if u == "-" {
+				conflict = "stdin (\"-\")"
+			}
+		}
+		if conflict != "" {
+			logger.Write(logger.FATAL, "--dry-run can't be used with %s", conflict)
+			return exitUsage
+		}
+	}
+
+	// With an output directory the URLs are cleaned as a batch
+	if outputDir != "" || dryRun {
+		for _, u := range urls {
+			if u == "-" {
+				logger.Write(logger.FATAL, "stdin (\"-\") can only be cleaned on its own, not in a batch")
+				return exitUsage
+			}
+		}
+		b := newBatch(outputDir, fetchOptions)
+		if b.filter, err = newURLFilter(); err != nil {
+			logger.Write(logger.FATAL, "%s", err)
+			return exitUsage
+		}
+
+		// FLAG "workers"
+		workers, err := fs.GetInt("workers")
+		if err != nil {
+			panic(err)
+		}
+		if workers < 1 {
+			logger.Write(logger.FATAL, "invalid worker count [%d]", workers)
+			return exitUsage
+		}
+		b.workers = int(workers)
+
+		// Progress is reported on stderr, unless --quiet
+		if !quiet {
+			tty := isTerminal(os.Stderr)
+			b.progress = func(total int) progress {
+				return newProgress(os.Stderr, total, tty, printVerbose)
+			}
+		}
+		b.format = format
+		b.clobber = clobber
+		b.images = images
+		b.assetDir = assetDir
+		if b.assetDir == "" {
+			b.assetDir = filepath.Join(outputDir, "assets")
+		}
+
+		// FLAG "save"
+		// A batch saves each source by its output name in a directory,
+		// or with the time in the output directory for --save auto
+		if b.saveDir, err = fs.GetString("save"); err != nil {
+			panic(err)
+		}
+		switch b.saveDir {
+		case saveStdout:
+			logger.Write(logger.FATAL, "--save - can't be used with a batch: give a directory")
+			return exitUsage
+		case saveAuto:
+			b.saveDir, b.saveAuto = outputDir, true
+		}
+
+		// FLAG "feed-links", "feed-state"
+		if b.feedLinks, err = fs.Get("feed-links"); err != nil {
+			panic(err)
+		}
+		if b.feedState, err = fs.GetString("feed-state"); err != nil {
+			panic(err)
+		}
+
+		ctx := context.Background()
+		if dryRun {
+			if sitemap {
+				var pages []string
+				for _, u := range urls {
+					list, code := b.sitemapPages(ctx, u)
+					if code != 0 {
+						return code
+					}
+					pages = append(pages, list...)
+				}
+				urls = pages
+			}
+			return b.dryRun(ctx, urls, stdout)
+		}
+		if crawlDepth > 0 {
+			return b.crawl(ctx, urlToClean, crawlLimits{int(crawlDepth), int(crawlLimit), crawlDelay})
+		}
+		if !sitemap && !warcFile {
+			return b.cleanURLs(ctx, urls)
+		}
+		// Each argument is a sitemap or WARC file of pages
+		clean := b.cleanSitemap
+		if warcFile {
+			clean = b.cleanWARC
+		}
+		exitCode := 0
+		for _, u := range urls {
+			if code := clean(ctx, u); exitCode == 0 {
+				exitCode = code
+			}
+		}
+		return exitCode
+	}
+
+	// FLAG "update"
+	update, err := fs.Get("update")
+	if err != nil {
+		panic(err)
+	}
+	if update && metadataOnly {
+		logger.Write(logger.FATAL, "--update with --metadata needs an --output to update")
+		return exitUsage
+	}
+	if update && clipboardOnly {
+		logger.Write(logger.FATAL, "--update with --clipboard needs an --output to update")
+		return exitUsage
+	}
+	// An unchanged source says nothing of the file compared with
+	if update && compareFile != "" {
+		logger.Write(logger.FATAL, "--update can't be used with --compare")
+		return exitUsage
+	}
+	// Ask whether the source changed since the output was rendered
+	if (update || watchInterval > 0) && outputFile != "" {
+		if prev, ok := readSummary(outputFile); ok && prev.Source == urlToClean {
+			fetchOptions.IfNoneMatch = prev.ETag
+			fetchOptions.IfModifiedSince = prev.LastModified
+		}
+	}
+
+	// An existing output is checked before fetching; --update
+	// and --watch re-render it in place
+	outputClobber := clobber
+	if update || watchInterval > 0 {
+		outputClobber = overwriteExisting
+	}
+	if noOutput {
+		outputFile = ""
+	} else if outputFile, err = outputClobber.target(outputFile); err != nil {
+		logger.Write(logger.FATAL, "not replacing %s: use --force to overwrite it", err)
+		return exitExists
+	}
+
+	// FLAG "fetcher"
+	fetcherSpec, err := fs.GetString("fetcher")
+	if err != nil {
+		panic(err)
+	}
+	fetcher, err := parseFetcher(fetcherSpec, fetchOptions)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
+	}
+
+	if watchInterval > 0 {
+		if assetDir == "" {
+			assetDir = strings.TrimSuffix(strings.TrimSuffix(outputFile, gzipExt), format.ext()) + "_files"
+		}
+		w := &watcher{url: urlToClean, outputFile: outputFile, format: format, base: baseURL,
+			images: images, assetDir: assetDir, spec: fetcherSpec, opts: fetchOptions,
+			interval: watchInterval, count: int(watchCount), timeout: timeout}
+		logger.Write(logger.INFO, "watching [%s] every %v", urlToClean, watchInterval)
+		return w.run()
+	}
+
+	// FLAG "save"
+	saveFile, err := fs.GetString("save")
+	if err != nil {
+		panic(err)
+	}
+	if saveFile == saveStdout && linksFile == "-" {
+		logger.Write(logger.FATAL, "--save - and --links - can't both write to stdout")
+		return exitUsage
+	}
+	if metadataOnly && (saveFile == saveStdout || linksFile == "-") {
+		logger.Write(logger.FATAL, "--metadata writes to stdout without --output, so --save - and --links - can't")
+		return exitUsage
+	}
+	if compareFile != "" && (saveFile == saveStdout || linksFile == "-") {
+		logger.Write(logger.FATAL, "--compare writes to stdout, so --save - and --links - can't")
+		return exitUsage
+	}
+	// The source, links, metadata or comparison on stdout aren't
+	// mixed with the outcome
+	status := stdout
+	if linksFile == "-" || metadataOnly || compareFile != "" {
+		status = os.Stderr
+	}
+	switch saveFile {
+	case "", saveAuto:
+	case saveStdout:
+		status = os.Stderr
+	default:
+		// Verify is .html extension
+		if outputExt(saveFile) != ".html" {
+			logger.Write(logger.FATAL, "file [%s] must have .html or .html.gz extension", saveFile)
+			return exitUsage
+		}
+	}
+
+	// The timeout covers both fetching and cleaning the document
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// "-" cleans a document piped in on stdin
+	var result *cleanhtml.FetchResult
+	if urlToClean == "-" {
+		result, err = readStdin(fetchOptions)
+	} else {
+		result, err = fetcher.Fetch(ctx, urlToClean)
+		// A host given without a scheme may only serve http
+		if err != nil && allowHTTP && bareHosts[urlToClean] && httpsFailed(err) {
+			httpURL := "http://" + strings.TrimPrefix(urlToClean, "https://")
+			logger.Write(logger.NOTICE, "could not fetch [%s], trying [%s] (--allow-http): %s", urlToClean, httpURL, err)
+			urlToClean = httpURL
+			result, err = fetcher.Fetch(ctx, urlToClean)
+		}
+	}
+	if isTimeout(err) {
+		logger.Write(logger.FATAL, "timed out after %v fetching [%s]", timeout, urlToClean)
+		return exitTimeout
+	}
+	if errors.Is(err, cleanhtml.ErrBodyTooLarge) {
+		logger.Write(logger.FATAL, "Cannot read [%s]: larger than --max-size %s (use 0 for no limit)", urlToClean, maxSizeStr)
+		return exitTooBig
+	}
+	if err != nil {
+		logger.Write(logger.FATAL, "Cannot read [%s]: %s", urlToClean, err)
+		return fetchExitCode(err)
+	}
+	if result.NotModified {
+		fmt.Fprintf(status, "Document unchanged, %q not updated\n", outputFile)
+		logger.Write(logger.NOTICE, "[%s] unchanged since [%s] was rendered", urlToClean, outputFile)
+		if open {
+			return showOutput(outputFile)
+		}
+		return 0
+	}
+	sourceData := result.Body
+
+	logFetchResult(logger.With(logger.Fields{"url": urlToClean}), result)
+
+	if result.Snapshot != "" {
+		fmt.Fprintf(status, "Page is gone, using archived copy %q\n", result.Snapshot)
+	}
+
+	// Links are resolved against wherever the document ended up
+	if result.URL != urlToClean {
+		logger.Write(logger.INFO, "document read from URL=%s", result.URL)
+	}
+	if baseURL == "" && urlToClean == "-" {
+		logger.Write(logger.NOTICE, "stdin has no address: relative links are left as they are without --base")
+	} else if baseURL == "" {
+		baseURL = result.URL
+	}
+	cleanhtml.SetBaseURL(baseURL)
+
+	// Save a copy of the source, as it was fetched
+	if saveFile != "" {
+		// Named after the URL, next to the output
+		if saveFile == saveAuto {
+			saveFile = filepath.Join(filepath.Dir(outputFile), autoSaveName(urlToClean, result.ContentType, time.Now()))
+		}
+		if saveFile != saveStdout {
+			if saveFile, err = clobber.target(saveFile); err != nil {
+				logger.Write(logger.FATAL, "not replacing %s: use --force to overwrite it", err)
+				return exitExists
+			}
+			logger.Write(logger.INFO, "saving a copy of the source document to %s", saveFile)
+		}
+		if err := writeFile(saveFile, sourceData); err != nil {
+			logger.Write(logger.FATAL, "could not save the source document to [%s]: %s", saveFile, err)
+			return exitOutput
+		}
+	}
+
+	// Without an output file, --open shows a temporary one
+	if open && outputFile == "" {
+		f, err := ioutil.TempFile(os.TempDir(), "cleanpg-*.html")
+		if err != nil {
+			logger.Write(logger.FATAL, "could not create a temporary file: %s", err)
+			return exitOutput
+		}
+		outputFile = f.Name()
+		f.Close()
+	}
+
+	// Images are fetched as the page is cleaned, unless there
+	// is no output to show them
+	if (images == "download" || images == "inline") && !noOutput {
+		if assetDir == "" {
+			assetDir = strings.TrimSuffix(strings.TrimSuffix(outputFile, gzipExt), format.ext()) + "_files"
+		}
+		saver := newAssetSaver(ctx, fetchOptions, images == "inline", assetDir, filepath.Dir(outputFile))
+		cleanhtml.SetImageSource(saver.sourceFor(result.URL))
+	}
+
+	// Create the cleanly-formatted page
+	cleanData, err := cleanhtml.CleanHTMLContext(ctx, sourceData)
+	if isTimeout(err) {
+		logger.Write(logger.FATAL, "timed out after %v cleaning [%s]", timeout, urlToClean)
+		return exitTimeout
+	}
+	if errors.Is(err, cleanhtml.ErrNoIncludeMatch) {
+		logger.Write(logger.FATAL, "nothing in [%s] matches --include [%s]; use --include-fallback full to render the whole page", urlToClean, include)
+		return exitClean
+	}
+	if err != nil {
+		logger.Write(logger.FATAL, "Could not clean [%s]: %s", urlToClean, err)
+		return exitClean
+	}
+
+	// Write to designated output
+	if !noOutput {
+		s := summary{Source: urlToClean, ETag: result.ETag, LastModified: result.LastModified, Snapshot: result.Snapshot}
+		if err := writeOutput(outputFile, cleanData, s, format); err != nil {
+			logger.Write(logger.FATAL, "could not write [%s]: %s", outputFile, err)
+			return exitOutput
+		}
+		fmt.Fprintf(status, "Document rendered to %q\n", outputFile)
+		logger.Write(logger.INFO, "Document from %q rendered to %q", urlToClean, outputFile)
+	}
+
+	// The clipboard gets the output as read, without its summary
+	if copyToClipboard {
+		if code := copyOutput(cleanData, format); code != 0 {
+			return code
+		}
+		fmt.Fprintf(status, "Document copied to the clipboard\n")
+	}
+
+	// The links are those of the page as fetched
+	if linksFile != "" {
+		if code := saveLinks(linksFile, linksFormat == "tsv", sourceData, baseURL, clobber); code != 0 {
+			return code
+		}
+	}
+
+	// The metadata goes beside the output, on stderr unless to a file
+	if metadata {
+		var w io.Writer = os.Stderr
+		if metadataOnly {
+			w = stdout
+		}
+		if code := saveMetadata(metadataFile, w, urlToClean, sourceData, cleanData, baseURL, clobber); code != 0 {
+			return code
+		}
+	}
+
+	// The comparison is of the content as cleaned, whatever the --format
+	changed := false
+	if compareFile != "" {
+		if changed, err = writeComparison(stdout, compareFile, compareOld, cleanData, diffOpts); err != nil {
+			logger.Write(logger.FATAL, "could not compare [%s] with [%s]: %s", urlToClean, compareFile, err)
+			return exitClean
+		}
+	}
+
+	if open {
+		if code := showOutput(outputFile); code != 0 {
+			return code
+		}
+	}
+	if changed {
+		return exitChanged
+	}
 	return 0
 
 }
@@ -0,0 +1,1857 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// TestMain gives the tests a home and cache directory of their own,
+// so that runs logging to the default log file leave the user's alone
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "cleanpg-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", dir)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, ".cache"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestCleanpgMain_URLs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Page " + r.URL.Path + "</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	dir := t.TempDir()
+	saveDir := filepath.Join(t.TempDir(), "sources")
+	code := cleanpgMain([]string{"cleanpg", "--output-dir", dir, "--save", saveDir, "--workers", "1",
+		ts.URL + "/one", ts.URL + "/missing", ts.URL + "/two"})
+	if code != exitPartial {
+		t.Errorf("Expected exit code %d with a missing page, got %d", exitPartial, code)
+	}
+
+	expect := []string{"127.0.0.1-one.html", "127.0.0.1-two.html"}
+	if names := outputFiles(dir); !reflect.DeepEqual(names, expect) {
+		t.Fatalf("Expected %q, got %q", expect, names)
+	}
+	if names := outputFiles(saveDir); !reflect.DeepEqual(names, expect) {
+		t.Errorf("Expected saved sources %q, got %q", expect, names)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, expect[1]))
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	if !strings.Contains(string(data), "Page /two") {
+		t.Errorf("Expected the page cleaned, got %q", data)
+	}
+
+	// Several URLs need somewhere to put the pages
+	if code := cleanpgMain([]string{"cleanpg", ts.URL + "/one", ts.URL + "/two"}); code != 1 {
+		t.Errorf("Expected exit code 1 without --output-dir, got %d", code)
+	}
+}
+
+func TestCleanpgMain_InputFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Page " + r.URL.Path + "</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	list := "# from stdin\n" + ts.URL + "/one\n\n" + ts.URL + "/two\n" + ts.URL + "/one\nnot a url\n"
+	stdin = strings.NewReader(list)
+	defer func() { stdin = os.Stdin }()
+
+	dir := t.TempDir()
+	code := cleanpgMain([]string{"cleanpg", "--input-file", "-", "--output-dir", dir, ts.URL + "/three"})
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+
+	expect := []string{"127.0.0.1-one.html", "127.0.0.1-three.html", "127.0.0.1-two.html"}
+	if names := outputFiles(dir); !reflect.DeepEqual(names, expect) {
+		t.Errorf("Expected %q, got %q", expect, names)
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--input-file", filepath.Join(dir, "missing.txt"), "--output-dir", dir}); code != 1 {
+		t.Errorf("Expected exit code 1 for a missing list, got %d", code)
+	}
+}
+
+func TestCleanpgMain_Stdin(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetBaseURL("")
+	defer func() { stdin = os.Stdin }()
+
+	page := `<html><body><h1>Piped</h1><p><a href="/about">About</a></p></body></html>`
+	tests := []struct {
+		args []string
+		href string
+	}{
+		{nil, `href="/about"`},
+		{[]string{"--base", "https://example.com/docs/"}, `href="https://example.com/about"`},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		outputFile := filepath.Join(dir, "out.html")
+		saveFile := filepath.Join(dir, "source.html")
+		stdin = strings.NewReader(page)
+
+		args := append([]string{"cleanpg", "-o", outputFile, "--save", saveFile}, tt.args...)
+		if code := cleanpgMain(append(args, "-")); code != 0 {
+			t.Fatalf("Expected exit code 0, got %d", code)
+		}
+
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Could not read output: %v", err)
+		}
+		for _, want := range []string{"Piped", tt.href, `source="-"`} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Expected %q in the output, got %q", want, data)
+			}
+		}
+		if saved, _ := ioutil.ReadFile(saveFile); string(saved) != page {
+			t.Errorf("Expected the input saved, got %q", saved)
+		}
+	}
+
+	// --base must be an absolute http(s) URL, and is required to fetch images
+	defer cleanhtml.SetImageRender(false)
+	for _, args := range [][]string{
+		{"--base", "/docs/"},
+		{"--base", "ftp://example.com/"},
+		{"--base", "https:///docs"},
+		{"--images=download"},
+	} {
+		stdin = strings.NewReader(page)
+		args = append(append([]string{"cleanpg", "-o", filepath.Join(t.TempDir(), "out.html")}, args...), "-")
+		if code := cleanpgMain(args); code != 1 {
+			t.Errorf("%q: expected exit code 1, got %d", args, code)
+		}
+	}
+
+	// Oversized input is refused
+	defer cleanhtml.SetMaxBodyBytes(cleanhtml.DefaultMaxBodyBytes)
+	stdin = strings.NewReader(page)
+	if code := cleanpgMain([]string{"cleanpg", "--max-size", "10", "-o", filepath.Join(t.TempDir(), "out.html"), "-"}); code != exitTooBig {
+		t.Errorf("Expected exit code %d for oversized input, got %d", exitTooBig, code)
+	}
+}
+
+func TestCleanpgMain_Format(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetStyleRender(true)
+
+	tests := []struct {
+		format string
+		ext    string
+		expect []string
+	}{
+		{"html", ".html", []string{"<h1", "<b>before</b>", "<!-- cleanpg source="}},
+		{"markdown", ".md", []string{"# Pruning Roses\n", "**before**", "[sharp tools](https://garden.example/tools)", "| Tool | Use |", "<!-- cleanpg source="}},
+		{"text", ".txt", []string{"Pruning Roses\n=============\n", "sharp tools (https://garden.example/tools)", "Tool | Use"}},
+		{"json", ".json", []string{`"title": "Pruning Roses"`, `"html": "<!DOCTYPE html>`, `"text": "Pruning Roses\n`}},
+	}
+
+	for _, tt := range tests {
+		outputFile := filepath.Join(t.TempDir(), "out"+tt.ext)
+		if code := cleanpgMain([]string{"cleanpg", "-f", tt.format, "-o", outputFile, "testdata/article.html"}); code != 0 {
+			t.Fatalf("%s: expected exit code 0, got %d", tt.format, code)
+		}
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Could not read output: %v", err)
+		}
+		for _, want := range tt.expect {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("%s: expected %q in the output, got %q", tt.format, want, data)
+			}
+		}
+		if strings.Contains(string(data), "Home") {
+			t.Errorf("%s: expected canonical mode to skip the navigation, got %q", tt.format, data)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"-f", "pdf"},
+		{"-f", "markdown", "-o", filepath.Join(dir, "out.html")},
+		{"--text-width", "-1"},
+	} {
+		args = append(append([]string{"cleanpg"}, args...), "testdata/article.html")
+		if code := cleanpgMain(args); code != 1 {
+			t.Errorf("Expected exit code 1 for %q, got %d", args, code)
+		}
+	}
+
+	// Options which don't apply only warn
+	outputFile := filepath.Join(dir, "out.md")
+	if code := cleanpgMain([]string{"cleanpg", "-f", "markdown", "--nostyle", "-o", outputFile, "testdata/article.html"}); code != 0 {
+		t.Errorf("Expected exit code 0 with --nostyle, got %d", code)
+	}
+}
+
+func TestCleanpgMain_PostHeading(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetPostHeadingLevel(1)
+	defer cleanhtml.SetPostH1Selection(cleanhtml.FirstH1)
+
+	tests := []struct {
+		args     []string
+		expect   string
+		unexpect string
+	}{
+		{nil, "Notes from a small garden", ""},
+		{[]string{"-posth1"}, "Notes from a small garden", ""},
+		{[]string{"--post-heading", "h2"}, "Dividing Hostas", "Recent posts"},
+		{[]string{"--post-heading", "h3"}, "Recent posts", "Notes from a small garden"},
+		// The masthead and sidebar headings have little text after them
+		{[]string{"--post-heading", "auto"}, "Dividing Hostas", "Recent posts"},
+		{[]string{"--nocanon", "--post-heading", "h2"}, "Notes from a small garden", ""},
+	}
+
+	for _, tt := range tests {
+		outputFile := filepath.Join(t.TempDir(), "out.html")
+		args := append(append([]string{"cleanpg", "-o", outputFile}, tt.args...), "testdata/headings.html")
+		cleanhtml.SetPostH1Render(false)
+		if code := cleanpgMain(args); code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d", tt.args, code)
+		}
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("%q: could not read output: %v", tt.args, err)
+		}
+		if !strings.Contains(string(data), tt.expect) {
+			t.Errorf("%q: expected %q in the output, got %q", tt.args, tt.expect, data)
+		}
+		if tt.unexpect != "" && strings.Contains(string(data), tt.unexpect) {
+			t.Errorf("%q: expected %q skipped, got %q", tt.args, tt.unexpect, data)
+		}
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--post-heading", "h4", "testdata/headings.html"}); code != exitUsage {
+		t.Errorf("Expected exit code %d for --post-heading h4, got %d", exitUsage, code)
+	}
+}
+
+func TestCleanpgMain_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Slow</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetHTTPTimeout(cleanhtml.DefaultTimeout)
+
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	start := time.Now()
+	code := cleanpgMain([]string{"cleanpg", "--timeout", "200ms", "-o", outputFile, ts.URL})
+	if code != exitTimeout {
+		t.Errorf("Expected exit code %d, got %d", exitTimeout, code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the fetch abandoned at the deadline, took %v", elapsed)
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no output written, got %v", err)
+	}
+}
+
+func TestCleanpgMain_ExitCodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/slow":
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Page " + r.URL.Path + "</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetHTTPTimeout(cleanhtml.DefaultTimeout)
+	defer cleanhtml.SetInclude("", false)
+
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	ioutil.WriteFile(notDir, nil, 0644)
+
+	out := filepath.Join(dir, "out.html")
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-o", out, "--no-such-flag", ts.URL}, exitUsage},
+		{[]string{"-o", out, "http://127.0.0.1:1/dead"}, exitFetch},
+		{[]string{"-o", out, ts.URL + "/missing"}, exitStatus},
+		{[]string{"-o", out, "--include", "#missing", ts.URL}, exitClean},
+		{[]string{"-o", filepath.Join(notDir, "out.html"), ts.URL}, exitOutput},
+		{[]string{"-o", out, "--timeout", "100ms", ts.URL + "/slow"}, exitTimeout},
+		{[]string{"-O", filepath.Join(dir, "pages"), ts.URL + "/one", ts.URL + "/missing"}, exitPartial},
+		{[]string{"-O", filepath.Join(dir, "none"), ts.URL + "/missing", "http://127.0.0.1:1/dead"}, exitStatus},
+	}
+
+	for _, tt := range tests {
+		cleanhtml.SetInclude("", false)
+		cleanhtml.SetHTTPTimeout(cleanhtml.DefaultTimeout)
+
+		if code := cleanpgMain(append([]string{"cleanpg", "--force"}, tt.args...)); code != tt.code {
+			t.Errorf("%q: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+	}
+}
+
+func TestCleanpgMain_MaxSize(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html")
+		// Streamed, so only the body read reveals the size
+		w.(http.Flusher).Flush()
+		w.Write([]byte("<html><body><h1>Big</h1><p>" + strings.Repeat("x", 2000) + "</p></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetMaxBodyBytes(cleanhtml.DefaultMaxBodyBytes)
+
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	for _, tt := range []struct {
+		size string
+		code int
+	}{
+		{"1KB", exitTooBig},
+		{"1KiB", exitTooBig},
+		{"2KiB", 0},
+		{"0", 0},
+	} {
+		cleanhtml.SetMaxBodyBytes(cleanhtml.DefaultMaxBodyBytes)
+		if code := cleanpgMain([]string{"cleanpg", "--force", "--max-size", tt.size, "-o", outputFile, ts.URL}); code != tt.code {
+			t.Errorf("%s: expected exit code %d, got %d", tt.size, tt.code, code)
+		}
+	}
+
+	// A malformed size is refused before fetching
+	requests = 0
+	for _, size := range []string{"5XB", "-1", "MB", "1.5.2MB"} {
+		if code := cleanpgMain([]string{"cleanpg", "--max-size", size, "-o", outputFile, ts.URL}); code != exitUsage {
+			t.Errorf("%q: expected exit code %d, got %d", size, exitUsage, code)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests for malformed sizes, got %d", requests)
+	}
+}
+
+func TestCleanpgMain_Retry(t *testing.T) {
+	// Each page and image fails twice before it's served
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+		if n <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/logo.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><h1>Page ` + r.URL.Path + `</h1><p><img src="/logo.png" alt="logo"></p></body></html>`))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetRetries(0, 0)
+	defer cleanhtml.SetImageRender(false)
+	defer cleanhtml.SetImageSource(nil)
+
+	dir := t.TempDir()
+	if code := cleanpgMain([]string{"cleanpg", "--retry", "3", "--retry-delay", "1ms", "--images=download",
+		"-o", filepath.Join(dir, "out.html"), ts.URL + "/page"}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if calls["/page"] != 3 || calls["/logo.png"] != 3 {
+		t.Errorf("Expected 3 attempts at the page and the image, got %v", calls)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out_files", "logo.png")); err != nil {
+		t.Errorf("Expected the image downloaded: %v", err)
+	}
+
+	// Each page of a batch is retried on its own
+	cleanhtml.SetImageRender(false)
+	cleanhtml.SetImageSource(nil)
+	calls = make(map[string]int)
+	if code := cleanpgMain([]string{"cleanpg", "-r", "2", "--retry-delay", "1ms", "-O", filepath.Join(dir, "pages"),
+		ts.URL + "/one", ts.URL + "/two"}); code != 0 {
+		t.Fatalf("Expected exit code 0 for the batch, got %d", code)
+	}
+	if calls["/one"] != 3 || calls["/two"] != 3 {
+		t.Errorf("Expected 3 attempts at each page, got %v", calls)
+	}
+
+	// Too few retries fail, and the delay must be a duration
+	calls = make(map[string]int)
+	if code := cleanpgMain([]string{"cleanpg", "-r", "1", "--retry-delay", "1ms", "--force", "-o", filepath.Join(dir, "out.html"), ts.URL + "/page"}); code != exitStatus {
+		t.Errorf("Expected exit code %d with too few retries, got %d", exitStatus, code)
+	}
+	for _, delay := range []string{"soon", "0", "-1s"} {
+		if code := cleanpgMain([]string{"cleanpg", "--retry-delay", delay, ts.URL}); code != exitUsage {
+			t.Errorf("%q: expected exit code %d, got %d", delay, exitUsage, code)
+		}
+	}
+}
+
+func TestCleanpgMain_Headers(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Headers</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetHeaders(nil)
+
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	code := cleanpgMain([]string{"cleanpg", "-o", outputFile,
+		"--header", "Accept-Language: de", "-H", "X-Token: abc", "--header=User-Agent: reader/2", ts.URL})
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	for name, expect := range map[string]string{"Accept-Language": "de", "X-Token": "abc", "User-Agent": "reader/2"} {
+		if v := got.Get(name); v != expect {
+			t.Errorf("Expected %s %q, got %q", name, expect, v)
+		}
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "-H", "Host: other.example", ts.URL}); code != 1 {
+		t.Errorf("Expected exit code 1 for a Host header, got %d", code)
+	}
+}
+
+func TestCleanpgMain_Cookies(t *testing.T) {
+	// The page is behind a consent wall, which /accept sets the
+	// cookie to get past
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/accept" {
+			http.SetCookie(w, &http.Cookie{Name: "consent", Value: "yes-4821", Path: "/",
+				Expires: time.Now().Add(time.Hour)})
+		} else if c, err := r.Cookie("consent"); err != nil || c.Value != "yes-4821" {
+			http.Error(w, "consent needed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Behind the wall</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetCookies(nil)
+	defer cleanhtml.SetCookieJar(nil)
+	defer logger.SetLogFile("")
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.html")
+	jarFile := filepath.Join(dir, "cookies.txt")
+	logFile := filepath.Join(dir, "run.log")
+	run := func(args ...string) int {
+		return cleanpgMain(append([]string{"cleanpg", "--force", "-g", logFile, "-o", outputFile}, args...))
+	}
+
+	if code := run(ts.URL + "/article"); code != exitStatus {
+		t.Errorf("Expected exit code %d without the cookie, got %d", exitStatus, code)
+	}
+	if code := run("--cookie", "consent=yes-4821", ts.URL+"/article"); code != 0 {
+		t.Errorf("Expected exit code 0 with --cookie, got %d", code)
+	}
+	cleanhtml.SetCookies(nil)
+
+	// The jar keeps the cookie set by one run for the next
+	if code := run("--cookie-jar", jarFile, ts.URL+"/accept"); code != 0 {
+		t.Fatalf("Expected exit code 0 accepting, got %d", code)
+	}
+	cleanhtml.SetCookieJar(nil)
+	if data, err := ioutil.ReadFile(jarFile); err != nil || !strings.Contains(string(data), "\tconsent\tyes-4821\n") {
+		t.Errorf("Expected the cookie saved in the jar, got %q, %v", data, err)
+	}
+	if code := run("--cookie-jar", jarFile, ts.URL+"/article"); code != 0 {
+		t.Errorf("Expected exit code 0 with the jar, got %d", code)
+	}
+	cleanhtml.SetCookieJar(nil)
+
+	// Bad cookies and jars are refused before fetching
+	if code := run("--cookie", "consent", ts.URL+"/article"); code != exitUsage {
+		t.Errorf("Expected exit code %d for a malformed cookie, got %d", exitUsage, code)
+	}
+	badJar := filepath.Join(dir, "bad.txt")
+	ioutil.WriteFile(badJar, []byte("# Netscape HTTP Cookie File\n127.0.0.1\tFALSE\t/\n"), 0644)
+	if code := run("--cookie-jar", badJar, ts.URL+"/article"); code != exitUsage {
+		t.Errorf("Expected exit code %d for a malformed jar, got %d", exitUsage, code)
+	}
+
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Could not read log file: %v", err)
+	}
+	if !strings.Contains(string(data), "bad.txt: line 2") {
+		t.Errorf("Expected the bad line of the jar logged, got %q", data)
+	}
+	if strings.Contains(string(data), "yes-4821") {
+		t.Errorf("Expected no cookie value in the log, got %q", data)
+	}
+}
+
+func TestCleanpgMain_Proxy(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+	var direct int
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Via the proxy</h1></body></html>"))
+	}))
+	defer proxyServer.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		direct++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Direct</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetProxy("")
+	defer cleanhtml.SetNoProxy(false)
+	defer logger.SetLogFile("")
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.html")
+	logFile := filepath.Join(dir, "run.log")
+	run := func(args ...string) int {
+		return cleanpgMain(append([]string{"cleanpg", "--force", "-g", logFile, "-o", outputFile}, args...))
+	}
+
+	// Each run logs which proxy it fetches through, if any
+	logged := func(expect string) {
+		t.Helper()
+		if data, err := ioutil.ReadFile(logFile); err != nil || !strings.Contains(string(data), expect) {
+			t.Errorf("Expected %q in the log, got %q, %v", expect, data, err)
+		}
+	}
+
+	// The proxy fetches the page in place of its server
+	if code := run("--proxy", proxyServer.URL, ts.URL+"/page"); code != 0 {
+		t.Fatalf("Expected exit code 0 through the proxy, got %d", code)
+	}
+	logged("fetching through proxy [" + proxyServer.URL + "]")
+	if len(proxied) != 1 || proxied[0] != ts.URL+"/page" || direct != 0 {
+		t.Errorf("Expected only the proxy to see %s, got %q and %d direct", ts.URL+"/page", proxied, direct)
+	}
+	if data, _ := ioutil.ReadFile(outputFile); !strings.Contains(string(data), "Via the proxy") {
+		t.Errorf("Expected the proxy's page, got %q", data)
+	}
+	cleanhtml.SetProxy("")
+
+	if code := run("--no-proxy", ts.URL+"/page"); code != 0 {
+		t.Fatalf("Expected exit code 0 with --no-proxy, got %d", code)
+	}
+	logged("fetching directly, ignoring any proxy")
+	if len(proxied) != 1 || direct != 1 {
+		t.Errorf("Expected a direct fetch, got %q and %d direct", proxied, direct)
+	}
+
+	// A proxy which can't be used is refused before fetching
+	for _, args := range [][]string{
+		{"--proxy", "ftp://proxy.example:21"},
+		{"--proxy", "proxy.example:3128"},
+		{"--proxy", proxyServer.URL, "--no-proxy"},
+	} {
+		if code := run(append(args, ts.URL)...); code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+	if len(proxied) != 1 || direct != 1 {
+		t.Errorf("Expected no more fetches, got %q and %d direct", proxied, direct)
+	}
+}
+
+func TestCleanpgMain_Insecure(t *testing.T) {
+	// The test server's certificate is signed by no trusted CA
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Self-signed</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetTLS("", "", "", false)
+	defer cleanhtml.SetCookieJar(nil)
+	defer logger.SetLogFile("")
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.html")
+	jarFile := filepath.Join(dir, "cookies.txt")
+	logFile := filepath.Join(dir, "run.log")
+	run := func(args ...string) int {
+		return cleanpgMain(append([]string{"cleanpg", "--force", "-g", logFile, "-o", outputFile}, args...))
+	}
+
+	if code := run(ts.URL); code != exitFetch {
+		t.Errorf("Expected exit code %d verifying the certificate, got %d", exitFetch, code)
+	}
+	if code := run("--insecure", ts.URL); code != 0 {
+		t.Fatalf("Expected exit code 0 with --insecure, got %d", code)
+	}
+	if data, err := ioutil.ReadFile(logFile); err != nil || !strings.Contains(string(data), "WARNING: TLS certificates are not verified") {
+		t.Errorf("Expected the warning logged, got %q, %v", data, err)
+	}
+	cleanhtml.SetTLS("", "", "", false)
+
+	// A cookie jar needs its own permission, and is left alone without
+	if code := run("-k", "--cookie-jar", jarFile, ts.URL); code != exitUsage {
+		t.Errorf("Expected exit code %d for --insecure with --cookie-jar, got %d", exitUsage, code)
+	}
+	if _, err := os.Stat(jarFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no cookie jar saved, got %v", err)
+	}
+	if code := run("-k", "--insecure-allow-cookies", "--cookie-jar", jarFile, ts.URL); code != 0 {
+		t.Errorf("Expected exit code 0 with --insecure-allow-cookies, got %d", code)
+	}
+	if _, err := os.Stat(jarFile); err != nil {
+		t.Errorf("Expected the cookie jar saved, got %v", err)
+	}
+}
+
+func TestCleanpgMain_Charset(t *testing.T) {
+	// The page is windows-1252, but says it's UTF-8
+	var fetches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data, _ := ioutil.ReadFile("testdata/windows-1252.html")
+		w.Write(data)
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetCharset("")
+
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	for _, tt := range []struct {
+		charset string
+		decoded bool
+	}{
+		{"auto", false},
+		{"windows-1252", true},
+		{"latin1", true},
+		{"auto", false},
+	} {
+		if code := cleanpgMain([]string{"cleanpg", "--force", "--charset", tt.charset, "-o", outputFile, ts.URL}); code != 0 {
+			t.Fatalf("%s: expected exit code 0, got %d", tt.charset, code)
+		}
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Could not read output: %v", err)
+		}
+		if decoded := strings.Contains(string(data), "Crème brûlée, £4 — served naïvely warm."); decoded != tt.decoded {
+			t.Errorf("%s: expected decoded %v, got %q", tt.charset, tt.decoded, data)
+		}
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--charset", "klingon", "-o", outputFile, ts.URL}); code != exitUsage {
+		t.Errorf("Expected exit code %d for an unknown charset, got %d", exitUsage, code)
+	}
+	if fetches != 4 {
+		t.Errorf("Expected no fetch for an unknown charset, got %d fetches", fetches)
+	}
+}
+
+func TestCleanpgMain_LocalFile(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetBaseURL("")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	page := []byte(`<html><body><h1>Saved</h1><p>See <a href="other.html">the other page</a>.</p></body></html>`)
+	for _, name := range []string{"saved.html", "example.com"} {
+		if err := ioutil.WriteFile(name, page, 0644); err != nil {
+			t.Fatalf("Could not write %s: %v", name, err)
+		}
+	}
+	absolute := filepath.Join(dir, "saved.html")
+
+	tests := []struct {
+		name   string
+		args   []string
+		expect string // in the output
+		notice bool   // of reading a file named like a host
+	}{
+		{"relative path", []string{"saved.html"}, fileURLOf(t, filepath.Join(dir, "other.html")), false},
+		{"absolute path", []string{absolute}, fileURLOf(t, filepath.Join(dir, "other.html")), false},
+		{"base", []string{"--base", "https://example.org/pages/", absolute}, "https://example.org/pages/other.html", false},
+		{"named like a host", []string{"example.com"}, fileURLOf(t, filepath.Join(dir, "other.html")), true},
+	}
+	for _, tt := range tests {
+		args := append([]string{"cleanpg", "--force", "-o", "out.html"}, tt.args...)
+		if code := cleanpgMain(args); code != 0 {
+			t.Fatalf("%s: expected exit code 0, got %d", tt.name, code)
+		}
+		data, err := ioutil.ReadFile("out.html")
+		if err != nil {
+			t.Fatalf("%s: could not read output: %v", tt.name, err)
+		}
+		if !strings.Contains(string(data), `href="`+tt.expect+`"`) {
+			t.Errorf("%s: expected a link to %q, got %q", tt.name, tt.expect, data)
+		}
+		log, _ := ioutil.ReadFile(logger.DefaultLogFile())
+		if notice := strings.Contains(string(log), "NOTICE: reading the local file [example.com]; to fetch the site, give https://example.com"); notice != tt.notice {
+			t.Errorf("%s: expected notice %v in log %q", tt.name, tt.notice, log)
+		}
+		cleanhtml.SetBaseURL("")
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "-o", "missing.html", "no-such-page.html"}); code != exitFetch {
+		t.Errorf("Expected exit code %d for a missing file, got %d", exitFetch, code)
+	}
+	if _, err := os.Stat("missing.html"); !os.IsNotExist(err) {
+		t.Errorf("Expected no output for a missing file: %v", err)
+	}
+}
+
+// fileURLOf returns the file:// URL of path
+func fileURLOf(t *testing.T, path string) string {
+	t.Helper()
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func TestCleanpgMain_BareHost(t *testing.T) {
+	page := []byte("<html><body><h1>Article</h1><p>Fetched.</p></body></html>")
+	var tlsPaths []string
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tlsPaths = append(tlsPaths, r.URL.Path)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(page)
+	}))
+	defer tlsServer.Close()
+	var plainFetches int
+	plainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plainFetches++
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(page)
+	}))
+	defer plainServer.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetTLS("", "", "", false)
+	defer logger.SetLogFile("")
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatalf("Could not write CA certificate: %v", err)
+	}
+	outputFile := filepath.Join(dir, "out.html")
+	logFile := filepath.Join(dir, "run.log")
+	run := func(args ...string) int {
+		return cleanpgMain(append([]string{"cleanpg", "--force", "-g", logFile, "-C", caFile, "-o", outputFile}, args...))
+	}
+
+	// Pasted without a scheme, and in angle brackets
+	bare := strings.TrimPrefix(tlsServer.URL, "https://")
+	if code := run("<" + bare + "/article> "); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if !reflect.DeepEqual(tlsPaths, []string{"/article"}) {
+		t.Errorf("Expected /article fetched over https, got %q", tlsPaths)
+	}
+	if data, err := ioutil.ReadFile(outputFile); err != nil || !strings.Contains(string(data), "Fetched.") {
+		t.Errorf("Expected the article rendered, got %q, %v", data, err)
+	}
+
+	// A server which only speaks http needs --allow-http
+	plain := strings.TrimPrefix(plainServer.URL, "http://")
+	if code := run(plain); code != exitFetch {
+		t.Errorf("Expected exit code %d over https, got %d", exitFetch, code)
+	}
+	if code := run("--allow-http", plain); code != 0 {
+		t.Fatalf("Expected exit code 0 with --allow-http, got %d", code)
+	}
+	if plainFetches != 1 {
+		t.Errorf("Expected one fetch over http, got %d", plainFetches)
+	}
+	if data, _ := ioutil.ReadFile(logFile); !strings.Contains(string(data), "trying [http://"+plain+"] (--allow-http)") {
+		t.Errorf("Expected the fallback logged, got %q", data)
+	}
+
+	// An unsupported scheme is refused before there is a log
+	os.Remove(logFile)
+	if code := run("ftp://" + bare + "/article"); code != exitUsage {
+		t.Errorf("Expected exit code %d for ftp, got %d", exitUsage, code)
+	}
+	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no log for an unsupported scheme: %v", err)
+	}
+}
+
+func TestCleanpgMain_Quiet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Quiet</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer logger.SetDiscard(false)
+	defer logger.LogToStderr(false)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	if code := cleanpgMain([]string{"cleanpg", "-q", "-v", ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.html")); err != nil {
+		t.Errorf("Expected the output written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no log file with --quiet, got %v", err)
+	}
+}
+
+func TestCleanpgMain_Logfile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Logged</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer logger.SetLogFile("")
+	defer logger.SetDiscard(false)
+	defer logger.LogToStderr(false)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	// Neither special value leaves a log file, nor touches the
+	// default one's directory
+	cache := os.Getenv("XDG_CACHE_HOME")
+	defer os.Setenv("XDG_CACHE_HOME", cache)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(t.TempDir(), "cache"))
+	for _, logFile := range []string{"none", "stderr"} {
+		if code := cleanpgMain([]string{"cleanpg", "--force", "--logfile", logFile, ts.URL}); code != 0 {
+			t.Fatalf("%s: expected exit code 0, got %d", logFile, code)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		if len(files) != 1 || filepath.Base(files[0]) != "out.html" {
+			t.Errorf("%s: expected only the output written, got %q", logFile, files)
+		}
+		if _, err := os.Stat(os.Getenv("XDG_CACHE_HOME")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no cache directory created, got %v", logFile, err)
+		}
+	}
+	os.Setenv("XDG_CACHE_HOME", cache)
+
+	nested := filepath.Join(dir, "logs", "cleanpg", "run.log")
+	if code := cleanpgMain([]string{"cleanpg", "-g", nested, ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(nested)
+	if err != nil {
+		t.Fatalf("Could not read log file: %v", err)
+	}
+	if !strings.Contains(string(data), "reading data from URL="+ts.URL) {
+		t.Errorf("Expected the run logged, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no default log file, got %v", err)
+	}
+
+	// By default the log goes to the cache directory, and the
+	// current directory only when named
+	os.Remove(logger.DefaultLogFile())
+	if code := cleanpgMain([]string{"cleanpg", "--force", ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	cacheDir, _ := os.UserCacheDir()
+	if data, err := ioutil.ReadFile(filepath.Join(cacheDir, "cleanpg", "cleanpg.log")); err != nil || !strings.Contains(string(data), "reading data from URL="+ts.URL) {
+		t.Errorf("Expected the run logged in the cache directory, got %q, %v", data, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(files) != 0 {
+		t.Errorf("Expected no log file in the current directory, got %q", files)
+	}
+	if code := cleanpgMain([]string{"cleanpg", "--force", "-g", "./log.txt", ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "log.txt")); err != nil || !strings.Contains(string(data), "reading data from URL="+ts.URL) {
+		t.Errorf("Expected the run logged in ./log.txt, got %q, %v", data, err)
+	}
+}
+
+func TestCleanpgMain_LogLevel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Logged</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetTLS("", "", "", false)
+	defer logger.SetLogFile("")
+	defer logger.SetLevel(logger.INFO)
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "run.log")
+	out := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "--log-level", "warning", "--insecure", "-g", logFile, "-o", out, ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Could not read log file: %v", err)
+	}
+	if !strings.Contains(string(data), "TLS certificates are not verified") {
+		t.Errorf("Expected the warning logged, got %q", data)
+	}
+	if strings.Contains(string(data), "reading data from URL=") {
+		t.Errorf("Expected no INFO messages logged, got %q", data)
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--log-level", "debug", "-g", logFile, "-o", out, ts.URL}); code != exitUsage {
+		t.Errorf("Expected exit code %d for an unknown level, got %d", exitUsage, code)
+	}
+}
+
+// Fetches are logged with fields, which --log-json makes members
+func TestCleanpgMain_LogJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Logged</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer logger.SetLogFile("")
+	defer logger.SetJSON(false)
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "run.log")
+	out := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "-g", logFile, "-o", out, ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Could not read log file: %v", err)
+	}
+	if expect := "INFO: fetched bytes=41 elapsed="; !strings.Contains(string(data), expect) ||
+		!strings.Contains(string(data), " status=200 url="+ts.URL+"\n") {
+		t.Errorf("Expected the fetch logged with fields, got %q", data)
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--log-json", "--force", "-g", logFile, "-o", out, ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err = ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Could not read log file: %v", err)
+	}
+	var fetched map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var message map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			t.Fatalf("Expected JSON lines, got %q", line)
+		}
+		if message["msg"] == "fetched" {
+			fetched = message
+		}
+	}
+	if fetched == nil || fetched["url"] != ts.URL || fetched["status"] != 200.0 || fetched["level"] != "info" {
+		t.Errorf("Expected the fetch logged with its fields, got %v in %q", fetched, data)
+	}
+}
+
+func TestCleanpgMain_Clobber(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Notes</title></head><body><h1>Fresh</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	const annotated = "<p>my annotations</p>"
+	tests := []struct {
+		flag   string
+		code   int
+		page   string // contents of page.html after the run
+		page2  bool   // page-2.html written
+		source string // contents of source.html after the run
+	}{
+		{"", 0, annotated, true, annotated},
+		{"--no-clobber", exitExists, annotated, false, annotated},
+		{"--force", 0, "Fresh", false, "Fresh"},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		page, source := filepath.Join(dir, "page.html"), filepath.Join(dir, "source.html")
+		ioutil.WriteFile(page, []byte(annotated), 0644)
+		ioutil.WriteFile(source, []byte(annotated), 0644)
+
+		args := []string{"cleanpg", "-o", page, "-s", source, ts.URL}
+		if tt.flag != "" {
+			args = append(args[:1], append([]string{tt.flag}, args[1:]...)...)
+		}
+		if code := cleanpgMain(args); code != tt.code {
+			t.Errorf("%q: expected exit code %d, got %d", tt.flag, tt.code, code)
+		}
+
+		for fileName, expect := range map[string]string{page: tt.page, source: tt.source} {
+			if data, _ := ioutil.ReadFile(fileName); !strings.Contains(string(data), expect) {
+				t.Errorf("%q: expected %q in %s, got %q", tt.flag, expect, filepath.Base(fileName), data)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "page-2.html")); (err == nil) != tt.page2 {
+			t.Errorf("%q: expected page-2.html written %v, got %v", tt.flag, tt.page2, err)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "source-2.html")); tt.page2 && !strings.Contains(string(data), "Fresh") {
+			t.Errorf("%q: expected the source saved to source-2.html, got %q %v", tt.flag, data, err)
+		}
+
+		// Batches follow the same policy
+		outputDir := filepath.Join(dir, "pages")
+		os.Mkdir(outputDir, 0755)
+		notes := filepath.Join(outputDir, "notes.html")
+		ioutil.WriteFile(notes, []byte(annotated), 0644)
+		args = []string{"cleanpg", "-O", outputDir}
+		if tt.flag != "" {
+			args = append(args, tt.flag)
+		}
+		args = append(args, ts.URL+"/a")
+		if code := cleanpgMain(args); code != tt.code {
+			t.Errorf("%q batch: expected exit code %d, got %d", tt.flag, tt.code, code)
+		}
+		if data, _ := ioutil.ReadFile(notes); !strings.Contains(string(data), tt.page) {
+			t.Errorf("%q batch: expected %q in notes.html, got %q", tt.flag, tt.page, data)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "notes-2.html")); (err == nil) != tt.page2 {
+			t.Errorf("%q batch: expected notes-2.html written %v, got %v", tt.flag, tt.page2, err)
+		}
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--force", "--no-clobber", ts.URL}); code != 1 {
+		t.Errorf("Expected exit code 1 for --force with --no-clobber, got %d", code)
+	}
+}
+
+func TestCleanpgMain_Save(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			w.Header().Set("Content-Type", "application/xhtml+xml")
+		} else {
+			w.Header().Set("Content-Type", "text/html")
+		}
+		w.Write([]byte("<html><body><h1>Source " + r.URL.Path + "</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	savedSources := func(dir, prefix string) []string {
+		files, _ := filepath.Glob(filepath.Join(dir, prefix+"*"))
+		var names []string
+		for _, f := range files {
+			names = append(names, filepath.Base(f))
+		}
+		return names
+	}
+	stamped := regexp.MustCompile(`^127\.0\.0\.1-docs-intro-\d{8}-\d{6}(-2)?\.html$`)
+
+	// --save alone names the source after the URL, next to the output;
+	// a second run in the same second doesn't replace the first
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.html")
+	for i := 0; i < 2; i++ {
+		if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "--save", ts.URL + "/docs/intro"}); code != 0 {
+			t.Fatalf("Expected exit code 0, got %d", code)
+		}
+	}
+	names := savedSources(dir, "127.0.0.1-docs-")
+	if len(names) != 2 {
+		t.Fatalf("Expected two saved sources, got %q", names)
+	}
+	for _, name := range names {
+		if !stamped.MatchString(name) {
+			t.Errorf("Expected a name from the URL and time, got %q", name)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, name)); !strings.Contains(string(data), "<h1>Source /docs/intro</h1>") {
+			t.Errorf("Expected the source in %s, got %q", name, data)
+		}
+	}
+
+	// A source which isn't HTML keeps its own extension
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "--force", "--save", "auto", ts.URL + "/feed"}); code != 0 {
+		t.Fatalf("Expected exit code 0 for the XHTML page, got %d", code)
+	}
+	if names := savedSources(dir, "127.0.0.1-feed-"); len(names) != 1 || filepath.Ext(names[0]) != ".xhtml" {
+		t.Errorf("Expected the source saved as .xhtml, got %q", names)
+	}
+
+	// --save - writes just the source to stdout
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "--force", "--save", "-", ts.URL + "/piped"}); code != 0 {
+		t.Fatalf("Expected exit code 0 with --save -, got %d", code)
+	}
+	if got := out.String(); got != "<html><body><h1>Source /piped</h1></body></html>" {
+		t.Errorf("Expected the source alone on stdout, got %q", got)
+	}
+	if data, _ := ioutil.ReadFile(outputFile); !strings.Contains(string(data), "Source /piped") {
+		t.Errorf("Expected the cleaned page in the output, got %q", data)
+	}
+
+	// A batch saves alongside the pages in the output directory
+	outputDir := filepath.Join(t.TempDir(), "pages")
+	if code := cleanpgMain([]string{"cleanpg", "-O", outputDir, "--save", "auto", ts.URL + "/a", ts.URL + "/b"}); code != 0 {
+		t.Fatalf("Expected exit code 0 for the batch, got %d", code)
+	}
+	if names := outputFiles(outputDir); len(names) != 4 {
+		t.Errorf("Expected two pages and two sources, got %q", names)
+	}
+	if code := cleanpgMain([]string{"cleanpg", "-O", outputDir, "--save", "-", ts.URL + "/a", ts.URL + "/b"}); code != exitUsage {
+		t.Errorf("Expected exit code %d for a batch with --save -, got %d", exitUsage, code)
+	}
+}
+
+func TestCleanpgMain_Links(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/links.html")
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	page := ts.URL + "/catalogue/list.html"
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.html")
+	tests := []struct {
+		format string
+		expect string
+	}{
+		{"url", ts.URL + "/seeds/tomatoes\n" + ts.URL + "/catalogue/beans.html\nhttps://herbs.example/basil\n" + ts.URL + "/about\n"},
+		{"tsv", ts.URL + "/seeds/tomatoes\ttomatoes\n" + ts.URL + "/catalogue/beans.html\tbeans\nhttps://herbs.example/basil\tbasil from our partner\n" + ts.URL + "/about\tAbout us\n"},
+	}
+	for _, tt := range tests {
+		linksFile := filepath.Join(dir, "links-"+tt.format+".txt")
+		if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "--force", "--links", linksFile, "--links-format", tt.format, page}); code != 0 {
+			t.Fatalf("%s: expected exit code 0, got %d", tt.format, code)
+		}
+		if data, _ := ioutil.ReadFile(linksFile); string(data) != tt.expect {
+			t.Errorf("%s: expected %q, got %q", tt.format, tt.expect, data)
+		}
+	}
+
+	// "-" writes the links alone to stdout, beside the output file
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "--force", "--links", "-", page}); code != 0 {
+		t.Fatalf("Expected exit code 0 with --links -, got %d", code)
+	}
+	if out.String() != tests[0].expect {
+		t.Errorf("Expected %q on stdout, got %q", tests[0].expect, out.String())
+	}
+	if data, _ := ioutil.ReadFile(outputFile); !strings.Contains(string(data), "Orders ship within a week") {
+		t.Errorf("Expected the cleaned page in the output, got %q", data)
+	}
+
+	for _, args := range [][]string{
+		{"--links-format", "csv", "--links", "-"},
+		{"--links", "-", "--save", "-"},
+		{"--links", "-", "-O", dir},
+	} {
+		args = append(append([]string{"cleanpg", "-o", outputFile, "--force"}, args...), page)
+		if code := cleanpgMain(args); code != exitUsage {
+			t.Errorf("Expected exit code %d for %q, got %d", exitUsage, args, code)
+		}
+	}
+}
+
+func TestCleanpgMain_Metadata(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture := "cleanhtml/testdata/opengraph.html"
+		if r.URL.Path == "/plain" {
+			fixture = "testdata/article.html"
+		}
+		http.ServeFile(w, r, filepath.Join(wd, fixture))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	// Only the fields the page gives are written; the words are
+	// those of the cleaned page, without the navigation
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"/garlic.html", `{
+  "source": "` + ts.URL + `/garlic.html",
+  "title": "Planting Garlic | The Allotment",
+  "description": "When and how to plant garlic for a summer harvest.",
+  "canonical": "` + ts.URL + `/garlic",
+  "og": {
+    "description": "Autumn is the time to plant garlic.",
+    "image": "https://allotment.example/images/garlic.jpg",
+    "site_name": "The Allotment",
+    "title": "Planting Garlic",
+    "type": "article",
+    "url": "https://allotment.example/garlic"
+  },
+  "author": "Ada Fenwick",
+  "published": "2020-10-01T08:00:00Z",
+  "word_count": 14,
+  "reading_minutes": 1
+}
+`},
+		{"/plain", `{
+  "source": "` + ts.URL + `/plain",
+  "title": "Pruning Roses",
+  "word_count": 15,
+  "reading_minutes": 1
+}
+`},
+	}
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	// The output would be out.html in the working directory
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tt := range tests {
+		out.Reset()
+		if code := cleanpgMain([]string{"cleanpg", "--metadata", ts.URL + tt.path}); code != 0 {
+			t.Fatalf("%s: expected exit code 0, got %d", tt.path, code)
+		}
+		if out.String() != tt.expect {
+			t.Errorf("%s: expected %s, got %s", tt.path, tt.expect, out.String())
+		}
+	}
+	// In place of the output
+	if names := outputFiles(dir); len(names) != 0 {
+		t.Errorf("Expected no output with --metadata alone, got %q", names)
+	}
+
+	// With --output, a sidecar file holds the metadata
+	outputFile, metadataFile := filepath.Join(dir, "garlic.html"), filepath.Join(dir, "garlic.json")
+	out.Reset()
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "--metadata-file", metadataFile, ts.URL + "/garlic.html"}); code != 0 {
+		t.Fatalf("Expected exit code 0 with --metadata-file, got %d", code)
+	}
+	if data, _ := ioutil.ReadFile(metadataFile); string(data) != tests[0].expect {
+		t.Errorf("Expected %s in %s, got %s", tests[0].expect, metadataFile, data)
+	}
+	if data, _ := ioutil.ReadFile(outputFile); !strings.Contains(string(data), "Plant the cloves") {
+		t.Errorf("Expected the cleaned page in the output, got %q", data)
+	}
+	if strings.Contains(out.String(), "{") {
+		t.Errorf("Expected no metadata on stdout, got %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"--metadata-file", filepath.Join(dir, "meta.txt")},
+		{"--metadata", "--open"},
+		{"--metadata", "--save", "-"},
+		{"--metadata", "-O", dir},
+	} {
+		args = append(append([]string{"cleanpg"}, args...), ts.URL+"/garlic.html")
+		if code := cleanpgMain(args); code != exitUsage {
+			t.Errorf("Expected exit code %d for %q, got %d", exitUsage, args, code)
+		}
+	}
+}
+
+func TestCleanpgMain_CSS(t *testing.T) {
+	var fetches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Styled</title></head><body><h1>Styled</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetStyleRender(true)
+	defer cleanhtml.SetStylesheet("")
+	defer cleanhtml.SetStylesheetURL("")
+
+	dir := t.TempDir()
+	cssFile := filepath.Join(dir, "reader.css")
+	if err := ioutil.WriteFile(cssFile, []byte("body { max-width: 40em; }\n"), 0644); err != nil {
+		t.Fatalf("Could not write stylesheet: %v", err)
+	}
+
+	tests := []struct {
+		args   []string
+		expect []string
+		reject []string
+	}{
+		// A local file is embedded in place of the tag-level styles
+		{[]string{"--css", cssFile}, []string{"<style>\nbody { max-width: 40em; }\n</style>"}, []string{"style=", "<link"}},
+		// A URL is linked
+		{[]string{"-D", "https://example.com/reader.css"}, []string{`<link rel="stylesheet" href="https://example.com/reader.css"/>`}, []string{"style=", "<style>"}},
+		// The tag-level styles can be kept as well
+		{[]string{"--css", cssFile, "--keep-default-style"}, []string{"<style>", `<h1 style="`}, nil},
+	}
+
+	for i, tt := range tests {
+		cleanhtml.SetStyleRender(true)
+		cleanhtml.SetStylesheet("")
+		cleanhtml.SetStylesheetURL("")
+
+		outputFile := filepath.Join(dir, fmt.Sprintf("out%d.html", i))
+		args := append(append([]string{"cleanpg", "-o", outputFile}, tt.args...), ts.URL)
+		if code := cleanpgMain(args); code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d", tt.args, code)
+		}
+		data, _ := ioutil.ReadFile(outputFile)
+		for _, e := range tt.expect {
+			if !strings.Contains(string(data), e) {
+				t.Errorf("%q: expected %q in the output, got %q", tt.args, e, data)
+			}
+		}
+		for _, r := range tt.reject {
+			if strings.Contains(string(data), r) {
+				t.Errorf("%q: expected no %q in the output, got %q", tt.args, r, data)
+			}
+		}
+	}
+
+	// A missing stylesheet fails before anything is fetched
+	fetches = 0
+	if code := cleanpgMain([]string{"cleanpg", "-o", filepath.Join(dir, "missing.html"), "--css", filepath.Join(dir, "missing.css"), ts.URL}); code != 1 {
+		t.Errorf("Expected exit code 1 for a missing stylesheet, got %d", code)
+	}
+	if fetches != 0 {
+		t.Errorf("Expected no fetch, got %d", fetches)
+	}
+}
+
+func TestCleanpgMain_Exclude(t *testing.T) {
+	var fetches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		http.ServeFile(w, r, "testdata/discussion.html")
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetExclude()
+
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	args := []string{"cleanpg", "-o", outputFile, "--exclude", ".comments,#sidebar", "-X", "nav, aside.newsletter", ts.URL}
+	if code := cleanpgMain(args); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	for _, e := range []string{"long slant", "grafting wax"} {
+		if !strings.Contains(string(data), e) {
+			t.Errorf("Expected %q in the output, got %q", e, data)
+		}
+	}
+	for _, r := range []string{"Popular posts", "Subscribe", "Comments", "Great advice", "Next post"} {
+		if strings.Contains(string(data), r) {
+			t.Errorf("Expected %q excluded, got %q", r, data)
+		}
+	}
+
+	// An invalid selector fails before anything is fetched
+	cleanhtml.SetExclude()
+	fetches = 0
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, "-X", "nav, a:hover", ts.URL}); code != 1 {
+		t.Errorf("Expected exit code 1 for an invalid selector, got %d", code)
+	}
+	if fetches != 0 {
+		t.Errorf("Expected no fetch, got %d", fetches)
+	}
+}
+
+func TestCleanpgMain_DenySubtrees(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.DenySubtree()
+
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`<html><body><h1>Pruning</h1><p>Cut above the bud.</p>`+
+		`<form role="search"><label><p>Search the site</p></label><input placeholder="Search articles">`+
+		`<button><b>Search now</b></button></form></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "-o", outputFile, page}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	if !strings.Contains(string(data), "Cut above the bud.") || strings.Contains(string(data), "Search") {
+		t.Errorf("Expected the article without its search form, got %q", data)
+	}
+}
+
+func TestCleanpgMain_FormsAsText(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.DenySubtree()
+	defer cleanhtml.SetFormsAsText(false)
+
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`<html><body><h1>Newsletter</h1><form><label>Email</label>`+
+		`<input placeholder="you@example.com"><label><input type="checkbox" checked> Weekly</label>`+
+		`<button>Subscribe</button></form><dialog><p>Cookie banner</p></dialog></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "--forms-as-text", "-o", outputFile, page}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	for _, expect := range []string{"<label>Email</label> [you@example.com]", "[x] Weekly", ">Subscribe</span>"} {
+		if !strings.Contains(string(data), expect) {
+			t.Errorf("Expected %q, got %q", expect, data)
+		}
+	}
+	if strings.Contains(string(data), "Cookie banner") {
+		t.Errorf("Expected dialogs still denied, got %q", data)
+	}
+}
+
+func TestCleanpgMain_Footnotes(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetFootnotes(false)
+
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`<html><body><h1>Pruning</h1>`+
+		`<p>Cut above the bud.<sup><a href="#note-9">[9]</a></sup></p>`+
+		`<ol class="notes"><li id="note-9">Royal Horticultural Society, 2019.</li></ol></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(dir, "out.html")
+	for _, test := range []struct {
+		args   []string
+		expect string
+	}{
+		{nil, `bud.<sup id="fnref-1"><a href="#fn-1">[1]</a></sup>`},
+		{[]string{"--nofootnotes"}, `<a href="#note-9">[9]</a>`},
+	} {
+		args := append([]string{"cleanpg", "--force", "-o", outputFile}, test.args...)
+		if code := cleanpgMain(append(args, page)); code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d", test.args, code)
+		}
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Could not read output: %v", err)
+		}
+		if !strings.Contains(string(data), test.expect) {
+			t.Errorf("%q: expected %q, got %q", test.args, test.expect, data)
+		}
+	}
+}
+
+func TestCleanpgMain_Gallery(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetImageRender(false)
+	defer cleanhtml.SetGallery(false, 0)
+
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`<html><body><h1>Harvest</h1>`+
+		`<figure><img src="https://example.org/a.jpg"><figcaption>Picking</figcaption></figure><p>Into the barn.</p>`+
+		`<figure><img src="https://example.org/b.jpg" alt="Storing"></figure></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "--images", "--gallery", "--gallery-max", "1", "-o", outputFile, page}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	gallery := strings.Index(string(data), ">Gallery</h2>")
+	if gallery < strings.Index(string(data), "Into the barn.") || !strings.Contains(string(data), "<figcaption>Picking</figcaption>") ||
+		strings.Contains(string(data), "b.jpg") {
+		t.Errorf("Expected the first image in a gallery after the text, got %q", data)
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--gallery", "--gallery-max", "-1", "-o", outputFile, page}); code != exitUsage {
+		t.Errorf("Expected exit code %d for a negative maximum, got %d", exitUsage, code)
+	}
+}
+
+func TestCleanpgMain_DetectLang(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetDetectLanguage(false)
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "--detect-lang", "-o", outputFile, "cleanhtml/testdata/lang-de.html"}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	if !strings.Contains(string(data), ` lang="de">`) {
+		t.Errorf("Expected the page in German, got %q", data)
+	}
+}
+
+func TestCleanpgMain_TitleHeading(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetTitleHeading(false)
+	defer cleanhtml.SetTrimTitle(false)
+
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`<html><head><title>Storm closes coastal roads | Example News</title></head>`+
+		`<body><h1>Storm closes coastal roads</h1><p>High tides flooded the road.</p></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		args   []string
+		output string
+		expect string
+	}{
+		{[]string{"--title-heading", "-f", "markdown"}, "out.md", "# Storm closes coastal roads\n\nHigh tides"},
+		{[]string{"--trim-title", "-f", "json"}, "out.json", `"title": "Storm closes coastal roads",`},
+	} {
+		outputFile := filepath.Join(dir, test.output)
+		args := append(append([]string{"cleanpg"}, test.args...), "-o", outputFile, page)
+		if code := cleanpgMain(args); code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d", test.args, code)
+		}
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Could not read output: %v", err)
+		}
+		if !strings.Contains(string(data), test.expect) || strings.Contains(string(data), "Example News") {
+			t.Errorf("%q: expected %q without the site name, got %q", test.args, test.expect, data)
+		}
+	}
+}
+
+func TestCleanpgMain_WrapColumn(t *testing.T) {
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetWrapColumn(0)
+
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`<html><body><h1>Pruning</h1><p>`+
+		strings.Repeat("Cut back to an outward-facing bud. ", 30)+`</p></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(dir, "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "--wrap-column", "72", "-o", outputFile, page}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(line) > 72 && !strings.HasPrefix(line, "<") {
+			t.Errorf("Expected lines of at most 72 characters, got %q", line)
+		}
+	}
+	if !strings.Contains(string(data), "outward-facing\nbud.") && !strings.Contains(string(data), "outward-facing bud.\n") {
+		t.Errorf("Expected the paragraph wrapped at word boundaries, got %q", data)
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--wrap-column", "-1", page}); code != exitUsage {
+		t.Errorf("Expected exit code %d for a negative column, got %d", exitUsage, code)
+	}
+}
+
+func TestCleanpgMain_Include(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/discussion.html")
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetInclude("", false)
+	defer cleanhtml.SetIncludeFallback(false)
+
+	tests := []struct {
+		args   []string
+		code   int
+		expect []string // "" = no output written
+		reject []string
+	}{
+		{[]string{"--include", "section.comments"}, 0, []string{"<title>Grafting Apples</title>", "Great advice"}, []string{"long slant", "Popular posts"}},
+		{[]string{"-M", "p", "--include-all"}, 0, []string{"Popular posts", "long slant", "grafting wax", "Great advice"}, []string{"<h1"}},
+		{[]string{"--include", "#missing", "--include-fallback", "full"}, 0, []string{"long slant", "Great advice"}, nil},
+		{[]string{"--include", "#missing"}, exitClean, []string{""}, nil},
+		{[]string{"--include", "div[id"}, 1, []string{""}, nil},
+	}
+
+	for i, tt := range tests {
+		cleanhtml.SetInclude("", false)
+		cleanhtml.SetIncludeFallback(false)
+
+		outputFile := filepath.Join(t.TempDir(), fmt.Sprintf("out%d.html", i))
+		args := append(append([]string{"cleanpg", "-o", outputFile}, tt.args...), ts.URL)
+		if code := cleanpgMain(args); code != tt.code {
+			t.Errorf("%q: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		data, err := ioutil.ReadFile(outputFile)
+		if tt.expect[0] == "" {
+			if err == nil {
+				t.Errorf("%q: expected no output, got %q", tt.args, data)
+			}
+			continue
+		}
+		for _, e := range tt.expect {
+			if !strings.Contains(string(data), e) {
+				t.Errorf("%q: expected %q in the output, got %q", tt.args, e, data)
+			}
+		}
+		for _, r := range tt.reject {
+			if strings.Contains(string(data), r) {
+				t.Errorf("%q: expected no %q in the output, got %q", tt.args, r, data)
+			}
+		}
+	}
+}
+
+func TestCleanpgMain_UserStdin(t *testing.T) {
+	defer cleanhtml.SetBasicAuth("", "")
+	defer func() { stdin = os.Stdin }()
+
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"--user", "bob", "-"},
+		{"--user", "bob", "--input-file", "-", "-O", dir},
+	} {
+		stdin = strings.NewReader("hunter2\n<html><body><h1>Piped</h1><p>page</p></body></html>")
+		outputFile := filepath.Join(dir, "out.html")
+		if code := cleanpgMain(append([]string{"cleanpg", "-o", outputFile}, args...)); code != exitUsage {
+			t.Errorf("%q: expected exit code %d, got %d", args, exitUsage, code)
+		}
+		if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+			t.Errorf("%q: expected no output, got %v", args, err)
+		}
+	}
+}
+
+func TestCleanpgMain_ImagesOtherHost(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	seen := make(map[string]http.Header)
+	var mu sync.Mutex
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		w.Write(png)
+	}))
+	defer images.Close()
+	// The same server by another name is another host
+	otherHost := strings.Replace(images.URL, "127.0.0.1", "localhost", 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/own.png" {
+			mu.Lock()
+			seen[r.URL.Path] = r.Header.Clone()
+			mu.Unlock()
+			w.Write(png)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><h1>Roses</h1><p><img src="/own.png" alt="Own"></p>` +
+			`<p><img src="` + otherHost + `/other.png" alt="Other"></p></body></html>`))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetImageRender(false)
+	defer cleanhtml.SetImageSource(nil)
+	defer cleanhtml.SetBasicAuth("", "")
+	defer cleanhtml.SetHeaders(nil)
+	defer cleanhtml.SetCookies(nil)
+	stdin = strings.NewReader("hunter2\n")
+	defer func() { stdin = os.Stdin }()
+
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	args := []string{"cleanpg", "-o", outputFile, "--images=inline", "--user", "bob",
+		"-H", "X-Token: abc", "-b", "session=s3cret", ts.URL}
+	if code := cleanpgMain(args); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	own, other := seen["/own.png"], seen["/other.png"]
+	if own == nil || other == nil {
+		t.Fatalf("Expected both images fetched, got %v", seen)
+	}
+	if own.Get("Authorization") == "" || own.Get("X-Token") != "abc" || own.Get("Cookie") == "" {
+		t.Errorf("Expected the page's host sent the credentials, got %v", own)
+	}
+	for _, header := range []string{"Authorization", "Cookie", "X-Token"} {
+		if other.Get(header) != "" {
+			t.Errorf("Expected no %s sent to another host, got %q", header, other.Get(header))
+		}
+	}
+}
+
+func TestCleanpgMain_Images(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	gif := append([]byte("GIF89a"), make([]byte, 2000)...)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/img/rose.png":
+			w.Write(png)
+		case "/img/seedling":
+			w.Header().Set("Content-Type", "image/gif")
+			w.Write(gif)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><h1>Roses</h1>` +
+				`<p><img src="img/rose.png" alt="Rose"></p>` +
+				`<p><img src="/img/seedling" alt="Seedling"></p></body></html>`))
+		}
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+	defer cleanhtml.SetImageRender(false)
+	defer cleanhtml.SetImageSource(nil)
+	defer cleanhtml.SetMaxBodyBytes(cleanhtml.DefaultMaxBodyBytes)
+
+	dataURI := func(mt string, data []byte) string {
+		return "data:" + mt + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	tests := []struct {
+		args   []string
+		expect []string // srcs in the output
+		files  map[string][]byte
+	}{
+		{nil, nil, nil},
+		{[]string{"--images"}, []string{ts.URL + "/img/rose.png", ts.URL + "/img/seedling"}, nil},
+		{[]string{"--images=download"}, []string{"out_files/rose.png", "out_files/seedling.gif"},
+			map[string][]byte{"out_files/rose.png": png, "out_files/seedling.gif": gif}},
+		{[]string{"-Z=download", "--asset-dir", "media/roses"}, []string{"media/roses/rose.png", "media/roses/seedling.gif"},
+			map[string][]byte{"media/roses/rose.png": png}},
+		{[]string{"--images=inline"}, []string{dataURI("image/png", png), dataURI("image/gif", gif)}, nil},
+		// An image over --max-size is left where it is
+		{[]string{"--images=download", "-m", "1000"}, []string{"out_files/rose.png", ts.URL + "/img/seedling"},
+			map[string][]byte{"out_files/rose.png": png}},
+	}
+
+	for _, tt := range tests {
+		cleanhtml.SetImageRender(false)
+		cleanhtml.SetImageSource(nil)
+		cleanhtml.SetMaxBodyBytes(cleanhtml.DefaultMaxBodyBytes)
+
+		dir := t.TempDir()
+		outputFile := filepath.Join(dir, "out.html")
+		for i, arg := range tt.args {
+			if arg == "media/roses" {
+				tt.args[i] = filepath.Join(dir, arg)
+			}
+		}
+		args := append(append([]string{"cleanpg", "-o", outputFile}, tt.args...), ts.URL)
+		if code := cleanpgMain(args); code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d", tt.args, code)
+		}
+
+		data, err := ioutil.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("%q: could not read output: %v", tt.args, err)
+		}
+		if tt.expect == nil && strings.Contains(string(data), "<img") {
+			t.Errorf("Expected no images by default, got %q", data)
+		}
+		for _, src := range tt.expect {
+			if !strings.Contains(string(data), `src="`+src+`"`) {
+				t.Errorf("%q: expected src %q in the output, got %q", tt.args, src, data)
+			}
+		}
+		for name, expect := range tt.files {
+			if got, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(got, expect) {
+				t.Errorf("%q: expected %s saved, got %q %v", tt.args, name, got, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// copier puts text on the user's clipboard
+type copier interface {
+	copy(text string) error
+}
+
+// clipboard copies the output for --clipboard
+var clipboard copier = execCopier{goos: runtime.GOOS, lookPath: exec.LookPath}
+
+// execCopier runs the first of the platform's clipboard
+// utilities found on the PATH, writing the text to its stdin
+type execCopier struct {
+	goos     string                            // as runtime.GOOS
+	lookPath func(file string) (string, error) // as exec.LookPath
+}
+
+// clipboardUtility is a command copying its stdin to the
+// clipboard, and what to install for it
+type clipboardUtility struct {
+	command []string
+	install string
+}
+
+// utilities returns the clipboard utilities to try, in order
+func (c execCopier) utilities() []clipboardUtility {
+	switch c.goos {
+	case "darwin":
+		return []clipboardUtility{{[]string{"pbcopy"}, "pbcopy"}}
+	case "windows":
+		return []clipboardUtility{{[]string{"clip.exe"}, "clip.exe"}}
+	}
+	// Wayland, then X, then Windows' own under WSL
+	return []clipboardUtility{
+		{[]string{"wl-copy"}, "wl-clipboard"},
+		{[]string{"xclip", "-selection", "clipboard"}, "xclip"},
+		{[]string{"clip.exe"}, "clip.exe"},
+	}
+}
+
+// copy implements the copier interface
+func (c execCopier) copy(text string) error {
+	var install []string
+	for _, u := range c.utilities() {
+		path, err := c.lookPath(u.command[0])
+		if err != nil {
+			install = append(install, u.install)
+			continue
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(path, u.command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("%s failed: %s: %s", u.command[0], err, msg)
+			}
+			return fmt.Errorf("%s failed: %s", u.command[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard utility found on the PATH: install %s", strings.Join(install, " or "))
+}
+
+// clipboardText returns a cleaned document as it's copied in
+// the format: as read, without the summary comment
+func clipboardText(cleanData string, f outputFormat) (string, error) {
+	switch f.name {
+	case "markdown":
+		return cleanhtml.RenderMarkdown(cleanData)
+	case "text":
+		return cleanhtml.RenderText(cleanData, f.textWidth)
+	}
+	return cleanData, nil
+}
+
+// copyOutput copies a cleaned document in the format to the
+// clipboard, returning the exit code
+func copyOutput(cleanData string, f outputFormat) int {
+	text, err := clipboardText(cleanData, f)
+	if err != nil {
+		logger.Write(logger.FATAL, "could not render for the clipboard: %s", err)
+		return exitClean
+	}
+	if err := clipboard.copy(text); err != nil {
+		logger.Write(logger.FATAL, "could not copy the output: %s", err)
+		return exitOutput
+	}
+	logger.Write(logger.INFO, "copied %d bytes to the clipboard", len(text))
+	return 0
+}
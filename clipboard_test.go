@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// fakeClipboard writes a script standing in for command in dir,
+// which records its arguments and stdin there, and returns a
+// copier finding only it
+func fakeClipboard(t *testing.T, dir, command, script string) execCopier {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake clipboard command is a shell script")
+	}
+	path := filepath.Join(dir, command)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Could not write %s: %v", command, err)
+	}
+	return execCopier{goos: "linux", lookPath: func(file string) (string, error) {
+		if file == command {
+			return path, nil
+		}
+		return "", exec.ErrNotFound
+	}}
+}
+
+// recordStdin is a fake clipboard script recording to dir
+func recordStdin(dir string) string {
+	return `echo "$@" > ` + filepath.Join(dir, "args") + `; cat > ` + filepath.Join(dir, "stdin")
+}
+
+func TestExecCopier(t *testing.T) {
+	dir := t.TempDir()
+	c := fakeClipboard(t, dir, "xclip", recordStdin(dir))
+	if err := c.copy("Quoted text\n"); err != nil {
+		t.Fatalf("Could not copy: %v", err)
+	}
+	for name, expect := range map[string]string{"args": "-selection clipboard\n", "stdin": "Quoted text\n"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != expect {
+			t.Errorf("Expected %s %q, got %q, %v", name, expect, data, err)
+		}
+	}
+
+	// A failure names the utility and says why
+	c = fakeClipboard(t, dir, "wl-copy", "echo 'no display' >&2; exit 1")
+	if err := c.copy("Lost"); err == nil || !strings.Contains(err.Error(), "wl-copy failed") || !strings.Contains(err.Error(), "no display") {
+		t.Errorf("Expected wl-copy's error, got %v", err)
+	}
+
+	// Without a utility, the error says what to install
+	c.lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	for goos, install := range map[string]string{"linux": "install wl-clipboard or xclip or clip.exe", "darwin": "install pbcopy"} {
+		c.goos = goos
+		if err := c.copy("Lost"); err == nil || !strings.HasSuffix(err.Error(), install) {
+			t.Errorf("%s: expected %q, got %v", goos, install, err)
+		}
+	}
+}
+
+func TestCleanpgMain_Clipboard(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(wd, "testdata/article.html"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+	defer func(c copier) { clipboard = c }(clipboard)
+	clipboard = fakeClipboard(t, dir, "xclip", recordStdin(dir))
+	copied := func() string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "stdin"))
+		os.Remove(filepath.Join(dir, "stdin"))
+		return string(data)
+	}
+
+	// The text is copied in place of the output
+	if code := cleanpgMain([]string{"cleanpg", "--quiet", "--clipboard", "-f", "text", ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if text := copied(); !strings.HasPrefix(text, "Pruning Roses") || strings.Contains(text, "cleanpg source") {
+		t.Errorf("Expected the text copied, got %q", text)
+	}
+	if _, err := os.Stat("out.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file without --output, got %v", err)
+	}
+
+	// With --output, it's both written and copied
+	if code := cleanpgMain([]string{"cleanpg", "--quiet", "--clipboard", "-f", "markdown", "-o", "page.md", ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if text := copied(); !strings.HasPrefix(text, "# Pruning Roses") {
+		t.Errorf("Expected the Markdown copied, got %q", text)
+	}
+	if data, err := ioutil.ReadFile("page.md"); err != nil || !strings.Contains(string(data), "cleanpg source") {
+		t.Errorf("Expected page.md written with its summary, got %q, %v", data, err)
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--quiet", "--clipboard", "-f", "json", ts.URL}); code != exitUsage {
+		t.Errorf("Expected exit code %d for --format json, got %d", exitUsage, code)
+	}
+	clipboard = execCopier{goos: "linux", lookPath: func(string) (string, error) { return "", errors.New("not found") }}
+	if code := cleanpgMain([]string{"cleanpg", "--quiet", "--clipboard", ts.URL}); code != exitOutput {
+		t.Errorf("Expected exit code %d without a clipboard utility, got %d", exitOutput, code)
+	}
+}
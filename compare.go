@@ -0,0 +1,61 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// parseCompareIgnore returns the differences --compare leaves
+// out, given as a "|"-separated list of headings and links
+func parseCompareIgnore(spec string) (cleanhtml.DiffOptions, error) {
+	var opts cleanhtml.DiffOptions
+	if spec == "" {
+		return opts, nil
+	}
+	for _, name := range strings.Split(spec, "|") {
+		switch strings.TrimSpace(name) {
+		case "headings":
+			opts.IgnoreHeadings = true
+		case "links":
+			opts.IgnoreLinks = true
+		default:
+			return opts, fmt.Errorf("invalid --compare-ignore [%s]: expected headings, links or \"headings|links\"", spec)
+		}
+	}
+	return opts, nil
+}
+
+// writeComparison writes to w a summary of the sections changed
+// in cleanData since the earlier output old, read from fileName,
+// and reports whether there were any
+func writeComparison(w io.Writer, fileName string, old []byte, cleanData string, opts cleanhtml.DiffOptions) (bool, error) {
+	sections, err := cleanhtml.DiffSections(string(old), cleanData, opts)
+	if err != nil {
+		return false, err
+	}
+	if len(sections) == 0 {
+		fmt.Fprintf(w, "No changes since %q\n", fileName)
+		return false, nil
+	}
+
+	var changes []cleanhtml.Change
+	for _, s := range sections {
+		changes = append(changes, s.Changes...)
+	}
+	fmt.Fprintf(w, "Changed since %q: %s\n", fileName, cleanhtml.DiffSummary(changes))
+	for _, s := range sections {
+		heading := s.Heading
+		if heading == "" {
+			heading = "(before the first heading)"
+		}
+		fmt.Fprintf(w, "  %-7s %s (%s)\n", s.Kind, heading, cleanhtml.DiffSummary(s.Changes))
+	}
+	return true, nil
+}
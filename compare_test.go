@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+func TestParseCompareIgnore(t *testing.T) {
+	tests := []struct {
+		spec   string
+		expect cleanhtml.DiffOptions
+		valid  bool
+	}{
+		{"", cleanhtml.DiffOptions{}, true},
+		{"links", cleanhtml.DiffOptions{IgnoreLinks: true}, true},
+		{"headings|links", cleanhtml.DiffOptions{IgnoreHeadings: true, IgnoreLinks: true}, true},
+		{"headings|images", cleanhtml.DiffOptions{}, false},
+	}
+	for _, tt := range tests {
+		opts, err := parseCompareIgnore(tt.spec)
+		if (err == nil) != tt.valid || (tt.valid && opts != tt.expect) {
+			t.Errorf("%q: expected %+v (valid %v), got %+v, %v", tt.spec, tt.expect, tt.valid, opts, err)
+		}
+	}
+}
+
+func TestCleanpgMain_Compare(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(wd, "testdata", strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	// The snapshot to compare with
+	if code := cleanpgMain([]string{"cleanpg", "-o", "old.html", ts.URL + "/compost-v1.html"}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	tests := []struct {
+		page   string
+		ignore string
+		code   int
+		expect string
+	}{
+		{"compost-v1.html", "", 0, "No changes since \"old.html\"\n"},
+		{"compost-v2.html", "", exitChanged, `Changed since "old.html": 5 blocks added, 5 removed
+  changed What to add (1 block added, 1 removed)
+  removed Turning (2 blocks removed)
+  changed Using it (2 blocks added, 2 removed)
+  added   Problems (2 blocks added)
+`},
+		{"compost-v2.html", "headings|links", exitChanged, `Changed since "old.html": 2 blocks added, 2 removed
+  changed Turning (1 block removed)
+  changed Using it (1 block added, 1 removed)
+  changed Problems (1 block added)
+`},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		args := []string{"cleanpg", "--compare", "old.html"}
+		if tt.ignore != "" {
+			args = append(args, "--compare-ignore", tt.ignore)
+		}
+		if code := cleanpgMain(append(args, ts.URL+"/"+tt.page)); code != tt.code {
+			t.Errorf("%s %q: expected exit code %d, got %d", tt.page, tt.ignore, tt.code, code)
+		}
+		if out.String() != tt.expect {
+			t.Errorf("%s %q: expected %q, got %q", tt.page, tt.ignore, tt.expect, out.String())
+		}
+	}
+
+	// Nothing but the snapshot is written without --output
+	if files, _ := filepath.Glob("*.html"); len(files) != 1 {
+		t.Errorf("Expected only old.html, found %v", files)
+	}
+
+	for _, args := range [][]string{
+		{"--compare", "missing.html"},
+		{"--compare", "old.txt"},
+		{"--compare", "old.html", "--compare-ignore", "images"},
+		{"--compare", "old.html", "--metadata"},
+		{"--compare", "old.html", "--open"},
+		{"--compare", "old.html", "--update", "-o", "new.html"},
+	} {
+		args = append(append([]string{"cleanpg"}, args...), ts.URL+"/compost-v2.html")
+		if code := cleanpgMain(args); code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
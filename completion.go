@@ -0,0 +1,322 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scu/cleanpg/logger"
+	"github.com/scu/flagplus"
+)
+
+// completionFlag is a flag as shell completion offers it
+type completionFlag struct {
+	long, short string
+	usage       string   // without the backquotes around the value's name
+	value       string   // name of the value it takes, "" for none
+	values      []string // the values it takes, when they are enumerated
+	repeatable  bool
+}
+
+// Kinds of value a flag takes, as completed
+const (
+	completeNothing = iota // no completion, e.g. a duration
+	completeValues         // one of the enumerated values
+	completeFiles
+	completeDirs
+)
+
+// completes returns the kind of value completed for the flag
+func (f completionFlag) completes() int {
+	switch {
+	case len(f.values) > 0:
+		return completeValues
+	case f.value == "directory":
+		return completeDirs
+	case strings.HasPrefix(f.value, "file") || f.value == "path":
+		return completeFiles
+	}
+	return completeNothing
+}
+
+// names returns the flag's short and long names, with their dashes
+func (f completionFlag) names() []string {
+	return []string{"-" + f.short, "--" + f.long}
+}
+
+// completionValues returns the values completed for each flag
+// which takes one of a fixed set
+func completionValues() map[string][]string {
+	values := map[string][]string{
+		"include-fallback": {"none", "full"},
+		"links-format":     {"url", "tsv"},
+		"compare-ignore":   {"headings", "links"},
+	}
+	for name := range formatExts {
+		values["format"] = append(values["format"], name)
+	}
+	for mode := range imageModes {
+		values["images"] = append(values["images"], mode)
+	}
+	for heading := range postHeadings {
+		values["post-heading"] = append(values["post-heading"], heading)
+	}
+	for level := logger.INFO; level <= logger.FATAL; level++ {
+		values["log-level"] = append(values["log-level"], level.String())
+	}
+
+	for _, v := range values {
+		sort.Strings(v)
+	}
+	return values
+}
+
+// flagDump matches a flag in the String form of a flagplus.FlagSet
+var flagDump = regexp.MustCompile(`flag\[("(?:[^"\\]|\\.)*")\]: TYPE=(\w+) shortName=("(?:[^"\\]|\\.)*") usage=("(?:[^"\\]|\\.)*")`)
+
+// completionFlags returns the flags defined in fs, sorted by long name.
+// flagplus has no way to list them but its String form, so they are
+// read from that.
+func completionFlags(fs *flagplus.FlagSet) []completionFlag {
+	values := completionValues()
+
+	var flags []completionFlag
+	for _, m := range flagDump.FindAllStringSubmatch(fs.String(), -1) {
+		var quoted [3]string
+		for i, q := range []string{m[1], m[3], m[4]} {
+			s, err := strconv.Unquote(q)
+			if err != nil {
+				panic(err)
+			}
+			quoted[i] = s
+		}
+
+		f := completionFlag{
+			long:       quoted[0],
+			short:      quoted[1],
+			values:     values[quoted[0]],
+			repeatable: repeatableFlags[quoted[0]] != nil || quoted[0] == "config",
+		}
+		f.value, f.usage = unquoteValueName(quoted[2])
+		switch m[2] {
+		case "BASE", "BOOL":
+			f.value = ""
+		default:
+			if f.value == "" {
+				f.value = strings.ToLower(m[2])
+			}
+		}
+		flags = append(flags, f)
+	}
+
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].long < flags[j].long
+	})
+	return flags
+}
+
+// unquoteValueName returns the name given in backquotes in usage
+// for the value of the flag, and usage without the backquotes
+func unquoteValueName(usage string) (name, unquoted string) {
+	start := strings.IndexByte(usage, '`')
+	if start < 0 {
+		return "", usage
+	}
+	end := strings.IndexByte(usage[start+1:], '`')
+	if end < 0 {
+		return "", usage
+	}
+	name = usage[start+1 : start+1+end]
+	return name, usage[:start] + name + usage[start+1+end+1:]
+}
+
+// completion writes the completion script for shell to stdout,
+// returning the exit code
+func completion(shell string) int {
+	switch shell {
+	case "bash", "zsh", "fish":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell [%s] for --completion: expected bash, zsh or fish\n", shell)
+		return exitUsage
+	}
+
+	if err := writeCompletion(stdout, shell, completionFlags(newFlagSet())); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write the completion script: %s\n", err)
+		return exitOutput
+	}
+	return 0
+}
+
+// writeCompletion writes a script for shell, one of bash, zsh or
+// fish, completing flags and then the source: an HTML file, or "-"
+// for stdin
+func writeCompletion(w io.Writer, shell string, flags []completionFlag) error {
+	var b strings.Builder
+	switch shell {
+	case "bash":
+		bashCompletion(&b, flags)
+	case "zsh":
+		zshCompletion(&b, flags)
+	case "fish":
+		fishCompletion(&b, flags)
+	default:
+		return fmt.Errorf("unknown shell [%s]", shell)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// bashCompletion writes the bash completion script to b
+func bashCompletion(b *strings.Builder, flags []completionFlag) {
+	b.WriteString(`# bash completion for cleanpg; source it, or install it with
+#   cleanpg --completion bash > /etc/bash_completion.d/cleanpg
+_cleanpg() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	# --flag=value is split into three words
+	if [[ $cur == = ]]; then
+		cur=
+	elif [[ $prev == = ]]; then
+		prev="${COMP_WORDS[COMP_CWORD-2]}"
+	fi
+
+	case "$prev" in
+`)
+
+	// Flags completing files or directories, or nothing, share a branch
+	groups := map[int][]string{}
+	for _, f := range flags {
+		switch f.completes() {
+		case completeValues:
+			fmt.Fprintf(b, "\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn ;;\n",
+				strings.Join(f.names(), "|"), strings.Join(f.values, " "))
+		default:
+			if f.value != "" {
+				groups[f.completes()] = append(groups[f.completes()], f.names()...)
+			}
+		}
+	}
+	for _, group := range []struct {
+		kind   int
+		action string
+	}{
+		{completeFiles, "\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n"},
+		{completeDirs, "\t\tCOMPREPLY=($(compgen -d -- \"$cur\"))\n"},
+		{completeNothing, ""},
+	} {
+		if names := groups[group.kind]; len(names) > 0 {
+			fmt.Fprintf(b, "\t%s)\n%s\t\treturn ;;\n", strings.Join(names, "|"), group.action)
+		}
+	}
+
+	var names []string
+	for _, f := range flags {
+		names = append(names, f.names()...)
+	}
+	fmt.Fprintf(b, `	esac
+
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	# The source: an HTML file, or - for stdin
+	COMPREPLY=($(compgen -f -X '!*.html' -- "$cur") $(compgen -d -- "$cur"))
+	[[ -z $cur ]] && COMPREPLY+=(-)
+}
+complete -o filenames -F _cleanpg cleanpg
+`, "- "+strings.Join(names, " "))
+}
+
+// zshCompletion writes the zsh completion script to b
+func zshCompletion(b *strings.Builder, flags []completionFlag) {
+	b.WriteString(`#compdef cleanpg
+# zsh completion for cleanpg; install it as _cleanpg in a directory of $fpath:
+#   cleanpg --completion zsh > "${fpath[1]}/_cleanpg"
+
+_arguments \
+`)
+
+	// Single-quoted words, with [] and : escaped where _arguments needs it
+	quote := func(s string) string {
+		return strings.ReplaceAll(s, "'", `'\''`)
+	}
+	describe := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+	message := strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+	for _, f := range flags {
+		// Flags taking a value accept it as the next word or after =
+		names := f.names()
+		if f.value != "" {
+			names = []string{names[0] + "=", names[1] + "="}
+		}
+		exclusion := "(" + strings.Join(f.names(), " ") + ")"
+		if f.repeatable {
+			exclusion = "*"
+		}
+		fmt.Fprintf(b, "\t'%s'{%s}'[%s]", exclusion, strings.Join(names, ","), quote(describe.Replace(f.usage)))
+
+		if f.value != "" {
+			action := " "
+			switch f.completes() {
+			case completeValues:
+				action = "(" + strings.Join(f.values, " ") + ")"
+			case completeFiles:
+				action = "_files"
+			case completeDirs:
+				action = "_files -/"
+			}
+			fmt.Fprintf(b, ":%s:%s", quote(message.Replace(f.value)), action)
+		}
+		b.WriteString("' \\\n")
+	}
+
+	b.WriteString(`	'*:source (HTML file, URL, or - for stdin):{_files -g "*.html(-.)"; compadd -- -}'
+`)
+}
+
+// fishCompletion writes the fish completion script to b
+func fishCompletion(b *strings.Builder, flags []completionFlag) {
+	b.WriteString(`# fish completion for cleanpg; install it with
+#   cleanpg --completion fish > ~/.config/fish/completions/cleanpg.fish
+
+complete -c cleanpg -f
+`)
+
+	quote := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	for _, f := range flags {
+		// Single letters are short options, others old-style ones
+		short := "-s " + f.short
+		if len(f.short) > 1 {
+			short = "-o " + f.short
+		}
+		fmt.Fprintf(b, "complete -c cleanpg -l %s %s", f.long, short)
+
+		if f.value != "" {
+			switch f.completes() {
+			case completeValues:
+				fmt.Fprintf(b, " -x -a '%s'", strings.Join(f.values, " "))
+			case completeFiles:
+				b.WriteString(" -r -F")
+			case completeDirs:
+				b.WriteString(" -x -a '(__fish_complete_directories)'")
+			default:
+				b.WriteString(" -x")
+			}
+		}
+		fmt.Fprintf(b, " -d '%s'\n", quote.Replace(f.usage))
+	}
+
+	b.WriteString(`
+# The source: an HTML file, or - for stdin
+complete -c cleanpg -a '(__fish_complete_suffix .html)'
+complete -c cleanpg -a - -d 'Read the document from stdin'
+`)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// completedFlag is a flag as read back from a completion script
+type completedFlag struct {
+	short      string
+	takesValue bool
+	values     string // the enumerated values, space separated
+}
+
+// Parsers reading back the flags a completion script offers
+var completionParsers = map[string]func(t *testing.T, script string) map[string]completedFlag{
+	"bash": func(t *testing.T, script string) map[string]completedFlag {
+		flags := make(map[string]completedFlag)
+		list := regexp.MustCompile(`if \[\[ \$cur == -\* \]\]; then\n\t\tCOMPREPLY=\(\$\(compgen -W "([^"]*)"`).FindStringSubmatch(script)
+		if list == nil {
+			t.Fatal("no list of flags")
+		}
+		words := strings.Fields(list[1])
+		if words[0] != "-" {
+			t.Errorf("list of flags starts with %q, want - for stdin", words[0])
+		}
+		for i := 1; i+1 < len(words); i += 2 {
+			flags[strings.TrimPrefix(words[i+1], "--")] = completedFlag{short: strings.TrimPrefix(words[i], "-")}
+		}
+
+		// The branches of the case on the previous word take values
+		branch := regexp.MustCompile(`(?m)^\t(-[^)]+)\)\n(?:\t\tCOMPREPLY=\(\$\(compgen -W "([^"]*)".*\n)?`)
+		for _, m := range branch.FindAllStringSubmatch(script, -1) {
+			names := strings.Split(m[1], "|")
+			for i := 0; i+1 < len(names); i += 2 {
+				long := strings.TrimPrefix(names[i+1], "--")
+				f := flags[long]
+				f.takesValue, f.values = true, m[2]
+				flags[long] = f
+			}
+		}
+		return flags
+	},
+	"zsh": func(t *testing.T, script string) map[string]completedFlag {
+		flags := make(map[string]completedFlag)
+		spec := regexp.MustCompile(`(?m)^\t'(?:\*|\([^)]*\))'\{-([^,=]+)(=?),--([^}=]+)=?\}'\[(?:[^'\]]|\\\]|'\\'')*\](?::(?:[^:']|\\:|'\\'')*:(?:\(([^)]*)\))?)?`)
+		for _, m := range spec.FindAllStringSubmatch(script, -1) {
+			flags[m[3]] = completedFlag{short: m[1], takesValue: m[2] == "=", values: m[4]}
+		}
+		if !strings.HasPrefix(script, "#compdef cleanpg\n") {
+			t.Error("no #compdef line")
+		}
+		return flags
+	},
+	"fish": func(t *testing.T, script string) map[string]completedFlag {
+		flags := make(map[string]completedFlag)
+		line := regexp.MustCompile(`(?m)^complete -c cleanpg -l (\S+) -[so] (\S+)( -[rx])?(?: -a '([^'(]*)')?`)
+		for _, m := range line.FindAllStringSubmatch(script, -1) {
+			flags[m[1]] = completedFlag{short: m[2], takesValue: m[3] != "", values: m[4]}
+		}
+		return flags
+	},
+}
+
+// usageFlag matches a flag in the usage
+var usageFlag = regexp.MustCompile(`(?m)^  -(\S+), --(\S+) ?(.*)$`)
+
+func TestWriteCompletion(t *testing.T) {
+	flags := completionFlags(newFlagSet())
+
+	// Every flag in the usage is offered, under the same names
+	usage := usageFlag.FindAllStringSubmatch(newFlagSet().Usage(), -1)
+	if len(usage) == 0 || len(usage) != len(flags) {
+		t.Fatalf("%d flags to complete, %d in the usage", len(flags), len(usage))
+	}
+
+	for shell, parse := range completionParsers {
+		var b bytes.Buffer
+		if err := writeCompletion(&b, shell, flags); err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		completed := parse(t, b.String())
+
+		if len(completed) != len(usage) {
+			t.Errorf("%s completes %d flags, want %d", shell, len(completed), len(usage))
+		}
+		for _, m := range usage {
+			short, long, value := m[1], m[2], m[3]
+			got, ok := completed[long]
+			if !ok {
+				t.Errorf("%s doesn't complete --%s", shell, long)
+				continue
+			}
+			if got.short != short {
+				t.Errorf("%s completes --%s as -%s, want -%s", shell, long, got.short, short)
+			}
+			if got.takesValue != (value != "") {
+				t.Errorf("%s: --%s takes a value is %v, want %v", shell, long, got.takesValue, value != "")
+			}
+		}
+
+		// Flags with a fixed set of values complete them
+		for long, values := range map[string]string{
+			"format":       "html json markdown text",
+			"images":       "download inline keep none",
+			"post-heading": "auto h1 h2 h3",
+		} {
+			if got := completed[long].values; got != values {
+				t.Errorf("%s completes --%s with %q, want %q", shell, long, got, values)
+			}
+		}
+	}
+
+	if err := writeCompletion(&bytes.Buffer{}, "tcsh", flags); err == nil {
+		t.Error("No error for an unknown shell")
+	}
+}
+
+func TestCompletionFlags(t *testing.T) {
+	flags := make(map[string]completionFlag)
+	for _, f := range completionFlags(newFlagSet()) {
+		flags[f.long] = f
+	}
+
+	for _, test := range []struct {
+		long      string
+		value     string
+		completes int
+	}{
+		{"verbose", "", completeNothing},
+		{"format", "format", completeValues},
+		{"output", "file.html", completeFiles},
+		{"logfile", "path", completeFiles},
+		{"output-dir", "directory", completeDirs},
+		{"timeout", "duration", completeNothing},
+		{"header", `"Name: value"`, completeNothing},
+	} {
+		f, ok := flags[test.long]
+		if !ok {
+			t.Errorf("No flag --%s", test.long)
+			continue
+		}
+		if f.value != test.value || f.completes() != test.completes {
+			t.Errorf("--%s takes %q completing as %d, want %q completing as %d",
+				test.long, f.value, f.completes(), test.value, test.completes)
+		}
+		if strings.Contains(f.usage, "`") {
+			t.Errorf("--%s usage %q keeps the backquotes", test.long, f.usage)
+		}
+	}
+
+	for long, want := range map[string]bool{"header": true, "config": true, "output": false} {
+		if flags[long].repeatable != want {
+			t.Errorf("--%s repeatable is %v, want %v", long, flags[long].repeatable, want)
+		}
+	}
+}
+
+// The bash script completes as expected when bash runs it
+func TestWriteCompletion_Bash(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash isn't installed")
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"page.html", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "pages"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var script bytes.Buffer
+	if err := writeCompletion(&script, "bash", completionFlags(newFlagSet())); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"--form"}, "--format --forms-as-text"},
+		{[]string{"--forma"}, "--format"},
+		{[]string{"--format", "ma"}, "markdown"},
+		{[]string{"--format", "=", "t"}, "text"},
+		{[]string{"-o", "n"}, "notes.txt"},
+		{[]string{"--output-dir", ""}, "pages"},
+		{[]string{"--timeout", ""}, ""},
+		{[]string{"-v", ""}, "page.html pages -"},
+	} {
+		cmd := exec.Command(bash, "-c", script.String()+`
+COMP_WORDS=(cleanpg "$@"); COMP_CWORD=$#; _cleanpg; echo "${COMPREPLY[*]}"`, "bash")
+		cmd.Args = append(cmd.Args, test.words...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%q: %v", test.words, err)
+		}
+		if got := strings.TrimSpace(string(out)); got != test.want {
+			t.Errorf("%q completes %q, want %q", test.words, got, test.want)
+		}
+	}
+}
+
+func TestCleanpgMain_Completion(t *testing.T) {
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	if code := cleanpgMain([]string{"cleanpg", "--completion", "fish"}); code != 0 {
+		t.Fatalf("Exit code %d", code)
+	}
+	if !strings.Contains(out.String(), "complete -c cleanpg -l format -s f -x -a 'html json markdown text'") {
+		t.Errorf("No completion of --format in\n%s", out.String())
+	}
+
+	if code := cleanpgMain([]string{"cleanpg", "--completion=tcsh"}); code != exitUsage {
+		t.Errorf("Exit code %d for an unknown shell, want %d", code, exitUsage)
+	}
+
+	// It is hidden
+	if strings.Contains(newFlagSet().Usage(), "completion") {
+		t.Error("--completion is in the usage")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package config reads cleanpg configuration files, which hold
+// defaults for command-line flags as one "key = value" per line.
+// The syntax is a subset of TOML: values may be bare or quoted,
+// and lines starting with # are comments.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Entry is a single key and value set in a configuration file
+type Entry struct {
+	Key   string
+	Value string
+	Line  int // line number, starting at 1
+}
+
+// Parse reads the entries of a configuration file from r;
+// name identifies the file in errors
+func Parse(r io.Reader, name string) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("config: [%s] line %d: expected key = value, got %q", name, line, text)
+		}
+		key := strings.TrimSpace(text[:eq])
+		if key == "" {
+			return nil, fmt.Errorf("config: [%s] line %d: missing key", name, line)
+		}
+		value, err := parseValue(strings.TrimSpace(text[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("config: [%s] line %d: %s", name, line, err)
+		}
+
+		entries = append(entries, Entry{Key: key, Value: value, Line: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config: [%s]: %s", name, err)
+	}
+
+	return entries, nil
+}
+
+// parseValue unquotes a value, or strips a trailing comment from a bare one
+func parseValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := closingQuote(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		if rest := strings.TrimSpace(s[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		// Literal strings have no escapes
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : end+1], nil
+	}
+
+	if hash := strings.Index(s, " #"); hash >= 0 {
+		s = strings.TrimSpace(s[:hash])
+	}
+	return s, nil
+}
+
+// closingQuote returns the index of the quote ending the
+// basic string at the start of s, or -1 if there is none
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// ReadFile reads the entries of the configuration file fileName
+func ReadFile(fileName string) ([]Entry, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f, fileName)
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	entries, err := ReadFile("testdata/config")
+	if err != nil {
+		t.Fatalf("Could not read config: %v", err)
+	}
+
+	expect := []Entry{
+		{"format", "markdown", 2},
+		{"timeout", "60s", 3},
+		{"nostyle", "true", 4},
+		{"user-agent", `Mozilla/5.0 (X11; Linux x86_64) "cleanpg"`, 6},
+		{"header", "X-Token: a#b", 7},
+	}
+	if !reflect.DeepEqual(entries, expect) {
+		t.Errorf("Expected %v, got %v", expect, entries)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		data   string
+		expect string
+	}{
+		{"nostyle\n", "line 1: expected key = value"},
+		{"# comment\n = 5\n", "line 2: missing key"},
+		{`agent = "unterminated` + "\n", "line 1: unterminated string"},
+		{`agent = "a" b` + "\n", `line 1: unexpected "b" after string`},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.data), "test")
+		if err == nil || !strings.Contains(err.Error(), tt.expect) {
+			t.Errorf("Expected error containing %q, got %v", tt.expect, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// Default of the "crawl-limit" and "crawl-delay" flags
+const (
+	defaultCrawlLimit = 100
+	defaultCrawlDelay = time.Second
+)
+
+// crawlLimits bounds a crawl
+type crawlLimits struct {
+	depth int           // links away from the start page
+	limit int           // pages fetched, the start page included (0 = no limit)
+	delay time.Duration // between fetches from one host
+}
+
+// crawl cleans the page at start into the output directory, then
+// the pages on its host which its cleaned content links to, and
+// so on to c.depth links away. Pages are cleaned breadth first,
+// each once however often it's linked to. A page which fails is
+// reported and skipped; the exit code is exitPartial if any did.
+func (b *batch) crawl(ctx context.Context, start string, c crawlLimits) int {
+	startURL, err := url.Parse(start)
+	if err != nil || (startURL.Scheme != "http" && startURL.Scheme != "https") {
+		logger.Write(logger.FATAL, "--crawl needs an http or https URL, not [%s]", start)
+		return exitUsage
+	}
+	if err := b.mkdirs(); err != nil {
+		return exitOutput
+	}
+
+	// The pages found at each depth are fetched together, sharing
+	// one limit on the rate of fetches from the host
+	opts := b.opts
+	opts.Limiter = cleanhtml.NewHostLimiter(c.delay)
+	level := []string{start}
+	visited := map[string]bool{cleanhtml.NormalizeURL(start): true}
+	used := make(map[string]bool)
+
+	var rendered, failed int
+	for depth := 0; len(level) > 0; depth++ {
+		if left := c.limit - rendered - failed; c.limit > 0 && len(level) > left {
+			logger.Write(logger.NOTICE, "stopping the crawl after %d pages, leaving %d found", c.limit, len(level)-left)
+			level = level[:left]
+		}
+
+		results, err := cleanhtml.FetchAllFunc(ctx, level, b.workers, opts, nil)
+		var next []string
+		for i, result := range results {
+			log := logger.With(logger.Fields{"url": level[i], "depth": depth})
+			if result.Err != nil {
+				log.WriteFields(logger.ERROR, "cannot read", logger.Fields{"error": result.Err})
+				b.fail(fetchExitCode(result.Err))
+				failed++
+				continue
+			}
+			logFetchResult(log, &result)
+			// A page redirected to is as visited as one linked to
+			if final := cleanhtml.NormalizeURL(result.URL); final != cleanhtml.NormalizeURL(level[i]) {
+				if visited[final] {
+					logger.Write(logger.INFO, "skipping [%s]: redirected to [%s], already cleaned", level[i], result.URL)
+					continue
+				}
+				visited[final] = true
+			}
+
+			name := uniqueName(used, b.format.withExt(pageName(cleanhtml.Title(result.Body), level[i])))
+			s := summary{Source: level[i], ETag: result.ETag, LastModified: result.LastModified}
+			cleanData, code := b.renderPage(ctx, result.Body, result.URL, name, s)
+			if code != 0 {
+				b.fail(code)
+				failed++
+				continue
+			}
+			rendered++
+			if depth < c.depth {
+				next = append(next, b.crawlLinks(cleanData, result.URL, startURL.Hostname(), visited)...)
+			}
+		}
+		if err != nil {
+			logger.Write(logger.FATAL, "crawl abandoned: %s", err)
+			break
+		}
+		level = next
+	}
+
+	fmt.Printf("%d of %d documents rendered to %q\n", rendered, rendered+failed, b.outputDir)
+	return b.exitCode(rendered, failed)
+}
+
+// crawlLinks returns the pages on host which the cleaned document
+// from base links to and which aren't yet visited, marking them
+// visited. Links are taken from what was rendered, not the page's
+// navigation and the like.
+func (b *batch) crawlLinks(cleanData, base, host string, visited map[string]bool) []string {
+	links, err := cleanhtml.ExtractLinks([]byte(cleanData), base)
+	if err != nil {
+		logger.Write(logger.WARNING, "could not read the links of [%s]: %s", base, err)
+		return nil
+	}
+
+	var found []string
+	for _, link := range links {
+		u, err := url.Parse(link.URL)
+		if err != nil || !strings.EqualFold(u.Hostname(), host) || !b.filter.matches(link.URL) {
+			continue
+		}
+		key := cleanhtml.NormalizeURL(link.URL)
+		if visited[key] {
+			continue
+		}
+		visited[key] = true
+		found = append(found, link.URL)
+	}
+	logger.Write(logger.INFO, "[%s] links to %d pages not yet crawled", base, len(found))
+	return found
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// crawlSite serves five interlinked pages, /a to /e, recording
+// the time of each request by path
+type crawlSite struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string][]time.Time
+}
+
+func newCrawlSite() *crawlSite {
+	links := map[string][]string{
+		// Itself by a fragment and another spelling, and off the site
+		"/a": {"#top", "/b", "/c", "/d", "http://elsewhere.example/", "/a#top"},
+		"/b": {"/a", "/c", "/d"},
+		"/c": {"/d", "/e"},
+		"/d": {"/e", "/b#section"},
+		"/e": {"/a"},
+	}
+	site := &crawlSite{requests: make(map[string][]time.Time)}
+	site.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.requests[r.URL.Path] = append(site.requests[r.URL.Path], time.Now())
+		site.mu.Unlock()
+
+		hrefs, ok := links[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		name := "Page " + r.URL.Path[1:]
+		body := "<html><head><title>" + name + "</title></head><body><h1>" + name + "</h1><p>"
+		for _, href := range hrefs {
+			body += `<a href="` + href + `">` + href + `</a> `
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(body + "</p></body></html>"))
+	}))
+	return site
+}
+
+// fetched returns the paths requested, sorted, and fails if any
+// was requested twice
+func (site *crawlSite) fetched(t *testing.T) []string {
+	t.Helper()
+	site.mu.Lock()
+	defer site.mu.Unlock()
+
+	var paths []string
+	for path, times := range site.requests {
+		if len(times) > 1 {
+			t.Errorf("%s fetched %d times", path, len(times))
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// outputNames returns the names of the pages written to dir, sorted
+func outputNames(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCrawl(t *testing.T) {
+	site := newCrawlSite()
+	defer site.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	// Depth 1 finds /b, /c and /d from /a, but the limit stops at /c
+	dir := t.TempDir()
+	delay := 50 * time.Millisecond
+	code := cleanpgMain([]string{"cleanpg", "--crawl", "1", "--crawl-limit", "3", "--crawl-delay", delay.String(),
+		"-O", dir, site.URL + "/a"})
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if expect, got := []string{"page-a.html", "page-b.html", "page-c.html"}, outputNames(dir); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q written, got %q", expect, got)
+	}
+	if expect, got := []string{"/a", "/b", "/c"}, site.fetched(t); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q fetched, got %q", expect, got)
+	}
+
+	// The fetches from the host are spaced out, give or take when
+	// they arrive
+	var times []time.Time
+	for _, path := range []string{"/a", "/b", "/c"} {
+		times = append(times, site.requests[path]...)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < delay*4/5 {
+			t.Errorf("Expected fetches %v apart, got %v", delay, gap)
+		}
+	}
+}
+
+// The cycles of a deeper crawl don't revisit pages
+func TestCrawl_Cycles(t *testing.T) {
+	site := newCrawlSite()
+	defer site.Close()
+
+	dir := t.TempDir()
+	b := newBatch(dir, cleanhtml.DefaultFetchOptions())
+	if code := b.crawl(context.Background(), site.URL+"/a", crawlLimits{depth: 5}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if expect, got := []string{"/a", "/b", "/c", "/d", "/e"}, site.fetched(t); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q fetched, got %q", expect, got)
+	}
+	if got := outputNames(dir); len(got) != 5 {
+		t.Errorf("Expected 5 pages written, got %q", got)
+	}
+}
+
+func TestCleanpgMain_CrawlUsage(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"--crawl", "1", "https://example.org/"},
+		{"--crawl", "-1", "-O", dir, "https://example.org/"},
+		{"--crawl", "1", "-O", dir, "https://example.org/a", "https://example.org/b"},
+		{"--crawl", "1", "--crawl-delay", "soon", "-O", dir, "https://example.org/"},
+		{"--crawl", "1", "--dry-run", "https://example.org/"},
+	} {
+		if code := cleanpgMain(append([]string{"cleanpg"}, args...)); code != exitUsage {
+			t.Errorf("%q: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
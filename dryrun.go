@@ -0,0 +1,114 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// dryRun fetches and cleans each of urls as the batch would,
+// writing nothing but a report to w of what each gives, and
+// returns the exit code the batch would have
+func (b *batch) dryRun(ctx context.Context, urls []string, w io.Writer) int {
+	report := progress(noProgress{})
+	if b.progress != nil {
+		report = b.progress(len(urls))
+	}
+	results, err := cleanhtml.FetchAllFunc(ctx, urls, b.workers, b.opts, func(i int, result cleanhtml.FetchResult) {
+		report.fetched(urls[i], result)
+	})
+	report.finish()
+	if err != nil {
+		logger.Write(logger.FATAL, "batch abandoned: %s", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tTYPE\tSIZE\tCLEANED\tWORDS\tSTART\tTITLE\tURL")
+	var failed int
+	for i, result := range results {
+		row, code := b.dryRunRow(ctx, result)
+		if code != 0 {
+			b.fail(code)
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", row, urls[i])
+	}
+	if err := tw.Flush(); err != nil {
+		logger.Write(logger.FATAL, "could not write the report: %s", err)
+		return exitOutput
+	}
+
+	fmt.Fprintf(w, "%d of %d documents would be rendered\n", len(urls)-failed, len(urls))
+	return b.exitCode(len(urls)-failed, failed)
+}
+
+// dryRunRow cleans the document fetched, returning the columns
+// of the report which describe it up to the URL, and the exit
+// code of any failure
+func (b *batch) dryRunRow(ctx context.Context, result cleanhtml.FetchResult) (string, int) {
+	status, contentType := "-", mediaTypeOf(result.ContentType)
+	if result.StatusCode != 0 {
+		status = strconv.Itoa(result.StatusCode)
+	}
+
+	var statusErr *cleanhtml.StatusError
+	var typeErr *cleanhtml.ContentTypeError
+	switch {
+	case result.Err == nil:
+	case errors.As(result.Err, &typeErr) && isFeedType(typeErr.ContentType):
+		// Its entries would be cleaned in its place
+		return fmt.Sprintf("feed\t%s\t-\t-\t-\t-\t-", typeErr.ContentType), 0
+	case errors.As(result.Err, &statusErr):
+		return fmt.Sprintf("%d\t-\t-\t-\t-\t-\t%s", statusErr.StatusCode, result.Err), fetchExitCode(result.Err)
+	default:
+		return fmt.Sprintf("failed\t-\t-\t-\t-\t-\t%s", result.Err), fetchExitCode(result.Err)
+	}
+	size := formatBytes(int64(len(result.Body)))
+
+	opts := cleanhtml.DefaultOptions()
+	opts.BaseURL = result.URL
+	cleanData, err := cleanhtml.CleanHTMLWithOptions(ctx, result.Body, opts)
+	if err != nil {
+		return fmt.Sprintf("%s\t%s\t%s\t-\t-\t-\t%s", status, contentType, size, err), exitClean
+	}
+
+	// Where reader mode starts: the heading it found, or none
+	start := "off"
+	if opts.PostH1Render && opts.Include == "" {
+		start = "none"
+		if heading, err := cleanhtml.CanonicalStart(result.Body, opts); err == nil && heading != nil {
+			start = heading.Tag
+		}
+	}
+	words := "-"
+	if m, err := cleanhtml.ExtractMetadata([]byte(cleanData), ""); err == nil {
+		words = strconv.Itoa(m.WordCount)
+	}
+	title := cleanhtml.Title(result.Body)
+	if title == "" {
+		title = "-"
+	}
+
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", status, contentType, size,
+		formatBytes(int64(len(cleanData))), words, start, title), 0
+}
+
+// mediaTypeOf returns the media type of a Content-Type value
+// without its parameters, or "-" if there is none
+func mediaTypeOf(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return "-"
+}
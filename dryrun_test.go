@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+func TestCleanpgMain_DryRun(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(wd, "testdata/article.html"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	// Anything written would land in the working directory
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	// Each URL has a line of the report, and any failure
+	// makes the exit code that of the batch
+	code := cleanpgMain([]string{"cleanpg", "--dry-run", "--cache-dir", "cache", ts.URL + "/roses", ts.URL + "/missing"})
+	if code != exitPartial {
+		t.Errorf("Expected exit code %d with a missing page, got %d", exitPartial, code)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	expect := []string{
+		`^STATUS +TYPE +SIZE +CLEANED +WORDS +START +TITLE +URL$`,
+		`^200 +text/html +354 B +\d+ B +15 +h1 +Pruning Roses +` + regexp.QuoteMeta(ts.URL+"/roses") + `$`,
+		`^404 +- +- +- +- +- +.*returned 404 Not Found +` + regexp.QuoteMeta(ts.URL+"/missing") + `$`,
+		`^1 of 2 documents would be rendered$`,
+	}
+	if len(lines) != len(expect) {
+		t.Fatalf("Expected %d lines in the report, got %q", len(expect), out.String())
+	}
+	for i, pattern := range expect {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("Expected line %d to match %q, got %q", i+1, pattern, lines[i])
+		}
+	}
+
+	out.Reset()
+	cleanhtml.SetPostH1Render(false)
+	if code := cleanpgMain([]string{"cleanpg", "--dry-run", "--quiet", "--nocanon", ts.URL + "/roses"}); code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if !strings.Contains(out.String(), " off ") || !strings.HasSuffix(out.String(), "1 of 1 documents would be rendered\n") {
+		t.Errorf("Expected a report with reader mode off, got %q", out.String())
+	}
+
+	// Flags which only write or open files are refused
+	for _, flag := range []string{"--save=source.html", "--links=links.txt", "--metadata", "--open", "--warc", "--watch=1m"} {
+		if code := cleanpgMain([]string{"cleanpg", "--dry-run", flag, ts.URL + "/roses"}); code != exitUsage {
+			t.Errorf("%s: expected exit code %d, got %d", flag, exitUsage, code)
+		}
+	}
+	if code := cleanpgMain([]string{"cleanpg", "--dry-run", "-"}); code != exitUsage {
+		t.Errorf("Expected exit code %d for stdin, got %d", exitUsage, code)
+	}
+
+	// Nothing was written: no output, log or cache entry
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Could not read directory: %v", err)
+	}
+	for _, f := range files {
+		t.Errorf("Expected no files, found %s", f.Name())
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// feedState holds the IDs of the feed entries already cleaned,
+// kept one per line in a state file between runs
+type feedState struct {
+	fileName string // "" = not kept
+	seen     map[string]bool
+	added    []string
+}
+
+// loadFeedState reads the state file fileName; a file which
+// doesn't exist yet means no entries have been seen
+func loadFeedState(fileName string) (*feedState, error) {
+	state := &feedState{fileName: fileName, seen: make(map[string]bool)}
+	if fileName == "" {
+		return state, nil
+	}
+
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			state.seen[id] = true
+		}
+	}
+	return state, scanner.Err()
+}
+
+// add records id as seen
+func (s *feedState) add(id string) {
+	if id == "" || s.seen[id] {
+		return
+	}
+	s.seen[id] = true
+	s.added = append(s.added, id)
+}
+
+// save appends the newly seen IDs to the state file
+func (s *feedState) save() error {
+	if s.fileName == "" || len(s.added) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(s.fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, id := range s.added {
+		fmt.Fprintln(f, id)
+	}
+	return f.Close()
+}
+
+// entryName derives a file name for a feed entry from its date
+// and title, e.g. 2020-06-01-first-post.html, falling back to
+// its link when it has no title
+func entryName(e cleanhtml.FeedEntry) string {
+	slug := slugify(e.Title)
+	if slug == "" {
+		return outputName(e.Link)
+	}
+	if !e.Published.IsZero() {
+		slug = e.Published.Format("2006-01-02") + "-" + slug
+	}
+	return slug + ".html"
+}
+
+// entryDocument wraps an entry's inline content in a document
+// headed by its title, so it is cleaned like a fetched page
+func entryDocument(e cleanhtml.FeedEntry, content string) []byte {
+	title := html.EscapeString(e.Title)
+	return []byte("<html><head><title>" + title + "</title></head><body><h1>" +
+		title + "</h1>\n" + content + "\n</body></html>")
+}
+
+// isFeedType determines if a media type may hold an RSS or
+// Atom feed; generic XML types need parsing to be sure
+func isFeedType(mt string) bool {
+	switch mt {
+	case "application/rss+xml", "application/atom+xml", "application/xml", "text/xml":
+		return true
+	}
+	return false
+}
+
+// cleanFeed cleans each entry of the RSS or Atom feed at feedURL,
+// returning the exit code. An entry's inline content is cleaned
+// unless feedLinks is set or it has none, when its link is
+// fetched and cleaned instead. Entries recorded in the feed state
+// file are skipped, and those cleaned are added to it.
+func (b *batch) cleanFeed(ctx context.Context, feedURL string) int {
+	feed, err := cleanhtml.ReadFeed(ctx, feedURL, b.opts)
+	if err != nil {
+		logger.Write(logger.FATAL, "Cannot read feed [%s]: %s", feedURL, err)
+		return fetchExitCode(err)
+	}
+	state, err := loadFeedState(b.feedState)
+	if err != nil {
+		logger.Write(logger.FATAL, "Cannot read feed state [%s]: %s", b.feedState, err)
+		return exitOutput
+	}
+	if err := b.mkdirs(); err != nil {
+		return exitOutput
+	}
+
+	// Pick the entries to clean, and the links to fetch for them
+	var entries []cleanhtml.FeedEntry
+	var links []string
+	for _, e := range feed.Entries {
+		if state.seen[e.ID] {
+			logger.Write(logger.INFO, "skipping feed entry [%s]: already cleaned", e.ID)
+			continue
+		}
+		if !b.filter.matches(e.Link) {
+			continue
+		}
+		if b.filter.max > 0 && len(entries) == b.filter.max {
+			logger.Write(logger.NOTICE, "stopping after %d entries", b.filter.max)
+			break
+		}
+		entries = append(entries, e)
+		if e.Link != "" && (b.feedLinks || e.Content == "") {
+			links = append(links, e.Link)
+		}
+	}
+
+	fetched := make(map[string]cleanhtml.FetchResult)
+	if len(links) > 0 {
+		results, err := cleanhtml.FetchAll(ctx, links, b.workers, b.opts)
+		if err != nil {
+			logger.Write(logger.FATAL, "feed abandoned: %s", err)
+		}
+		for i, result := range results {
+			fetched[links[i]] = result
+		}
+	}
+
+	used := make(map[string]bool)
+	var failed int
+	for _, e := range entries {
+		name := uniqueName(used, b.format.withExt(entryName(e)))
+		s := summary{Source: e.Link}
+		if s.Source == "" {
+			s.Source = feedURL
+		}
+
+		var body []byte
+		base := s.Source
+		if result, ok := fetched[e.Link]; ok {
+			if result.Err != nil {
+				logger.Write(logger.ERROR, "Cannot read [%s]: %s", e.Link, result.Err)
+				b.fail(fetchExitCode(result.Err))
+				failed++
+				continue
+			}
+			body, base = result.Body, result.URL
+			s.ETag, s.LastModified, s.Snapshot = result.ETag, result.LastModified, result.Snapshot
+		} else {
+			content := e.Content
+			if content == "" {
+				content = e.Summary
+			}
+			body = entryDocument(e, content)
+		}
+
+		if _, code := b.renderPage(ctx, body, base, name, s); code != 0 {
+			b.fail(code)
+			failed++
+			continue
+		}
+		state.add(e.ID)
+	}
+
+	fmt.Printf("%d of %d entries rendered to %q\n", len(entries)-failed, len(entries), b.outputDir)
+	if err := state.save(); err != nil {
+		logger.Write(logger.ERROR, "could not write feed state [%s]: %s", b.feedState, err)
+		return exitOutput
+	}
+	return b.exitCode(len(entries)-failed, failed)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// feedServer serves the feed fixtures, with their links pointing
+// back at the server, and a page for every other path
+func feedServer(t *testing.T) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed.rss", "/feed.atom":
+			data, err := ioutil.ReadFile("cleanhtml/testdata" + r.URL.Path)
+			if err != nil {
+				t.Fatalf("Could not read fixture: %v", err)
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(strings.Replace(string(data), "https://garden.example", ts.URL, -1)))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body><h1>Page " + r.URL.Path + "</h1><p>Linked page</p></body></html>"))
+		}
+	}))
+	return ts
+}
+
+// outputFiles lists the names of the files in dir
+func outputFiles(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+	sort.Strings(files)
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	return names
+}
+
+func TestCleanFeed_Inline(t *testing.T) {
+	ts := feedServer(t)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "seen.txt")
+	b := newBatch(dir, cleanhtml.DefaultFetchOptions())
+	b.feedState = stateFile
+	code := b.cleanFeed(context.Background(), ts.URL+"/feed.rss")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	expect := []string{"2020-06-01-late-winter-pruning.html", "2020-06-02-seed-catalogues.html"}
+	if names := outputFiles(dir); !reflect.DeepEqual(names, expect) {
+		t.Fatalf("Expected %q, got %q", expect, names)
+	}
+
+	// The first entry's content is inline, the second is fetched
+	for file, wants := range map[string][]string{
+		expect[0]: {"Late winter pruning", "Prune your", `href="` + ts.URL + `/tools"`, `source="` + ts.URL + `/posts/pruning"`},
+		expect[1]: {"Page /posts/seeds", "Linked page"},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("Could not read output: %v", err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Expected %q in %s, got %q", want, file, data)
+			}
+		}
+	}
+
+	state, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Could not read state file: %v", err)
+	}
+	if expect := "garden-101\n" + ts.URL + "/posts/seeds\n"; string(state) != expect {
+		t.Errorf("Expected state %q, got %q", expect, state)
+	}
+
+	// Entries in the state file aren't cleaned again
+	for _, name := range expect {
+		os.Remove(filepath.Join(dir, name))
+	}
+	code = b.cleanFeed(context.Background(), ts.URL+"/feed.rss")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if names := outputFiles(dir); len(names) != 0 {
+		t.Errorf("Expected seen entries to be skipped, got %q", names)
+	}
+}
+
+func TestCleanFeed_Links(t *testing.T) {
+	ts := feedServer(t)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	b := newBatch(dir, cleanhtml.DefaultFetchOptions())
+	b.feedLinks = true
+	code := b.cleanFeed(context.Background(), ts.URL+"/feed.atom")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	expect := []string{"2020-05-20-version-1.1.html", "2020-06-03-version-1.2-more.html"}
+	if names := outputFiles(dir); !reflect.DeepEqual(names, expect) {
+		t.Fatalf("Expected %q, got %q", expect, names)
+	}
+
+	// The linked page is cleaned even though the entry has content
+	data, err := ioutil.ReadFile(filepath.Join(dir, expect[0]))
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	if !strings.Contains(string(data), "Page /notes/1.1") || strings.Contains(string(data), "stable") {
+		t.Errorf("Expected the linked page, got %q", data)
+	}
+
+	// A page isn't a feed
+	if code := b.cleanFeed(context.Background(), ts.URL+"/page"); code != exitFetch {
+		t.Errorf("Expected exit code %d for a page, got %d", exitFetch, code)
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	tests := []struct {
+		entry  cleanhtml.FeedEntry
+		expect string
+	}{
+		{cleanhtml.FeedEntry{Title: "Hello, World!", Link: "https://a.test/x"}, "hello-world.html"},
+		{cleanhtml.FeedEntry{Link: "https://a.test/posts/x"}, "a.test-posts-x.html"},
+		{cleanhtml.FeedEntry{Title: "Notes: v2", Published: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}, "2020-01-02-notes-v2.html"},
+	}
+
+	for _, tt := range tests {
+		if got := entryName(tt.entry); got != tt.expect {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+	}
+}
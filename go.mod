@@ -3,6 +3,8 @@ module github.com/scu/cleanpg
 go 1.15
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/scu/flagplus v1.0.0
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
+	golang.org/x/text v0.3.6
 )
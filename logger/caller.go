@@ -0,0 +1,40 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"path"
+	"runtime"
+	"strconv"
+)
+
+// SetReportCaller determines whether each message is written with
+// the file and line it was written from, as its directory and
+// name, e.g. cleanhtml/parse.go:42: after the message in text, and
+// as its caller member in JSON
+func (l *Logger) SetReportCaller(flag bool) {
+	l.withCaller = flag
+}
+
+// caller returns the file and line of the call skip frames above
+// the function calling it, "" unless SetReportCaller is on. Each
+// exported function writing a message calls it directly, with skip
+// 1 for the line calling that function.
+func (l *Logger) caller(skip int) string {
+	if !l.withCaller {
+		return ""
+	}
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	// The paths of runtime.Caller are slashed on every platform
+	dir, name := path.Split(file)
+	return path.Base(dir) + "/" + name + ":" + strconv.Itoa(line)
+}
+
+// SetReportCaller determines whether the default Logger reports
+// callers; see Logger.SetReportCaller
+func SetReportCaller(flag bool) { std.SetReportCaller(flag) }
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// here returns this file and the line after the call to here
+func here() string {
+	_, _, line, _ := runtime.Caller(1)
+	return "logger/caller_test.go:" + strconv.Itoa(line+1)
+}
+
+func TestSetReportCaller(t *testing.T) {
+	var out strings.Builder
+	SetOutput(&out)
+	SetReportCaller(true)
+	defer SetLogFile("")
+	defer SetReportCaller(false)
+	l := New(WithOutput(&out))
+	l.SetReportCaller(true)
+
+	// Each way of writing reports the line calling it
+	var callers []string
+	callers = append(callers, here())
+	Write(INFO, "package Write")
+	callers = append(callers, here())
+	WriteFields(INFO, "package WriteFields", Fields{"n": 1})
+	callers = append(callers, here())
+	l.Write(INFO, "Logger.Write")
+	callers = append(callers, here())
+	l.WriteFields(INFO, "Logger.WriteFields", nil)
+	callers = append(callers, here())
+	l.With(Fields{"n": 2}).Write(INFO, "FieldLogger.Write")
+	callers = append(callers, here())
+	With(nil).WriteFields(INFO, "FieldLogger.WriteFields", nil)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(callers) {
+		t.Fatalf("Expected %d lines, got %q", len(callers), out.String())
+	}
+	for i, line := range lines {
+		if expect := " (" + callers[i] + ")"; !strings.HasSuffix(line, expect) {
+			t.Errorf("Expected %q to end %q", line, expect)
+		}
+	}
+	if !strings.HasSuffix(lines[1], "package WriteFields n=1 ("+callers[1]+")") {
+		t.Errorf("Expected the caller after the fields, got %q", lines[1])
+	}
+
+	// In JSON it's a member of its own
+	out.Reset()
+	l.SetJSON(true)
+	caller := here()
+	l.WriteFields(WARNING, "as JSON", Fields{"caller": "shadowed"})
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("Expected a JSON object, got %q: %v", out.String(), err)
+	}
+	if got["caller"] != caller || got["msg"] != "as JSON" || got["field.caller"] != "shadowed" {
+		t.Errorf("Expected caller %q, got %v", caller, got)
+	}
+
+	// Off, nothing is added
+	out.Reset()
+	l.SetJSON(false)
+	l.SetReportCaller(false)
+	l.Write(INFO, "no caller")
+	if strings.Contains(out.String(), "caller_test.go") {
+		t.Errorf("Expected no caller, got %q", out.String())
+	}
+}
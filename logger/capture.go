@@ -0,0 +1,141 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture records messages in memory, for tests of code which logs:
+// add it with AddSink to record each as written, fields and all,
+// or pass it to SetOutput to read back the lines of the log file.
+// It is safe for concurrent use.
+type Capture struct {
+	mu      sync.Mutex
+	entries []Entry
+	partial []byte // of a line written by SetOutput, until its newline
+}
+
+// NewCapture returns an empty Capture
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+// WriteEntry implements the Sink interface
+func (c *Capture) WriteEntry(e Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, e)
+	return nil
+}
+
+// Write implements the io.Writer interface, reading back the lines
+// of the log, text or JSON. A text line's fields stay part of its
+// Message.
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if e, ok := parseLine(string(c.partial[:i])); ok {
+			c.entries = append(c.entries, e)
+		}
+		c.partial = c.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// parseLine returns the entry written as a line of the log
+func parseLine(line string) (Entry, bool) {
+	if strings.HasPrefix(line, "{") {
+		var members map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &members); err != nil {
+			return Entry{}, false
+		}
+		var e Entry
+		e.Time, _ = time.Parse(time.RFC3339, stringOf(members["time"]))
+		e.Level, _ = ParseLevel(stringOf(members["level"]))
+		e.Message = stringOf(members["msg"])
+		e.Caller = stringOf(members["caller"])
+		for _, key := range []string{"time", "level", "msg", "caller"} {
+			delete(members, key)
+		}
+		if len(members) > 0 {
+			e.Fields = make(Fields, len(members))
+			for key, value := range members {
+				e.Fields[strings.TrimPrefix(key, "field.")] = value
+			}
+		}
+		return e, true
+	}
+
+	// 2006/01/02 15:04:05 LEVEL: message
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 3 {
+		return Entry{}, false
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", parts[0]+" "+parts[1], time.Local)
+	i := strings.Index(parts[2], ": ")
+	if err != nil || i < 0 {
+		return Entry{}, false
+	}
+	level, err := ParseLevel(parts[2][:i])
+	if err != nil {
+		return Entry{}, false
+	}
+	return Entry{Level: level, Time: t, Message: parts[2][i+2:]}, true
+}
+
+// stringOf returns value if it is a string, or else ""
+func stringOf(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+// Entries returns the messages recorded, oldest first
+func (c *Capture) Entries() []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Entry(nil), c.entries...)
+}
+
+// LastEntry returns the last message recorded, and false if there
+// is none
+func (c *Capture) LastEntry() (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) == 0 {
+		return Entry{}, false
+	}
+	return c.entries[len(c.entries)-1], true
+}
+
+// FilterByLevel returns the messages recorded of level, oldest first
+func (c *Capture) FilterByLevel(level MessageType) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var entries []Entry
+	for _, e := range c.entries {
+		if e.Level == level {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Reset forgets the messages recorded
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries, c.partial = nil, nil
+}
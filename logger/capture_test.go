@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestCapture_Sink(t *testing.T) {
+	l := New(WithOutput(ioutil.Discard), WithLevel(NOTICE))
+	c := NewCapture()
+	l.AddSink(c)
+
+	if _, ok := c.LastEntry(); ok {
+		t.Error("Expected no last entry of an empty Capture")
+	}
+	l.Write(NOTICE, "reading [%s]", "page.html")
+	l.WriteFields(ERROR, "cannot read", Fields{"url": "http://example.com/", "error": errors.New("timeout")})
+	l.Write(INFO, "dropped") // below the level
+
+	entries := c.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	if entries[0].Level != NOTICE || entries[0].Message != "reading [page.html]" || entries[0].Time.IsZero() {
+		t.Errorf("Expected the NOTICE entry, got %+v", entries[0])
+	}
+	last, ok := c.LastEntry()
+	if !ok || last.Message != "cannot read" || last.Fields["url"] != "http://example.com/" {
+		t.Errorf("Expected the ERROR entry last, got %+v", last)
+	}
+	if errs := c.FilterByLevel(ERROR); len(errs) != 1 || fmt.Sprint(errs[0].Fields["error"]) != "timeout" {
+		t.Errorf("Expected 1 ERROR entry, got %v", errs)
+	}
+	if warnings := c.FilterByLevel(WARNING); len(warnings) != 0 {
+		t.Errorf("Expected no WARNING entries, got %v", warnings)
+	}
+
+	c.Reset()
+	if len(c.Entries()) != 0 {
+		t.Errorf("Expected no entries after Reset, got %v", c.Entries())
+	}
+}
+
+func TestCapture_Output(t *testing.T) {
+	for _, json := range []bool{false, true} {
+		c := NewCapture()
+		l := New(WithOutput(c))
+		l.SetJSON(json)
+		l.Write(INFO, "reading [page.html]")
+		l.WriteFields(WARNING, "slow", Fields{"msg": "x", "elapsed": 2})
+
+		entries := c.Entries()
+		if len(entries) != 2 {
+			t.Fatalf("Expected 2 entries in JSON=%t, got %d: %v", json, len(entries), entries)
+		}
+		if entries[0].Level != INFO || entries[0].Message != "reading [page.html]" || entries[0].Time.IsZero() {
+			t.Errorf("Expected the INFO entry in JSON=%t, got %+v", json, entries[0])
+		}
+		expect := "slow elapsed=2 msg=x"
+		if json {
+			expect = "slow"
+			if entries[1].Fields["elapsed"] != 2.0 || entries[1].Fields["msg"] != "x" {
+				t.Errorf("Expected the fields of the JSON entry, got %v", entries[1].Fields)
+			}
+		}
+		if entries[1].Level != WARNING || entries[1].Message != expect {
+			t.Errorf("Expected WARNING %q in JSON=%t, got %+v", expect, json, entries[1])
+		}
+	}
+}
+
+func TestCapture_Concurrent(t *testing.T) {
+	c := NewCapture()
+	l := New(WithOutput(c))
+	l.AddSink(c)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				l.Write(INFO, "worker %d message %d", i, j)
+				c.LastEntry()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Each message is recorded by the sink and read back from the output
+	if n := len(c.FilterByLevel(INFO)); n != 2*8*50 {
+		t.Errorf("Expected %d entries, got %d", 2*8*50, n)
+	}
+}
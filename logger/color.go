@@ -0,0 +1,81 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"os"
+	"strings"
+)
+
+// ColorMode determines whether the levels of messages printed to
+// stderr are colored
+type ColorMode int
+
+const (
+	// ColorAuto colors them when stderr is a terminal, unless the
+	// NO_COLOR environment variable is set
+	ColorAuto ColorMode = iota
+	// ColorOn always colors them
+	ColorOn
+	// ColorOff never colors them
+	ColorOff
+)
+
+// levelColors are the ANSI colors of the levels which are colored
+var levelColors = map[MessageType]string{
+	WARNING: "\x1b[33m", // yellow
+	ERROR:   "\x1b[31m", // red
+	FATAL:   "\x1b[31m",
+}
+
+// colorReset ends a color
+const colorReset = "\x1b[0m"
+
+// stderrIsTerminal determines if stderr is a terminal rather than
+// a file or pipe, for ColorAuto
+var stderrIsTerminal = func() bool {
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colors determines whether mode colors stderr
+func colors(mode ColorMode) bool {
+	switch mode {
+	case ColorOn:
+		return true
+	case ColorOff:
+		return false
+	}
+	return os.Getenv("NO_COLOR") == "" && stderrIsTerminal()
+}
+
+// SetColor determines whether the levels of messages printed to
+// stderr are colored: WARNING yellow, and ERROR and FATAL red. The
+// log file and other outputs never are. The default is ColorAuto.
+func (l *Logger) SetColor(mode ColorMode) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	l.stderrSink.color = colors(mode)
+}
+
+// WithColor sets whether stderr is colored, as SetColor does
+func WithColor(mode ColorMode) Option {
+	return func(l *Logger) { l.SetColor(mode) }
+}
+
+// colorLevel returns the level prefix of a message of messageType
+// colored, for a sink which is
+func colorLevel(messageType MessageType, prefix string) string {
+	color, ok := levelColors[messageType]
+	if !ok || prefix == "" {
+		return prefix
+	}
+	level := strings.TrimRight(prefix, " ")
+	return color + level + colorReset + prefix[len(level):]
+}
+
+// SetColor sets whether the default Logger colors stderr; see
+// Logger.SetColor
+func SetColor(mode ColorMode) { std.SetColor(mode) }
@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// colorRun writes a message of each level to a Logger colored as
+// mode, printing to stderr, returning stderr and the output
+func colorRun(mode ColorMode) (stderr, out string) {
+	var errs, output strings.Builder
+	l := New(WithOutput(&output), WithStderrLevel(INFO), WithColor(mode))
+	l.stderrLogger.SetOutput(&errs)
+	for _, level := range []MessageType{INFO, NOTICE, WARNING, ERROR, FATAL} {
+		l.Write(level, "%s message", level)
+	}
+	return errs.String(), output.String()
+}
+
+func TestSetColor(t *testing.T) {
+	stderr, out := colorRun(ColorOn)
+	for _, expect := range []string{
+		" INFO: info message\n",
+		" NOTICE: notice message\n",
+		" \x1b[33mWARNING:\x1b[0m warning message\n",
+		" \x1b[31mERROR:\x1b[0m error message\n",
+		" \x1b[31mFATAL:\x1b[0m fatal message\n",
+	} {
+		if !strings.Contains(stderr, expect) {
+			t.Errorf("Expected %q on stderr, got %q", expect, stderr)
+		}
+	}
+	if strings.Contains(out, "\x1b") {
+		t.Errorf("Expected no escape codes in the output, got %q", out)
+	}
+
+	if stderr, _ := colorRun(ColorOff); strings.Contains(stderr, "\x1b") || !strings.Contains(stderr, " WARNING: warning message") {
+		t.Errorf("Expected plain stderr, got %q", stderr)
+	}
+}
+
+func TestSetColor_Auto(t *testing.T) {
+	defer func(f func() bool) { stderrIsTerminal = f }(stderrIsTerminal)
+	noColor, set := os.LookupEnv("NO_COLOR")
+	defer func() {
+		if set {
+			os.Setenv("NO_COLOR", noColor)
+		} else {
+			os.Unsetenv("NO_COLOR")
+		}
+	}()
+	os.Unsetenv("NO_COLOR")
+
+	for _, test := range []struct {
+		terminal bool
+		noColor  string
+		colored  bool
+	}{
+		{true, "", true},
+		{true, "1", false},
+		{false, "", false},
+	} {
+		stderrIsTerminal = func() bool { return test.terminal }
+		os.Setenv("NO_COLOR", test.noColor)
+		stderr, _ := colorRun(ColorAuto)
+		if got := strings.Contains(stderr, "\x1b[33m"); got != test.colored {
+			t.Errorf("Terminal %v, NO_COLOR %q: expected colored %v, got %q", test.terminal, test.noColor, test.colored, stderr)
+		}
+	}
+}
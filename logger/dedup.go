@@ -0,0 +1,98 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// repeats tracks the last message written, to suppress the same
+// again within the window set by SetDedup
+type repeats struct {
+	window     time.Duration // 0 = no deduplication
+	level      MessageType
+	text       string // the message with its fields, "" for none
+	count      int    // times suppressed since it was written
+	generation int    // of the message, so a stale timer does nothing
+	timer      *time.Timer
+}
+
+// SetDedup suppresses a message the same as the last one, at the
+// same level and with the same fields, within window of the last
+// being written: a single "last message repeated N times" line
+// follows instead, when a different message is written or the
+// window closes. After the window the same message is written
+// again. Messages dropped by SetLevel don't count. Window 0, the
+// default, writes every message.
+func (l *Logger) SetDedup(window time.Duration) {
+	l.flushRepeats(0)
+	l.dedupMu.Lock()
+	defer l.dedupMu.Unlock()
+	if window < 0 {
+		window = 0
+	}
+	l.repeats.window = window
+}
+
+// dedupWrite writes a message, after how often the last was
+// repeated, unless it repeats the last itself. It returns false,
+// writing nothing, when deduplication is off.
+func (l *Logger) dedupWrite(messageType MessageType, message string, fields Fields, caller string) bool {
+	l.dedupMu.Lock()
+	defer l.dedupMu.Unlock()
+	r := &l.repeats
+	if r.window == 0 {
+		return false
+	}
+
+	text := redact(message+formatFields(fields)) + caller
+	if r.text != "" && messageType == r.level && text == r.text {
+		r.count++
+		return true
+	}
+
+	l.writeRepeats()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.level, r.text, r.count = messageType, text, 0
+	r.generation++
+	generation := r.generation
+	r.timer = time.AfterFunc(r.window, func() { l.flushRepeats(generation) })
+
+	l.writeMessage(messageType, message, fields, caller)
+	return true
+}
+
+// writeRepeats writes how often the last message was suppressed,
+// if at all; it is called with dedupMu held
+func (l *Logger) writeRepeats() {
+	r := &l.repeats
+	if r.count > 0 {
+		l.writeMessage(r.level, fmt.Sprintf("last message repeated %d times", r.count), nil, "")
+	}
+	r.text, r.count = "", 0
+}
+
+// flushRepeats ends the window of the last message, writing how
+// often it was suppressed: that of generation, or any if 0
+func (l *Logger) flushRepeats(generation int) {
+	l.dedupMu.Lock()
+	defer l.dedupMu.Unlock()
+	r := &l.repeats
+	if generation != 0 && generation != r.generation {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	l.writeRepeats()
+}
+
+// SetDedup sets up deduplication of the default Logger's messages;
+// see Logger.SetDedup
+func SetDedup(window time.Duration) { std.SetDedup(window) }
@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuilder is a strings.Builder safe to read while written
+type syncBuilder struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (b *syncBuilder) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// messagesOf returns the messages of a log, without their times
+func messagesOf(log string) []string {
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSuffix(log, "\n"), "\n") {
+		if i := strings.Index(line, ": "); i >= 0 {
+			// Past the time, to the level
+			messages = append(messages, line[strings.LastIndex(line[:i], " ")+1:])
+		}
+	}
+	return messages
+}
+
+func TestSetDedup(t *testing.T) {
+	var out, stderr strings.Builder
+	l := New(WithOutput(&out), WithStderrLevel(INFO))
+	l.stderrLogger.SetOutput(&stderr)
+	l.SetDedup(time.Hour)
+
+	for i := 0; i < 400; i++ {
+		l.Write(WARNING, "dropped <li>")
+	}
+	l.Write(INFO, "dropped <li>") // at another level
+	l.Write(INFO, "dropped <li>")
+	l.WriteFields(ERROR, "cannot read", Fields{"url": "a"})
+	l.WriteFields(ERROR, "cannot read", Fields{"url": "b"})
+	l.WriteFields(ERROR, "cannot read", Fields{"url": "b"})
+	l.Write(ERROR, "once")
+	l.Close()
+
+	expect := []string{
+		"WARNING: dropped <li>",
+		"WARNING: last message repeated 399 times",
+		"INFO: dropped <li>",
+		"INFO: last message repeated 1 times",
+		"ERROR: cannot read url=a",
+		"ERROR: cannot read url=b",
+		"ERROR: last message repeated 1 times",
+		"ERROR: once",
+	}
+	for name, log := range map[string]string{"output": out.String(), "stderr": stderr.String()} {
+		got := messagesOf(log)
+		if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", name, strings.Join(expect, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+// The summary follows once the window closes, and the message is
+// written again after it
+func TestSetDedup_Window(t *testing.T) {
+	var out syncBuilder
+	l := New(WithOutput(&out), WithLevel(NOTICE))
+	l.SetDedup(50 * time.Millisecond)
+
+	l.Write(INFO, "retrying") // dropped, so never counted
+	l.Write(NOTICE, "retrying")
+	l.Write(INFO, "retrying")
+	l.Write(NOTICE, "retrying")
+	l.Write(NOTICE, "retrying")
+	time.Sleep(200 * time.Millisecond)
+	if got, expect := messagesOf(out.String()), []string{"NOTICE: retrying", "NOTICE: last message repeated 2 times"}; strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected %q when the window closed, got %q", expect, got)
+	}
+
+	l.Write(NOTICE, "retrying")
+	l.Close()
+	if got := messagesOf(out.String()); len(got) != 3 || got[2] != "NOTICE: retrying" {
+		t.Errorf("Expected the message again after the window, got %q", got)
+	}
+}
+
+// Without SetDedup every message is written
+func TestSetDedup_Off(t *testing.T) {
+	var out strings.Builder
+	l := New(WithOutput(&out))
+	for i := 0; i < 3; i++ {
+		l.Write(INFO, "again")
+	}
+	if got := strings.Count(out.String(), "INFO: again"); got != 3 {
+		t.Errorf("Expected 3 messages, got %d in %q", got, out.String())
+	}
+}
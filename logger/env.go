@@ -0,0 +1,70 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WithEnvironment configures the Logger from the environment, as
+// the default Logger is, for deployments which can't call its
+// setters: CLEANPG_LOG_LEVEL sets the level, one of info, notice,
+// warning, error or fatal; CLEANPG_LOG_FORMAT is text or json; and
+// CLEANPG_LOG_FILE is the log file, or "stderr", or "none" to log
+// nothing as Disable does. Options
+// after it and later calls, such as SetLevel, take precedence.
+// Invalid values are ignored for the defaults, and warned of with
+// the first message written, so that nothing is written until then.
+func WithEnvironment() Option {
+	return func(l *Logger) {
+		var invalid []string
+		if value, ok := os.LookupEnv("CLEANPG_LOG_LEVEL"); ok {
+			if level, err := ParseLevel(value); err == nil {
+				l.SetLevel(level)
+			} else {
+				invalid = append(invalid, fmt.Sprintf("CLEANPG_LOG_LEVEL=%q (expected %s)", value, strings.Join(levelNames, ", ")))
+			}
+		}
+		if value, ok := os.LookupEnv("CLEANPG_LOG_FORMAT"); ok {
+			switch strings.ToLower(value) {
+			case "text":
+				l.fileSink.json = false
+			case "json":
+				l.fileSink.json = true
+			default:
+				invalid = append(invalid, fmt.Sprintf("CLEANPG_LOG_FORMAT=%q (expected text or json)", value))
+			}
+		}
+		if value, ok := os.LookupEnv("CLEANPG_LOG_FILE"); ok {
+			switch value {
+			case "":
+				invalid = append(invalid, "CLEANPG_LOG_FILE=\"\" (expected a file, stderr or none)")
+			case "none":
+				l.disabled = true
+			case "stderr":
+				l.output = os.Stderr
+			default:
+				l.logFileName = value
+			}
+		}
+
+		if len(invalid) > 0 {
+			l.envWarning = "ignoring invalid " + strings.Join(invalid, ", ")
+		}
+	}
+}
+
+// warnEnvironment warns once of the invalid environment variables
+// WithEnvironment ignored, if any. The warning is written directly,
+// being written on the way to another message.
+func (l *Logger) warnEnvironment() {
+	l.envOnce.Do(func() {
+		if l.envWarning != "" && WARNING >= l.minLevel {
+			l.writeMessage(WARNING, l.envWarning, nil, "")
+		}
+	})
+}
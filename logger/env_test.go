@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setEnv sets the variables of env, unsetting those set to "-",
+// and returns a function restoring them
+func setEnv(env map[string]string) func() {
+	var restore []func()
+	for name, value := range env {
+		name := name
+		if old, ok := os.LookupEnv(name); ok {
+			restore = append(restore, func() { os.Setenv(name, old) })
+		} else {
+			restore = append(restore, func() { os.Unsetenv(name) })
+		}
+		if value == "-" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+	return func() {
+		for _, fn := range restore {
+			fn()
+		}
+	}
+}
+
+func TestWithEnvironment(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "env.log")
+	defer setEnv(map[string]string{
+		"CLEANPG_LOG_LEVEL":  "WARNING",
+		"CLEANPG_LOG_FORMAT": "json",
+		"CLEANPG_LOG_FILE":   logFile,
+	})()
+
+	l := New(WithEnvironment())
+	l.Write(INFO, "dropped")
+	l.Write(WARNING, "kept")
+	l.Close()
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Could not read the log file named by CLEANPG_LOG_FILE: %v", err)
+	}
+	if got := string(data); strings.Contains(got, "dropped") || !strings.Contains(got, `"level":"warning","msg":"kept"`) {
+		t.Errorf("Expected only the WARNING as JSON, got %q", got)
+	}
+
+	// Options after it, and setters, take precedence
+	var out strings.Builder
+	l = New(WithEnvironment(), WithLevel(INFO), WithOutput(&out))
+	l.SetJSON(false)
+	l.Write(INFO, "kept")
+	if got := out.String(); !strings.Contains(got, "INFO: kept") || strings.Contains(got, "{") {
+		t.Errorf("Expected the INFO message as text, got %q", got)
+	}
+	if _, err := os.Stat("env.log"); !os.IsNotExist(err) {
+		t.Errorf("Expected no log file in the current directory, got %v", err)
+	}
+}
+
+func TestWithEnvironment_Destinations(t *testing.T) {
+	defer setEnv(map[string]string{
+		"CLEANPG_LOG_LEVEL":  "-",
+		"CLEANPG_LOG_FORMAT": "-",
+		"CLEANPG_LOG_FILE":   "stderr",
+	})()
+	if l := New(WithEnvironment()); l.output != os.Stderr {
+		t.Errorf("Expected CLEANPG_LOG_FILE=stderr to log to stderr, got %v", l.output)
+	}
+
+	os.Setenv("CLEANPG_LOG_FILE", "none")
+	var stderr strings.Builder
+	l := New(WithEnvironment(), WithStderrLevel(INFO))
+	l.stderrLogger.SetOutput(&stderr)
+	l.Write(ERROR, "unlogged")
+	if stderr.Len() > 0 {
+		t.Errorf("Expected CLEANPG_LOG_FILE=none to log nothing, got %q", stderr.String())
+	}
+	var out strings.Builder
+	l.SetOutput(&out)
+	l.Write(ERROR, "logged")
+	if !strings.Contains(out.String(), "ERROR: logged") {
+		t.Errorf("Expected SetOutput to log again, got %q", out.String())
+	}
+}
+
+func TestWithEnvironment_Invalid(t *testing.T) {
+	defer setEnv(map[string]string{
+		"CLEANPG_LOG_LEVEL":  "loud",
+		"CLEANPG_LOG_FORMAT": "xml",
+		"CLEANPG_LOG_FILE":   "-",
+	})()
+
+	var out strings.Builder
+	l := New(WithEnvironment(), WithOutput(&out))
+	if out.Len() > 0 {
+		t.Errorf("Expected nothing written before the first message, got %q", out.String())
+	}
+	l.Write(INFO, "first")
+	l.Write(INFO, "second")
+
+	// The defaults, and a single warning
+	expect := []string{
+		`WARNING: ignoring invalid CLEANPG_LOG_LEVEL="loud" (expected info, notice, warning, error, fatal), CLEANPG_LOG_FORMAT="xml" (expected text or json)`,
+		"INFO: first",
+		"INFO: second",
+	}
+	if got := messagesOf(out.String()); strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
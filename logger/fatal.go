@@ -0,0 +1,58 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import "fmt"
+
+// SetExitOnFatal determines whether writing a FATAL message exits
+// the process: once it's written and the hooks have run, the Logger
+// is closed, flushing the log file and sinks, and the exit function
+// called with status 1. It is off by default, FATAL messages being
+// logged and returned from like any other. Even a Logger turned
+// off by Disable exits.
+func (l *Logger) SetExitOnFatal(flag bool) {
+	l.exitOnFatal = flag
+}
+
+// SetExitFunc sets the function SetExitOnFatal calls, os.Exit by
+// default, e.g. to a test's own
+func (l *Logger) SetExitFunc(fn func(code int)) {
+	l.exitFunc = fn
+}
+
+// Fatalf writes a FATAL message, as Write does; with SetExitOnFatal
+// on, it doesn't return
+func (l *Logger) Fatalf(format string, a ...interface{}) {
+	if l.dropped(FATAL) {
+		return
+	}
+	l.log(l.caller(1), FATAL, fmt.Sprintf(format, a...), nil)
+}
+
+// exit closes the Logger, so that the FATAL message just written
+// isn't lost, and calls the exit function
+func (l *Logger) exit() {
+	if err := l.Close(); err != nil {
+		fmt.Fprintf(l.stderrLogger.Writer(), "WARNING: %s\n", err)
+	}
+	l.exitFunc(1)
+}
+
+// SetExitOnFatal determines whether the default Logger exits after a
+// FATAL message; see Logger.SetExitOnFatal
+func SetExitOnFatal(flag bool) { std.SetExitOnFatal(flag) }
+
+// SetExitFunc sets the default Logger's exit function; see
+// Logger.SetExitFunc
+func SetExitFunc(fn func(code int)) { std.SetExitFunc(fn) }
+
+// Fatalf writes a FATAL message to the default Logger; see
+// Logger.Fatalf
+func Fatalf(format string, a ...interface{}) {
+	if std.dropped(FATAL) {
+		return
+	}
+	std.log(std.caller(1), FATAL, fmt.Sprintf(format, a...), nil)
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetExitOnFatal(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "log.txt")
+	l := New(WithLogFile(logFile))
+	c := NewCapture()
+	l.AddSink(c)
+
+	var codes []int
+	var logged string
+	l.SetExitFunc(func(code int) {
+		codes = append(codes, code)
+		data, _ := ioutil.ReadFile(logFile)
+		logged = string(data)
+	})
+
+	// Off by default
+	l.Write(FATAL, "carry on")
+	if len(codes) != 0 {
+		t.Errorf("Expected no exit by default, got %v", codes)
+	}
+
+	l.SetExitOnFatal(true)
+	l.Write(ERROR, "not fatal")
+	if len(codes) != 0 {
+		t.Errorf("Expected no exit after an ERROR, got %v", codes)
+	}
+	l.Fatalf("cannot write [%s]", "out.html")
+	if len(codes) != 1 || codes[0] != 1 {
+		t.Fatalf("Expected to exit once with status 1, got %v", codes)
+	}
+	if !strings.Contains(logged, "FATAL: cannot write [out.html]") {
+		t.Errorf("Expected the message in the log file before exiting, got %q", logged)
+	}
+	if last, ok := c.LastEntry(); !ok || last.Level != FATAL || last.Message != "cannot write [out.html]" {
+		t.Errorf("Expected the message in the sinks before exiting, got %+v", last)
+	}
+}
+
+func TestSetExitOnFatal_Disabled(t *testing.T) {
+	l := New(WithOutput(ioutil.Discard))
+	l.Disable()
+	exits := 0
+	l.SetExitFunc(func(int) { exits++ })
+	l.SetExitOnFatal(true)
+
+	l.Write(FATAL, "unlogged")
+	l.WriteFields(FATAL, "unlogged", Fields{"url": "a"})
+	if exits != 2 {
+		t.Errorf("Expected to exit after each FATAL message, got %d", exits)
+	}
+}
+
+func TestFatalf(t *testing.T) {
+	var out, def strings.Builder
+	l := New(WithOutput(&out))
+	SetOutput(&def)
+	defer SetLogFile("")
+	l.SetReportCaller(true)
+	l.Fatalf("failed %d times", 3)
+	Fatalf("the default Logger's")
+
+	if got := out.String(); !strings.Contains(got, "FATAL: failed 3 times (logger/fatal_test.go:") {
+		t.Errorf("Expected the FATAL message with its caller, got %q", got)
+	}
+	if got := def.String(); !strings.Contains(got, "FATAL: the default Logger's") {
+		t.Errorf("Expected the default Logger's FATAL message, got %q", got)
+	}
+}
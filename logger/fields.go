@@ -0,0 +1,178 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields are the key-value pairs of a structured message, such as
+// the URL and status of a fetch
+type Fields map[string]interface{}
+
+// SetJSON determines whether the log file, or the output set by
+// SetOutput, is written as JSON lines: an object for each message
+// with its time, level and msg, then its fields as members of their
+// own. Stderr and syslog still get text.
+func (l *Logger) SetJSON(flag bool) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	l.fileSink.json = flag
+}
+
+// WriteFields writes message with fields, as Write does. In text
+// they follow the message as key=value, sorted by key, values with
+// spaces or quotes being quoted; in JSON they are members of the
+// message's object.
+func (l *Logger) WriteFields(messageType MessageType, message string, fields Fields) {
+	if l.dropped(messageType) {
+		return
+	}
+	l.log(l.caller(1), messageType, message, fields)
+}
+
+// SetJSON determines whether the default Logger writes JSON; see
+// Logger.SetJSON
+func SetJSON(flag bool) { std.SetJSON(flag) }
+
+// WriteFields writes a message with fields to the default Logger;
+// see Logger.WriteFields
+func WriteFields(messageType MessageType, message string, fields Fields) {
+	if std.dropped(messageType) {
+		return
+	}
+	std.log(std.caller(1), messageType, message, fields)
+}
+
+// sortedKeys returns the keys of fields in order
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatFields returns fields as text, " key=value" for each
+func formatFields(fields Fields) string {
+	var b strings.Builder
+	for _, key := range sortedKeys(fields) {
+		value := fmt.Sprint(fields[key])
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
+
+// jsonValue returns value as it's marshalled: errors and Stringers,
+// such as a time.Duration, as their text, and anything which can't
+// be marshalled as fmt prints it
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return redact(v)
+	case error:
+		return redact(v.Error())
+	case fmt.Stringer:
+		return redact(v.String())
+	}
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+// jsonLine returns the line of the JSON log for an entry, with the
+// caller it was written from unless that's "". Fields named time,
+// level, msg or caller are written as field.time and so on.
+func jsonLine(e Entry) []byte {
+	var b bytes.Buffer
+	member := func(key string, value interface{}) {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		} else {
+			b.WriteByte('{')
+		}
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+
+	member("time", e.Time.Format(time.RFC3339))
+	member("level", e.Level.String())
+	member("msg", e.Message)
+	if e.Caller != "" {
+		member("caller", e.Caller)
+	}
+	for _, key := range sortedKeys(e.Fields) {
+		name := key
+		switch key {
+		case "time", "level", "msg", "caller":
+			name = "field." + key
+		}
+		member(name, jsonValue(e.Fields[key]))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// FieldLogger writes messages to a Logger with fields of its own,
+// such as the URL a batch worker is fetching, as well as any given
+// each message
+type FieldLogger struct {
+	logger *Logger
+	fields Fields
+}
+
+// With returns a FieldLogger adding fields to each message
+func (l *Logger) With(fields Fields) *FieldLogger {
+	return (&FieldLogger{logger: l}).With(fields)
+}
+
+// With returns a FieldLogger of the default Logger; see Logger.With
+func With(fields Fields) *FieldLogger {
+	return std.With(fields)
+}
+
+// With returns a FieldLogger adding fields to those of l, which
+// they override
+func (l *FieldLogger) With(fields Fields) *FieldLogger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &FieldLogger{logger: l.logger, fields: merged}
+}
+
+// Write writes a message with the fields of l, as Write does
+func (l *FieldLogger) Write(messageType MessageType, format string, a ...interface{}) {
+	if l.logger.dropped(messageType) {
+		return
+	}
+	l.logger.log(l.logger.caller(1), messageType, fmt.Sprintf(format, a...), l.fields)
+}
+
+// WriteFields writes a message with the fields of l and fields,
+// which override them, as WriteFields does
+func (l *FieldLogger) WriteFields(messageType MessageType, message string, fields Fields) {
+	if l.logger.dropped(messageType) {
+		return
+	}
+	l.logger.log(l.logger.caller(1), messageType, message, l.With(fields).fields)
+}
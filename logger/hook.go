@@ -0,0 +1,68 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import "fmt"
+
+// hook is a function run for each message of minLevel and above
+type hook struct {
+	minLevel MessageType
+	fn       func(level MessageType, msg string)
+}
+
+// AddHook runs fn for each message of minLevel and above, e.g.
+// ERROR to send a notification or count failures, after it is
+// written. Hooks run in the order added, as each message is
+// written, whatever the level set by SetLevel; msg is the message
+// as written, with its fields and without the time. A panic in fn
+// is logged as a WARNING. fn shouldn't write messages of minLevel
+// and above itself, which would run it again.
+func (l *Logger) AddHook(minLevel MessageType, fn func(level MessageType, msg string)) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+	l.hooks = append(l.hooks, hook{minLevel: minLevel, fn: fn})
+}
+
+// hooked reports whether any hook runs for messages of level
+func (l *Logger) hooked(level MessageType) bool {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+	for _, h := range l.hooks {
+		if level >= h.minLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// runHooks runs the hooks for a message of level
+func (l *Logger) runHooks(level MessageType, msg string) {
+	// Copied, so that a hook may add another
+	l.hooksMu.Lock()
+	hooks := append([]hook(nil), l.hooks...)
+	l.hooksMu.Unlock()
+
+	for _, h := range hooks {
+		if level >= h.minLevel {
+			l.runHook(h, level, msg)
+		}
+	}
+}
+
+// runHook runs a hook, logging a panic in it. The WARNING doesn't
+// run the hooks, so a hook which always panics can't loop.
+func (l *Logger) runHook(h hook, level MessageType, msg string) {
+	defer func() {
+		if r := recover(); r != nil && WARNING >= l.minLevel {
+			l.writeMessage(WARNING, fmt.Sprintf("log hook panicked on a %s message: %v", level, r), nil, "")
+		}
+	}()
+	h.fn(level, msg)
+}
+
+// AddHook adds a hook to the default Logger; see Logger.AddHook
+func AddHook(minLevel MessageType, fn func(level MessageType, msg string)) {
+	std.AddHook(minLevel, fn)
+}
@@ -7,8 +7,17 @@
 package logger
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // MessageType holds the log level of the message.
@@ -31,121 +40,636 @@ const (
 	FATAL
 )
 
-var (
-	logger       []*log.Logger // slice of loggers for each level
-	stderrLogger *log.Logger   // stderr logger
-)
+// levelNames are the names of the levels, as String gives them
+var levelNames = []string{"info", "notice", "warning", "error", "fatal"}
 
-var (
-	logFileName string   = "log.txt" // holds name of log file
-	logToStderr bool     = false     // flag to indicate whether loggint to stderr
-	logFileFD   *os.File             // log file descriptor
-)
+// String implements the fmt.Stringer interface, naming the level
+// in lowercase, e.g. "warning"
+func (t MessageType) String() string {
+	if t < INFO || t > FATAL {
+		return fmt.Sprintf("MessageType(%d)", int(t))
+	}
+	return levelNames[t]
+}
+
+// ParseLevel returns the level named name, in any case: info,
+// notice, warning, error or fatal
+func ParseLevel(name string) (MessageType, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return MessageType(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown log level [%s]: expected %s", name, strings.Join(levelNames, ", "))
+}
+
+// stderrOff is the stderr level at which no messages print to stderr
+const stderrOff = FATAL + 1
+
+// Logger writes messages to a log file, or the output set by
+// SetOutput, and to stderr and any sinks as set. The package-level
+// functions use a default Logger; those made by New are separate
+// from it, with log files, levels and outputs of their own.
+type Logger struct {
+	stderrLogger *log.Logger // stderr logger, for warnings about the log itself
+
+	logFileName string      // holds name of log file, "" for DefaultLogFile
+	minLevel    MessageType // lowest level written at all
+	discard     bool        // flag to indicate whether the log file is unused
+	withCaller  bool        // set by SetReportCaller
+	exitOnFatal bool        // set by SetExitOnFatal
+	exitFunc    func(int)   // exits after a FATAL message, os.Exit by default
+	envWarning  string      // of invalid variables, set by WithEnvironment
+	envOnce     sync.Once   // warns of them once
+
+	mu          sync.Mutex // guards the fields below
+	disabled    bool       // set by Disable, nothing is logged at all
+	stderrOnly  bool       // set by SetStderrOnly, no file is touched
+	fileFailed  bool       // the log file could not be created, stderr is used
+	closed      bool       // set by Close, stderr is used
+	logFileFD   *os.File   // log file descriptor, nil until first written
+	logFileSize int64      // bytes in the log file, while open
+	rotateAt    int64      // size past which the log file is rotated (0 = never)
+	rotateKeep  int        // rotated log files kept, as cleanpg.log.1 and on
+	output      io.Writer  // set by SetOutput in place of the log file
+
+	sinksMu    sync.Mutex     // guards the sinks and their settings
+	sinks      []*sink        // fileSink, stderrSink, then those added
+	fileSink   *sink          // the log file, or the output set by SetOutput
+	stderrSink *sink          // stderr, as set by SetStderrLevel
+	sinkFailed map[*sink]bool // sinks already warned of a failed write
+
+	hooksMu sync.Mutex // guards hooks
+	hooks   []hook     // added by AddHook
+
+	dedupMu sync.Mutex // guards repeats
+	repeats repeats    // set up by SetDedup
+
+	prefixMu      sync.Mutex        // guards the prefixes below
+	prefix        string            // set by SetPrefix, before the level's
+	levelPrefixes [FATAL + 1]string // set by SetLevelPrefix, e.g. "INFO: "
+}
+
+// Option configures a Logger made by New
+type Option func(*Logger)
+
+// WithLogFile sets the name of the log file, as SetLogFile does
+func WithLogFile(fileName string) Option {
+	return func(l *Logger) { l.logFileName = fileName }
+}
+
+// WithOutput writes the log to w instead of a file, as SetOutput does
+func WithOutput(w io.Writer) Option {
+	return func(l *Logger) { l.output = w }
+}
+
+// WithLevel sets the lowest level of message written, as SetLevel does
+func WithLevel(level MessageType) Option {
+	return func(l *Logger) { l.SetLevel(level) }
+}
+
+// WithStderrLevel sets the lowest level of message which prints to
+// stderr, as SetStderrLevel does
+func WithStderrLevel(level MessageType) Option {
+	return func(l *Logger) { l.SetStderrLevel(level) }
+}
+
+// WithJSON writes the log as JSON lines, as SetJSON does
+func WithJSON() Option {
+	return func(l *Logger) { l.SetJSON(true) }
+}
+
+// New returns a Logger configured by opts. Without any it is as
+// the default Logger starts out, unless the environment configures
+// that (see WithEnvironment): writing INFO and above to the
+// DefaultLogFile, created with the first message, and nothing to
+// stderr.
+func New(opts ...Option) *Logger {
+	l := &Logger{
+		minLevel: INFO,
+		exitFunc: os.Exit,
+	}
+	l.fileSink = &sink{w: logWriter{l}}
+	l.stderrSink = &sink{w: stderrWriter{l}, minLevel: stderrOff, color: colors(ColorAuto)}
+	l.sinks = []*sink{l.fileSink, l.stderrSink}
+	for level := range l.levelPrefixes {
+		l.levelPrefixes[level] = strings.ToUpper(levelNames[level]) + ": "
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.initLoggers()
+	return l
+}
+
+// std is the default Logger, used by the package-level functions and
+// configured from the environment
+var std = New(WithEnvironment())
+
+// Default returns the default Logger, which the package-level
+// functions use
+func Default() *Logger {
+	return std
+}
+
+// DefaultLogFile returns the log file used unless SetLogFile names
+// another: cleanpg/cleanpg.log in the user's cache directory, e.g.
+// ~/.cache on Linux, or in the temporary directory if there's no
+// cache directory. Nothing is created until the first message, when
+// the temporary directory is used if the cache one can't be.
+func DefaultLogFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return tempLogFile()
+	}
+	return filepath.Join(dir, "cleanpg", "cleanpg.log")
+}
+
+// tempLogFile returns the DefaultLogFile in the temporary directory
+func tempLogFile() string {
+	return filepath.Join(os.TempDir(), "cleanpg", "cleanpg.log")
+}
+
+// fileName returns the name of the log file, settling on the
+// DefaultLogFile the first time if none is set; it is called with
+// mu held
+func (l *Logger) fileName() string {
+	if l.logFileName == "" {
+		l.logFileName = DefaultLogFile()
+	}
+	return l.logFileName
+}
 
 // createLogFile is called from the logWriter if the log file is not open
-func createLogFile(logFileFD *os.File) (*os.File, error) {
-	logFileFD, err := os.OpenFile(logFileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+func (l *Logger) createLogFile() (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(l.fileName()), 0755); err != nil {
+		// The default moves to the temporary directory
+		if l.fileName() != DefaultLogFile() || l.fileName() == tempLogFile() {
+			return nil, err
+		}
+		l.logFileName = tempLogFile()
+		if err := os.MkdirAll(filepath.Dir(l.logFileName), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	logFileFD, err := os.OpenFile(l.fileName(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
 
-	log.SetOutput(logFileFD)
+	// The standard logger follows the default one's file
+	if l == std {
+		log.SetOutput(logFileFD)
+	}
+
+	info, err := logFileFD.Stat()
+	if err != nil {
+		logFileFD.Close()
+		return nil, err
+	}
+	l.logFileSize = info.Size()
 
 	return logFileFD, nil
 }
 
+// rotateLogFile renames the log file to cleanpg.log.1, shifting older
+// ones up to cleanpg.log.<rotateKeep> and removing any beyond it, and
+// opens a new one. It is called with mu held, so no message is
+// written in between.
+func (l *Logger) rotateLogFile() error {
+	if err := l.logFileFD.Close(); err != nil {
+		return err
+	}
+	l.logFileFD = nil
+
+	rotated := func(n int) string { return l.fileName() + "." + strconv.Itoa(n) }
+	if l.rotateKeep == 0 {
+		if err := os.Remove(l.fileName()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		if err := os.Remove(rotated(l.rotateKeep)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for n := l.rotateKeep - 1; n >= 1; n-- {
+			if err := os.Rename(rotated(n), rotated(n+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(l.fileName(), rotated(1)); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	l.logFileFD, err = l.createLogFile()
+	return err
+}
+
+// logWriter writes to the output set by SetOutput or else the log
+// file, creating it with the first message so that nothing is
+// created until then. The loggers of every level share it.
+type logWriter struct {
+	l *Logger
+}
+
+// String names the log file's sink in warnings
+func (w logWriter) String() string {
+	return "the log output"
+}
+
+// Write implements the io.Writer interface
+func (w logWriter) Write(p []byte) (int, error) {
+	l := w.l
+	if l.discard {
+		return len(p), nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return l.stderrLogger.Writer().Write(p)
+	}
+	if l.output != nil {
+		return l.output.Write(p)
+	}
+	// A log file which can't be created, e.g. in a read-only
+	// directory, is given up for stderr rather than failing
+	if l.logFileFD == nil && !l.fileFailed {
+		var err error
+		l.logFileFD, err = l.createLogFile()
+		if err != nil {
+			l.giveUpLogFile("create", err)
+		}
+	}
+	if l.fileFailed {
+		return l.stderrLogger.Writer().Write(p)
+	}
+
+	// A message never goes to a rotated file in part, and one
+	// larger than the limit gets a file to itself
+	if l.rotateAt > 0 && l.logFileSize > 0 && l.logFileSize+int64(len(p)) > l.rotateAt {
+		if err := l.rotateLogFile(); err != nil {
+			l.giveUpLogFile("rotate", err)
+			return l.stderrLogger.Writer().Write(p)
+		}
+	}
+	n, err := l.logFileFD.Write(p)
+	l.logFileSize += int64(n)
+	if err != nil {
+		// e.g. the disk is full: the message isn't lost
+		l.giveUpLogFile("write to", err)
+		return l.stderrLogger.Writer().Write(p)
+	}
+	return n, nil
+}
+
+// giveUpLogFile logs to stderr from now on, until SetLogFile, as
+// the log file couldn't be created or written to; it is called
+// with mu held
+func (l *Logger) giveUpLogFile(action string, err error) {
+	l.fileFailed = true
+	fmt.Fprintf(l.stderrLogger.Writer(), "WARNING: could not %s log file [%s], logging to stderr: %s\n", action, l.fileName(), err)
+}
+
+// fileState reports whether SetStderrOnly is on, and whether the
+// log file was given up for stderr or the Logger closed, so that
+// the log file's sink prints there
+func (l *Logger) fileState() (stderrOnly, loggingToStderr bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stderrOnly, (l.closed || l.fileFailed && l.output == nil) && !l.discard
+}
+
 // LogToStderr determines whether log messages will print to stderr
 // as well as the log file
-func LogToStderr(flag bool) {
-	logToStderr = flag
+func (l *Logger) LogToStderr(flag bool) {
+	if flag {
+		l.SetStderrLevel(INFO)
+	} else {
+		l.SetStderrLevel(stderrOff)
+	}
+}
+
+// SetStderrLevel sets the lowest level of message which prints to
+// stderr as well as the log file, e.g. FATAL for failures only;
+// LogToStderr(true) is the same as SetStderrLevel(INFO)
+func (l *Logger) SetStderrLevel(level MessageType) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	l.stderrSink.minLevel = level
+}
+
+// SetLevel sets the lowest level of message written, to both the
+// log file and stderr, e.g. WARNING to drop INFO and NOTICE. FATAL
+// messages are always written.
+func (l *Logger) SetLevel(level MessageType) {
+	if level > FATAL {
+		level = FATAL
+	}
+	l.minLevel = level
+}
+
+// SetRotation rotates the log file when a message would take it
+// past maxBytes: it is renamed to cleanpg.log.1, older ones shift
+// up to cleanpg.log.<keep> and beyond that are removed, and a new one is
+// started. With keep 0 the old log is removed; maxBytes 0, the
+// default, never rotates.
+func (l *Logger) SetRotation(maxBytes int64, keep int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	l.rotateAt, l.rotateKeep = maxBytes, keep
+}
+
+// SetDiscard determines whether log messages are discarded rather
+// than written to the log file, which is then never created.
+// Messages still print to stderr as set by LogToStderr.
+func (l *Logger) SetDiscard(flag bool) {
+	l.discard = flag
+}
+
+// Disable turns logging off entirely, to the log file, any output
+// set by SetOutput and stderr, for a library user which wants no
+// messages; call it before any are written to create no log file.
+// SetLogFile or SetOutput turn logging back on.
+func (l *Logger) Disable() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Nothing is logged, so there's nothing to do about an error
+	l.closeLogFile()
+	l.disabled = true
+}
+
+// SetLogFile sets the name of the log file, which is created
+// along with any missing directories when first written.
+// If not set, or set to "", it is the DefaultLogFile; "log.txt"
+// logs to the current directory instead. It replaces
+// any output set by SetOutput. An error closing the previous log
+// file is returned, the new one being set all the same.
+func (l *Logger) SetLogFile(fileName string) error {
+	l.mu.Lock()
+	err := l.closeLogFile()
+	l.output = nil
+	l.disabled, l.stderrOnly, l.fileFailed, l.closed = false, false, false, false
+	l.logFileName = fileName
+	l.mu.Unlock()
+	l.initLoggers()
+	return err
+}
+
+// SetOutput writes log messages to w, e.g. a bytes.Buffer or
+// ioutil.Discard, instead of any log file, closing the log file
+// if it is open; SetLogFile goes back to a file. A nil w is the
+// same as SetLogFile with the current name. An error closing the
+// log file is returned, w being set all the same.
+func (l *Logger) SetOutput(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.closeLogFile()
+	l.output = w
+	l.disabled, l.stderrOnly, l.closed = false, false, false
+	return err
 }
 
-// SetLogFile sets the name of the log file.
-// If not set, the default filename is "log.txt"
-func SetLogFile(fileName string) {
-	closeLogFile()
-	logFileName = fileName
-	initLoggers()
+// SetStderrOnly determines whether messages print to stderr alone,
+// at every level written, for a pipeline such as cleanpg url |
+// pandoc: no log file is opened, truncated or removed, and no
+// output set by SetOutput written. SetLogFile or SetOutput turn it
+// off. An error closing a log file already open is returned.
+func (l *Logger) SetStderrOnly(flag bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stderrOnly = flag
+	if flag {
+		return l.closeLogFile()
+	}
+	return nil
 }
 
-// Truncate is used to truncate the log file to zero length
-func Truncate() error {
+// Close flushes and closes the log file, removing it if nothing
+// was written, and closes any sinks such as syslog. Messages
+// written after are printed to stderr, until SetLogFile or
+// SetOutput. Closing again does nothing.
+func (l *Logger) Close() error {
+	l.flushRepeats(0)
+	l.StopSinks()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.stderrOnly {
+		return nil
+	}
+	if l.logFileFD == nil && l.output == nil && !l.discard {
+		if l.logFileName == "" {
+			// Neither truncated nor written, so not even resolved
+			return nil
+		}
+		// Truncated but never written to since
+		if info, err := os.Stat(l.fileName()); err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+			if err := os.Remove(l.fileName()); err != nil {
+				return fmt.Errorf("could not remove [%s]: %w", l.fileName(), err)
+			}
+		}
+		return nil
+	}
+	if l.logFileFD != nil {
+		// Sync fails for what holds nothing back, e.g. a pipe
+		if err := l.logFileFD.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+			l.closeLogFile()
+			return fmt.Errorf("could not flush log file [%s]: %w", l.fileName(), err)
+		}
+	}
+	return l.closeLogFile()
+}
+
+// Truncate is used to truncate the log file to zero length. If it
+// can't be, the error is returned and messages are appended to it.
+func (l *Logger) Truncate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.discard || l.disabled || l.stderrOnly || l.output != nil {
+		return nil
+	}
+
 	// If file doesn't exist, no need to truncate
-	_, err := os.Stat(logFileName)
+	_, err := os.Stat(l.fileName())
 	if os.IsNotExist(err) {
 		return nil
 	}
 
 	// Truncate it
-	err = os.Truncate(logFileName, 0)
+	err = os.Truncate(l.fileName(), 0)
 	if err != nil {
-		log.Fatalf("Could not truncate log file: [%s]", err)
 		return err
 	}
+	l.logFileSize = 0
 
 	return nil
 }
 
-// Write is a function which writes a variable length string message to the log file
-func Write(messageType MessageType, format string, a ...interface{}) {
+// userinfoPassword matches the password in a URL's userinfo,
+// and cookieHeader the value of a Cookie or Set-Cookie header
+var (
+	userinfoPassword = regexp.MustCompile(`(://[^/?#@:\s]*):[^/?#@\s]*@`)
+	cookieHeader     = regexp.MustCompile(`(?i)(\b(?:set-)?cookie:\s*)[^\r\n"\]]+`)
+)
 
-	if logToStderr {
-		stderrLogger.SetPrefix(logger[messageType].Prefix())
-		stderrLogger.Printf(format, a...)
+// redact masks any URL passwords in a message, as url.Redacted
+// does, and any cookies sent or received
+func redact(message string) string {
+	message = userinfoPassword.ReplaceAllString(message, "$1:xxxxx@")
+	return cookieHeader.ReplaceAllString(message, "${1}xxxxx")
+}
+
+// Write is a function which writes a variable length string message to the log file.
+// Passwords in URLs and cookie headers are masked. Messages below the
+// level set by SetLevel are dropped, though hooks still run for them.
+func (l *Logger) Write(messageType MessageType, format string, a ...interface{}) {
+	if l.dropped(messageType) {
+		return
 	}
+	l.log(l.caller(1), messageType, fmt.Sprintf(format, a...), nil)
+}
 
-	logger[messageType].Printf(format, a...)
+// dropped reports whether nothing is done with a message of
+// messageType: it's neither written nor hooked
+func (l *Logger) dropped(messageType MessageType) bool {
+	if messageType == FATAL && l.exitOnFatal {
+		return false
+	}
+	return l.isDisabled() || (messageType < l.minLevel && !l.hooked(messageType))
+}
 
+// log writes a message and its fields unless SetLevel drops it,
+// then runs the hooks, and exits after a FATAL one if SetExitOnFatal
+// is on. caller is where it was written from, as the caller method
+// gives it.
+func (l *Logger) log(caller string, messageType MessageType, message string, fields Fields) {
+	if !l.isDisabled() {
+		l.warnEnvironment()
+		if messageType >= l.minLevel && !l.dedupWrite(messageType, message, fields, caller) {
+			l.writeMessage(messageType, message, fields, caller)
+		}
+		l.runHooks(messageType, redact(message+formatFields(fields)))
+	}
+	if messageType == FATAL && l.exitOnFatal {
+		l.exit()
+	}
 }
 
-// initLoggers initializes a log file and loggers for each level
-func initLoggers() {
+// writeMessage writes a message and its fields, if any, to the
+// sinks: the log file, stderr and any added, with the caller it
+// was written from if that is reported
+func (l *Logger) writeMessage(messageType MessageType, message string, fields Fields, caller string) {
+	// The prefixes as they are now, for every sink alike
+	prefix, levelPrefix := l.prefixes(messageType)
+	l.writeSinks(Entry{Level: messageType, Time: time.Now(), Message: redact(message), Fields: fields, Caller: caller}, prefix, levelPrefix)
+}
 
-	var err error
-	logFileFD, err = createLogFile(logFileFD)
-	if err != nil {
-		log.Fatalf("Could not create log file: [%s]", err)
-	}
+// isDisabled reports whether Disable turned logging off
+func (l *Logger) isDisabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.disabled
+}
 
+// initLoggers initializes the logger of stderr; no file is
+// created until the first message is written, so importing the
+// package leaves none behind
+func (l *Logger) initLoggers() {
 	// Logger flags
-	const lflags int = log.Ldate | log.Ltime | log.Lmsgprefix
-
-	// Build slice of loggers for each level
-	logger = append(logger, log.New(logFileFD, "INFO: ", lflags))
-	logger = append(logger, log.New(logFileFD, "NOTICE: ", lflags))
-	logger = append(logger, log.New(logFileFD, "WARNING: ", lflags))
-	logger = append(logger, log.New(logFileFD, "ERROR: ", lflags))
-	logger = append(logger, log.New(logFileFD, "FATAL: ", lflags))
+	const lflags int = log.Ldate | log.Ltime
 
 	// Special logger to handle output to stderr
-	stderrLogger = log.New(os.Stderr, "", lflags)
+	l.stderrLogger = log.New(os.Stderr, "", lflags)
 }
 
-// closeLogFile closes the current fd and removes the logfile if zero length
-func closeLogFile() {
-	// Close it
-	if err := logFileFD.Close(); err != nil {
-		log.Fatalf("Could not close log file [%s]: [%s]", logFileName, err)
-		return
+// closeLogFile closes the current fd and removes the logfile if
+// zero length; the fd is let go of even if that fails
+func (l *Logger) closeLogFile() error {
+	// Nothing to do if it was never written
+	if l.logFileFD == nil {
+		return nil
 	}
+	defer func() { l.logFileFD = nil }()
 
-	// Get the length of the file via stat
-	info, err := os.Stat(logFileName)
+	// Get the length of the file via stat, before
+	// closing as the name may no longer reach it
+	info, err := l.logFileFD.Stat()
 	if err != nil {
-		log.Fatalf("Could not stat log file [%s]: [%s]", logFileName, err)
-		return
+		l.logFileFD.Close()
+		return fmt.Errorf("could not stat log file [%s]: %w", l.fileName(), err)
+	}
+
+	// Close it
+	if err := l.logFileFD.Close(); err != nil {
+		return fmt.Errorf("could not close log file [%s]: %w", l.fileName(), err)
 	}
 
 	// If it's zero length, remove it
 	if info.Size() == 0 {
-		err := os.Remove(logFileName)
-		if err != nil {
-			log.Fatalf("Could not remove [%s]: [%s]", logFileName, err)
-			return
+		err := os.Remove(l.fileName())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove [%s]: %w", l.fileName(), err)
 		}
 	}
+	return nil
 }
 
-func init() {
-	initLoggers()
+// The package-level functions act on the default Logger
+
+// Write writes a message to the default Logger; see Logger.Write
+func Write(messageType MessageType, format string, a ...interface{}) {
+	if std.dropped(messageType) {
+		return
+	}
+	std.log(std.caller(1), messageType, fmt.Sprintf(format, a...), nil)
 }
+
+// LogToStderr determines whether the default Logger prints to stderr
+func LogToStderr(flag bool) { std.LogToStderr(flag) }
+
+// SetStderrLevel sets the default Logger's stderr level; see
+// Logger.SetStderrLevel
+func SetStderrLevel(level MessageType) { std.SetStderrLevel(level) }
+
+// SetLevel sets the default Logger's level; see Logger.SetLevel
+func SetLevel(level MessageType) { std.SetLevel(level) }
+
+// SetRotation sets the rotation of the default Logger's log file;
+// see Logger.SetRotation
+func SetRotation(maxBytes int64, keep int) { std.SetRotation(maxBytes, keep) }
+
+// SetDiscard determines whether the default Logger discards
+// messages; see Logger.SetDiscard
+func SetDiscard(flag bool) { std.SetDiscard(flag) }
+
+// Disable turns the default Logger off; see Logger.Disable
+func Disable() { std.Disable() }
+
+// SetLogFile sets the default Logger's log file; see Logger.SetLogFile
+func SetLogFile(fileName string) error { return std.SetLogFile(fileName) }
+
+// SetOutput sets the default Logger's output; see Logger.SetOutput
+func SetOutput(w io.Writer) error { return std.SetOutput(w) }
+
+// SetStderrOnly determines whether the default Logger prints to
+// stderr alone; see Logger.SetStderrOnly
+func SetStderrOnly(flag bool) error { return std.SetStderrOnly(flag) }
+
+// Truncate truncates the default Logger's log file; see Logger.Truncate
+func Truncate() error { return std.Truncate() }
+
+// Close closes the default Logger; see Logger.Close
+func Close() error { return std.Close() }
@@ -0,0 +1,49 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+// SetPrefix writes prefix before the level of each message in text,
+// to the log file and stderr alike, e.g. "cleanpg/fetch: " for the
+// component logging; it is "" by default. JSON lines have none.
+func (l *Logger) SetPrefix(prefix string) {
+	l.prefixMu.Lock()
+	defer l.prefixMu.Unlock()
+	l.prefix = prefix
+}
+
+// SetLevelPrefix sets the tag written for messages of level, after
+// any prefix set by SetPrefix, e.g. "warning: " for lowercase tags;
+// by default it's the level's name in uppercase and ": ", as in
+// "WARNING: ". On a terminal's stderr the tag, without the spaces
+// after it, is colored as the level is.
+func (l *Logger) SetLevelPrefix(level MessageType, prefix string) {
+	if level < INFO || level > FATAL {
+		return
+	}
+	l.prefixMu.Lock()
+	defer l.prefixMu.Unlock()
+	l.levelPrefixes[level] = prefix
+}
+
+// WithPrefix writes a prefix before each message, as SetPrefix does
+func WithPrefix(prefix string) Option {
+	return func(l *Logger) { l.prefix = prefix }
+}
+
+// prefixes returns the prefix and the level's tag written before a
+// message of level
+func (l *Logger) prefixes(level MessageType) (prefix, levelPrefix string) {
+	l.prefixMu.Lock()
+	defer l.prefixMu.Unlock()
+	return l.prefix, l.levelPrefixes[level]
+}
+
+// SetPrefix sets the prefix of the default Logger's messages; see
+// Logger.SetPrefix
+func SetPrefix(prefix string) { std.SetPrefix(prefix) }
+
+// SetLevelPrefix sets the tag of the default Logger's messages of
+// level; see Logger.SetLevelPrefix
+func SetLevelPrefix(level MessageType, prefix string) { std.SetLevelPrefix(level, prefix) }
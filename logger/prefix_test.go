@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSetPrefix(t *testing.T) {
+	var out, stderr strings.Builder
+	l := New(WithOutput(&out), WithStderrLevel(INFO), WithPrefix("cleanpg/fetch: "), WithColor(ColorOff))
+	l.stderrLogger.SetOutput(&stderr)
+	for level := INFO; level <= FATAL; level++ {
+		l.SetLevelPrefix(level, level.String()+": ")
+	}
+	l.SetLevelPrefix(FATAL+1, "ignored: ")
+
+	l.Write(INFO, "reading [page.html]")
+	l.WriteFields(WARNING, "slow", Fields{"elapsed": "2s"})
+	l.SetPrefix("")
+	l.SetLevelPrefix(ERROR, "")
+	l.Write(ERROR, "bare")
+
+	expect := []string{
+		"cleanpg/fetch: info: reading [page.html]",
+		"cleanpg/fetch: warning: slow elapsed=2s",
+		"bare",
+	}
+	for name, got := range map[string]string{"log": out.String(), "stderr": stderr.String()} {
+		lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+		if len(lines) != len(expect) {
+			t.Fatalf("%s: expected %d lines, got %q", name, len(expect), got)
+		}
+		for i, line := range lines {
+			// Past the date and time
+			if message := strings.SplitN(line, " ", 3)[2]; message != expect[i] {
+				t.Errorf("%s: expected %q, got %q", name, expect[i], message)
+			}
+		}
+	}
+
+	// The level's tag is colored as the level is
+	stderr.Reset()
+	l.SetColor(ColorOn)
+	l.SetLevelPrefix(WARNING, "warn:  ")
+	l.Write(WARNING, "colored")
+	if got := stderr.String(); !strings.HasSuffix(got, " \x1b[33mwarn:\x1b[0m  colored\n") {
+		t.Errorf("Expected the custom tag colored, got %q", got)
+	}
+}
+
+func TestSetPrefix_Concurrent(t *testing.T) {
+	var out, stderr syncBuilder
+	l := New(WithOutput(&out), WithStderrLevel(INFO), WithColor(ColorOff))
+	l.stderrLogger.SetOutput(&stderr)
+
+	var wg sync.WaitGroup
+	for level := INFO; level <= FATAL; level++ {
+		wg.Add(1)
+		go func(level MessageType) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				l.Write(level, "%s %d", level, i)
+			}
+		}(level)
+	}
+	wg.Wait()
+
+	// Each line has the tag of its own level
+	for name, got := range map[string]string{"log": out.String(), "stderr": stderr.String()} {
+		lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+		if len(lines) != 500 {
+			t.Errorf("%s: expected 500 lines, got %d", name, len(lines))
+		}
+		for _, line := range lines {
+			message := strings.SplitN(line, " ", 3)[2]
+			tag := strings.SplitN(message, ": ", 2)
+			if len(tag) != 2 || !strings.HasPrefix(tag[1], strings.ToLower(tag[0])+" ") {
+				t.Errorf("%s: expected the tag of the message's level, got %q", name, line)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Entry is a message as sinks are given it
+type Entry struct {
+	Level   MessageType
+	Time    time.Time
+	Message string // with any passwords and cookies masked
+	Fields  Fields // nil for none
+	Caller  string // file and line written from, if SetReportCaller is on
+}
+
+// String returns the entry as written in text, without its time
+// and level: the message, its fields and where it was written from
+func (e Entry) String() string {
+	text := redact(e.Message + formatFields(e.Fields))
+	if e.Caller != "" {
+		text += " (" + e.Caller + ")"
+	}
+	return text
+}
+
+// Sink is an output given each message as an Entry rather than a
+// line of text, such as syslog or a Capture. A Sink which is an
+// io.Closer is closed by StopSinks.
+type Sink interface {
+	// WriteEntry writes a message. It may be called from several
+	// goroutines, though not at once for one Logger. An error is
+	// warned of once, the message still going to the other sinks.
+	WriteEntry(e Entry) error
+}
+
+// sink is an output messages fan out to: the log file and stderr,
+// which every Logger has, and those added by AddSink. Its settings
+// are guarded by the Logger's sinksMu.
+type sink struct {
+	w        io.Writer   // written lines of text or JSON, unless entries is set
+	entries  Sink        // given each Entry instead
+	minLevel MessageType // lowest level written to it
+	json     bool        // JSON lines rather than text
+	color    bool        // the levels colored, for stderr
+}
+
+// SinkOption configures a sink added by AddSink
+type SinkOption func(*sink)
+
+// SinkLevel writes only messages of level and above to the sink;
+// by default it gets every message the Logger writes
+func SinkLevel(level MessageType) SinkOption {
+	return func(s *sink) { s.minLevel = level }
+}
+
+// SinkJSON writes JSON lines to the sink, as SetJSON does to the
+// log file, rather than text
+func SinkJSON() SinkOption {
+	return func(s *sink) { s.json = true }
+}
+
+// SinkHandle is a sink added by AddSink, to remove it by
+type SinkHandle struct {
+	s *sink
+}
+
+// AddSink writes messages to w as well as the log file and stderr,
+// e.g. os.Stdout, a second file, or a Capture, as configured by opts:
+// at the Logger's level and above and in text by default. A w which
+// implements Sink is given each Entry instead, whatever the format.
+// Messages dropped by SetLevel or deduplication don't reach it.
+func (l *Logger) AddSink(w io.Writer, opts ...SinkOption) SinkHandle {
+	s := &sink{w: w}
+	if entries, ok := w.(Sink); ok {
+		s.entries = entries
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	l.addSink(s)
+	return SinkHandle{s}
+}
+
+// addSink adds s to the sinks, after the log file and stderr
+func (l *Logger) addSink(s *sink) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	l.sinks = append(l.sinks, s)
+}
+
+// RemoveSink writes no more to the sink h, without closing it;
+// removing one already removed does nothing
+func (l *Logger) RemoveSink(h SinkHandle) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	for i, s := range l.sinks {
+		if s == h.s && s != l.fileSink && s != l.stderrSink {
+			l.sinks = append(l.sinks[:i:i], l.sinks[i+1:]...)
+			delete(l.sinkFailed, s)
+			return
+		}
+	}
+}
+
+// StopSinks closes the Sinks which are io.Closers, such as syslog,
+// and writes no more to any output but the log file and stderr
+func (l *Logger) StopSinks() {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	for _, s := range l.sinks[2:] {
+		if c, ok := s.entries.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	l.sinks, l.sinkFailed = l.sinks[:2:2], nil
+}
+
+// writeSinks writes an entry to each sink of its level, with the
+// prefixes given; the log file comes first, so that a message isn't
+// printed to stderr twice when the file is given up for it. The
+// first time a sink fails, it's warned of on stderr.
+func (l *Logger) writeSinks(e Entry, prefix, levelPrefix string) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	text := e.String()
+	stderrOnly, loggingToStderr := l.fileState()
+	for _, s := range l.sinks {
+		switch {
+		case s == l.fileSink && stderrOnly:
+			continue
+		case s == l.stderrSink && stderrOnly:
+			// Every message, in place of the log file
+		case e.Level < s.minLevel, s == l.stderrSink && loggingToStderr:
+			continue
+		}
+
+		var err error
+		switch {
+		case s.entries != nil:
+			err = s.entries.WriteEntry(e)
+		case s.json:
+			_, err = s.w.Write(jsonLine(e))
+		default:
+			level := levelPrefix
+			if s.color {
+				level = colorLevel(e.Level, levelPrefix)
+			}
+			_, err = io.WriteString(s.w, e.Time.Format("2006/01/02 15:04:05 ")+prefix+level+text+"\n")
+		}
+
+		if err != nil && !l.sinkFailed[s] {
+			if l.sinkFailed == nil {
+				l.sinkFailed = make(map[*sink]bool)
+			}
+			l.sinkFailed[s] = true
+			var name interface{} = s.w
+			if s.entries != nil {
+				name = s.entries
+			}
+			if stringer, ok := name.(fmt.Stringer); ok {
+				name = stringer.String()
+			} else {
+				name = fmt.Sprintf("%T", name)
+			}
+			fmt.Fprintf(l.stderrLogger.Writer(), "WARNING: could not write to %s, logging to the other outputs: %s\n", name, err)
+		}
+	}
+}
+
+// stderrWriter writes to the stderr logger's output, wherever that is set
+type stderrWriter struct {
+	l *Logger
+}
+
+// Write implements the io.Writer interface
+func (w stderrWriter) Write(p []byte) (int, error) {
+	return w.l.stderrLogger.Writer().Write(p)
+}
+
+// AddSink adds a sink to the default Logger; see Logger.AddSink
+func AddSink(w io.Writer, opts ...SinkOption) SinkHandle { return std.AddSink(w, opts...) }
+
+// RemoveSink removes a sink from the default Logger; see
+// Logger.RemoveSink
+func RemoveSink(h SinkHandle) { std.RemoveSink(h) }
+
+// StopSinks stops the default Logger's sinks; see Logger.StopSinks
+func StopSinks() { std.StopSinks() }
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAddSink(t *testing.T) {
+	var out, stderr, text, json strings.Builder
+	l := New(WithOutput(&out), WithStderrLevel(WARNING), WithColor(ColorOff))
+	l.stderrLogger.SetOutput(&stderr)
+	c := NewCapture()
+	l.AddSink(&text)
+	jsonSink := l.AddSink(&json, SinkLevel(WARNING), SinkJSON())
+	l.AddSink(c, SinkLevel(ERROR))
+
+	l.Write(INFO, "reading")
+	l.WriteFields(WARNING, "slow", Fields{"elapsed": "2s"})
+	l.Write(ERROR, "cannot write")
+
+	for _, test := range []struct {
+		name, got string
+		expect    []string
+	}{
+		{"log file", out.String(), []string{"INFO: reading", "WARNING: slow elapsed=2s", "ERROR: cannot write"}},
+		{"stderr", stderr.String(), []string{"WARNING: slow elapsed=2s", "ERROR: cannot write"}},
+		{"text sink", text.String(), []string{"INFO: reading", "WARNING: slow elapsed=2s", "ERROR: cannot write"}},
+	} {
+		if got := messagesOf(test.got); strings.Join(got, "\n") != strings.Join(test.expect, "\n") {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expect, got)
+		}
+	}
+	if got := json.String(); strings.Count(got, "\n") != 2 || !strings.Contains(got, `"level":"warning","msg":"slow","elapsed":"2s"}`) ||
+		!strings.Contains(got, `"level":"error","msg":"cannot write"}`) {
+		t.Errorf("JSON sink: expected the WARNING and ERROR as JSON, got %q", got)
+	}
+	if entries := c.Entries(); len(entries) != 1 || entries[0].Level != ERROR || entries[0].Message != "cannot write" {
+		t.Errorf("Capture: expected only the ERROR, got %v", entries)
+	}
+
+	// A removed sink gets no more; the others go on
+	l.RemoveSink(jsonSink)
+	l.RemoveSink(jsonSink)
+	json.Reset()
+	l.Write(FATAL, "stopped")
+	if json.Len() > 0 {
+		t.Errorf("Expected nothing written to the removed sink, got %q", json.String())
+	}
+	if last, _ := c.LastEntry(); last.Message != "stopped" || !strings.Contains(text.String(), "FATAL: stopped") {
+		t.Errorf("Expected the FATAL message in the other sinks, got %+v and %q", last, text.String())
+	}
+
+	// StopSinks leaves the log file and stderr
+	l.StopSinks()
+	l.Write(ERROR, "after")
+	if strings.Contains(text.String(), "after") || !strings.Contains(out.String(), "ERROR: after") || !strings.Contains(stderr.String(), "ERROR: after") {
+		t.Errorf("Expected only the log file and stderr written after StopSinks, got %q, %q and %q", text.String(), out.String(), stderr.String())
+	}
+}
+
+func TestAddSink_Failing(t *testing.T) {
+	var stderr strings.Builder
+	l := New(WithOutput(ioutil.Discard))
+	l.stderrLogger.SetOutput(&stderr)
+	c := NewCapture()
+	l.AddSink(failingWriter{})
+	l.AddSink(c)
+
+	l.Write(INFO, "first")
+	l.Write(INFO, "second")
+	if got := stderr.String(); strings.Count(got, "WARNING: could not write to logger.failingWriter") != 1 || !strings.Contains(got, "disk full") {
+		t.Errorf("Expected the failing sink warned of once, got %q", got)
+	}
+	if n := len(c.Entries()); n != 2 {
+		t.Errorf("Expected the other sinks written all the same, got %d entries", n)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink writes messages to syslog at the severity of
+// their level
+type syslogSink struct {
+	w    *syslog.Writer
+	addr string
+}
+
+// SetSyslog logs to syslog as well as the log file, tagging the
+// messages with tag: at addr over network, e.g. "udp" and
+// "host:514" or "unixgram" and "/dev/log", or the local syslog
+// (the journal, where systemd runs) if network is "". To log to
+// syslog only, call SetDiscard(true) too. An error is returned if
+// syslog can't be reached; later failures to write are warned of
+// once on stderr.
+func (l *Logger) SetSyslog(network, addr, tag string) error {
+	w, err := syslog.Dial(network, addr, syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		return err
+	}
+	if network == "" {
+		addr = "the local syslog"
+	}
+	l.addSink(&sink{entries: &syslogSink{w: w, addr: addr}})
+	return nil
+}
+
+// SetSyslog logs the default Logger to syslog as well; see
+// Logger.SetSyslog
+func SetSyslog(network, addr, tag string) error {
+	return std.SetSyslog(network, addr, tag)
+}
+
+// WriteEntry implements the Sink interface; INFO to ERROR are the
+// syslog severities of the same names, and FATAL is critical
+func (s *syslogSink) WriteEntry(e Entry) error {
+	message := e.String()
+	switch e.Level {
+	case NOTICE:
+		return s.w.Notice(message)
+	case WARNING:
+		return s.w.Warning(message)
+	case ERROR:
+		return s.w.Err(message)
+	case FATAL:
+		return s.w.Crit(message)
+	}
+	return s.w.Info(message)
+}
+
+// Close implements the io.Closer interface
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// String names the sink in warnings
+func (s *syslogSink) String() string {
+	return fmt.Sprintf("syslog [%s]", s.addr)
+}
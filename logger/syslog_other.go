@@ -0,0 +1,20 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+//go:build windows || plan9
+// +build windows plan9
+
+package logger
+
+import "errors"
+
+// SetSyslog returns an error: there is no syslog on this platform
+func (l *Logger) SetSyslog(network, addr, tag string) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+// SetSyslog returns an error: there is no syslog on this platform
+func SetSyslog(network, addr, tag string) error {
+	return std.SetSyslog(network, addr, tag)
+}
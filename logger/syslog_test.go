@@ -0,0 +1,106 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenSyslog listens for syslog messages on a unix socket in a
+// temporary directory, returning its path
+func listenSyslog(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Could not listen on a unix socket: %v", err)
+	}
+	return conn, path
+}
+
+// readSyslog returns the severity and text of the next message
+func readSyslog(t *testing.T, conn *net.UnixConn) (int, string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Could not read a syslog message: %v", err)
+	}
+	packet := string(buf[:n])
+	end := strings.IndexByte(packet, '>')
+	if !strings.HasPrefix(packet, "<") || end < 0 {
+		t.Fatalf("Expected a priority, got %q", packet)
+	}
+	priority, err := strconv.Atoi(packet[1:end])
+	if err != nil {
+		t.Fatalf("Expected a priority, got %q", packet)
+	}
+	return priority % 8, packet
+}
+
+func TestSetSyslog(t *testing.T) {
+	conn, path := listenSyslog(t)
+	defer conn.Close()
+	logFile := filepath.Join(t.TempDir(), "log.txt")
+	SetLogFile(logFile)
+	defer SetLogFile("")
+
+	if err := SetSyslog("unixgram", path, "cleanpg-test"); err != nil {
+		t.Fatalf("Could not set syslog: %v", err)
+	}
+	defer StopSinks()
+
+	// The severities of RFC 5424
+	for level, severity := range map[MessageType]int{INFO: 6, NOTICE: 5, WARNING: 4, ERROR: 3, FATAL: 2} {
+		Write(level, "%s to syslog", level)
+		got, packet := readSyslog(t, conn)
+		if got != severity {
+			t.Errorf("%s: expected severity %d, got %d in %q", level, severity, got, packet)
+		}
+		if expect := "cleanpg-test"; !strings.Contains(packet, expect) || !strings.Contains(packet, level.String()+" to syslog") {
+			t.Errorf("%s: expected the tag and message, got %q", level, packet)
+		}
+	}
+
+	if err := SetSyslog("unixgram", filepath.Join(t.TempDir(), "missing.sock"), "cleanpg-test"); err == nil {
+		t.Error("Expected an error for a missing socket")
+	}
+}
+
+// Once syslog is gone, messages still reach the log file, with
+// one warning
+func TestSetSyslog_Failed(t *testing.T) {
+	conn, path := listenSyslog(t)
+	logFile := filepath.Join(t.TempDir(), "log.txt")
+	SetLogFile(logFile)
+	defer SetLogFile("")
+	var stderr strings.Builder
+	std.stderrLogger.SetOutput(&stderr)
+
+	if err := SetSyslog("unixgram", path, "cleanpg-test"); err != nil {
+		t.Fatalf("Could not set syslog: %v", err)
+	}
+	defer StopSinks()
+	conn.Close()
+
+	Write(ERROR, "first")
+	Write(ERROR, "second")
+	if got := strings.Count(stderr.String(), "could not write to syslog"); got != 1 {
+		t.Errorf("Expected one warning, got %q", stderr.String())
+	}
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Could not read log file: %v", err)
+	}
+	if got := string(data); !strings.Contains(got, "ERROR: first") || !strings.Contains(got, "ERROR: second") {
+		t.Errorf("Expected both messages in the log file, got %q", got)
+	}
+}
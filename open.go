@@ -0,0 +1,64 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"github.com/scu/cleanpg/logger"
+)
+
+// opener shows a rendered file to the user
+type opener interface {
+	open(fileName string) error
+}
+
+// browser opens the output for --open
+var browser opener = execOpener{goos: runtime.GOOS, lookPath: exec.LookPath}
+
+// execOpener runs the platform's command for opening a file
+// in its default application, without waiting for it
+type execOpener struct {
+	goos     string                            // as runtime.GOOS
+	lookPath func(file string) (string, error) // as exec.LookPath
+}
+
+// command returns the command line opening fileName
+func (o execOpener) command(fileName string) []string {
+	switch o.goos {
+	case "darwin":
+		return []string{"open", fileName}
+	case "windows":
+		return []string{"rundll32", "url.dll,FileProtocolHandler", fileName}
+	}
+	return []string{"xdg-open", fileName}
+}
+
+// open implements the opener interface
+func (o execOpener) open(fileName string) error {
+	command := o.command(fileName)
+	path, err := o.lookPath(command[0])
+	if err != nil {
+		return fmt.Errorf("%s is needed to open [%s]: %s", command[0], fileName, err)
+	}
+	cmd := exec.Command(path, command[1:]...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// showOutput opens fileName with the browser for --open,
+// returning the exit code
+func showOutput(fileName string) int {
+	if err := browser.open(fileName); err != nil {
+		logger.Write(logger.FATAL, "could not open the output: %s", err)
+		return exitOutput
+	}
+	logger.Write(logger.INFO, "opened [%s]", fileName)
+	return 0
+}
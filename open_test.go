@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// recordingOpener records the files it's asked to open
+type recordingOpener struct {
+	files []string
+	err   error
+}
+
+func (o *recordingOpener) open(fileName string) error {
+	o.files = append(o.files, fileName)
+	return o.err
+}
+
+func TestCleanpgMain_Open(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Opened</h1></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	defer func(b opener) { browser = b }(browser)
+	rec := &recordingOpener{}
+	browser = rec
+
+	// The output file is opened once it's written
+	outputFile := filepath.Join(t.TempDir(), "out.html")
+	if code := cleanpgMain([]string{"cleanpg", "--open", "-o", outputFile, ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if !reflect.DeepEqual(rec.files, []string{outputFile}) {
+		t.Errorf("Expected %q opened, got %q", outputFile, rec.files)
+	}
+
+	// Without an output file a temporary one is opened
+	rec.files = nil
+	if code := cleanpgMain([]string{"cleanpg", "--open", "-o", "", ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if len(rec.files) != 1 || filepath.Dir(rec.files[0]) != filepath.Clean(os.TempDir()) ||
+		!strings.HasPrefix(filepath.Base(rec.files[0]), "cleanpg") {
+		t.Fatalf("Expected a temporary file opened, got %q", rec.files)
+	}
+	defer os.Remove(rec.files[0])
+	if data, err := ioutil.ReadFile(rec.files[0]); err != nil || !strings.Contains(string(data), "Opened") {
+		t.Errorf("Expected the page in the temporary file, got %q, %v", data, err)
+	}
+
+	// Only HTML is opened, and only for a single page
+	rec.files = nil
+	for _, args := range [][]string{
+		{"--open", "-f", "markdown", "-o", filepath.Join(t.TempDir(), "out.md"), ts.URL},
+		{"--open", "-O", t.TempDir(), ts.URL},
+	} {
+		if code := cleanpgMain(append([]string{"cleanpg"}, args...)); code != exitUsage {
+			t.Errorf("%q: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+	if len(rec.files) != 0 {
+		t.Errorf("Expected nothing opened, got %q", rec.files)
+	}
+
+	// A failure to open is reported
+	rec.err = errors.New("no opener")
+	if code := cleanpgMain([]string{"cleanpg", "--open", "--force", "-o", outputFile, ts.URL}); code != exitOutput {
+		t.Errorf("Expected exit code %d when the opener fails, got %d", exitOutput, code)
+	}
+}
+
+func TestExecOpener(t *testing.T) {
+	for goos, expect := range map[string][]string{
+		"linux":   {"xdg-open", "page.html"},
+		"freebsd": {"xdg-open", "page.html"},
+		"darwin":  {"open", "page.html"},
+		"windows": {"rundll32", "url.dll,FileProtocolHandler", "page.html"},
+	} {
+		if command := (execOpener{goos: goos}).command("page.html"); !reflect.DeepEqual(command, expect) {
+			t.Errorf("%s: expected %q, got %q", goos, expect, command)
+		}
+	}
+
+	// A missing opener is named in the error
+	missing := execOpener{goos: "linux", lookPath: func(string) (string, error) { return "", errors.New("not found") }}
+	if err := missing.open("page.html"); err == nil || !strings.Contains(err.Error(), "xdg-open") {
+		t.Errorf("Expected an error naming xdg-open, got %v", err)
+	}
+}
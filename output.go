@@ -0,0 +1,461 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+	"golang.org/x/text/unicode/norm"
+)
+
+// summary records where a rendered document came from. It is
+// stored as a comment at the end of the output file so a later
+// run can ask the server whether the source has changed.
+type summary struct {
+	Source       string // URL or file named on the command line
+	ETag         string
+	LastModified string
+	Snapshot     string // archived copy read in place of Source
+}
+
+// summaryPattern matches a stored summary comment, and
+// summaryField each of its fields
+var (
+	summaryPattern = regexp.MustCompile(`<!-- cleanpg((?: [a-z-]+="(?:[^"\\]|\\.)*")+) -->`)
+	summaryField   = regexp.MustCompile(`([a-z-]+)=("(?:[^"\\]|\\.)*")`)
+)
+
+// comment renders the summary as an HTML comment
+func (s summary) comment() string {
+	c := fmt.Sprintf("<!-- cleanpg source=%q etag=%q last-modified=%q", s.Source, s.ETag, s.LastModified)
+	if s.Snapshot != "" {
+		c += fmt.Sprintf(" snapshot=%q", s.Snapshot)
+	}
+	return c + " -->\n"
+}
+
+// readSummary returns the summary stored in a previously
+// rendered output file, if there is one
+func readSummary(fileName string) (summary, bool) {
+	data, err := readFile(fileName)
+	if err != nil {
+		return summary{}, false
+	}
+
+	m := summaryPattern.FindSubmatch(data)
+	if m == nil {
+		return summary{}, false
+	}
+
+	var s summary
+	for _, field := range summaryField.FindAllSubmatch(m[1], -1) {
+		value, err := strconv.Unquote(string(field[2]))
+		if err != nil {
+			return summary{}, false
+		}
+		switch string(field[1]) {
+		case "source":
+			s.Source = value
+		case "etag":
+			s.ETag = value
+		case "last-modified":
+			s.LastModified = value
+		case "snapshot":
+			s.Snapshot = value
+		}
+	}
+	return s, true
+}
+
+// formatExts maps each output format to its file extension
+var formatExts = map[string]string{
+	"html":     ".html",
+	"markdown": ".md",
+	"text":     ".txt",
+	"json":     ".json",
+}
+
+// formatContentTypes maps each output format to its media type
+var formatContentTypes = map[string]string{
+	"html":     "text/html; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+	"text":     "text/plain; charset=utf-8",
+	"json":     "application/json",
+}
+
+// outputFormat is how a cleaned document is written out
+type outputFormat struct {
+	name      string // a key of formatExts
+	textWidth int    // wrap width for text (0 = no wrapping)
+}
+
+// htmlFormat is the default output format
+var htmlFormat = outputFormat{name: "html"}
+
+// ext returns the file extension for the format
+func (f outputFormat) ext() string {
+	return formatExts[f.name]
+}
+
+// contentType returns the media type of the format
+func (f outputFormat) contentType() string {
+	return formatContentTypes[f.name]
+}
+
+// withExt replaces the .html extension of a derived file name
+func (f outputFormat) withExt(name string) string {
+	return strings.TrimSuffix(name, ".html") + f.ext()
+}
+
+// jsonDocument is a cleaned document in the json format
+type jsonDocument struct {
+	Source       string `json:"source"`
+	Title        string `json:"title"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Snapshot     string `json:"snapshot,omitempty"`
+	HTML         string `json:"html"`
+	Text         string `json:"text"`
+}
+
+// render converts a cleaned document to the format. HTML and
+// Markdown end with the summary comment; text has no summary.
+func (f outputFormat) render(cleanData string, s summary) (string, error) {
+	switch f.name {
+	case "markdown":
+		md, err := cleanhtml.RenderMarkdown(cleanData)
+		if err != nil {
+			return "", err
+		}
+		return md + "\n" + s.comment(), nil
+	case "text":
+		return cleanhtml.RenderText(cleanData, f.textWidth)
+	case "json":
+		text, err := cleanhtml.RenderText(cleanData, 0)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(jsonDocument{
+			Source:       s.Source,
+			Title:        cleanhtml.Title([]byte(cleanData)),
+			ETag:         s.ETag,
+			LastModified: s.LastModified,
+			Snapshot:     s.Snapshot,
+			HTML:         cleanData,
+			Text:         text,
+		})
+		return buf.String(), err
+	}
+	return cleanData + "\n" + s.comment(), nil
+}
+
+// writeOutput writes a cleaned document and its summary to
+// fileName in the format, gzipped if fileName ends .gz
+func writeOutput(fileName, cleanData string, s summary, f outputFormat) error {
+	data, err := f.render(cleanData, s)
+	if err != nil {
+		return err
+	}
+	return writeFile(fileName, []byte(data))
+}
+
+// clobberPolicy decides what is written when a file already exists
+type clobberPolicy int
+
+const (
+	// renameExisting writes to a numbered name instead, e.g. page-2.html
+	renameExisting clobberPolicy = iota
+	// overwriteExisting replaces the file (--force)
+	overwriteExisting
+	// refuseExisting fails rather than replace the file (--no-clobber)
+	refuseExisting
+)
+
+// target returns the file to write in place of fileName under the
+// policy; the error wraps os.ErrExist if the file is refused
+func (p clobberPolicy) target(fileName string) (string, error) {
+	if p == overwriteExisting || !fileExists(fileName) {
+		return fileName, nil
+	}
+	if p == refuseExisting {
+		return "", fmt.Errorf("[%s] %w", fileName, os.ErrExist)
+	}
+
+	// The number goes before both extensions of page.html.gz
+	ext := filepath.Ext(fileName)
+	if ext == gzipExt {
+		ext = outputExt(fileName) + gzipExt
+	}
+	base := strings.TrimSuffix(fileName, ext)
+	name := fileName
+	for i := 2; fileExists(name); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	logger.Write(logger.NOTICE, "[%s] already exists, writing [%s] instead", fileName, name)
+	return name, nil
+}
+
+// fileExists determines if there is a file at fileName
+func fileExists(fileName string) bool {
+	_, err := os.Lstat(fileName)
+	return err == nil
+}
+
+// maxNameLen bounds the length of derived file names
+const maxNameLen = 100
+
+// unsafeNameChars matches runs of characters left out of file names
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._]+`)
+
+// outputName derives a file name for the document at rawurl
+// from its host and path, e.g. example.com-docs-intro.html
+func outputName(rawurl string) string {
+	name := rawurl
+	if u, err := url.Parse(rawurl); err == nil && u.Host != "" {
+		p := strings.TrimSuffix(u.Path, path.Ext(u.Path))
+		name = u.Hostname() + "-" + p
+	}
+
+	name = strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-.")
+	if len(name) > maxNameLen {
+		name = strings.TrimRight(name[:maxNameLen], "-.")
+	}
+	if name == "" {
+		name = "index"
+	}
+	return name + ".html"
+}
+
+// jsonMetadata is the metadata of a page written by --metadata;
+// fields the page doesn't give are left out
+type jsonMetadata struct {
+	Source         string            `json:"source"`
+	Title          string            `json:"title,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Canonical      string            `json:"canonical,omitempty"`
+	OpenGraph      map[string]string `json:"og,omitempty"`
+	Author         string            `json:"author,omitempty"`
+	Published      string            `json:"published,omitempty"`
+	WordCount      int               `json:"word_count,omitempty"`
+	ReadingMinutes int               `json:"reading_minutes,omitempty"`
+}
+
+// writeMetadata writes the metadata m of the page from source
+// to w as an indented JSON object
+func writeMetadata(w io.Writer, source string, m *cleanhtml.Metadata) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonMetadata{
+		Source:         source,
+		Title:          m.Title,
+		Description:    m.Description,
+		Canonical:      m.Canonical,
+		OpenGraph:      m.OpenGraph,
+		Author:         m.Author,
+		Published:      m.Published,
+		WordCount:      m.WordCount,
+		ReadingMinutes: int(m.ReadingTime() / time.Minute),
+	})
+}
+
+// saveMetadata writes the metadata of the document data, as
+// fetched from source, to fileName under the policy, or to w
+// when fileName is "". The word count is that of the cleaned
+// document, which is what's read. It logs any failure and
+// returns its exit code.
+func saveMetadata(fileName string, w io.Writer, source string, data []byte, cleanData, base string, p clobberPolicy) int {
+	m, err := cleanhtml.ExtractMetadata(data, base)
+	if err != nil {
+		logger.Write(logger.FATAL, "could not extract metadata: %s", err)
+		return exitClean
+	}
+	if cleaned, err := cleanhtml.ExtractMetadata([]byte(cleanData), ""); err == nil {
+		m.WordCount = cleaned.WordCount
+	}
+
+	if fileName == "" {
+		if err := writeMetadata(w, source, m); err != nil {
+			logger.Write(logger.FATAL, "could not write metadata: %s", err)
+			return exitOutput
+		}
+		return 0
+	}
+	if fileName, err = p.target(fileName); err != nil {
+		logger.Write(logger.FATAL, "not replacing %s: use --force to overwrite it", err)
+		return exitExists
+	}
+	f, err := createSink(fileName)
+	if err != nil {
+		logger.Write(logger.FATAL, "could not open metadata file [%s]: %s", fileName, err)
+		return exitOutput
+	}
+	err = writeMetadata(f, source, m)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Write(logger.FATAL, "could not write [%s]: %s", fileName, err)
+		return exitOutput
+	}
+	logger.Write(logger.INFO, "wrote the metadata to %s", fileName)
+	return 0
+}
+
+// writeLinks writes links to w one per line, as the URL alone
+// or, for tsv, the URL and anchor text separated by a tab
+func writeLinks(w io.Writer, links []cleanhtml.Link, tsv bool) error {
+	bw := bufio.NewWriter(w)
+	for _, l := range links {
+		if tsv {
+			fmt.Fprintf(bw, "%s\t%s\n", l.URL, l.Text)
+		} else {
+			fmt.Fprintln(bw, l.URL)
+		}
+	}
+	return bw.Flush()
+}
+
+// saveLinks writes the links in the document data, resolved
+// against base, to fileName ("-" = stdout) under the policy,
+// logging any failure and returning its exit code
+func saveLinks(fileName string, tsv bool, data []byte, base string, p clobberPolicy) int {
+	links, err := cleanhtml.ExtractLinks(data, base)
+	if err != nil {
+		logger.Write(logger.FATAL, "could not extract links: %s", err)
+		return exitClean
+	}
+
+	if fileName != "-" {
+		if fileName, err = p.target(fileName); err != nil {
+			logger.Write(logger.FATAL, "not replacing %s: use --force to overwrite it", err)
+			return exitExists
+		}
+	}
+	f, err := createSink(fileName)
+	if err != nil {
+		logger.Write(logger.FATAL, "could not open links file [%s]: %s", fileName, err)
+		return exitOutput
+	}
+	err = writeLinks(f, links, tsv)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Write(logger.FATAL, "could not write [%s]: %s", fileName, err)
+		return exitOutput
+	}
+	logger.Write(logger.INFO, "wrote %d links to %s", len(links), fileName)
+	return 0
+}
+
+// Values of the "save" flag other than a file name
+const (
+	saveAuto   = "auto" // derive the name from the URL and time
+	saveStdout = "-"    // write the source to stdout
+)
+
+// saveTimeFormat is the timestamp in the names of saved sources
+const saveTimeFormat = "20060102-150405"
+
+// sourceExts holds the extension of a saved source whose content
+// type isn't HTML, where there are several to choose from
+var sourceExts = map[string]string{
+	"application/xhtml+xml": ".xhtml",
+	"application/xml":       ".xml",
+	"text/xml":              ".xml",
+}
+
+// sourceExt returns the extension of a source saved with the
+// Content-Type contentType; generic types are taken as HTML,
+// as they were when the source was cleaned
+func sourceExt(contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "", "text/html", "text/plain", "application/octet-stream":
+		return ".html"
+	}
+	if ext, ok := sourceExts[mt]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".html"
+}
+
+// timestamped inserts the time t into name before its extension,
+// e.g. page-20201005-142301.html
+func timestamped(name string, t time.Time) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + t.Format(saveTimeFormat) + ext
+}
+
+// autoSaveName derives the name of the source fetched from rawurl
+// with the Content-Type contentType, saved at time t, e.g.
+// example.com-docs-intro-20201005-142301.html
+func autoSaveName(rawurl, contentType string, t time.Time) string {
+	return timestamped(strings.TrimSuffix(outputName(rawurl), ".html")+sourceExt(contentType), t)
+}
+
+// slugify reduces text such as a title to lower case letters,
+// digits and single hyphens, dropping accents and other symbols,
+// e.g. "Café: a Review" gives "cafe-a-review"
+func slugify(text string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(text) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+
+	slug := strings.Trim(unsafeNameChars.ReplaceAllString(b.String(), "-"), "-.")
+	if len(slug) > maxNameLen {
+		slug = strings.TrimRight(slug[:maxNameLen], "-.")
+	}
+	return slug
+}
+
+// pageName derives a file name for a page from its title, e.g.
+// getting-started.html, falling back to outputName(rawurl)
+// when the page has no usable title
+func pageName(title, rawurl string) string {
+	if slug := slugify(title); slug != "" {
+		return slug + ".html"
+	}
+	return outputName(rawurl)
+}
+
+// uniqueName returns name, or name with a numeric suffix
+// when it is already in used, and records it as used
+func uniqueName(used map[string]bool, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	used[name] = true
+	return name
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSummary(t *testing.T) {
+	dir := t.TempDir()
+	expect := summary{
+		Source:       "https://example.com/a?b=\"c\"",
+		ETag:         `W/"abc"`,
+		LastModified: "Wed, 21 Oct 2015 07:28:00 GMT",
+		Snapshot:     "https://web.archive.org/web/2015/https://example.com/a",
+	}
+
+	fileName := filepath.Join(dir, "out.html")
+	data := "<!DOCTYPE html>\n<html><body><p>hi</p></body></html>\n" + expect.comment()
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatalf("Could not write output: %v", err)
+	}
+
+	got, ok := readSummary(fileName)
+	if !ok {
+		t.Fatalf("Expected a summary in %q", data)
+	}
+	if got != expect {
+		t.Errorf("Expected %+v, got %+v", expect, got)
+	}
+
+	if _, ok := readSummary(filepath.Join(dir, "missing.html")); ok {
+		t.Errorf("Expected no summary for a missing file")
+	}
+	plain := filepath.Join(dir, "plain.html")
+	ioutil.WriteFile(plain, []byte("<p>no summary</p>"), 0644)
+	if _, ok := readSummary(plain); ok {
+		t.Errorf("Expected no summary for a file without one")
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		url    string
+		expect string
+	}{
+		{"https://example.com/docs/intro.html", "example.com-docs-intro.html"},
+		{"https://example.com/", "example.com.html"},
+		{"https://example.com:8080/a b/c?x=1", "example.com-a-b-c.html"},
+		{"https://example.com/" + strings.Repeat("x", 200), "example.com-" + strings.Repeat("x", maxNameLen-12) + ".html"},
+		{"", "index.html"},
+	}
+
+	for _, tt := range tests {
+		if got := outputName(tt.url); got != tt.expect {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, expect := range []string{"a.html", "a-2.html", "a-3.html"} {
+		if got := uniqueName(used, "a.html"); got != expect {
+			t.Errorf("Expected %q, got %q", expect, got)
+		}
+	}
+}
+
+func TestAutoSaveName(t *testing.T) {
+	saved := time.Date(2020, 10, 5, 14, 23, 1, 0, time.UTC)
+	tests := []struct {
+		url         string
+		contentType string
+		expect      string
+	}{
+		{"https://example.com/docs/intro.html", "text/html; charset=utf-8", "example.com-docs-intro-20201005-142301.html"},
+		{"https://example.com/docs/intro", "", "example.com-docs-intro-20201005-142301.html"},
+		{"https://example.com/docs/intro", "text/plain", "example.com-docs-intro-20201005-142301.html"},
+		{"https://example.com/feed", "application/xhtml+xml", "example.com-feed-20201005-142301.xhtml"},
+		{"https://example.com/sitemap", "text/xml; charset=utf-8", "example.com-sitemap-20201005-142301.xml"},
+	}
+
+	for _, tt := range tests {
+		if got := autoSaveName(tt.url, tt.contentType, saved); got != tt.expect {
+			t.Errorf("%s %q: expected %q, got %q", tt.url, tt.contentType, tt.expect, got)
+		}
+	}
+}
+
+func TestPageName(t *testing.T) {
+	tests := []struct {
+		title  string
+		url    string
+		expect string
+	}{
+		{"Getting Started", "https://example.com/a", "getting-started.html"},
+		{"Input/Output: read & write", "https://example.com/a", "input-output-read-write.html"},
+		{"Café 🎉 party 🎉", "https://example.com/a", "cafe-party.html"},
+		{"../../etc/passwd", "https://example.com/a", "etc-passwd.html"},
+		{strings.Repeat("word ", 40), "https://example.com/a", strings.TrimRight(strings.Repeat("word-", 20), "-") + ".html"},
+		{"", "https://example.com/docs/intro", "example.com-docs-intro.html"},
+		{"🎉🎉", "https://example.com/docs/intro", "example.com-docs-intro.html"},
+	}
+
+	for _, tt := range tests {
+		if got := pageName(tt.title, tt.url); got != tt.expect {
+			t.Errorf("Expected %q, got %q", tt.expect, got)
+		}
+	}
+
+	// Pages with the same title are numbered
+	used := make(map[string]bool)
+	for _, expect := range []string{"home.html", "home-2.html"} {
+		if got := uniqueName(used, pageName("Home", "https://example.com/")); got != expect {
+			t.Errorf("Expected %q, got %q", expect, got)
+		}
+	}
+}
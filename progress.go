@@ -0,0 +1,175 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// progress reports the documents of a batch as they're fetched
+type progress interface {
+	// fetched reports the fetch of a document from source
+	fetched(source string, result cleanhtml.FetchResult)
+	// finish ends the report
+	finish()
+}
+
+// newProgress returns the report of a batch of total documents
+// written to w: a line updated in place on a terminal, otherwise
+// a line per document. verbose adds the detail of each fetch.
+func newProgress(w io.Writer, total int, tty, verbose bool) progress {
+	lines := &lineProgress{w: w, total: total, verbose: verbose, start: time.Now()}
+	if tty {
+		return &ttyProgress{lineProgress: lines}
+	}
+	return lines
+}
+
+// isTerminal determines if f is a terminal rather than
+// a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// noProgress reports nothing, for --quiet
+type noProgress struct{}
+
+func (noProgress) fetched(string, cleanhtml.FetchResult) {}
+func (noProgress) finish()                               {}
+
+// lineProgress writes a line for each document fetched,
+// e.g. "[ 42/300] ok     https://example.com/ (12.1 KiB in 412ms)"
+type lineProgress struct {
+	sync.Mutex
+	w       io.Writer
+	total   int
+	done    int
+	failed  int
+	bytes   int64
+	verbose bool
+	start   time.Time // of the batch
+}
+
+// fetched implements the progress interface
+func (p *lineProgress) fetched(source string, result cleanhtml.FetchResult) {
+	p.Lock()
+	defer p.Unlock()
+	p.count(result)
+	fmt.Fprintln(p.w, p.line(source, result))
+}
+
+// finish implements the progress interface
+func (p *lineProgress) finish() {}
+
+// fetchStatus describes the outcome of a fetch: "ok", "failed",
+// or "feed" for a feed whose entries are cleaned in its place
+func fetchStatus(result cleanhtml.FetchResult) string {
+	var typeErr *cleanhtml.ContentTypeError
+	switch {
+	case result.Err == nil:
+		return "ok"
+	case errors.As(result.Err, &typeErr) && isFeedType(typeErr.ContentType):
+		return "feed"
+	}
+	return "failed"
+}
+
+// count adds result to the totals
+func (p *lineProgress) count(result cleanhtml.FetchResult) {
+	p.done++
+	if fetchStatus(result) == "failed" {
+		p.failed++
+	}
+	p.bytes += int64(len(result.Body))
+}
+
+// line describes the document fetched from source
+func (p *lineProgress) line(source string, result cleanhtml.FetchResult) string {
+	status := fetchStatus(result)
+	detail := fmt.Sprintf("%s in %s", formatBytes(int64(len(result.Body))), formatDuration(result.Elapsed))
+	if status == "failed" {
+		detail = "after " + formatDuration(result.Elapsed)
+	}
+	if p.verbose {
+		if result.StatusCode != 0 {
+			detail += fmt.Sprintf(", status %d", result.StatusCode)
+		}
+		if result.URL != "" && result.URL != source {
+			detail += ", from " + result.URL
+		}
+		detail += fmt.Sprintf("; %s into the batch", formatDuration(time.Since(p.start)))
+	}
+	return fmt.Sprintf("%s %-6s %s (%s)", p.counter(), status, source, detail)
+}
+
+// counter returns the count of documents done, e.g. "[ 42/300]"
+func (p *lineProgress) counter() string {
+	return fmt.Sprintf("[%*d/%d]", len(fmt.Sprint(p.total)), p.done, p.total)
+}
+
+// ttyProgress keeps a single status line up to date, printing
+// a line of its own only for each failure
+type ttyProgress struct {
+	*lineProgress
+}
+
+// ttyWidth bounds the status line, so it doesn't wrap
+// on a standard terminal
+const ttyWidth = 79
+
+// fetched implements the progress interface
+func (p *ttyProgress) fetched(source string, result cleanhtml.FetchResult) {
+	p.Lock()
+	defer p.Unlock()
+	p.count(result)
+	if fetchStatus(result) == "failed" {
+		fmt.Fprintf(p.w, "\r\x1b[K%s\n", p.line(source, result))
+	}
+
+	status := fmt.Sprintf("%s %d ok, %d failed, %s: ", p.counter(), p.done-p.failed, p.failed, formatBytes(p.bytes))
+	if room := ttyWidth - len(status); room > 3 && len(source) > room {
+		source = "..." + source[len(source)-room+3:]
+	}
+	fmt.Fprintf(p.w, "\r\x1b[K%s%s", status, source)
+}
+
+// finish implements the progress interface
+func (p *ttyProgress) finish() {
+	p.Lock()
+	defer p.Unlock()
+	if p.done > 0 {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// formatBytes returns n as a size such as "12.1 KiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < 2 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMG"[prefix])
+}
+
+// formatDuration returns d rounded for display, e.g. "412ms"
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+func TestLineProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, 12, false, false)
+	p.fetched("http://example.com/a", cleanhtml.FetchResult{Body: make([]byte, 2048), Elapsed: 412 * time.Millisecond})
+	p.fetched("http://example.com/b", cleanhtml.FetchResult{Err: errors.New("gone"), Elapsed: 1500 * time.Millisecond})
+	p.fetched("http://example.com/c", cleanhtml.FetchResult{Err: &cleanhtml.ContentTypeError{ContentType: "application/rss+xml"}})
+	p.finish()
+
+	expect := "[ 1/12] ok     http://example.com/a (2.0 KiB in 412ms)\n" +
+		"[ 2/12] failed http://example.com/b (after 1.5s)\n" +
+		"[ 3/12] feed   http://example.com/c (0 B in 0s)\n"
+	if buf.String() != expect {
+		t.Errorf("Expected %q, got %q", expect, buf.String())
+	}
+
+	// Verbose adds the detail of each fetch
+	buf.Reset()
+	p = newProgress(&buf, 1, false, true)
+	p.fetched("http://example.com/a", cleanhtml.FetchResult{URL: "http://example.com/a/", StatusCode: 200, Body: []byte("<p>"), Elapsed: time.Millisecond})
+	if line := buf.String(); !strings.HasPrefix(line, "[1/1] ok     http://example.com/a (3 B in 1ms, status 200, from http://example.com/a/; ") ||
+		!strings.HasSuffix(line, " into the batch)\n") {
+		t.Errorf("Expected the verbose detail, got %q", line)
+	}
+}
+
+func TestTTYProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, 3, true, false)
+	p.fetched("http://example.com/a", cleanhtml.FetchResult{Body: make([]byte, 100)})
+	p.fetched("http://example.com/b", cleanhtml.FetchResult{Err: errors.New("gone"), Elapsed: time.Second})
+	p.fetched("http://example.com/"+strings.Repeat("x", 100), cleanhtml.FetchResult{Body: make([]byte, 2000)})
+	p.finish()
+
+	// The status line is redrawn in place; failures keep a line of their own
+	expect := "\r\x1b[K[1/3] 1 ok, 0 failed, 100 B: http://example.com/a" +
+		"\r\x1b[K[2/3] failed http://example.com/b (after 1s)\n" +
+		"\r\x1b[K[2/3] 1 ok, 1 failed, 100 B: http://example.com/b" +
+		"\r\x1b[K[3/3] 2 ok, 1 failed, 2.1 KiB: ..." + strings.Repeat("x", 45) + "\n"
+	if buf.String() != expect {
+		t.Errorf("Expected %q, got %q", expect, buf.String())
+	}
+	for _, line := range strings.Split(buf.String(), "\r\x1b[K") {
+		if len(strings.TrimSuffix(line, "\n")) > ttyWidth {
+			t.Errorf("Expected lines of at most %d columns, got %q", ttyWidth, line)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, expect := range map[int64]string{
+		0: "0 B", 1023: "1023 B", 1024: "1.0 KiB", 12390: "12.1 KiB",
+		5 << 20: "5.0 MiB", 3 << 30: "3.0 GiB", 2048 << 30: "2048.0 GiB",
+	} {
+		if got := formatBytes(n); got != expect {
+			t.Errorf("%d: expected %q, got %q", n, expect, got)
+		}
+	}
+}
+
+func TestCleanURLs_Progress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Page</h1></body></html>"))
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	b := newBatch(filepath.Join(t.TempDir(), "pages"), cleanhtml.FetchOptions{})
+	b.progress = func(total int) progress { return newProgress(&buf, total, false, false) }
+	b.cleanURLs(context.Background(), []string{ts.URL + "/a", ts.URL + "/missing", ts.URL + "/b"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a line per URL, got %q", buf.String())
+	}
+	for _, want := range []string{"ok     " + ts.URL + "/a", "failed " + ts.URL + "/missing", "ok     " + ts.URL + "/b"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the progress, got %q", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// server cleans the pages requested of it over HTTP, rendering
+// them as the command line flags do for a single URL
+type server struct {
+	fetcher cleanhtml.Fetcher
+	opts    cleanhtml.FetchOptions // for fetching images
+	clean   cleanhtml.Options      // copied for each request
+	format  outputFormat
+	images  string        // "images" flag mode
+	timeout time.Duration // for each request (0 = no limit)
+	cache   *resultCache  // nil = no caching
+}
+
+// serveMain serves cleaned pages at addr until interrupted,
+// returning the exit code
+func serveMain(addr string, format outputFormat, images string, timeout time.Duration) int {
+	if images == "download" {
+		logger.Write(logger.FATAL, "--serve can't save images to disk: use --images inline or keep")
+		return exitUsage
+	}
+
+	// FLAG "serve-cache"
+	cacheSize, err := fs.GetInt("serve-cache")
+	if err != nil {
+		panic(err)
+	}
+	if cacheSize < 0 {
+		logger.Write(logger.FATAL, "invalid cache size [%d]", cacheSize)
+		return exitUsage
+	}
+
+	// FLAG "allow-private"
+	allowPrivate, err := fs.Get("allow-private")
+	if err != nil {
+		panic(err)
+	}
+	opts := cleanhtml.DefaultFetchOptions()
+	opts.PublicOnly = !allowPrivate
+
+	// FLAG "fetcher"
+	fetcherSpec, err := fs.GetString("fetcher")
+	if err != nil {
+		panic(err)
+	}
+	fetcher, err := parseFetcher(fetcherSpec, opts)
+	if err != nil {
+		logger.Write(logger.FATAL, "%s", err)
+		return exitUsage
+	}
+
+	s := &server{fetcher: fetcher, opts: opts, clean: cleanhtml.DefaultOptions(),
+		format: format, images: images, timeout: timeout}
+	if cacheSize > 0 {
+		s.cache = newResultCache(int(cacheSize))
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Write(logger.FATAL, "could not listen on [%s]: %s", addr, err)
+		return exitUsage
+	}
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	// An interrupt lets the requests in progress finish
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+		case <-done:
+			return
+		}
+		logger.Write(logger.NOTICE, "interrupted: shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	fmt.Printf("Serving cleaned pages at http://%s/\n", ln.Addr())
+	logger.Write(logger.INFO, "serving at [%s]", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		logger.Write(logger.FATAL, "server stopped: %s", err)
+		return exitFetch
+	}
+	return 0
+}
+
+// handler returns the server's HTTP handler
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveIndex)
+	mux.HandleFunc("/clean", s.serveClean)
+	return mux
+}
+
+// indexPage is the form for entering a URL to clean
+var indexPage = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>cleanpg</title></head>
+<body>
+<h1>cleanpg</h1>
+<form action="/clean" method="get">
+<input type="url" name="url" size="60" placeholder="https://example.com/article" required>
+<input type="submit" value="Clean">
+</form>
+</body></html>
+`))
+
+// serveIndex answers "/" with the URL form
+func (s *server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexPage.Execute(w, nil)
+}
+
+// serveClean answers "/clean?url=..." with the cleaned page
+func (s *server) serveClean(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target := r.URL.Query().Get("url")
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	if s.cache != nil {
+		if cached, ok := s.cache.get(target); ok {
+			logger.Write(logger.INFO, "served [%s] from the cache", target)
+			s.write(w, cached)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	page, err := s.cleanPage(ctx, target)
+	if err != nil {
+		logger.Write(logger.ERROR, "Could not serve [%s]: %s", target, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	logger.Write(logger.INFO, "served [%s]: %d bytes in %v", target, len(page.body), time.Since(start).Round(time.Millisecond))
+
+	if s.cache != nil {
+		s.cache.add(page)
+	}
+	s.write(w, page)
+}
+
+// cleanPage fetches and cleans target in the server's format
+func (s *server) cleanPage(ctx context.Context, target string) (*cachedPage, error) {
+	result, err := s.fetcher.Fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each request has its own options, so pages are
+	// cleaned concurrently without sharing state
+	opts := s.clean
+	opts.BaseURL = result.URL
+	if s.images == "inline" {
+		opts.ImageSource = newAssetSaver(ctx, s.opts, true, "", "").sourceFor(result.URL)
+	}
+	cleanData, err := cleanhtml.CleanHTMLWithOptions(ctx, result.Body, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := summary{Source: target, ETag: result.ETag, LastModified: result.LastModified, Snapshot: result.Snapshot}
+	data, err := s.format.render(cleanData, sum)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedPage{url: target, contentType: s.format.contentType(), body: []byte(data)}, nil
+}
+
+// write sends a cleaned page as the response
+func (s *server) write(w http.ResponseWriter, page *cachedPage) {
+	w.Header().Set("Content-Type", page.contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(page.body)))
+	w.Write(page.body)
+}
+
+// errorStatus returns the HTTP status reporting err
+func errorStatus(err error) int {
+	var statusErr *cleanhtml.StatusError
+	var cleanErr *cleanhtml.CleanError
+	switch {
+	case errors.Is(err, cleanhtml.ErrPrivateAddress):
+		return http.StatusForbidden
+	case isTimeout(err):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, cleanhtml.ErrNotHTML):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, cleanhtml.ErrNoIncludeMatch):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &statusErr):
+		return http.StatusBadGateway
+	case errors.As(err, &cleanErr):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway
+}
+
+// cachedPage is a cleaned page ready to send
+type cachedPage struct {
+	url         string
+	contentType string
+	body        []byte
+}
+
+// resultCache keeps the most recently served pages, safe
+// for concurrent use
+type resultCache struct {
+	sync.Mutex
+	size  int
+	pages map[string]*list.Element
+	order *list.List // of *cachedPage, most recently used first
+}
+
+// newResultCache returns a cache holding up to size pages
+func newResultCache(size int) *resultCache {
+	return &resultCache{size: size, pages: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the cached page for url
+func (c *resultCache) get(url string) (*cachedPage, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.pages[url]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedPage), true
+}
+
+// add caches page, evicting the least recently used
+// page when the cache is full
+func (c *resultCache) add(page *cachedPage) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.pages[page.url]; ok {
+		e.Value = page
+		c.order.MoveToFront(e)
+		return
+	}
+	c.pages[page.url] = c.order.PushFront(page)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.pages, oldest.Value.(*cachedPage).url)
+	}
+}
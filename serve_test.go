@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+// newTestServer returns a server cleaning pages with the
+// default options
+func newTestServer(opts cleanhtml.FetchOptions, format outputFormat) *server {
+	return &server{fetcher: &cleanhtml.HTTPFetcher{Options: opts}, opts: opts,
+		clean: cleanhtml.DefaultOptions(), format: format}
+}
+
+// get requests path of ts, returning the status, content type and body
+func get(t *testing.T, ts *httptest.Server, path string) (int, string, string) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatalf("Could not get %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+}
+
+func TestServe(t *testing.T) {
+	var mu sync.Mutex
+	fetches := make(map[string]int)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/slow":
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><h1>Page ` + r.URL.Path + `</h1><p><a href="/next">next</a></p></body></html>`))
+	}))
+	defer site.Close()
+
+	s := newTestServer(cleanhtml.FetchOptions{}, htmlFormat)
+	s.timeout = 200 * time.Millisecond
+	s.cache = newResultCache(1)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	clean := func(target string) string { return "/clean?url=" + url.QueryEscape(target) }
+
+	// The index has the form
+	if code, contentType, body := get(t, ts, "/"); code != 200 || !strings.HasPrefix(contentType, "text/html") || !strings.Contains(body, `action="/clean"`) {
+		t.Errorf("Expected the index form, got %d %q %q", code, contentType, body)
+	}
+	if code, _, _ := get(t, ts, "/other"); code != 404 {
+		t.Errorf("Expected 404 for an unknown path, got %d", code)
+	}
+
+	// A page is cleaned, resolving its links against itself
+	code, contentType, body := get(t, ts, clean(site.URL+"/article"))
+	if code != 200 || contentType != "text/html; charset=utf-8" {
+		t.Fatalf("Expected a cleaned page, got %d %q %q", code, contentType, body)
+	}
+	for _, want := range []string{"Page /article", `href="` + site.URL + `/next"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the page, got %q", want, body)
+		}
+	}
+
+	// Repeat requests are served from the cache, which keeps
+	// only the most recent page
+	get(t, ts, clean(site.URL+"/article"))
+	get(t, ts, clean(site.URL+"/other"))
+	get(t, ts, clean(site.URL+"/article"))
+	mu.Lock()
+	if fetches["/article"] != 2 || fetches["/other"] != 1 {
+		t.Errorf("Expected /article fetched twice and /other once, got %v", fetches)
+	}
+	mu.Unlock()
+
+	// Failures have their own statuses
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/clean", http.StatusBadRequest},
+		{clean("file:///etc/passwd"), http.StatusBadRequest},
+		{clean("ftp://example.com/"), http.StatusBadRequest},
+		{clean("/relative"), http.StatusBadRequest},
+		{clean(site.URL + "/missing"), http.StatusBadGateway},
+		{clean(site.URL + "/slow"), http.StatusGatewayTimeout},
+	} {
+		if code, _, body := get(t, ts, tt.path); code != tt.code {
+			t.Errorf("%s: expected status %d, got %d %q", tt.path, tt.code, code, body)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+clean(site.URL+"/article"), "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Could not post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for a POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestServe_PublicOnly(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Private</h1></body></html>"))
+	}))
+	defer site.Close()
+
+	ts := httptest.NewServer(newTestServer(cleanhtml.FetchOptions{PublicOnly: true}, htmlFormat).handler())
+	defer ts.Close()
+
+	// The test site is on loopback, as an intranet host would be private
+	code, _, body := get(t, ts, "/clean?url="+url.QueryEscape(site.URL))
+	if code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a loopback address, got %d %q", code, body)
+	}
+}
+
+func TestServe_Format(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Formatted</h1><p>Some text.</p></body></html>"))
+	}))
+	defer site.Close()
+
+	for name, want := range map[string]string{
+		"markdown": "text/markdown; charset=utf-8",
+		"text":     "text/plain; charset=utf-8",
+		"json":     "application/json",
+	} {
+		ts := httptest.NewServer(newTestServer(cleanhtml.FetchOptions{}, outputFormat{name: name}).handler())
+		code, contentType, body := get(t, ts, "/clean?url="+url.QueryEscape(site.URL))
+		ts.Close()
+		if code != 200 || contentType != want || !strings.Contains(body, "Formatted") {
+			t.Errorf("%s: expected %q, got %d %q %q", name, want, code, contentType, body)
+		}
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	c := newResultCache(2)
+	for i := 0; i < 3; i++ {
+		c.add(&cachedPage{url: fmt.Sprint(i)})
+		if i == 1 {
+			c.get("0") // "1" is now the least recently used
+		}
+	}
+	for url, want := range map[string]bool{"0": true, "1": false, "2": true} {
+		if _, ok := c.get(url); ok != want {
+			t.Errorf("%s: expected cached %v, got %v", url, want, ok)
+		}
+	}
+}
+
+func TestCleanpgMain_Serve(t *testing.T) {
+	if code := cleanpgMain([]string{"cleanpg", "--serve", "127.0.0.1:0", "http://example.com/"}); code != exitUsage {
+		t.Errorf("Expected exit code %d with a URL, got %d", exitUsage, code)
+	}
+	if code := cleanpgMain([]string{"cleanpg", "--serve", "127.0.0.1:0", "--images=download"}); code != exitUsage {
+		t.Errorf("Expected exit code %d for --images download, got %d", exitUsage, code)
+	}
+	cleanhtml.SetImageRender(false)
+	if code := cleanpgMain([]string{"cleanpg", "--serve", "not an address"}); code != exitUsage {
+		t.Errorf("Expected exit code %d for a bad address, got %d", exitUsage, code)
+	}
+}
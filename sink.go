@@ -0,0 +1,121 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gzipExt marks an output file to be gzipped, e.g. page.html.gz
+const gzipExt = ".gz"
+
+// sink is where a document is written: a file, gzipped if its
+// name ends .gz, or stdout. Anything which fails to be written
+// is reported by Close at the latest, so its error must be
+// checked.
+type sink struct {
+	w  io.WriteCloser
+	gz *gzip.Writer // compressing into w, nil if not gzipped
+}
+
+// newSink returns a sink writing to w, gzipped or not
+func newSink(w io.WriteCloser, gzipped bool) *sink {
+	s := &sink{w: w}
+	if gzipped {
+		s.gz = gzip.NewWriter(w)
+	}
+	return s
+}
+
+// createSink creates the file fileName, or writes to stdout
+// for "-"
+func createSink(fileName string) (*sink, error) {
+	if fileName == "-" {
+		return newSink(nopCloser{stdout}, false), nil
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return newSink(f, isGzipped(fileName)), nil
+}
+
+// Write implements io.Writer
+func (s *sink) Write(p []byte) (int, error) {
+	if s.gz != nil {
+		return s.gz.Write(p)
+	}
+	return s.w.Write(p)
+}
+
+// Close flushes anything buffered and closes the file,
+// returning the first error
+func (s *sink) Close() error {
+	var err error
+	if s.gz != nil {
+		err = s.gz.Close()
+	}
+	if closeErr := s.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// nopCloser is a writer, such as stdout, which isn't closed
+// when its sink is
+type nopCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer
+func (nopCloser) Close() error { return nil }
+
+// writeFile writes data to fileName through a sink, as
+// ioutil.WriteFile does
+func writeFile(fileName string, data []byte) error {
+	s, err := createSink(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Write(data); err != nil {
+		s.Close()
+		return err
+	}
+	return s.Close()
+}
+
+// isGzipped determines if fileName is of a gzipped file
+func isGzipped(fileName string) bool {
+	return filepath.Ext(fileName) == gzipExt
+}
+
+// outputExt returns the extension of fileName, e.g. ".html",
+// without any .gz after it
+func outputExt(fileName string) string {
+	return filepath.Ext(strings.TrimSuffix(fileName, gzipExt))
+}
+
+// readFile returns the contents of fileName, uncompressed
+// if it's gzipped
+func readFile(fileName string) ([]byte, error) {
+	if !isGzipped(fileName) {
+		return ioutil.ReadFile(fileName)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
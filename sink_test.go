@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// faultyWriter fails to write after limit bytes, or to close
+type faultyWriter struct {
+	bytes.Buffer
+	limit    int
+	closeErr error
+}
+
+var errFaulty = errors.New("disk full")
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.limit {
+		return 0, errFaulty
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *faultyWriter) Close() error { return w.closeErr }
+
+func TestSink(t *testing.T) {
+	// A gzipped sink compresses what's written
+	w := &faultyWriter{limit: 1 << 20}
+	s := newSink(w, true)
+	if _, err := s.Write([]byte("<p>Compressed</p>")); err != nil {
+		t.Fatalf("Could not write: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Could not close: %v", err)
+	}
+	r, err := gzip.NewReader(&w.Buffer)
+	if err != nil {
+		t.Fatalf("Could not gunzip: %v", err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != "<p>Compressed</p>" {
+		t.Errorf("Expected the data written, got %q", data)
+	}
+
+	// The gzip stream is buffered, so a failure to write it
+	// may only come out on Close, as does a failure to close
+	for _, tt := range []struct {
+		name    string
+		w       *faultyWriter
+		gzipped bool
+	}{
+		{"gzip write", &faultyWriter{limit: 5}, true},
+		{"gzip close", &faultyWriter{limit: 1 << 20, closeErr: errFaulty}, true},
+		{"plain close", &faultyWriter{limit: 1 << 20, closeErr: errFaulty}, false},
+	} {
+		s := newSink(tt.w, tt.gzipped)
+		s.Write([]byte("<p>Lost</p>"))
+		if err := s.Close(); !errors.Is(err, errFaulty) {
+			t.Errorf("%s: expected %v from Close, got %v", tt.name, errFaulty, err)
+		}
+	}
+}
+
+func TestOutputExt(t *testing.T) {
+	for name, ext := range map[string]string{
+		"page.html":    ".html",
+		"page.html.gz": ".html",
+		"notes.md.gz":  ".md",
+		"page.gz":      "",
+	} {
+		if got := outputExt(name); got != ext {
+			t.Errorf("%s: expected %q, got %q", name, ext, got)
+		}
+	}
+}
+
+func TestCleanpgMain_Gzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/article.html")
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	gunzip := func(fileName string) string {
+		t.Helper()
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatalf("Could not open output: %v", err)
+		}
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("Could not gunzip %s: %v", fileName, err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Could not gunzip %s: %v", fileName, err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		args   []string
+		output string
+		expect string
+	}{
+		{[]string{"-o", filepath.Join(dir, "page.html.gz")}, "page.html.gz", "<!-- cleanpg source="},
+		{[]string{"-o", filepath.Join(dir, "page.html.gz")}, "page-2.html.gz", "Pruning Roses"},
+		{[]string{"-f", "markdown", "-o", filepath.Join(dir, "page.md.gz")}, "page.md.gz", "# Pruning Roses"},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"cleanpg"}, tt.args...), ts.URL)
+		if code := cleanpgMain(args); code != 0 {
+			t.Fatalf("%v: expected exit code 0, got %d", args, code)
+		}
+		if data := gunzip(filepath.Join(dir, tt.output)); !strings.Contains(data, tt.expect) {
+			t.Errorf("%v: expected %q in %s, got %q", args, tt.expect, tt.output, data)
+		}
+	}
+
+	// The summary is read back through the compression
+	if s, ok := readSummary(filepath.Join(dir, "page.html.gz")); !ok || s.Source != ts.URL {
+		t.Errorf("Expected the summary of %s, got %+v, %v", ts.URL, s, ok)
+	}
+
+	for _, name := range []string{"page.txt.gz", "page.gz"} {
+		if code := cleanpgMain([]string{"cleanpg", "-o", filepath.Join(dir, name), ts.URL}); code != exitUsage {
+			t.Errorf("%s: expected exit code %d, got %d", name, exitUsage, code)
+		}
+	}
+	if code := cleanpgMain([]string{"cleanpg", "--open", "-o", filepath.Join(dir, "open.html.gz"), ts.URL}); code != exitUsage {
+		t.Errorf("Expected exit code %d for --open, got %d", exitUsage, code)
+	}
+}
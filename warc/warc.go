@@ -0,0 +1,109 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package warc reads the records of WARC files (ISO 28500), the
+// format web crawlers archive their fetches in, so stored pages
+// can be cleaned without fetching them again.
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Record is a single WARC record
+type Record struct {
+	// Header holds the record's named fields, e.g. WARC-Type
+	Header textproto.MIMEHeader
+
+	// Content is the record block; for a response record,
+	// the HTTP response as it was received
+	Content []byte
+}
+
+// Type returns the record type, e.g. "response"
+func (rec *Record) Type() string {
+	return rec.Header.Get("WARC-Type")
+}
+
+// TargetURI returns the URI the record was captured from
+func (rec *Record) TargetURI() string {
+	// Some writers wrap the URI in angle brackets
+	return strings.Trim(rec.Header.Get("WARC-Target-URI"), "<>")
+}
+
+// HTTPResponse parses the HTTP response held by a response record
+func (rec *Record) HTTPResponse() (*http.Response, error) {
+	if rec.Type() != "response" {
+		return nil, fmt.Errorf("warc: %s record has no HTTP response", rec.Type())
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Content)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("warc: invalid HTTP response for [%s]: %s", rec.TargetURI(), err)
+	}
+	return resp, nil
+}
+
+// Reader reads records from a WARC file
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader for the WARC file in r, which
+// may be gzipped (as a whole or, as usual, record by record)
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last
+func (wr *Reader) Next() (*Record, error) {
+	// Records are separated by blank lines
+	var version string
+	for {
+		line, err := wr.r.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if version = strings.TrimSpace(line); version != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("warc: expected a record, got %q", version)
+	}
+
+	header, err := textproto.NewReader(wr.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("warc: invalid record header: %s", err)
+	}
+
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("warc: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(wr.r, content); err != nil {
+		return nil, fmt.Errorf("warc: truncated record: %s", err)
+	}
+
+	return &Record{Header: header, Content: content}, nil
+}
@@ -0,0 +1,114 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll returns the type and target URI of each record in data
+func readAll(t *testing.T, data []byte) []string {
+	wr, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Could not open WARC: %v", err)
+	}
+
+	var got []string
+	for {
+		rec, err := wr.Next()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("Could not read record %d: %v", len(got)+1, err)
+		}
+		got = append(got, rec.Type()+" "+rec.TargetURI())
+	}
+}
+
+func TestReader(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/crawl.warc")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+
+	expect := []string{
+		"warcinfo ",
+		"request https://example.com/articles/first",
+		"response https://example.com/articles/first",
+		"response https://example.com/logo.png",
+		"response https://example.com/articles/second",
+	}
+	if got := readAll(t, data); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	// Each record gzipped separately, as crawlers write them
+	var gz bytes.Buffer
+	for _, rec := range bytes.SplitAfter(data, []byte("\r\n\r\nWARC/")) {
+		zw := gzip.NewWriter(&gz)
+		zw.Write(rec)
+		zw.Close()
+	}
+	if got := readAll(t, gz.Bytes()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q from gzipped records, got %q", expect, got)
+	}
+}
+
+func TestRecord_HTTPResponse(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/crawl.warc")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+	wr, _ := NewReader(bytes.NewReader(data))
+
+	var types []string
+	for {
+		rec, err := wr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Could not read record: %v", err)
+		}
+
+		resp, err := rec.HTTPResponse()
+		if rec.Type() != "response" {
+			if err == nil {
+				t.Errorf("Expected an error for a %s record", rec.Type())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse response: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || int64(len(body)) != resp.ContentLength {
+			t.Errorf("Expected a complete 200 response, got %d with %d of %d bytes",
+				resp.StatusCode, len(body), resp.ContentLength)
+		}
+		types = append(types, resp.Header.Get("Content-Type"))
+	}
+
+	expect := "text/html; charset=utf-8,image/png,text/html; charset=iso-8859-1"
+	if got := strings.Join(types, ","); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestReader_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"WARC/1.0\r\nWARC-Type: response\r\nContent-Length: 100\r\n\r\nshort",
+		"WARC/1.0\r\nWARC-Type: response\r\n\r\n",
+	} {
+		wr, _ := NewReader(strings.NewReader(data))
+		if _, err := wr.Next(); err == nil || err == io.EOF {
+			t.Errorf("%q: expected an error, got %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/scu/cleanpg/cleanhtml"
+	"github.com/scu/cleanpg/logger"
+)
+
+// watcher re-cleans a URL on an interval, replacing the output
+// only when the cleaned content has changed
+type watcher struct {
+	url        string
+	outputFile string
+	format     outputFormat
+	base       string // "base" flag, "" = the document's own URL
+	images     string // "images" flag mode
+	assetDir   string // where downloaded images are saved
+	spec       string // "fetcher" flag
+	opts       cleanhtml.FetchOptions
+	interval   time.Duration
+	count      int           // checks to make (0 = until interrupted)
+	timeout    time.Duration // for each check (0 = no limit)
+	last       string        // cleaned document last written ("" = none)
+}
+
+// run checks the URL every interval until interrupted or count
+// checks are made, returning the exit code of the last check
+func (w *watcher) run() int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			logger.Write(logger.NOTICE, "interrupted: no longer watching [%s]", w.url)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// An output already rendered in HTML is the first to compare
+	if w.format.name == "html" {
+		if data, err := readFile(w.outputFile); err == nil {
+			w.last = string(data)
+		}
+	}
+
+	for n := 1; ; n++ {
+		exitCode := w.check(ctx)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if w.count > 0 && n == w.count {
+			return exitCode
+		}
+		select {
+		case <-time.After(w.interval):
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// check fetches and cleans the URL, writing the output if its
+// content changed, and returns the exit code
+func (w *watcher) check(parent context.Context) int {
+	ctx := parent
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, w.timeout)
+		defer cancel()
+	}
+
+	fetcher, err := parseFetcher(w.spec, w.opts)
+	if err != nil {
+		logger.Write(logger.ERROR, "%s", err)
+		return exitUsage
+	}
+	result, err := fetcher.Fetch(ctx, w.url)
+	if parent.Err() != nil {
+		return 0
+	}
+	if err != nil {
+		logger.Write(logger.ERROR, "Cannot read [%s]: %s", w.url, err)
+		return fetchExitCode(err)
+	}
+	if result.NotModified {
+		logger.Write(logger.INFO, "[%s] not modified", w.url)
+		return 0
+	}
+
+	// Later checks ask whether the source changed since this one
+	w.opts.IfNoneMatch = result.ETag
+	w.opts.IfModifiedSince = result.LastModified
+
+	opts := cleanhtml.DefaultOptions()
+	opts.BaseURL = w.base
+	if opts.BaseURL == "" {
+		opts.BaseURL = result.URL
+	}
+	if w.images == "download" || w.images == "inline" {
+		opts.ImageSource = newAssetSaver(ctx, w.opts, w.images == "inline", w.assetDir, filepath.Dir(w.outputFile)).sourceFor(result.URL)
+	}
+	cleanData, err := cleanhtml.CleanHTMLWithOptions(ctx, result.Body, opts)
+	if isTimeout(err) {
+		logger.Write(logger.ERROR, "timed out after %v cleaning [%s]", w.timeout, w.url)
+		return exitTimeout
+	}
+	if err != nil {
+		logger.Write(logger.ERROR, "Could not clean [%s]: %s", w.url, err)
+		return exitClean
+	}
+
+	change := "first rendering"
+	if w.last != "" {
+		changes, err := cleanhtml.Diff(w.last, cleanData)
+		if err != nil {
+			logger.Write(logger.ERROR, "Could not compare [%s]: %s", w.url, err)
+			return exitClean
+		}
+		if len(changes) == 0 {
+			logger.Write(logger.INFO, "[%s] unchanged", w.url)
+			return 0
+		}
+		change = cleanhtml.DiffSummary(changes)
+	}
+
+	s := summary{Source: w.url, ETag: result.ETag, LastModified: result.LastModified, Snapshot: result.Snapshot}
+	if err := writeOutput(w.outputFile, cleanData, s, w.format); err != nil {
+		logger.Write(logger.ERROR, "could not write [%s]: %s", w.outputFile, err)
+		return exitOutput
+	}
+	w.last = cleanData
+	logger.Write(logger.NOTICE, "[%s] changed (%s): rendered to %q", w.url, change, w.outputFile)
+	return 0
+}
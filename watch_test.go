@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/scu/cleanpg/cleanhtml"
+)
+
+func TestCleanpgMain_Watch(t *testing.T) {
+	// The page changes once, after it has been fetched twice
+	var mu sync.Mutex
+	var requests int
+	var conditional []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		version := "v1"
+		if requests > 2 {
+			version = "v2"
+		}
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		mu.Unlock()
+
+		etag := `"` + version + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Watched</h1><p>Version " + version + "</p></body></html>"))
+	}))
+	defer ts.Close()
+	defer cleanhtml.SetPostH1Render(false)
+
+	outputFile := filepath.Join(t.TempDir(), "page.html")
+	if code := cleanpgMain([]string{"cleanpg", "--watch", "10ms", "--watch-count", "4", "-o", outputFile, ts.URL}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+	if !strings.Contains(string(data), "Version v2") {
+		t.Errorf("Expected the changed page, got %q", data)
+	}
+	// Each check after the first is conditional on the last
+	expect := []string{"", `"v1"`, `"v1"`, `"v2"`}
+	if strings.Join(conditional, ",") != strings.Join(expect, ",") {
+		t.Errorf("Expected If-None-Match %q, got %q", expect, conditional)
+	}
+
+	for _, args := range [][]string{
+		{"--watch", "soon", ts.URL},
+		{"--watch", "-1m", ts.URL},
+		{"--watch-count", "2", ts.URL},
+		{"--watch", "1m", "-"},
+		{"--watch", "1m", "-O", t.TempDir(), ts.URL},
+	} {
+		if code := cleanpgMain(append([]string{"cleanpg", "-o", outputFile}, args...)); code != exitUsage {
+			t.Errorf("%q: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestWatcher_Unchanged(t *testing.T) {
+	// Each response differs only in markup
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Same</h1>" + strings.Repeat("\n", requests) + "<p>Text</p></body></html>"))
+	}))
+	defer ts.Close()
+
+	outputFile := filepath.Join(t.TempDir(), "page.html")
+	w := &watcher{url: ts.URL, outputFile: outputFile, format: htmlFormat, count: 1}
+	if code := w.check(context.Background()); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if _, err := os.Stat(outputFile); err != nil {
+		t.Fatalf("Expected the first check to write the output: %v", err)
+	}
+
+	// The output isn't written again for the same content
+	os.Remove(outputFile)
+	if code := w.check(context.Background()); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the unchanged page not written, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}